@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+const (
+	ABRMinKbps = 250
+	ABRMaxKbps = 50000
+
+	ABRHighLossPercent = 5.0             // back off above this receive loss
+	ABRLowLossPercent  = 1.0             // probe upwards below this receive loss
+	ABRSmoothing       = 0.3             // EWMA weight of the newest sample
+	ABRProbeRate       = 0.05            // upward probe per second while the links are clean
+	ABRProbeHeadroom   = 1.1             // the probe stays within this factor of the receive rate
+	ABRMaxProbeStep    = 5 * time.Second // longest gap between samples a probe covers
+)
+
+// bitrateMessage is the receiver's sustainable bitrate estimate. It is
+// broadcast over the WebSocket and served at /api/bitrate in go-irl's own
+// format, for scripts that drive the encoder; sender apps don't read it.
+type bitrateMessage struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Type        string    `json:"type"` // always "bitrate"
+	BitrateKbps int       `json:"bitrateKbps"`
+	RecvKbps    float64   `json:"recvKbps"`
+	LossPercent float64   `json:"lossPercent"`
+	RttMs       float64   `json:"rttMs"`
+}
+
+// abrEstimator derives a sustainable bitrate from the receiving SRT
+// connection: it backs off multiplicatively when loss or RTT rise and
+// probes upwards slowly while the links are clean, never further than
+// ABRProbeHeadroom above what is actually received.
+type abrEstimator struct {
+	mu         sync.Mutex
+	recvKbps   float64
+	loss       float64
+	rtt        float64
+	minRtt     float64
+	targetKbps float64
+	updated    time.Time
+}
+
+var abr = &abrEstimator{}
+
+func (a *abrEstimator) update(s *srt.Statistics, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	recv := s.Instantaneous.MbpsRecvRate * 1000
+	loss := s.Instantaneous.PktRecvLossRate
+	rtt := s.Instantaneous.MsRTT
+
+	first := a.updated.IsZero()
+	var elapsed time.Duration
+	if first {
+		a.recvKbps, a.loss, a.rtt, a.minRtt = recv, loss, rtt, rtt
+		a.targetKbps = recv
+	} else {
+		// The probe grows with time, not with the stats interval
+		elapsed = min(max(now.Sub(a.updated), 0), ABRMaxProbeStep)
+		a.recvKbps += ABRSmoothing * (recv - a.recvKbps)
+		a.loss += ABRSmoothing * (loss - a.loss)
+		a.rtt += ABRSmoothing * (rtt - a.rtt)
+	}
+	if rtt > 0 && (a.minRtt <= 0 || rtt < a.minRtt) {
+		a.minRtt = rtt
+	}
+	a.updated = now
+
+	congested := a.loss > ABRHighLossPercent || (a.minRtt > 0 && a.rtt > 2*a.minRtt+100)
+	clean := a.loss < ABRLowLossPercent && (a.minRtt <= 0 || a.rtt < 1.3*a.minRtt+20)
+
+	switch {
+	case congested:
+		a.targetKbps = a.recvKbps * (1 - a.loss/100) * 0.8
+	case clean && !first:
+		probe := a.targetKbps * math.Pow(1+ABRProbeRate, elapsed.Seconds())
+		a.targetKbps = math.Min(probe, a.recvKbps*ABRProbeHeadroom)
+	}
+	a.targetKbps = math.Min(math.Max(a.targetKbps, ABRMinKbps), ABRMaxKbps)
+}
+
+func (a *abrEstimator) snapshot() bitrateMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return bitrateMessage{
+		Timestamp:   a.updated,
+		Type:        "bitrate",
+		BitrateKbps: int(a.targetKbps),
+		RecvKbps:    a.recvKbps,
+		LossPercent: a.loss,
+		RttMs:       a.rtt,
+	}
+}
+
+func handleBitrate(w http.ResponseWriter, r *http.Request) {
+	snap := abr.snapshot()
+	if snap.Timestamp.IsZero() {
+		http.Error(w, "no stream", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, snap)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// abrSample returns reader stats with the given receive rate and loss at a
+// steady 40 ms RTT.
+func abrSample(recvKbps, lossPercent float64) *srt.Statistics {
+	s := &srt.Statistics{}
+	s.Instantaneous.MbpsRecvRate = recvKbps / 1000
+	s.Instantaneous.PktRecvLossRate = lossPercent
+	s.Instantaneous.MsRTT = 40
+	return s
+}
+
+// TestABRSteadyCleanStaysNearRecv replays a sender steady at 5 Mbps on clean
+// links: the target may probe above it, but no further than the headroom,
+// at any stats interval.
+func TestABRSteadyCleanStaysNearRecv(t *testing.T) {
+	for _, interval := range []time.Duration{100 * time.Millisecond, time.Second, 5 * time.Second} {
+		a := &abrEstimator{}
+		now := time.Unix(1700000000, 0)
+		for i := 0; i < 1000; i++ {
+			a.update(abrSample(5000, 0), now)
+			now = now.Add(interval)
+		}
+		if got := a.snapshot().BitrateKbps; got < 5000 || float64(got) > 5000*ABRProbeHeadroom {
+			t.Errorf("every %s: target %d kbps, want 5000-%.0f", interval, got, 5000*ABRProbeHeadroom)
+		}
+	}
+}
+
+// TestABRProbeScalesWithTime checks that the probe grows by ABRProbeRate per
+// second whether the stats come every 100 ms or every second.
+func TestABRProbeScalesWithTime(t *testing.T) {
+	var targets []float64
+	for _, interval := range []time.Duration{100 * time.Millisecond, time.Second} {
+		a := &abrEstimator{}
+		now := time.Unix(1700000000, 0)
+		a.update(abrSample(5000, 0), now)
+		a.targetKbps = 4000 // as after a back-off
+		for elapsed := time.Duration(0); elapsed < 2*time.Second; elapsed += interval {
+			now = now.Add(interval)
+			a.update(abrSample(5000, 0), now)
+		}
+		targets = append(targets, a.targetKbps)
+	}
+	want := 4000 * math.Pow(1+ABRProbeRate, 2)
+	for _, got := range targets {
+		if math.Abs(got-want) > 1 {
+			t.Fatalf("targets %v after 2s, want %.0f", targets, want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// ackMaxDelay is how long a received packet may wait for its SRTLA ACK,
+// see -ack-max-delay. Zero ACKs only every RecvACKInterval packets, like
+// the reference receiver.
+var ackMaxDelay time.Duration
+
+// AckFlushMinPeriod bounds how often the flusher scans the groups.
+const AckFlushMinPeriod = 5 * time.Millisecond
+
+// flushAck sends the SRTLA ACK for the packets logged on c, fewer than
+// RecvACKInterval when the ACK is due by time. Senders read as many
+// sequence numbers as the ACK holds. Must be called with g.mu held.
+func flushAck(g *Group, c *Conn) {
+	n := c.recvIdx
+	c.recvIdx = 0
+	if n <= 0 || g.withholdAcks() {
+		return // withheld: the sender is asked to slow down, see backpressure.go
+	}
+
+	// Build srtla_ack_pkt: 4 bytes type + n * 4 bytes
+	ack := &c.ackBuf
+	binary.BigEndian.PutUint32(ack[0:4], uint32(SRTLATypeACK)<<16)
+	for i := 0; i < n; i++ {
+		binary.BigEndian.PutUint32(ack[4+i*4:], c.recvLog[i])
+	}
+	if _, err := srtlaSock.WriteToUDP(ack[:4+n*4], c.addr); err != nil {
+		logHot("[%s] [group %p] Failed to send the SRTLA ACK: %v", c.addr, g, err)
+	}
+}
+
+// flushGroupAcks sends the pending ACKs of every link of g, when the SRT
+// session ends and no more packets will fill them.
+func flushGroupAcks(g *Group) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, c := range g.conns {
+		flushAck(g, c)
+	}
+}
+
+// runAckFlusher sends the ACKs that are due by time on links too slow to
+// fill a count based ACK, e.g. a sender that fell back to audio only.
+func runAckFlusher(ctx context.Context) {
+	ticker := clk.NewTicker(max(ackMaxDelay/2, AckFlushMinPeriod))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			now := clk.Now()
+			for _, g := range groupList() {
+				g.mu.Lock()
+				for _, c := range g.conns {
+					if c.recvIdx > 0 && now.Sub(c.recvFirst) >= ackMaxDelay {
+						flushAck(g, c)
+					}
+				}
+				g.mu.Unlock()
+			}
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	ACMECheckPeriod      = 12 * time.Hour
+	ACMERetryPeriod      = 10 * time.Minute
+	ACMERenewBefore      = 30 * 24 * time.Hour // renew certificates expiring within this
+	ACMEPropagationDelay = 15 * time.Second    // after the TXT record was created, before the CA is asked to check it
+	ACMEOrderTimeout     = 5 * time.Minute
+
+	route53API = "https://route53.amazonaws.com/2013-04-01"
+)
+
+// acmeConfig holds the ACME settings, as given on the command line.
+type acmeConfig struct {
+	DNS   string // provider answering the DNS-01 challenges
+	Email string
+	Dir   string // account key and certificate cache
+	CA    string // ACME directory URL
+}
+
+// dnsChallenger publishes the TXT records of DNS-01 challenges.
+type dnsChallenger interface {
+	setTXT(name, value string) error
+	removeTXT(name, value string) error
+}
+
+// acmeManager obtains a certificate for one domain with DNS-01 challenges,
+// so the instance does not have to be reachable from the internet, and
+// renews it before it expires.
+type acmeManager struct {
+	domain string
+	cfg    acmeConfig
+	dns    dnsChallenger
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+func parseDNSChallenger(name string) (dnsChallenger, error) {
+	client := &http.Client{Timeout: DDNSRequestTimeout}
+	switch name {
+	case "cloudflare":
+		token := os.Getenv("CLOUDFLARE_API_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("CLOUDFLARE_API_TOKEN must be set")
+		}
+		return &cloudflareDNS{token: token, client: client, txtIDs: map[string]string{}}, nil
+	case "route53":
+		p := &route53DNS{accessKey: os.Getenv("AWS_ACCESS_KEY_ID"), secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), client: client}
+		if p.accessKey == "" || p.secretKey == "" {
+			return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+		}
+		return p, nil
+	case "":
+		return nil, fmt.Errorf("-tls-domain needs -acme-dns (cloudflare or route53)")
+	}
+	return nil, fmt.Errorf("unknown -acme-dns %q (expected cloudflare or route53)", name)
+}
+
+func newACMEManager(domain string, cfg acmeConfig) (*acmeManager, error) {
+	dns, err := parseDNSChallenger(cfg.DNS)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	m := &acmeManager{domain: strings.ToLower(domain), cfg: cfg, dns: dns}
+	if cert, err := tls.LoadX509KeyPair(m.path(".crt"), m.path(".key")); err == nil {
+		m.cert = &cert
+	}
+	return m, nil
+}
+
+func (m *acmeManager) path(ext string) string {
+	return filepath.Join(m.cfg.Dir, strings.ReplaceAll(m.domain, "*", "_")+ext)
+}
+
+func (m *acmeManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return nil, fmt.Errorf("no certificate for %s yet", m.domain)
+	}
+	return m.cert, nil
+}
+
+func (m *acmeManager) start() {
+	go supervise("acme", m.run)
+}
+
+func (m *acmeManager) run() {
+	for {
+		wait := ACMECheckPeriod
+		if expiry := m.expiry(); time.Until(expiry) < ACMERenewBefore {
+			if expiry.IsZero() {
+				log.Printf("[acme] Requesting a certificate for %s (DNS-01 via %s)", m.domain, m.cfg.DNS)
+			} else {
+				log.Printf("[acme] Certificate for %s expires %s, renewing", m.domain, expiry.Format(time.RFC3339))
+			}
+			if err := m.obtain(); err != nil {
+				log.Printf("[acme] Failed to get a certificate for %s, retrying in %s: %v", m.domain, ACMERetryPeriod, err)
+				emitEvent("tls.certificate_failed", map[string]any{"domain": m.domain, "error": err.Error()})
+				wait = ACMERetryPeriod
+			} else {
+				expiry = m.expiry()
+				log.Printf("[acme] Got a certificate for %s, valid until %s", m.domain, expiry.Format(time.RFC3339))
+				emitEvent("tls.certificate_issued", map[string]any{"domain": m.domain, "expires": expiry})
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (m *acmeManager) expiry() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return time.Time{}
+	}
+	leaf, err := x509.ParseCertificate(m.cert.Certificate[0])
+	if err != nil {
+		return time.Time{}
+	}
+	return leaf.NotAfter
+}
+
+// accountKey loads the ACME account key, creating it on first use.
+func (m *acmeManager) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.cfg.Dir, "account.key")
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM key", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, _ := x509.MarshalECPrivateKey(key)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// obtain runs an ACME order for m.domain and installs the certificate.
+func (m *acmeManager) obtain() error {
+	ctx, cancel := context.WithTimeout(context.Background(), ACMEOrderTimeout)
+	defer cancel()
+
+	key, err := m.accountKey()
+	if err != nil {
+		return fmt.Errorf("account key: %w", err)
+	}
+	client := &acme.Client{Key: key, DirectoryURL: m.cfg.CA}
+	var contact []string
+	if m.cfg.Email != "" {
+		contact = []string{"mailto:" + m.cfg.Email}
+	}
+	if _, err := client.Register(ctx, &acme.Account{Contact: contact}, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("account registration: %w", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.domain))
+	if err != nil {
+		return fmt.Errorf("order: %w", err)
+	}
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, u); err != nil {
+			return err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return fmt.Errorf("order: %w", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{m.domain}}, certKey)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("finalizing: %w", err)
+	}
+
+	var certPEM bytes.Buffer
+	for _, der := range chain {
+		pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	der, _ := x509.MarshalECPrivateKey(certKey)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	cert, err := tls.X509KeyPair(certPEM.Bytes(), keyPEM)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.path(".key"), keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.path(".crt"), certPEM.Bytes(), 0o644); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+	return nil
+}
+
+// authorize answers the DNS-01 challenge of one authorization.
+func (m *acmeManager) authorize(ctx context.Context, client *acme.Client, u string) error {
+	z, err := client.GetAuthorization(ctx, u)
+	if err != nil {
+		return fmt.Errorf("authorization: %w", err)
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("the CA offers no dns-01 challenge for %s", z.Identifier.Value)
+	}
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	name := "_acme-challenge." + strings.TrimPrefix(z.Identifier.Value, "*.")
+	if err := m.dns.setTXT(name, value); err != nil {
+		return fmt.Errorf("creating %s: %w", name, err)
+	}
+	defer func() {
+		if err := m.dns.removeTXT(name, value); err != nil {
+			log.Printf("[acme] Failed to remove %s: %v", name, err)
+		}
+	}()
+	time.Sleep(ACMEPropagationDelay)
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("challenge: %w", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, z.URI); err != nil {
+		return fmt.Errorf("challenge: %w", err)
+	}
+	return nil
+}
+
+func (p *cloudflareDNS) setTXT(name, value string) error {
+	zoneID, err := p.zone(name)
+	if err != nil {
+		return err
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	record := map[string]any{"type": "TXT", "name": name, "content": value, "ttl": 60}
+	if err := p.call(http.MethodPost, "/zones/"+zoneID+"/dns_records", record, &created); err != nil {
+		return err
+	}
+	p.txtIDs[value] = zoneID + "/dns_records/" + created.ID
+	return nil
+}
+
+func (p *cloudflareDNS) removeTXT(name, value string) error {
+	id, ok := p.txtIDs[value]
+	if !ok {
+		return nil
+	}
+	delete(p.txtIDs, value)
+	return p.call(http.MethodDelete, "/zones/"+id, nil, nil)
+}
+
+// route53DNS publishes challenges in Amazon Route 53, with the credentials
+// from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY. They need the
+// route53:ListHostedZonesByName, route53:ChangeResourceRecordSets and
+// route53:GetChange permissions.
+type route53DNS struct {
+	accessKey, secretKey string
+	client               *http.Client
+}
+
+func (p *route53DNS) setTXT(name, value string) error {
+	return p.change("UPSERT", name, value)
+}
+
+func (p *route53DNS) removeTXT(name, value string) error {
+	return p.change("DELETE", name, value)
+}
+
+// change applies one change to the TXT record and waits until Route 53
+// reports it as propagated to its name servers.
+func (p *route53DNS) change(action, name, value string) error {
+	zoneID, err := p.zone(name)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		XMLName xml.Name `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+		Action  string   `xml:"ChangeBatch>Changes>Change>Action"`
+		Name    string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>Name"`
+		Type    string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>Type"`
+		TTL     int      `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>TTL"`
+		Value   string   `xml:"ChangeBatch>Changes>Change>ResourceRecordSet>ResourceRecords>ResourceRecord>Value"`
+	}
+	req.Action, req.Name, req.Type, req.TTL, req.Value = action, name, "TXT", 60, `"`+value+`"`
+	body, _ := xml.Marshal(req)
+	var resp struct {
+		ID     string `xml:"ChangeInfo>Id"`
+		Status string `xml:"ChangeInfo>Status"`
+	}
+	if err := p.call(http.MethodPost, "/hostedzone/"+zoneID+"/rrset", nil, body, &resp); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(2 * time.Minute); resp.Status != "INSYNC"; {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("route53: change %s not in sync after 2 minutes", resp.ID)
+		}
+		time.Sleep(5 * time.Second)
+		if err := p.call(http.MethodGet, "/change/"+strings.TrimPrefix(resp.ID, "/change/"), nil, nil, &resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zone returns the ID of the hosted zone name is in, trying every parent
+// domain.
+func (p *route53DNS) zone(name string) (string, error) {
+	for zone := name; strings.Contains(zone, "."); zone = zone[strings.Index(zone, ".")+1:] {
+		var resp struct {
+			Zones []struct {
+				ID   string `xml:"Id"`
+				Name string `xml:"Name"`
+			} `xml:"HostedZones>HostedZone"`
+		}
+		if err := p.call(http.MethodGet, "/hostedzonesbyname", url.Values{"dnsname": {zone}, "maxitems": {"1"}}, nil, &resp); err != nil {
+			return "", err
+		}
+		if len(resp.Zones) > 0 && strings.TrimSuffix(resp.Zones[0].Name, ".") == zone {
+			return strings.TrimPrefix(resp.Zones[0].ID, "/hostedzone/"), nil
+		}
+	}
+	return "", fmt.Errorf("route53: no hosted zone for %s", name)
+}
+
+// call sends a signed Route 53 request and decodes the XML answer into out.
+func (p *route53DNS) call(method, path string, query url.Values, body []byte, out any) error {
+	u, _ := url.Parse(route53API + path)
+	u.RawQuery = s3CanonicalQuery(query)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(body)
+	signV4(req, s3EscapePath(u.Path), u.RawQuery, hex.EncodeToString(hash[:]), "route53", "us-east-1", p.accessKey, p.secretKey, time.Now().UTC())
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("route53: %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(data))
+	}
+	return xml.Unmarshal(data, out)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	ActionQueueLen     = 64
+	ActionOutputMaxLen = 1024 // of a failed command's output, logged
+	ActionWaitDelay    = 1 * time.Second
+)
+
+// eventAction runs a local command whenever an event matching its pattern
+// is emitted, see -on-event.
+type eventAction struct {
+	pattern string // event name, path.Match syntax, e.g. "stream.*"
+	command string // run by the shell
+}
+
+// eventActions collects repeated -on-event flags.
+type eventActions []eventAction
+
+func (a *eventActions) String() string {
+	var parts []string
+	for _, act := range *a {
+		parts = append(parts, act.pattern+"="+act.command)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (a *eventActions) Set(s string) error {
+	pattern, command, ok := strings.Cut(s, "=")
+	pattern, command = strings.TrimSpace(pattern), strings.TrimSpace(command)
+	if !ok || pattern == "" || command == "" {
+		return fmt.Errorf("expected event=command, e.g. stream.started=/usr/local/bin/start-recording.sh")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid event pattern %q: %v", pattern, err)
+	}
+	*a = append(*a, eventAction{pattern: pattern, command: command})
+	return nil
+}
+
+type actionJob struct {
+	action eventAction
+	ev     event
+}
+
+// startActionRunner runs the actions for every matching event on workers
+// goroutines, giving each command up to timeout. Events are queued while
+// all workers are busy and dropped once the queue is full.
+func startActionRunner(actions eventActions, workers int, timeout time.Duration) {
+	jobs := make(chan actionJob, ActionQueueLen)
+	for i := 0; i < max(workers, 1); i++ {
+		go supervise("actions", func() {
+			for job := range jobs {
+				runAction(job, timeout)
+			}
+		})
+	}
+
+	subscribeEvents(func(ev event) {
+		for _, act := range actions {
+			if ok, _ := path.Match(act.pattern, ev.Name); !ok {
+				continue
+			}
+			select {
+			case jobs <- actionJob{action: act, ev: ev}:
+			default:
+				log.Printf("[actions] Queue full, skipping %q for %s", act.command, ev.Name)
+			}
+		}
+	})
+	log.Printf("[actions] %d actions, %d at a time, timeout %s", len(actions), max(workers, 1), timeout)
+}
+
+// runAction runs the command with the event in its environment
+// (GOIRL_EVENT, GOIRL_EVENT_TIME, GOIRL_EVENT_FIELDS as JSON and one
+// GOIRL_<FIELD> per field) and as JSON on stdin.
+func runAction(job actionJob, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", job.action.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", job.action.command)
+	}
+
+	fields, _ := json.Marshal(job.ev.Fields)
+	cmd.Env = append(os.Environ(),
+		"GOIRL_EVENT="+job.ev.Name,
+		"GOIRL_EVENT_TIME="+job.ev.Timestamp.Format(time.RFC3339),
+		"GOIRL_EVENT_FIELDS="+string(fields),
+	)
+	for k, v := range job.ev.Fields {
+		cmd.Env = append(cmd.Env, "GOIRL_"+envName(k)+"="+fmt.Sprint(v))
+	}
+	data, _ := json.Marshal(job.ev)
+	cmd.Stdin = bytes.NewReader(data)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.WaitDelay = ActionWaitDelay // for children of the shell still holding the output open
+
+	start := time.Now()
+	err := cmd.Run()
+	took := time.Since(start).Round(time.Millisecond)
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		log.Printf("[actions] %q for %s killed after %s", job.action.command, job.ev.Name, timeout)
+	case err != nil:
+		msg := out.String()
+		if len(msg) > ActionOutputMaxLen {
+			msg = msg[len(msg)-ActionOutputMaxLen:]
+		}
+		log.Printf("[actions] %q for %s failed after %s: %v\n%s", job.action.command, job.ev.Name, took, err, strings.TrimSpace(msg))
+	default:
+		log.Printf("[actions] %q for %s done in %s", job.action.command, job.ev.Name, took)
+	}
+}
+
+// envName turns an event field name like "recvKbps" into RECV_KBPS.
+func envName(field string) string {
+	var b strings.Builder
+	for i, r := range field {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		case r >= 'a' && r <= 'z':
+			b.WriteRune(r - 'a' + 'A')
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
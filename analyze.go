@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AnalyzeRoutinePeriod is how often the timeline summarizes the keepalives,
+// ACKs and data of a link when nothing else happens on it.
+const AnalyzeRoutinePeriod = 10 * time.Second
+
+// SRT handshake types of rejections: 1000 plus the reject reason, see
+// srt_rejectreason in libsrt.
+const SRTHandshakeRejectBase = 1000
+
+const SRTTypeACKACK = 0x8006
+
+var srtRejectReasons = []string{
+	"unknown", "system", "peer", "resource", "rogue", "backlog", "ipe", "close",
+	"version", "rdvcookie", "badsecret", "unsecure", "messageapi", "congestion",
+	"filter", "group", "timeout", "crypto",
+}
+
+// srtRejectHints explain the reject reasons senders run into most.
+var srtRejectHints = map[string]string{
+	"badsecret": "the sender's passphrase differs from -passphrase",
+	"unsecure":  "only one side has a passphrase",
+	"peer":      "the receiver refused the stream, e.g. its stream ID",
+	"version":   "the sender's SRT version is too old",
+}
+
+// States of an analyzed link, as the server sees it.
+const (
+	analyzeUnknown     = iota // nothing seen, e.g. registered before the capture started
+	analyzeRegistering        // REG1 or REG2 sent, no REG3 yet
+	analyzeRegistered
+	analyzeRejected // REG_ERR or REG_NGP received
+	analyzeExpired  // silent for longer than ConnTimeout
+)
+
+type analyzeGroup struct {
+	name      string
+	requested bool   // a REG1 is in the capture
+	serverID  []byte // assigned in the server's REG2
+	links     map[netip.AddrPort]bool
+}
+
+// analyzeLink is a sender address in the capture.
+type analyzeLink struct {
+	addr     netip.AddrPort
+	group    *analyzeGroup
+	state    int
+	lastSent time.Time
+	warned   bool     // about SRT sent in the current state
+	awaiting string   // the request the server hasn't answered yet
+	cookie   uint32   // from the last induction response
+	sentData bool     // first data packet was shown
+	data     int      // data packets sent
+	acks     int      // SRTLA ACKs received
+	routine  []string // packet names since the last line of the link
+	since    time.Time
+}
+
+// analyzer decodes the SRTLA and SRT control flow of a capture into a
+// timeline and the protocol violations in it.
+type analyzer struct {
+	port     uint16
+	all      bool // every packet on the timeline
+	first    time.Time
+	links    map[netip.AddrPort]*analyzeLink
+	order    []*analyzeLink
+	groups   map[string]*analyzeGroup // by the sender's half of the group ID
+	problems []string
+}
+
+// runAnalyze implements the "analyze" subcommand, for triaging reports of
+// senders that don't connect without reading hexdumps.
+func runAnalyze(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	port := fs.Int("port", 0, "SRTLA port of the server, 0 takes the destination of the first packet")
+	all := fs.Bool("all", false, "Show every packet instead of summarizing keepalives, ACKs and data")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: go-irl analyze [flags] capture.pcap\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	file := fs.Arg(0)
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	defer f.Close()
+	pr, err := newPcapReader(f)
+	if err != nil {
+		log.Fatalf("ERROR: %s: %v", file, err)
+	}
+
+	a := &analyzer{port: uint16(*port), all: *all, links: map[netip.AddrPort]*analyzeLink{}, groups: map[string]*analyzeGroup{}}
+	for {
+		p, err := pr.next()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			log.Fatalf("ERROR: %s: %v", file, err)
+		}
+		a.packet(p)
+	}
+	if a.first.IsZero() {
+		log.Fatalf("ERROR: %s has no SRTLA packets", file)
+	}
+	a.finish()
+	if len(a.problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func (a *analyzer) packet(p capturedPacket) {
+	if a.port == 0 {
+		a.port = p.dst.Port()
+		fmt.Printf("SRTLA port %d, from the first packet\n\n", a.port)
+	}
+	var l *analyzeLink
+	switch {
+	case p.src.Port() == a.port:
+		l = a.link(p.dst)
+	case p.dst.Port() == a.port:
+		l = a.link(p.src)
+	default:
+		return // other traffic
+	}
+	if a.first.IsZero() {
+		a.first = p.at
+	}
+	if p.dst.Port() == a.port && p.src.Port() != a.port {
+		a.fromSender(l, p)
+	} else {
+		a.fromServer(l, p)
+	}
+}
+
+func (a *analyzer) link(addr netip.AddrPort) *analyzeLink {
+	l := a.links[addr]
+	if l == nil {
+		l = &analyzeLink{addr: addr}
+		a.links[addr] = l
+		a.order = append(a.order, l)
+	}
+	return l
+}
+
+// group returns the group of a REG1 or REG2 ID.
+func (a *analyzer) group(id []byte) *analyzeGroup {
+	key := string(id[:SRTLAIDLen/2])
+	g := a.groups[key]
+	if g == nil {
+		g = &analyzeGroup{
+			name:  fmt.Sprintf("group %d (%s)", len(a.groups)+1, hex.EncodeToString(id[:4])),
+			links: map[netip.AddrPort]bool{},
+		}
+		a.groups[key] = g
+	}
+	return g
+}
+
+func (a *analyzer) fromSender(l *analyzeLink, p capturedPacket) {
+	pkt, at := p.data, p.at
+	if l.state == analyzeRegistered && !l.lastSent.IsZero() && at.Sub(l.lastSent) > ConnTimeout {
+		a.problem(at, l, "->", "Link was silent for %v, longer than the server's %v link timeout, so the server dropped it",
+			at.Sub(l.lastSent).Round(time.Millisecond), ConnTimeout)
+		l.state, l.warned = analyzeExpired, false
+	}
+	l.lastSent = at
+
+	typ := getSRTType(pkt)
+	switch {
+	case typ == SRTLATypeReg1 || typ == SRTLATypeReg2:
+		name := map[uint16]string{SRTLATypeReg1: "REG1", SRTLATypeReg2: "REG2"}[typ]
+		id := srtlaRegID(pkt, typ)
+		if id == nil {
+			a.problem(at, l, "->", "%s of %d bytes, must be %d; the server ignores it", name, len(pkt), SRTLAReg1Len)
+			return
+		}
+		g := a.group(id)
+		switch {
+		case typ == SRTLATypeReg1 && l.group != nil && l.group != g && l.state == analyzeRegistered:
+			a.problem(at, l, "->", "REG1 %s from an address registered to %s; the server answers REG_ERR", g.name, l.group.name)
+		case typ == SRTLATypeReg1 && g.serverID != nil:
+			a.event(at, l, "->", "REG1 %s again: the sender restarted, the server replaces the group", g.name)
+			a.replaced(g)
+		case typ == SRTLATypeReg1:
+			a.event(at, l, "->", "REG1 %s", g.name)
+		case g.serverID == nil && !g.requested:
+			a.event(at, l, "->", "REG2 %s, registered before the capture started", g.name)
+		case g.serverID == nil:
+			a.problem(at, l, "->", "REG2 %s before the server assigned the group ID in its REG2", g.name)
+		case !bytes.Equal(id, g.serverID):
+			a.problem(at, l, "->", "REG2 %s with another ID than the server assigned; the server answers REG_NGP", g.name)
+		default:
+			a.event(at, l, "->", "REG2 %s", g.name)
+		}
+		l.group, l.awaiting, l.warned = g, name, false
+		g.requested = g.requested || typ == SRTLATypeReg1
+		if l.state != analyzeRegistered {
+			l.state = analyzeRegistering
+		}
+	case typ == SRTLATypeReg3 || typ == SRTLATypeRegErr || typ == SRTLATypeRegNGP || typ == SRTLATypeACK || typ == SRTLATypeCongestion:
+		a.problem(at, l, "->", "%s sent by the sender, only the server sends it", analyzeName(pkt))
+	case typ == SRTLATypeKeepalive:
+		a.routine(at, l, "->", "keepalive")
+	case typ>>12 == SRTLATypeKeepalive>>12:
+		a.problem(at, l, "->", "Unknown SRTLA packet type 0x%04x", typ)
+	case len(pkt) < SRTMinLen:
+		a.problem(at, l, "->", "Packet of %d bytes, shorter than an SRT header", len(pkt))
+	default:
+		a.srtFromSender(l, pkt, at)
+	}
+}
+
+// replaced drops the links of a group the server replaces on a new REG1
+// with its client ID. They have to register again.
+func (a *analyzer) replaced(g *analyzeGroup) {
+	for addr := range g.links {
+		if l := a.links[addr]; l.state == analyzeRegistered {
+			l.state, l.warned = analyzeExpired, false
+		}
+	}
+	g.serverID, g.links = nil, map[netip.AddrPort]bool{}
+}
+
+func (a *analyzer) srtFromSender(l *analyzeLink, pkt []byte, at time.Time) {
+	if !l.warned {
+		switch l.state {
+		case analyzeUnknown:
+			a.event(at, l, "->", "SRT without an SRTLA registration: a plain SRT sender, or a link registered before the capture started")
+		case analyzeRegistering:
+			a.problem(at, l, "->", "SRT sent before the server confirmed the link with REG3; the server drops it")
+		case analyzeRejected:
+			a.problem(at, l, "->", "SRT sent after the server refused the registration; the server drops it")
+		case analyzeExpired:
+			a.problem(at, l, "->", "SRT sent on a link the server dropped, without registering it again with REG2")
+		}
+		l.warned = true
+	}
+
+	typ := getSRTType(pkt)
+	if sn := getSRTSN(pkt); sn >= 0 {
+		l.data++
+		if !l.sentData {
+			l.sentData = true
+			a.event(at, l, "->", "First SRT data packet, sequence number %d", sn)
+			return
+		}
+		a.routine(at, l, "->", "srt-data")
+		return
+	}
+	switch typ {
+	case SRTTypeHandshake:
+		hs, err := parseSRTHandshake(pkt)
+		if err != nil {
+			a.problem(at, l, "->", "Malformed SRT handshake: %v", err)
+			return
+		}
+		switch hs.Type {
+		case SRTHandshakeInduction:
+			a.event(at, l, "->", "SRT handshake induction, version %d", hs.Version)
+			if hs.Version != 4 {
+				a.problem(at, l, "->", "Induction request of version %d, callers send version 4", hs.Version)
+			}
+			l.awaiting = "the SRT induction"
+		case SRTHandshakeConclusion:
+			a.event(at, l, "->", "SRT handshake conclusion, version %d, socket %08x, cookie %08x", hs.Version, hs.SourceID, hs.SynCookie)
+			if hs.Version != 5 {
+				a.problem(at, l, "->", "Conclusion request of version %d; the server only accepts HSv5 (version 5)", hs.Version)
+			}
+			if l.cookie != 0 && hs.SynCookie != l.cookie {
+				a.problem(at, l, "->", "Conclusion with cookie %08x, the induction response gave %08x; the server rejects it", hs.SynCookie, l.cookie)
+			}
+			l.awaiting = "the SRT conclusion"
+		default:
+			a.event(at, l, "->", "SRT handshake of type %d", int32(hs.Type))
+		}
+	case SRTTypeShutdown:
+		a.event(at, l, "->", "SRT shutdown")
+	default:
+		a.routine(at, l, "->", analyzeName(pkt))
+	}
+}
+
+func (a *analyzer) fromServer(l *analyzeLink, p capturedPacket) {
+	pkt, at := p.data, p.at
+	switch typ := getSRTType(pkt); typ {
+	case SRTLATypeReg2:
+		id := srtlaRegID(pkt, typ)
+		if id == nil {
+			a.problem(at, l, "<-", "REG2 of %d bytes, must be %d", len(pkt), SRTLAReg2Len)
+			return
+		}
+		g := a.group(id)
+		g.serverID = append([]byte(nil), id...)
+		l.group, l.awaiting = g, ""
+		a.event(at, l, "<-", "REG2 %s, group ID assigned", g.name)
+	case SRTLATypeReg3:
+		l.state, l.awaiting, l.warned = analyzeRegistered, "", false
+		name := "an unknown group"
+		if l.group != nil {
+			l.group.links[l.addr] = true
+			name = fmt.Sprintf("%s as link %d", l.group.name, len(l.group.links))
+		}
+		a.event(at, l, "<-", "REG3 link registered to %s", name)
+	case SRTLATypeRegErr:
+		l.state, l.awaiting, l.warned = analyzeRejected, "", false
+		a.problem(at, l, "<-", "REG_ERR: the server refused the registration (too many groups or links, address in another group, memory cap or ingest schedule; its log says which)")
+	case SRTLATypeRegNGP:
+		l.state, l.awaiting, l.warned = analyzeRejected, "", false
+		a.problem(at, l, "<-", "REG_NGP: the server has no such group, e.g. after a restart or a group timeout; the sender has to start over with REG1")
+	case SRTLATypeACK:
+		l.acks++
+		a.routine(at, l, "<-", "srtla-ack")
+	case SRTTypeHandshake:
+		hs, err := parseSRTHandshake(pkt)
+		if err != nil {
+			a.problem(at, l, "<-", "Malformed SRT handshake: %v", err)
+			return
+		}
+		l.awaiting = ""
+		switch {
+		case hs.Type == SRTHandshakeInduction:
+			l.cookie = hs.SynCookie
+			a.event(at, l, "<-", "SRT handshake induction response, cookie %08x", hs.SynCookie)
+		case hs.Type == SRTHandshakeConclusion:
+			a.event(at, l, "<-", "SRT handshake conclusion response, socket %08x: connected", hs.SourceID)
+		case hs.Type >= SRTHandshakeRejectBase:
+			reason := fmt.Sprintf("reason %d", hs.Type-SRTHandshakeRejectBase)
+			if r := int(hs.Type - SRTHandshakeRejectBase); r < len(srtRejectReasons) {
+				reason = srtRejectReasons[r]
+				if hint, ok := srtRejectHints[reason]; ok {
+					reason += ", " + hint
+				}
+			}
+			a.problem(at, l, "<-", "SRT handshake rejected: %s", reason)
+		default:
+			a.event(at, l, "<-", "SRT handshake of type %d", int32(hs.Type))
+		}
+	case SRTTypeShutdown:
+		a.event(at, l, "<-", "SRT shutdown")
+	default:
+		a.routine(at, l, "<-", analyzeName(pkt))
+	}
+}
+
+// analyzeName names a packet on the timeline.
+func analyzeName(pkt []byte) string {
+	switch getSRTType(pkt) {
+	case SRTLATypeRegErr:
+		return "REG_ERR"
+	case SRTLATypeRegNGP:
+		return "REG_NGP"
+	case SRTLATypeReg3:
+		return "REG3"
+	case SRTTypeACKACK:
+		return "srt-ackack"
+	}
+	return replyName(pkt)
+}
+
+func (a *analyzer) line(at time.Time, l *analyzeLink, dir, text string) {
+	fmt.Printf("%10.3fs  %-22s %s %s\n", at.Sub(a.first).Seconds(), l.addr, dir, text)
+}
+
+// event puts a packet on the timeline, after the routine packets of the
+// link before it.
+func (a *analyzer) event(at time.Time, l *analyzeLink, dir, format string, args ...any) {
+	a.flush(at, l)
+	a.line(at, l, dir, fmt.Sprintf(format, args...))
+}
+
+// problem is an event that breaks the protocol or keeps the sender from
+// connecting.
+func (a *analyzer) problem(at time.Time, l *analyzeLink, dir, format string, args ...any) {
+	text := fmt.Sprintf(format, args...)
+	a.event(at, l, dir, "!! %s", text)
+	a.problems = append(a.problems, fmt.Sprintf("%.3fs %s: %s", at.Sub(a.first).Seconds(), l.addr, text))
+}
+
+// routine counts a packet that is only shown in the link's summaries,
+// unless -all is set.
+func (a *analyzer) routine(at time.Time, l *analyzeLink, dir, name string) {
+	if a.all {
+		a.line(at, l, dir, name)
+		return
+	}
+	if dir == "<-" {
+		name += " reply"
+		if name == "srtla-ack reply" {
+			name = "srtla-ack"
+		}
+	}
+	if len(l.routine) == 0 {
+		l.since = at
+	}
+	l.routine = append(l.routine, name)
+	if at.Sub(l.since) >= AnalyzeRoutinePeriod {
+		a.flush(at, l)
+	}
+}
+
+// flush prints the routine packets of a link as one line.
+func (a *analyzer) flush(at time.Time, l *analyzeLink) {
+	if len(l.routine) == 0 {
+		return
+	}
+	counts := map[string]int{}
+	var names []string
+	for _, name := range l.routine {
+		if counts[name] == 0 {
+			names = append(names, name)
+		}
+		counts[name]++
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%d %s", counts[name], name)
+	}
+	a.line(at, l, "  ", fmt.Sprintf("... %s over %v", strings.Join(parts, ", "), at.Sub(l.since).Round(time.Millisecond)))
+	l.routine = l.routine[:0]
+}
+
+// finish ends the timeline and prints the summary of groups, links and
+// problems.
+func (a *analyzer) finish() {
+	var last time.Time
+	for _, l := range a.order {
+		if l.lastSent.After(last) {
+			last = l.lastSent
+		}
+	}
+	for _, l := range a.order {
+		a.flush(last, l)
+		if l.awaiting != "" {
+			a.problem(last, l, "  ", "No reply to %s by the end of the capture", l.awaiting)
+		}
+		if l.state == analyzeRegistered && l.data >= 2*RecvACKInterval && l.acks == 0 {
+			a.problem(last, l, "  ", "Sent %d SRT data packets without getting an SRTLA ACK; the server isn't forwarding the stream", l.data)
+		}
+	}
+
+	fmt.Printf("\nGroups:\n")
+	if len(a.groups) == 0 {
+		fmt.Printf("  none registered in the capture\n")
+	}
+	groups := make([]*analyzeGroup, 0, len(a.groups))
+	for _, g := range a.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	for _, g := range groups {
+		fmt.Printf("  %s: %d links registered\n", g.name, len(g.links))
+	}
+	fmt.Printf("\nLinks:\n")
+	states := []string{"unknown", "registering", "registered", "rejected", "expired"}
+	for _, l := range a.order {
+		group := "no group"
+		if l.group != nil {
+			group = l.group.name
+		}
+		fmt.Printf("  %-22s %-11s %s, %d data packets, %d SRTLA ACKs\n", l.addr, states[l.state], group, l.data, l.acks)
+	}
+	if len(a.problems) == 0 {
+		fmt.Printf("\nNo protocol problems found.\n")
+		return
+	}
+	fmt.Printf("\n%d problems:\n", len(a.problems))
+	for _, p := range a.problems {
+		fmt.Printf("  %s\n", p)
+	}
+}
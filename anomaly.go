@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	DefaultAnomalyZ = 3.0
+
+	AnomalyInterval = 1 * time.Second // receiver sample period of the link bitrates
+	AnomalyAlpha    = 0.1             // EWMA weight of a new sample
+	AnomalyWarmup   = 20              // samples before a link is judged
+	AnomalyHold     = 3               // consecutive anomalous samples for link.degrading
+	AnomalyRecover  = 5               // consecutive normal samples for link.recovered
+)
+
+// anomalyZ is how many deviations from its baseline a link metric must be
+// to count as anomalous, see -anomaly-z. 0 disables the detector.
+var anomalyZ = DefaultAnomalyZ
+
+// anomalyMetric tracks the EWMA mean and variance of a link metric and
+// flags a sustained excursion, which usually comes some seconds before a
+// modem drops out entirely.
+type anomalyMetric struct {
+	rising   bool    // a rise is bad (RTT), otherwise a drop is (bitrate)
+	relStd   float64 // floor of the deviation, relative to the mean, so steady links don't alarm on noise
+	absStd   float64 // and in absolute terms
+	mean     float64
+	variance float64
+	n        int
+	bad      int
+	good     int
+	degraded bool
+}
+
+func newBitrateAnomaly() anomalyMetric { return anomalyMetric{relStd: 0.2, absStd: 1} }
+func newRTTAnomaly() anomalyMetric     { return anomalyMetric{rising: true, relStd: 0.2, absStd: 5} }
+
+// add feeds a sample and returns the z-score of x against the baseline,
+// and whether the metric went degraded (+1) or recovered (-1).
+func (m *anomalyMetric) add(x float64) (z float64, change int) {
+	anomalous := false
+	if m.n >= AnomalyWarmup && anomalyZ > 0 {
+		std := math.Max(math.Sqrt(m.variance), math.Max(m.relStd*math.Abs(m.mean), m.absStd))
+		z = (x - m.mean) / std
+		anomalous = z <= -anomalyZ
+		if m.rising {
+			anomalous = z >= anomalyZ
+		}
+		switch {
+		case anomalous:
+			m.bad, m.good = m.bad+1, 0
+		case math.Abs(z) < anomalyZ/2:
+			m.good, m.bad = m.good+1, 0
+		}
+		if !m.degraded && m.bad >= AnomalyHold {
+			m.degraded, change = true, 1
+		} else if m.degraded && m.good >= AnomalyRecover {
+			m.degraded, change = false, -1
+		}
+	}
+
+	// Anomalous samples barely move the baseline, so the excursion neither
+	// inflates the variance it is judged by nor becomes the new normal
+	// before the link recovers
+	alpha := AnomalyAlpha
+	if m.n == 0 {
+		alpha = 1
+	} else if anomalous || m.degraded {
+		alpha /= 10
+	}
+	diff := x - m.mean
+	m.mean += alpha * diff
+	m.variance = (1 - alpha) * (m.variance + alpha*diff*diff)
+	m.n++
+	return z, change
+}
+
+// anomalyEvent returns the link.degrading or link.recovered event for a
+// change reported by add.
+func anomalyEvent(change int, metric string, value, baseline, z float64, fields map[string]any) pendingEvent {
+	fields["metric"] = metric
+	fields["value"] = math.Round(value*10) / 10
+	fields["baseline"] = math.Round(baseline*10) / 10
+	fields["z"] = math.Round(z*10) / 10
+	if change > 0 {
+		return pendingEvent{"link.degrading", fields}
+	}
+	return pendingEvent{"link.recovered", fields}
+}
+
+// runAnomalyWatch samples the bitrate each link of a group carries. With
+// several links it judges the link's share of the group's bitrate, so the
+// encoder lowering its bitrate doesn't look like every link degrading.
+func runAnomalyWatch(ctx context.Context) {
+	ticker := clk.NewTicker(AnomalyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			for _, g := range groupList() {
+				for _, ev := range g.sampleAnomalies(now) {
+					emitEvent(ev.name, ev.fields)
+				}
+			}
+		}
+	}
+}
+
+func (g *Group) sampleAnomalies(now time.Time) []pendingEvent {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Links joining or leaving shift every share, start over
+	if len(g.conns) != g.anomalyConns {
+		g.anomalyConns = len(g.conns)
+		for _, c := range g.conns {
+			c.bitrateAnomaly = newBitrateAnomaly()
+		}
+	}
+	kbps := make([]float64, len(g.conns))
+	total := 0.0
+	for i, c := range g.conns {
+		if secs := now.Sub(c.anomalyAt).Seconds(); !c.anomalyAt.IsZero() && secs > 0 {
+			kbps[i] = float64(c.rxBytes-c.anomalyBytes) * 8 / 1000 / secs
+		}
+		c.anomalyBytes, c.anomalyAt = c.rxBytes, now
+		total += kbps[i]
+	}
+
+	var evs []pendingEvent
+	for i, c := range g.conns {
+		value := kbps[i]
+		if len(g.conns) > 1 {
+			if total == 0 {
+				continue // nothing to share, e.g. the encoder paused
+			}
+			value = 100 * kbps[i] / total
+		}
+		baseline := c.bitrateAnomaly.mean
+		z, change := c.bitrateAnomaly.add(value)
+		if change == 0 {
+			continue
+		}
+		label, _ := c.label()
+		if change > 0 {
+			log.Printf("[%s] [group %p] Link degrading: bitrate %.0f kbps, z %.1f", c.addr, g, kbps[i], z)
+		} else {
+			log.Printf("[%s] [group %p] Link recovered: bitrate %.0f kbps", c.addr, g, kbps[i])
+		}
+		fields := map[string]any{"group": fmt.Sprintf("%p", g), "addr": c.addr.String(), "kbps": int(kbps[i])}
+		if label != "" {
+			fields["label"] = label
+		}
+		evs = append(evs, anomalyEvent(change, "bitrate", value, baseline, z, fields))
+	}
+	return evs
+}
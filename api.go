@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("API: failed to encode response: %v", err)
+	}
+}
+
+// apiMux holds the API routes. Subsystems that only exist in some modes
+// register theirs on it before runAPIServer is started.
+var apiMux = http.NewServeMux()
+
+func runAPIServer(host string, port int) {
+	mux := apiMux
+	subscribeEvents(countEvent)
+	mux.HandleFunc("/api/diagnostics", handleDiagnostics)
+	mux.HandleFunc("/api/clock", handleClock)
+	mux.HandleFunc("/api/bitrate", handleBitrate)
+	mux.HandleFunc("GET /api/stream", handleStream)
+	mux.HandleFunc("GET /api/filters", handleFilters)
+	mux.HandleFunc("PUT /api/filters/{name}", requireControl(handleFilterConfig))
+	mux.HandleFunc("GET /api/toggles", handleToggles)
+	mux.HandleFunc("PUT /api/toggles/{name}", requireControl(handleToggleSet))
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("GET /api/v1/grafana-dashboard", handleGrafanaDashboard)
+	mux.HandleFunc("/api/integrity", handleIntegrity)
+	mux.HandleFunc("/api/metadata", requireControl(handleMetadata))
+	mux.HandleFunc("/api/location", requireControl(handleLocation))
+	mux.HandleFunc("PUT /api/location/privacy", requireControl(handleLocationPrivacy))
+	mux.HandleFunc("GET /api/sensors", handleSensors)
+	mux.HandleFunc("POST /api/sensors/{name}", requireControl(handleSensorReading))
+	mux.HandleFunc("GET /api/widgets", handleWidgets)
+	mux.HandleFunc("POST /api/widgets/{name}", handleWidgetRegister)
+	mux.HandleFunc("PUT /api/widgets/{name}/data", handleWidgetPush)
+	mux.HandleFunc("DELETE /api/widgets/{name}", handleWidgetRemove)
+	mux.HandleFunc("/api/uploads", handleUploads)
+	mux.HandleFunc("/api/markers", requireControl(handleMarkers))
+	mux.HandleFunc("GET /api/cluster", handleCluster)
+	mux.HandleFunc("GET /api/ddns", handleDDNS)
+	mux.HandleFunc("GET /api/endpoints", handleEndpoints)
+	mux.HandleFunc("GET /api/startup", handleStartup)
+	mux.HandleFunc("GET /api/i18n", handleI18n)
+	mux.HandleFunc("GET /api/links", handleLinks)
+	mux.HandleFunc("GET /api/labels", handleLabels)
+	mux.HandleFunc("PUT /api/labels", requireControl(handleLabelAssign))
+	mux.HandleFunc("GET /links", handleLinksPage)
+	mux.HandleFunc("GET /api/history", handleHistory)
+	mux.HandleFunc("GET /api/mediamtx", handleMediaMTX)
+	mux.HandleFunc("GET /stats", handleSLSStats)
+	mux.HandleFunc("GET /history", handleHistoryPage)
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	log.Printf("API server address: %s", webURL("http", host, port, "/api/"))
+
+	err := listenAndServe("api", addr, mux)
+	if err != nil {
+		fatalWithHint(err, listenSubject("tcp", port), "Failed to start API server: %v", err)
+	}
+}
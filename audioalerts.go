@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AudioAlertCooldown   = 15 * time.Second // the same cue is played at most this often
+	AudioAlertTTSTimeout = 30 * time.Second
+	AudioAlertRate       = 22050
+)
+
+type alertNote struct {
+	hz float64 // 0 is a pause
+	ms int
+}
+
+// audioCue is an alert the overlay can play. Its sound is rendered once
+// at startup: spoken by the -tts command, or as the tone sequence without
+// one, so the cues tell apart by ear even without speech.
+type audioCue struct {
+	name   string
+	events []string // that trigger the cue
+	tones  []alertNote
+	wav    []byte
+}
+
+var audioCues = []*audioCue{
+	{name: "link_down", events: []string{"bond.link_down", "conn.removed"}, tones: []alertNote{{880, 150}, {0, 60}, {660, 150}, {0, 60}, {440, 300}}},
+	{name: "link_up", events: []string{"bond.link_up"}, tones: []alertNote{{440, 120}, {0, 40}, {880, 180}}},
+	{name: "bitrate_low", events: []string{"stream.low_bitrate"}, tones: []alertNote{{740, 120}, {0, 80}, {740, 120}, {0, 80}, {740, 120}}},
+	{name: "bitrate_ok", events: []string{"stream.bitrate_recovered"}, tones: []alertNote{{587, 120}, {0, 40}, {740, 120}, {0, 40}, {880, 200}}},
+	{name: "stream_lost", events: []string{"stream.stopped"}, tones: []alertNote{{523, 250}, {0, 80}, {392, 250}, {0, 80}, {262, 500}}},
+	{name: "stream_back", events: []string{"stream.started"}, tones: []alertNote{{262, 150}, {0, 40}, {392, 150}, {0, 40}, {523, 300}}},
+	{name: "link_degrading", events: []string{"link.degrading"}, tones: []alertNote{{660, 200}, {0, 100}, {622, 200}, {0, 100}, {587, 300}}},
+	{name: "failover", events: []string{"srt.failover"}, tones: []alertNote{{660, 100}, {0, 50}, {990, 100}, {0, 50}, {660, 100}, {0, 50}, {990, 100}}},
+}
+
+var audioCuesReady sync.WaitGroup
+
+// audioAlertMessage asks the overlay to play a cue.
+type audioAlertMessage struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"` // always "audio_alert"
+	Cue       string    `json:"cue"`
+	Text      string    `json:"text"`
+	URL       string    `json:"url"` // relative to the Browser Source
+	Event     string    `json:"event"`
+}
+
+// startAudioAlerts renders the cues, with ttsCommand when it is set, and
+// publishes an audio_alert message whenever one of their events fires.
+func startAudioAlerts(ttsCommand string) {
+	msgs := messages(uiLang)
+	audioCuesReady.Add(1)
+	go func() {
+		defer audioCuesReady.Done()
+		spoken := 0
+		for _, c := range audioCues {
+			if ttsCommand != "" {
+				wav, err := renderSpeech(ttsCommand, msgs["alert."+c.name], uiLang)
+				if err == nil {
+					c.wav = wav
+					spoken++
+					continue
+				}
+				log.Printf("[alerts] Speaking %q failed, using tones: %v", c.name, err)
+			}
+			c.wav = renderTones(c.tones)
+		}
+		log.Printf("[alerts] %d audio cues ready, %d spoken", len(audioCues), spoken)
+	}()
+
+	var mu sync.Mutex
+	last := map[string]time.Time{}
+	subscribeEvents(func(ev event) {
+		for _, c := range audioCues {
+			for _, name := range c.events {
+				if name != ev.Name {
+					continue
+				}
+				mu.Lock()
+				if ev.Timestamp.Sub(last[c.name]) < AudioAlertCooldown {
+					mu.Unlock()
+					return
+				}
+				last[c.name] = ev.Timestamp
+				mu.Unlock()
+				publishMessage(audioAlertMessage{
+					Timestamp: ev.Timestamp,
+					Type:      "audio_alert",
+					Cue:       c.name,
+					Text:      msgs["alert."+c.name],
+					URL:       "alerts/" + c.name + ".wav",
+					Event:     ev.Name,
+				})
+				return
+			}
+		}
+	})
+}
+
+// renderSpeech runs the TTS command with GOIRL_TTS_TEXT, GOIRL_TTS_LANG
+// and GOIRL_TTS_OUT, the WAV file it must write.
+func renderSpeech(command, text, lang string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "goirl-tts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "cue.wav")
+
+	ctx, cancel := context.WithTimeout(context.Background(), AudioAlertTTSTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "GOIRL_TTS_TEXT="+text, "GOIRL_TTS_LANG="+lang, "GOIRL_TTS_OUT="+out)
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(msg)))
+	}
+	return os.ReadFile(out)
+}
+
+// renderTones synthesizes notes as a 16-bit mono WAV, with short fades
+// against clicks.
+func renderTones(notes []alertNote) []byte {
+	var pcm bytes.Buffer
+	fade := AudioAlertRate / 200 // 5 ms
+	for _, n := range notes {
+		samples := AudioAlertRate * n.ms / 1000
+		for i := 0; i < samples; i++ {
+			var v float64
+			if n.hz > 0 {
+				env := min(1, float64(i)/float64(fade), float64(samples-i)/float64(fade))
+				v = 0.5 * env * math.Sin(2*math.Pi*n.hz*float64(i)/AudioAlertRate)
+			}
+			binary.Write(&pcm, binary.LittleEndian, int16(v*math.MaxInt16))
+		}
+	}
+
+	var b bytes.Buffer
+	le := func(v any) { binary.Write(&b, binary.LittleEndian, v) }
+	b.WriteString("RIFF")
+	le(uint32(36 + pcm.Len()))
+	b.WriteString("WAVEfmt ")
+	le(uint32(16))
+	le(uint16(1)) // PCM
+	le(uint16(1)) // mono
+	le(uint32(AudioAlertRate))
+	le(uint32(AudioAlertRate * 2))
+	le(uint16(2))
+	le(uint16(16))
+	b.WriteString("data")
+	le(uint32(pcm.Len()))
+	b.Write(pcm.Bytes())
+	return b.Bytes()
+}
+
+// handleAudioCue serves /alerts/{cue}.wav.
+func handleAudioCue(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSuffix(r.PathValue("file"), ".wav")
+	audioCuesReady.Wait()
+	for _, c := range audioCues {
+		if c.name == name && c.wav != nil {
+			w.Header().Set("Content-Type", "audio/wav")
+			w.Header().Set("Cache-Control", "max-age=3600")
+			w.Write(c.wav)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
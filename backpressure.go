@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	BackpressureOff  = "off"
+	BackpressureAck  = "ack"  // withhold SRTLA ACKs
+	BackpressureHint = "hint" // send SRTLATypeCongestion packets
+
+	// A group counts as overloaded once its work queue is this full, and
+	// stops counting as overloaded when it has drained back down.
+	BackpressureHighWater = GroupWorkQueueLen * 3 / 4
+	BackpressureLowWater  = GroupWorkQueueLen / 4
+
+	CongestionHintPeriod = 200 * time.Millisecond
+)
+
+// backpressureMode is how overloaded groups signal their senders.
+var backpressureMode = BackpressureOff
+
+func parseBackpressureMode(s string) (string, error) {
+	switch s {
+	case BackpressureOff, BackpressureAck, BackpressureHint:
+		return s, nil
+	case "":
+		return BackpressureOff, nil
+	}
+	return "", fmt.Errorf("unknown -backpressure %q (expected %s, %s or %s)", s, BackpressureOff, BackpressureAck, BackpressureHint)
+}
+
+// withholdAcks reports whether SRTLA ACKs for the group are suppressed.
+// Senders size their per-link windows from the ACKs, so without them they
+// back off instead of retransmitting into a server that cannot keep up.
+func (g *Group) withholdAcks() bool {
+	return backpressureMode == BackpressureAck && g.overloaded.Load()
+}
+
+// updateBackpressure tracks whether the group's worker is falling behind,
+// from the depth of its work queue. Called by the group worker only.
+func (g *Group) updateBackpressure() {
+	if backpressureMode == BackpressureOff {
+		return
+	}
+	depth := len(g.work)
+	overloaded := g.overloaded.Load()
+	switch {
+	case !overloaded && depth >= BackpressureHighWater:
+		g.overloaded.Store(true)
+		log.Printf("[group %p] Overloaded (%d packets queued), signalling the sender (%s)", g, depth, backpressureMode)
+		emitEvent("group.overloaded", map[string]any{
+			"group":  fmt.Sprintf("%p", g),
+			"queued": depth,
+			"mode":   backpressureMode,
+		})
+	case overloaded && depth <= BackpressureLowWater:
+		g.overloaded.Store(false)
+		log.Printf("[group %p] No longer overloaded", g)
+		emitEvent("group.overload_cleared", map[string]any{"group": fmt.Sprintf("%p", g)})
+		if backpressureMode == BackpressureHint {
+			g.sendCongestionHint(0)
+			g.lastHint = time.Time{}
+		}
+		return
+	}
+
+	if backpressureMode == BackpressureHint && g.overloaded.Load() {
+		if now := clk.Now(); now.Sub(g.lastHint) >= CongestionHintPeriod {
+			g.lastHint = now
+			g.sendCongestionHint(depth * 100 / GroupWorkQueueLen)
+		}
+	}
+}
+
+// sendCongestionHint tells the sender on all connections how full the
+// group's queue is, in percent; 0 clears the congestion. The packet is
+// SRTLATypeCongestion followed by the level as a 16 bit value.
+func (g *Group) sendCongestionHint(level int) {
+	var pkt [4]byte
+	binary.BigEndian.PutUint16(pkt[0:], SRTLATypeCongestion)
+	binary.BigEndian.PutUint16(pkt[2:], uint16(level))
+
+	if err := writeToAll(srtlaSock, pkt[:], g.connAddrs()); err != nil {
+		log.Printf("[group %p] Failed to send the congestion hint: %v", g, err)
+	}
+}
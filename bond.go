@@ -0,0 +1,892 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BondWindowDef = 20 // initial congestion window per link, in packets
+	BondWindowMin = 1
+	BondWindowMax = 60
+
+	BondHousekeepingPeriod = 1 * time.Second
+	BondRegTimeout         = 5 * time.Second // give up on a pending registration
+	BondAckTimeout         = 1 * time.Second // forget in-flight packets without ACKs
+
+	BondSeqRing = 8192 // remembered sequence number -> link assignments
+
+	BondCapBurst = 250 * time.Millisecond // traffic a capped link may send in one burst
+
+	BondAutoLinks      = "auto"
+	BondDefaultExclude = "lo,docker*,veth*,br-*,virbr*,tun*,tap*,wg*"
+)
+
+// bondLink is one uplink of the bonding sender. A link is configured either
+// as a local IP or as an interface name; in the latter case its address is
+// re-resolved periodically so the link follows DHCP renewals and WiFi /
+// cellular handovers.
+type bondLink struct {
+	name  string // as configured (interface name or IP)
+	iface string // interface to follow, empty for a fixed IP
+	auto  bool   // discovered by the interface monitor, removed when it dies
+
+	capKbps   int     // bandwidth cap, 0 for none
+	weight    float64 // scheduling preference relative to the other links
+	capTokens float64 // token bucket enforcing capKbps, in bytes
+	capLast   time.Time
+	label     string          // sent to the server in label keepalives, see -labels
+	labelSent time.Time       // zero to send it with the next keepalive
+	impair    *linkImpairment // simulated link quality, see -demo
+	localIP   net.IP
+	conn      *net.UDPConn
+
+	ready    bool // REG3 received for conn
+	window   int
+	inflight int
+	lastRecv time.Time
+	lastAck  time.Time
+
+	kaSent     time.Time // last keepalive, zero once its echo arrived
+	rtt        time.Duration
+	rttAnomaly anomalyMetric
+
+	// make-before-break replacement: registered on the new address while
+	// the old conn keeps carrying traffic until REG3 arrives
+	pending      *net.UDPConn
+	pendingIP    net.IP
+	pendingSince time.Time
+}
+
+// bondSender is the sender side of SRTLA: it receives SRT from a local
+// encoder and spreads it over several uplinks towards a go-irl (or any
+// SRTLA) server, relaying the server's replies back to the encoder.
+type bondSender struct {
+	mu         sync.Mutex
+	server     *net.UDPAddr
+	serverName string // as named by the director, empty without -director
+	clientID   []byte
+	groupID    []byte
+	links      []*bondLink
+
+	auto    bool     // add every usable interface as a link
+	exclude []string // interface name patterns ignored in auto mode
+
+	// per link settings by name, kept so rediscovered links get them back
+	caps    map[string]int
+	weights map[string]float64
+	labels  map[string]string
+
+	local   *net.UDPConn // the encoder sends SRT here
+	encoder *net.UDPAddr // last address the encoder sent from
+
+	seqLink [BondSeqRing]*bondLink // which link carried a sequence number
+
+	congestion int // last congestion level reported by the server, in percent
+}
+
+// bondConfig holds the bond sender settings, as given on the command line.
+type bondConfig struct {
+	Server   string
+	Listen   string
+	Links    string
+	Exclude  string
+	Caps     string
+	Weights  string
+	Labels   string
+	Director string
+	Sender   string
+}
+
+// runBond implements the "bond" subcommand.
+func runBond(args []string) {
+	fs := flag.NewFlagSet("bond", flag.ExitOnError)
+	var cfg bondConfig
+	fs.StringVar(&cfg.Server, "server", "", "SRTLA server address (host:port)")
+	fs.StringVar(&cfg.Listen, "listen", "127.0.0.1:6000", "Local UDP address the SRT encoder sends to")
+	fs.StringVar(&cfg.Links, "links", "", "Comma separated uplinks: interface names (followed across address changes) or local IPs, \"auto\" adds every usable interface")
+	fs.StringVar(&cfg.Exclude, "exclude", BondDefaultExclude, "Comma separated interface name patterns ignored by -links=auto")
+	fs.StringVar(&cfg.Caps, "caps", "", "Comma separated per link bandwidth caps in kbps, e.g. usb1=2000")
+	fs.StringVar(&cfg.Weights, "weights", "", "Comma separated per link scheduling weights (default 1), e.g. eth0=4,usb1=0.5")
+	fs.StringVar(&cfg.Labels, "labels", "", "Comma separated per link names shown by the server, e.g. \"wwan0=Verizon,wlan0=Home WiFi\"")
+	fs.Float64Var(&anomalyZ, "anomaly-z", DefaultAnomalyZ, "Raise link.degrading when a link's RTT stays this many deviations above its baseline; 0 disables it")
+	fs.StringVar(&cfg.Director, "director", "", "URL of a go-irl director picking the ingest server with the lowest RTT, replaces -server")
+	fs.StringVar(&cfg.Sender, "sender", "", "Name reported to the director (default: hostname)")
+	wsPort := fs.Int("ws-port", 0, "WebSocket port publishing the link inventory, 0 disables it")
+	apiPort := fs.Int("api-port", 0, "Port for the HTTP API (link inventory and settings), 0 disables it")
+	apiHost := fs.String("api-host", "127.0.0.1", "Address the HTTP API binds to")
+	fs.StringVar(&controlToken, "control-token", "", "Token API clients send as a bearer token to change link settings; empty makes them read only")
+	fs.Parse(args)
+	if cfg.Links == "" {
+		log.Fatalf("ERROR: bond mode requires -links and either -server or -director")
+	}
+
+	b := newBondSender(cfg)
+
+	runStatsHub(*wsPort)
+	if *apiPort > 0 {
+		apiMux.HandleFunc("GET /api/bond/links", b.handleLinks)
+		apiMux.HandleFunc("PUT /api/bond/links/{name}", requireControl(b.handleLinkConfig))
+		go runAPIServer(*apiHost, *apiPort)
+	}
+
+	if cfg.Director != "" {
+		if cfg.Sender == "" {
+			cfg.Sender, _ = os.Hostname()
+		}
+		ready := make(chan struct{})
+		go supervise("bond-director", func() { b.runDirector(newDirectorClient(cfg.Director, cfg.Sender), ready) })
+		<-ready
+	}
+	b.start()
+	waitForSignal()
+}
+
+// newBondSender sets up a bond sender from cfg, exiting on invalid
+// settings. The encoder socket is bound right away, the links are brought
+// up by start.
+func newBondSender(cfg bondConfig) *bondSender {
+	if (cfg.Server == "") == (cfg.Director == "") {
+		log.Fatalf("ERROR: bond mode requires -links and either -server or -director")
+	}
+
+	var raddr *net.UDPAddr
+	var err error
+	if cfg.Server != "" {
+		if raddr, err = net.ResolveUDPAddr("udp", cfg.Server); err != nil {
+			log.Fatalf("ERROR: failed to resolve -server: %v", err)
+		}
+	}
+	laddr, err := net.ResolveUDPAddr("udp", cfg.Listen)
+	if err != nil {
+		log.Fatalf("ERROR: failed to resolve -listen: %v", err)
+	}
+	local, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		log.Fatalf("ERROR: failed to listen on %s: %v", laddr, err)
+	}
+
+	b := &bondSender{
+		server:   raddr,
+		clientID: randomBytes(SRTLAIDLen / 2),
+		local:    local,
+		caps:     map[string]int{},
+		weights:  map[string]float64{},
+		labels:   parseLinkLabels(cfg.Labels),
+	}
+	capValues, err := parseLinkValues(cfg.Caps)
+	if err != nil {
+		log.Fatalf("ERROR: invalid -caps: %v", err)
+	}
+	for name, v := range capValues {
+		if v < 0 {
+			log.Fatalf("ERROR: invalid -caps: negative cap for %s", name)
+		}
+		b.caps[name] = int(v)
+	}
+	if b.weights, err = parseLinkValues(cfg.Weights); err != nil {
+		log.Fatalf("ERROR: invalid -weights: %v", err)
+	}
+	for name, v := range b.weights {
+		if v <= 0 {
+			log.Fatalf("ERROR: invalid -weights: weight for %s must be positive", name)
+		}
+	}
+	for _, name := range strings.Split(cfg.Links, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "":
+		case BondAutoLinks:
+			b.auto = true
+		default:
+			b.addLink(name)
+		}
+	}
+	for _, pattern := range strings.Split(cfg.Exclude, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			b.exclude = append(b.exclude, pattern)
+		}
+	}
+	return b
+}
+
+func (b *bondSender) start() {
+	log.Printf("[bond] Encoder input srt://%s  SRTLA server %s  %d links", b.local.LocalAddr(), b.server, len(b.links))
+
+	go supervise("bond-housekeeping", b.housekeeping)
+	go supervise("bond-encoder", b.readEncoder)
+}
+
+func (b *bondSender) addLink(name string) {
+	b.mu.Lock()
+	l := b.newLink(name)
+	if ip := net.ParseIP(name); ip != nil {
+		l.localIP = ip
+	} else {
+		l.iface = name
+	}
+	b.links = append(b.links, l)
+	b.mu.Unlock()
+}
+
+// newLink must be called with b.mu held.
+func (b *bondSender) newLink(name string) *bondLink {
+	l := &bondLink{name: name, window: BondWindowDef, capKbps: b.caps[name], weight: 1, label: b.labels[name], rttAnomaly: newRTTAnomaly()}
+	if w, ok := b.weights[name]; ok {
+		l.weight = w
+	}
+	return l
+}
+
+// parseLinkValues parses "name=value,name=value". The value is split off at
+// the last '=' so IPv6 link names work too.
+func parseLinkValues(s string) (map[string]float64, error) {
+	values := map[string]float64{}
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		i := strings.LastIndex(kv, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not name=value", kv)
+		}
+		v, err := strconv.ParseFloat(kv[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", kv, err)
+		}
+		values[kv[:i]] = v
+	}
+	return values, nil
+}
+
+// parseLinkLabels parses "name=label,name=label".
+func parseLinkLabels(s string) map[string]string {
+	labels := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		if name, label, ok := strings.Cut(kv, "="); ok && strings.TrimSpace(name) != "" {
+			labels[strings.TrimSpace(name)] = cleanLabel(label)
+		}
+	}
+	return labels
+}
+
+// interfaceIP returns the first address of iface usable to reach server.
+var interfaceIP = func(iface string, server *net.UDPAddr) (net.IP, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	if ifi.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("interface %s is down", iface)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	wantV4 := server.IP.To4() != nil
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if (ipn.IP.To4() != nil) == wantV4 {
+			return ipn.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no usable address", iface)
+}
+
+func (b *bondSender) dial(ip net.IP) (*net.UDPConn, error) {
+	conn, err := net.DialUDP("udp", &net.UDPAddr{IP: ip}, b.server)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetReadBuffer(recvBufSize)
+	_ = conn.SetWriteBuffer(sendBufSize)
+	go supervise("bond-link", func() { b.readLink(conn) })
+	return conn, nil
+}
+
+func (b *bondSender) sendReg2(conn *net.UDPConn) {
+	if b.groupID == nil {
+		return
+	}
+	pkt := make([]byte, SRTLAReg2Len)
+	binary.BigEndian.PutUint16(pkt[:2], SRTLATypeReg2)
+	copy(pkt[2:], b.groupID)
+	conn.Write(pkt)
+}
+
+// housekeeping registers the group and links, follows interface address
+// changes and sends keepalives. Interface notifications (netlink on Linux)
+// trigger an immediate pass so hot-plugged modems join without delay.
+func (b *bondSender) housekeeping() {
+	changes, err := watchInterfaces()
+	if err != nil {
+		log.Printf("[bond] Interface monitor unavailable, polling every %s: %v", BondHousekeepingPeriod, err)
+	}
+	ticker := time.NewTicker(BondHousekeepingPeriod)
+	defer ticker.Stop()
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		if b.auto {
+			b.discoverLinks()
+		}
+		kept := b.links[:0]
+		for _, l := range b.links {
+			if !b.refreshLink(l, now) && l.auto {
+				log.Printf("[bond] [%s] Uplink gone, removing it", l.name)
+				emitEvent("bond.link_removed", map[string]any{"link": l.name})
+				b.dropLink(l)
+				continue
+			}
+			kept = append(kept, l)
+		}
+		for i := len(kept); i < len(b.links); i++ {
+			b.links[i] = nil
+		}
+		b.links = kept
+		if b.groupID == nil {
+			b.sendReg1()
+		}
+		inventory := b.inventory(now)
+		b.mu.Unlock()
+
+		publishMessage(inventory)
+
+		select {
+		case <-ticker.C:
+		case <-changes:
+		}
+	}
+}
+
+// discoverLinks adds every up, non-excluded interface with an address usable
+// to reach the server that is not a link yet. Must be called with b.mu held.
+func (b *bondSender) discoverLinks() {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		log.Printf("[bond] Failed to list interfaces: %v", err)
+		return
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 || b.excluded(ifi.Name) || b.hasLink(ifi.Name) {
+			continue
+		}
+		if _, err := interfaceIP(ifi.Name, b.server); err != nil {
+			continue
+		}
+		log.Printf("[bond] [%s] New uplink detected", ifi.Name)
+		emitEvent("bond.link_added", map[string]any{"link": ifi.Name})
+		l := b.newLink(ifi.Name)
+		l.iface, l.auto = ifi.Name, true
+		b.links = append(b.links, l)
+	}
+}
+
+func (b *bondSender) excluded(iface string) bool {
+	for _, pattern := range b.exclude {
+		if ok, _ := path.Match(pattern, iface); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLink must be called with b.mu held.
+func (b *bondSender) hasLink(iface string) bool {
+	for _, l := range b.links {
+		if l.iface == iface {
+			return true
+		}
+	}
+	return false
+}
+
+type bondLinkStatus struct {
+	Name          string  `json:"name"`
+	Label         string  `json:"label,omitempty"`
+	Addr          string  `json:"addr,omitempty"`
+	Auto          bool    `json:"auto"`
+	Ready         bool    `json:"ready"`
+	Window        int     `json:"window"`
+	Inflight      int     `json:"inflight"`
+	CapKbps       int     `json:"capKbps"`
+	Weight        float64 `json:"weight"`
+	IdleSeconds   float64 `json:"idleSeconds"`
+	RTTMs         float64 `json:"rttMs"`
+	Reregistering bool    `json:"reregistering"`
+}
+
+type bondLinksMessage struct {
+	Timestamp time.Time        `json:"timestamp"`
+	Type      string           `json:"type"` // "bond_links"
+	Links     []bondLinkStatus `json:"links"`
+}
+
+// inventory must be called with b.mu held.
+func (b *bondSender) inventory(now time.Time) bondLinksMessage {
+	msg := bondLinksMessage{Timestamp: now, Type: "bond_links", Links: []bondLinkStatus{}}
+	for _, l := range b.links {
+		st := bondLinkStatus{
+			Name:          l.name,
+			Label:         l.label,
+			Auto:          l.auto,
+			Ready:         l.ready,
+			Window:        l.window,
+			Inflight:      l.inflight,
+			CapKbps:       l.capKbps,
+			Weight:        l.weight,
+			Reregistering: l.pending != nil,
+		}
+		if l.conn != nil {
+			st.Addr = l.localIP.String()
+			st.IdleSeconds = now.Sub(l.lastRecv).Seconds()
+			st.RTTMs = float64(l.rtt.Microseconds()) / 1000
+		}
+		msg.Links = append(msg.Links, st)
+	}
+	return msg
+}
+
+func (b *bondSender) handleLinks(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	inventory := b.inventory(time.Now())
+	b.mu.Unlock()
+	writeJSON(w, inventory)
+}
+
+type bondLinkConfig struct {
+	CapKbps *int     `json:"capKbps"`
+	Weight  *float64 `json:"weight"`
+	Label   *string  `json:"label"`
+}
+
+// handleLinkConfig changes a link's cap, weight and/or label while
+// streaming.
+func (b *bondSender) handleLinkConfig(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var cfg bondLinkConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cfg.CapKbps != nil && *cfg.CapKbps < 0 {
+		http.Error(w, "capKbps must not be negative", http.StatusBadRequest)
+		return
+	}
+	if cfg.Weight != nil && *cfg.Weight <= 0 {
+		http.Error(w, "weight must be positive", http.StatusBadRequest)
+		return
+	}
+
+	b.mu.Lock()
+	var link *bondLink
+	for _, l := range b.links {
+		if l.name == name {
+			link = l
+		}
+	}
+	if link == nil {
+		b.mu.Unlock()
+		http.Error(w, "unknown link "+name, http.StatusNotFound)
+		return
+	}
+	if cfg.CapKbps != nil {
+		link.capKbps = *cfg.CapKbps
+		b.caps[name] = *cfg.CapKbps
+	}
+	if cfg.Weight != nil {
+		link.weight = *cfg.Weight
+		b.weights[name] = *cfg.Weight
+	}
+	if cfg.Label != nil {
+		link.label, link.labelSent = cleanLabel(*cfg.Label), time.Time{}
+		b.labels[name] = link.label
+	}
+	capKbps, weight, label := link.capKbps, link.weight, link.label
+	inventory := b.inventory(time.Now())
+	b.mu.Unlock()
+
+	log.Printf("[bond] [%s] Cap %d kbps, weight %.2f, label %q", name, capKbps, weight, label)
+	emitEvent("bond.link_config", map[string]any{"link": name, "capKbps": capKbps, "weight": weight, "label": label})
+	writeJSON(w, inventory)
+}
+
+// refreshLink must be called with b.mu held. It returns false when the
+// link's interface has no usable address.
+func (b *bondSender) refreshLink(l *bondLink, now time.Time) bool {
+	ip := l.localIP
+	if l.iface != "" {
+		var err error
+		if ip, err = interfaceIP(l.iface, b.server); err != nil {
+			if l.conn != nil {
+				log.Printf("[bond] [%s] Link lost: %v", l.name, err)
+				emitEvent("bond.link_down", map[string]any{"link": l.name, "error": err.Error()})
+				b.dropLink(l)
+			}
+			return false
+		}
+	}
+
+	switch {
+	case l.conn == nil:
+		conn, err := b.dial(ip)
+		if err != nil {
+			log.Printf("[bond] [%s] Failed to open socket on %s: %v", l.name, ip, err)
+			return true
+		}
+		l.conn, l.localIP, l.ready, l.lastRecv = conn, ip, false, now
+		log.Printf("[bond] [%s] Link up on %s", l.name, ip)
+		b.sendReg2(conn)
+
+	case !ip.Equal(l.localIP) && l.pending == nil:
+		// Make before break: register the new path first and keep sending
+		// on the old one until the server confirmed the new one.
+		conn, err := b.dial(ip)
+		if err != nil {
+			log.Printf("[bond] [%s] Failed to open socket on new address %s: %v", l.name, ip, err)
+			return true
+		}
+		l.pending, l.pendingIP, l.pendingSince = conn, ip, now
+		log.Printf("[bond] [%s] Address changed %s -> %s, registering new path", l.name, l.localIP, ip)
+		b.sendReg2(conn)
+
+	case l.pending != nil:
+		if !ip.Equal(l.pendingIP) {
+			// The interface flapped again before the new path registered
+			log.Printf("[bond] [%s] Address changed again (%s), abandoning new path %s", l.name, ip, l.pendingIP)
+			l.pending.Close()
+			l.pending = nil
+		} else if now.Sub(l.pendingSince) > BondRegTimeout {
+			log.Printf("[bond] [%s] New path %s did not register in time, retrying", l.name, l.pendingIP)
+			l.pending.Close()
+			l.pending = nil
+		} else {
+			b.sendReg2(l.pending)
+		}
+	}
+
+	if l.conn == nil {
+		return true
+	}
+	if now.Sub(l.lastRecv) >= ConnTimeout {
+		if l.ready {
+			log.Printf("[bond] [%s] Link timed out, re-registering", l.name)
+		}
+		l.ready = false
+	}
+	if !l.ready {
+		b.sendReg2(l.conn)
+	}
+	if now.Sub(l.lastAck) >= BondAckTimeout {
+		l.inflight = 0
+	}
+
+	if l.ready && l.label != "" && now.Sub(l.labelSent) >= BondLabelPeriod {
+		l.impair.write(l.conn, labelKeepalive(l.label))
+		l.labelSent, l.kaSent = now, now
+		return true
+	}
+	var ka [2]byte
+	binary.BigEndian.PutUint16(ka[:], SRTLATypeKeepalive)
+	l.impair.write(l.conn, ka[:])
+	l.kaSent = now
+	return true
+}
+
+// dropLink must be called with b.mu held.
+func (b *bondSender) dropLink(l *bondLink) {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+	if l.pending != nil {
+		l.pending.Close()
+		l.pending = nil
+	}
+	l.ready = false
+	l.inflight = 0
+	l.window = BondWindowDef
+}
+
+// sendReg1 must be called with b.mu held.
+func (b *bondSender) sendReg1() {
+	for _, l := range b.links {
+		if l.conn == nil {
+			continue
+		}
+		pkt := make([]byte, SRTLAReg1Len)
+		binary.BigEndian.PutUint16(pkt[:2], SRTLATypeReg1)
+		copy(pkt[2:], b.clientID)
+		l.conn.Write(pkt)
+		return
+	}
+}
+
+// linkFor returns the link owning conn (as its live or pending socket).
+// Must be called with b.mu held.
+func (b *bondSender) linkFor(conn *net.UDPConn) *bondLink {
+	for _, l := range b.links {
+		if l.conn == conn || l.pending == conn {
+			return l
+		}
+	}
+	return nil
+}
+
+func (b *bondSender) readLink(conn *net.UDPConn) {
+	buf := make([]byte, MTU)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			b.mu.Lock()
+			owned := b.linkFor(conn) != nil
+			b.mu.Unlock()
+			if !owned {
+				return // closed by a handover or link removal
+			}
+			// ICMP errors surface as read errors on connected sockets;
+			// the link will time out if the path stays broken
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		b.handleLinkPacket(conn, buf[:n])
+	}
+}
+
+func (b *bondSender) handleLinkPacket(conn *net.UDPConn, pkt []byte) {
+	b.mu.Lock()
+	l := b.linkFor(conn)
+	if l == nil {
+		b.mu.Unlock()
+		return
+	}
+	now := time.Now()
+
+	switch getSRTType(pkt) {
+	case SRTLATypeReg2:
+		if id := srtlaRegID(pkt, SRTLATypeReg2); id != nil && b.groupID == nil {
+			b.groupID = append([]byte(nil), id...)
+			log.Printf("[bond] Group registered")
+			for _, ll := range b.links {
+				if ll.conn != nil {
+					b.sendReg2(ll.conn)
+				}
+			}
+		}
+		b.mu.Unlock()
+		return
+
+	case SRTLATypeReg3:
+		if conn == l.pending {
+			old := l.conn
+			l.conn, l.localIP, l.pending = l.pending, l.pendingIP, nil
+			l.ready, l.lastRecv, l.labelSent = true, now, time.Time{}
+			l.rttAnomaly = newRTTAnomaly() // a new path
+			if old != nil {
+				old.Close()
+			}
+			log.Printf("[bond] [%s] Handover to %s complete", l.name, l.localIP)
+			emitEvent("bond.link_handover", map[string]any{"link": l.name, "addr": l.localIP.String()})
+		} else if !l.ready {
+			l.ready, l.lastRecv, l.labelSent = true, now, time.Time{}
+			log.Printf("[bond] [%s] Link registered", l.name)
+			emitEvent("bond.link_up", map[string]any{"link": l.name, "addr": l.localIP.String()})
+		}
+		b.mu.Unlock()
+		return
+
+	case SRTLATypeRegNGP:
+		// The server forgot our group: start over with REG1
+		log.Printf("[bond] [%s] Server has no group for us, re-registering", l.name)
+		b.groupID = nil
+		for _, ll := range b.links {
+			ll.ready = false
+		}
+		b.mu.Unlock()
+		return
+
+	case SRTLATypeRegErr:
+		log.Printf("[bond] [%s] Registration rejected by server", l.name)
+		if conn == l.conn {
+			l.ready = false
+		}
+		b.mu.Unlock()
+		return
+	}
+
+	if conn != l.conn {
+		b.mu.Unlock()
+		return
+	}
+	l.lastRecv = now
+
+	switch getSRTType(pkt) {
+	case SRTLATypeKeepalive:
+		if l.kaSent.IsZero() {
+			b.mu.Unlock()
+			return
+		}
+		l.rtt, l.kaSent = now.Sub(l.kaSent), time.Time{}
+		ms := float64(l.rtt.Microseconds()) / 1000
+		baseline := l.rttAnomaly.mean
+		z, change := l.rttAnomaly.add(ms)
+		name, label := l.name, l.label
+		b.mu.Unlock()
+		if change != 0 {
+			if change > 0 {
+				log.Printf("[bond] [%s] Link degrading: RTT %.0f ms, baseline %.0f ms", name, ms, baseline)
+			} else {
+				log.Printf("[bond] [%s] Link recovered: RTT %.0f ms", name, ms)
+			}
+			fields := map[string]any{"link": name}
+			if label != "" {
+				fields["label"] = label
+			}
+			ev := anomalyEvent(change, "rtt", ms, baseline, z, fields)
+			emitEvent(ev.name, ev.fields)
+		}
+		return
+	case SRTLATypeCongestion:
+		if len(pkt) < 4 {
+			b.mu.Unlock()
+			return
+		}
+		level := int(binary.BigEndian.Uint16(pkt[2:]))
+		changed := (level > 0) != (b.congestion > 0)
+		b.congestion = level
+		b.mu.Unlock()
+		if changed {
+			reportCongestion(level)
+		}
+		return
+	case SRTLATypeACK:
+		acked := (len(pkt) - 4) / 4
+		if acked > 0 {
+			l.inflight -= acked
+			if l.inflight < 0 {
+				l.inflight = 0
+			}
+			l.window += acked
+			if l.window > BondWindowMax {
+				l.window = BondWindowMax
+			}
+			l.lastAck = now
+		}
+		b.mu.Unlock()
+		return
+	case SRTTypeNAK:
+		// The server broadcasts NAKs on every link; only the copy arriving
+		// on the link that carried the lost packet shrinks its window.
+		if len(pkt) >= SRTMinLen+4 {
+			sn := binary.BigEndian.Uint32(pkt[SRTMinLen:]) & 0x7fffffff
+			if b.seqLink[sn%BondSeqRing] == l {
+				l.window -= 10
+				if l.window < BondWindowMin {
+					l.window = BondWindowMin
+				}
+			}
+		}
+	}
+	encoder := b.encoder
+	b.mu.Unlock()
+
+	// Everything else is SRT from the server, relayed to the encoder
+	if encoder != nil {
+		b.local.WriteToUDP(pkt, encoder)
+	}
+}
+
+type bondCongestionMessage struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"` // "congestion"
+	Level     int       `json:"level"`
+}
+
+// reportCongestion announces that the server started or stopped reporting
+// congestion. The encoder behind the bond speaks plain SRT and would not
+// understand the hint, so it goes out as an event and a WebSocket message
+// for whatever controls the encoder bitrate.
+func reportCongestion(level int) {
+	if level > 0 {
+		log.Printf("[bond] Server is overloaded (queue %d%% full), lower the encoder bitrate", level)
+	} else {
+		log.Printf("[bond] Server congestion cleared")
+	}
+	emitEvent("bond.congestion", map[string]any{"level": level})
+	publishMessage(bondCongestionMessage{Timestamp: time.Now(), Type: "congestion", Level: level})
+}
+
+// capAllows reports whether a packet of size bytes fits into the link's
+// bandwidth cap. Must be called with b.mu held.
+func (l *bondLink) capAllows(size int, now time.Time) bool {
+	if l.capKbps <= 0 {
+		return true
+	}
+	rate := float64(l.capKbps) * 1000 / 8
+	burst := max(rate*BondCapBurst.Seconds(), MTU)
+	l.capTokens = min(burst, l.capTokens+now.Sub(l.capLast).Seconds()*rate)
+	l.capLast = now
+	return l.capTokens >= float64(size)
+}
+
+// selectLink picks the ready link with the most free window, scaled by its
+// weight, among those with room under their cap for size bytes. Must be
+// called with b.mu held.
+func (b *bondSender) selectLink(size int, now time.Time) *bondLink {
+	var best *bondLink
+	bestScore := -1.0
+	for _, l := range b.links {
+		if !l.ready || l.conn == nil || !l.capAllows(size, now) {
+			continue
+		}
+		score := l.weight * float64(l.window) / float64(l.inflight+1)
+		if score > bestScore {
+			best, bestScore = l, score
+		}
+	}
+	return best
+}
+
+func (b *bondSender) readEncoder() {
+	buf := make([]byte, MTU)
+	for {
+		n, addr, err := b.local.ReadFromUDP(buf)
+		if err != nil {
+			log.Printf("[bond] Encoder read error: %v", err)
+			continue
+		}
+		pkt := buf[:n]
+
+		b.mu.Lock()
+		b.encoder = addr
+		l := b.selectLink(n, time.Now())
+		if l == nil {
+			b.mu.Unlock()
+			continue
+		}
+		if l.capKbps > 0 {
+			l.capTokens -= float64(n)
+		}
+		if sn := getSRTSN(pkt); sn >= 0 {
+			l.inflight++
+			b.seqLink[uint32(sn)%BondSeqRing] = l
+		}
+		conn, impair := l.conn, l.impair
+		b.mu.Unlock()
+
+		impair.write(conn, pkt)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bondHarness runs a bond sender with one uplink, "wwan0", whose address
+// the test changes at will, against a loopback UDP socket standing in for
+// the SRTLA server. The uplink addresses are loopback aliases, so the
+// server sees every path by its own source address.
+type bondHarness struct {
+	t   *testing.T
+	b   *bondSender
+	srv *net.UDPConn
+
+	mu sync.Mutex
+	ip net.IP // the uplink's address, nil while it is down
+}
+
+func newBondHarness(t *testing.T) *bondHarness {
+	for _, ip := range []string{"127.0.0.2", "127.0.0.3"} {
+		c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip)})
+		if err != nil {
+			t.Skipf("no loopback alias %s to flap to: %v", ip, err)
+		}
+		c.Close()
+	}
+	srv, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &bondHarness{t: t, srv: srv}
+	h.b = &bondSender{
+		server:   srv.LocalAddr().(*net.UDPAddr),
+		clientID: bytes.Repeat([]byte{0xab}, SRTLAIDLen/2),
+		groupID:  bytes.Repeat([]byte{0xcd}, SRTLAIDLen), // registered already
+		caps:     map[string]int{},
+		weights:  map[string]float64{},
+		labels:   map[string]string{},
+	}
+	h.b.addLink("wwan0")
+
+	saved := interfaceIP
+	interfaceIP = func(iface string, server *net.UDPAddr) (net.IP, error) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.ip == nil {
+			return nil, fmt.Errorf("interface %s is down", iface)
+		}
+		return h.ip, nil
+	}
+	t.Cleanup(func() {
+		h.b.mu.Lock()
+		for _, l := range h.b.links {
+			h.b.dropLink(l)
+		}
+		h.b.mu.Unlock()
+		interfaceIP = saved
+		srv.Close()
+	})
+	return h
+}
+
+func (h *bondHarness) link() *bondLink { return h.b.links[0] }
+
+// setIP moves the uplink to ip, or takes it down for "".
+func (h *bondHarness) setIP(ip string) {
+	h.mu.Lock()
+	h.ip = net.ParseIP(ip)
+	h.mu.Unlock()
+}
+
+// refresh runs the housekeeping pass over the uplink at now.
+func (h *bondHarness) refresh(now time.Time) {
+	h.b.mu.Lock()
+	h.b.refreshLink(h.link(), now)
+	h.b.mu.Unlock()
+}
+
+// expect returns the source of the next packet of type typ the server
+// receives from ip, skipping the others.
+func (h *bondHarness) expect(typ uint16, ip string) *net.UDPAddr {
+	h.t.Helper()
+	buf := make([]byte, MTU)
+	h.srv.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, from, err := h.srv.ReadFromUDP(buf)
+		if err != nil {
+			h.t.Fatalf("waiting for packet type 0x%04x from %s: %v", typ, ip, err)
+		}
+		if getSRTType(buf[:n]) == typ && from.IP.Equal(net.ParseIP(ip)) {
+			return from
+		}
+	}
+}
+
+// reg3 answers a registration the way the server does once it accepted it.
+func (h *bondHarness) reg3(to *net.UDPAddr) {
+	h.t.Helper()
+	var pkt [2]byte
+	binary.BigEndian.PutUint16(pkt[:], SRTLATypeReg3)
+	if _, err := h.srv.WriteToUDP(pkt[:], to); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// state returns the uplink's live and pending sockets and whether it is
+// ready, under the sender's lock.
+func (h *bondHarness) state() (conn, pending *net.UDPConn, ready bool) {
+	h.b.mu.Lock()
+	defer h.b.mu.Unlock()
+	l := h.link()
+	return l.conn, l.pending, l.ready
+}
+
+// up brings the uplink up on ip and lets the server register it.
+func (h *bondHarness) up(ip string, now time.Time) *net.UDPConn {
+	h.t.Helper()
+	h.setIP(ip)
+	h.refresh(now)
+	h.reg3(h.expect(SRTLATypeReg2, ip))
+	eventually(h.t, func() bool { _, _, ready := h.state(); return ready }, "the link to register")
+	conn, _, _ := h.state()
+	return conn
+}
+
+// socketClosed reports whether conn was closed, without sending on it.
+func socketClosed(conn *net.UDPConn) bool {
+	return errors.Is(conn.SetWriteDeadline(time.Time{}), net.ErrClosed)
+}
+
+// TestBondFlapMakeBeforeBreak moves an uplink to a new address: the new path
+// has to be registered while the old one keeps carrying traffic, and the old
+// one may only go once the server confirmed the new one with REG3.
+func TestBondFlapMakeBeforeBreak(t *testing.T) {
+	h := newBondHarness(t)
+	now := time.Now()
+	old := h.up("127.0.0.1", now)
+
+	h.setIP("127.0.0.2")
+	h.refresh(now.Add(time.Second))
+	newPath := h.expect(SRTLATypeReg2, "127.0.0.2")
+	h.expect(SRTLATypeKeepalive, "127.0.0.1") // the old path is kept alive
+	conn, pending, ready := h.state()
+	if conn != old || pending == nil || !ready || socketClosed(old) {
+		t.Fatal("the old path went down before the new one registered")
+	}
+	h.b.mu.Lock()
+	selected := h.b.selectLink(100, now)
+	reregistering := h.b.inventory(now).Links[0].Reregistering
+	h.b.mu.Unlock()
+	if selected == nil || !reregistering {
+		t.Fatalf("link selected %v, reregistering %v during the handover", selected != nil, reregistering)
+	}
+
+	h.reg3(newPath)
+	eventually(t, func() bool { conn, _, _ := h.state(); return conn == pending }, "the handover")
+	if _, pending, ready := h.state(); pending != nil || !ready {
+		t.Fatalf("pending %v, ready %v after the handover", pending != nil, ready)
+	}
+	if !socketClosed(old) {
+		t.Fatal("the old path is still open after the handover")
+	}
+	if !h.link().localIP.Equal(net.IPv4(127, 0, 0, 2)) {
+		t.Fatalf("link address %s after the handover", h.link().localIP)
+	}
+}
+
+// TestBondFlapAgainBeforeRegistered moves the uplink twice in a row: the
+// first new path is abandoned unregistered, the old one stays until the
+// second registers.
+func TestBondFlapAgainBeforeRegistered(t *testing.T) {
+	h := newBondHarness(t)
+	now := time.Now()
+	old := h.up("127.0.0.1", now)
+
+	h.setIP("127.0.0.2")
+	h.refresh(now.Add(time.Second))
+	h.expect(SRTLATypeReg2, "127.0.0.2")
+	_, abandoned, _ := h.state()
+
+	h.setIP("127.0.0.3")
+	h.refresh(now.Add(2 * time.Second))
+	if conn, pending, ready := h.state(); conn != old || pending != nil || !ready || !socketClosed(abandoned) {
+		t.Fatal("flapping again did not abandon the unregistered path for the old one")
+	}
+	h.refresh(now.Add(3 * time.Second))
+	newPath := h.expect(SRTLATypeReg2, "127.0.0.3")
+	if conn, _, _ := h.state(); conn != old || socketClosed(old) {
+		t.Fatal("the old path went down before the new one registered")
+	}
+
+	h.reg3(newPath)
+	eventually(t, func() bool { conn, _, _ := h.state(); return conn != old }, "the handover")
+	if !socketClosed(old) {
+		t.Fatal("the old path is still open after the handover")
+	}
+}
+
+// TestBondFlapRegTimeout retries a new path the server doesn't confirm,
+// keeping the old one meanwhile.
+func TestBondFlapRegTimeout(t *testing.T) {
+	h := newBondHarness(t)
+	now := time.Now()
+	old := h.up("127.0.0.1", now)
+
+	h.setIP("127.0.0.2")
+	h.refresh(now)
+	h.expect(SRTLATypeReg2, "127.0.0.2")
+	_, first, _ := h.state()
+
+	h.refresh(now.Add(BondRegTimeout + time.Second))
+	if conn, pending, _ := h.state(); conn != old || pending != nil || !socketClosed(first) || socketClosed(old) {
+		t.Fatal("an unconfirmed path was not given up for the old one")
+	}
+	h.refresh(now.Add(BondRegTimeout + 2*time.Second))
+	h.reg3(h.expect(SRTLATypeReg2, "127.0.0.2"))
+	eventually(t, func() bool { conn, _, _ := h.state(); return conn != old }, "the retried handover")
+}
+
+// TestBondInterfaceDownUp takes the uplink's interface away and brings it
+// back on another address: with no old path left to keep, the link starts
+// over and registers again.
+func TestBondInterfaceDownUp(t *testing.T) {
+	h := newBondHarness(t)
+	now := time.Now()
+	old := h.up("127.0.0.1", now)
+
+	h.setIP("")
+	h.refresh(now.Add(time.Second))
+	if conn, _, ready := h.state(); conn != nil || ready || !socketClosed(old) {
+		t.Fatal("the link stayed up without its interface")
+	}
+	if len(h.b.links) != 1 {
+		t.Fatal("a configured link was removed with its interface")
+	}
+
+	if fresh := h.up("127.0.0.2", now.Add(2*time.Second)); fresh == old {
+		t.Fatal("the link came back on its closed socket")
+	}
+}
@@ -5,12 +5,19 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync/atomic"
 )
 
 //go:embed frontend/dist/index.html
 var browserSourceHtml []byte
 
+// browserSourceEnabled is switched through the API; while it is off the
+// Browser Source server answers 503, and OBS shows nothing after a reload.
+var browserSourceEnabled atomic.Bool
+
 func runBrowserSource(port int) {
+	browserSourceEnabled.Store(true)
+	registerToggle("browser-source", "the Browser Source server", browserSourceEnabled.Load, setBrowserSourceEnabled)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/app" {
@@ -20,10 +27,42 @@ func runBrowserSource(port int) {
 		}
 	})
 
-	log.Printf("Browser Source address: http://127.0.0.1:%d/app\n", port)
+	mux.HandleFunc("/theme.css", handleThemeCSS)
+	mux.HandleFunc("/i18n.json", handleI18n)
+	mux.HandleFunc("GET /obs-setup", handleOBSSetup)
+	mux.HandleFunc("POST /obs-setup", handleOBSApply)
+	if *audioAlerts {
+		mux.HandleFunc("GET /alerts/{file}", handleAudioCue)
+	}
+
+	if timeshift {
+		registerTimeshiftRoutes(mux)
+		log.Printf("Timeshift playlist: %s", webURL("http", "127.0.0.1", port, "/timeshift.m3u8?delay=60"))
+	}
+	if *previewInterval > 0 {
+		mux.HandleFunc("/preview.jpg", handlePreview)
+		log.Printf("Stream preview: %s", webURL("http", "127.0.0.1", port, "/preview.jpg"))
+	}
+
+	log.Printf("Browser Source address: %s\n", webURL("http", "127.0.0.1", port, "/app"))
 
-	err := http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", port), mux)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !browserSourceEnabled.Load() {
+			http.Error(w, "the Browser Source is disabled", http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+	err := listenAndServe("browser_source", fmt.Sprintf("127.0.0.1:%d", port), handler)
 	if err != nil {
-		log.Fatalf("Failed to start Browser Source server: %v", err)
+		fatalWithHint(err, listenSubject("tcp", port), "Failed to start Browser Source server: %v", err)
+	}
+}
+
+func setBrowserSourceEnabled(on bool) {
+	if browserSourceEnabled.Swap(on) == on {
+		return
 	}
+	log.Printf("[browser-source] %s", map[bool]string{true: "Enabled", false: "Disabled"}[on])
+	emitEvent("browser_source.toggled", map[string]any{"enabled": on})
 }
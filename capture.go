@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CaptureQueueLen    = 4096 // packets waiting for the writer before new ones are dropped
+	CaptureFlushPeriod = 1 * time.Second
+
+	// pcap link types read and written: raw IP without a link layer
+	// header, Ethernet and Linux "any" captures of tcpdump
+	PcapLinkRaw      = 101
+	PcapLinkEthernet = 1
+	PcapLinkSLL      = 113
+
+	SRTExtSID = 5 // handshake extension carrying the stream ID
+)
+
+// captureConfig is the -capture setting of srtla.
+type captureConfig struct {
+	Path      string
+	Anonymize bool // blank the stream and the stream ID, hide sender IPs
+}
+
+type capturedPacket struct {
+	at       time.Time
+	src, dst netip.AddrPort
+	data     []byte
+}
+
+// capture writes the datagrams of the SRTLA socket to a pcap file that
+// Wireshark decodes and the replay subcommand plays back.
+type capture struct {
+	anonymize bool
+	local     netip.AddrPort
+	queue     chan capturedPacket
+	dropped   atomic.Int64
+
+	mu       sync.Mutex
+	senders  map[netip.Addr]netip.Addr // real -> documentation address, when anonymizing
+	senders4 int                       // how many of them are IPv4
+}
+
+// startCapture records what sock receives and sends from now on.
+func startCapture(cfg captureConfig, sock packetConn) (packetConn, error) {
+	f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriterSize(f, 1<<20)
+	if err := writePcapHeader(w); err != nil {
+		f.Close()
+		return nil, err
+	}
+	local, _ := netip.ParseAddrPort(sock.LocalAddr().String())
+	c := &capture{
+		anonymize: cfg.Anonymize,
+		local:     local,
+		queue:     make(chan capturedPacket, CaptureQueueLen),
+		senders:   map[netip.Addr]netip.Addr{},
+	}
+	go supervise("capture", func() { c.run(f, w) })
+	how := ""
+	if cfg.Anonymize {
+		how = ", anonymized"
+	}
+	log.Printf("[capture] Recording the SRTLA traffic to %s%s", cfg.Path, how)
+	return &captureSock{packetConn: sock, c: c}, nil
+}
+
+func (c *capture) run(f *os.File, w *bufio.Writer) {
+	ticker := time.NewTicker(CaptureFlushPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case p := <-c.queue:
+			if err := writePcapPacket(w, p); err != nil {
+				log.Printf("[capture] Write failed, stopping: %v", err)
+				f.Close()
+				return
+			}
+		case <-ticker.C:
+			w.Flush()
+			if n := c.dropped.Swap(0); n > 0 {
+				logHot("[capture] Dropped %d packets, the disk can't keep up", n)
+			}
+		}
+	}
+}
+
+// record queues a copy of a datagram from src to dst.
+func (c *capture) record(src, dst netip.AddrPort, b []byte) {
+	p := capturedPacket{at: time.Now(), src: src, dst: dst, data: append([]byte(nil), b...)}
+	if c.anonymize {
+		if src != c.local {
+			p.src = netip.AddrPortFrom(c.hide(src.Addr()), src.Port())
+		}
+		if dst != c.local {
+			p.dst = netip.AddrPortFrom(c.hide(dst.Addr()), dst.Port())
+		}
+		anonymizePacket(p.data)
+	}
+	select {
+	case c.queue <- p:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// The IPv4 documentation ranges (RFC 5737) anonymized senders are spread
+// over, one host each, followed by the benchmarking range (RFC 2544) for
+// captures with more senders than they hold.
+var (
+	docNets4  = [][3]byte{{192, 0, 2}, {198, 51, 100}, {203, 0, 113}}
+	benchNet4 = [2]byte{198, 18} // 198.18.0.0/15
+)
+
+const (
+	docHosts4  = 254 // .1 to .254 of each /24
+	benchSize4 = 1 << 17
+)
+
+// hide maps a sender's address to one of the documentation ranges, or
+// 2001:db8::/32 for IPv6, the same one for every packet and a different
+// one for every sender.
+func (c *capture) hide(a netip.Addr) netip.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h, ok := c.senders[a]; ok {
+		return h
+	}
+	var h netip.Addr
+	if a.Unmap().Is4() {
+		h = docAddr4(c.senders4)
+		c.senders4++
+	} else {
+		n := uint32(len(c.senders) - c.senders4 + 1)
+		h = netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 12: byte(n >> 24), 13: byte(n >> 16), 14: byte(n >> 8), 15: byte(n)})
+	}
+	c.senders[a] = h
+	return h
+}
+
+// docAddr4 returns the i-th IPv4 address hide hands out.
+func docAddr4(i int) netip.Addr {
+	if i < len(docNets4)*docHosts4 {
+		n := docNets4[i/docHosts4]
+		return netip.AddrFrom4([4]byte{n[0], n[1], n[2], byte(1 + i%docHosts4)})
+	}
+	i = (i - len(docNets4)*docHosts4) % benchSize4
+	return netip.AddrFrom4([4]byte{benchNet4[0], benchNet4[1] + byte(i>>16), byte(i >> 8), byte(i)})
+}
+
+// anonymizePacket blanks, in place, what a capture shouldn't reveal: the
+// payload of SRT data packets, and the stream ID and peer IP of
+// handshakes. Lengths, sequence numbers and SRTLA packets stay intact.
+func anonymizePacket(b []byte) {
+	switch {
+	case getSRTSN(b) >= 0:
+		if len(b) > SRTMinLen {
+			clear(b[SRTMinLen:])
+		}
+	case getSRTType(b) == SRTTypeHandshake && len(b) >= SRTHandshakeSize:
+		clear(b[SRTHandshakeSize-16 : SRTHandshakeSize]) // peer IP
+		for ext := b[SRTHandshakeSize:]; len(ext) >= 4; {
+			typ, n := binary.BigEndian.Uint16(ext), 4*int(binary.BigEndian.Uint16(ext[2:]))
+			if 4+n > len(ext) {
+				break
+			}
+			if typ == SRTExtSID {
+				clear(ext[4 : 4+n])
+			}
+			ext = ext[4+n:]
+		}
+	}
+}
+
+// captureSock is the SRTLA socket with -capture.
+type captureSock struct {
+	packetConn
+	c *capture
+}
+
+func (s *captureSock) ReadBatch(bufs [][]byte, sizes []int, addrs []netip.AddrPort) (int, error) {
+	n, err := readBatch(s.packetConn, bufs, sizes, addrs)
+	for i := 0; i < n; i++ {
+		s.c.record(addrs[i], s.c.local, bufs[i][:sizes[i]])
+	}
+	return n, err
+}
+
+func (s *captureSock) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error) {
+	n, addr, err := s.packetConn.ReadFromUDPAddrPort(b)
+	if err == nil {
+		s.c.record(addr, s.c.local, b[:n])
+	}
+	return n, addr, err
+}
+
+func (s *captureSock) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	s.c.record(s.c.local, addr.AddrPort(), b)
+	return s.packetConn.WriteToUDP(b, addr)
+}
+
+func (s *captureSock) WriteToAll(b []byte, addrs []*net.UDPAddr) error {
+	for _, addr := range addrs {
+		s.c.record(s.c.local, addr.AddrPort(), b)
+	}
+	return writeToAll(s.packetConn, b, addrs)
+}
+
+// writePcapHeader starts a pcap file of raw IP packets with microsecond
+// timestamps.
+func writePcapHeader(w *bufio.Writer) error {
+	var h [24]byte
+	binary.LittleEndian.PutUint32(h[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], 65535)
+	binary.LittleEndian.PutUint32(h[20:], PcapLinkRaw)
+	_, err := w.Write(h[:])
+	return err
+}
+
+// writePcapPacket writes p as a UDP datagram in an IPv4 or IPv6 packet.
+func writePcapPacket(w *bufio.Writer, p capturedPacket) error {
+	src, dst := p.src.Addr().Unmap(), p.dst.Addr().Unmap()
+	if src.IsUnspecified() {
+		src = unspecifiedLike(dst)
+	}
+	if dst.IsUnspecified() {
+		dst = unspecifiedLike(src)
+	}
+	udp := make([]byte, 8, 8+len(p.data))
+	binary.BigEndian.PutUint16(udp[0:], p.src.Port())
+	binary.BigEndian.PutUint16(udp[2:], p.dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(p.data)))
+	udp = append(udp, p.data...)
+
+	var ip []byte
+	if src.Is4() {
+		ip = make([]byte, 20, 20+len(udp))
+		ip[0], ip[8], ip[9] = 0x45, 64, 17
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+		s4, d4 := src.As4(), dst.As4()
+		copy(ip[12:], s4[:])
+		copy(ip[16:], d4[:])
+		binary.BigEndian.PutUint16(ip[10:], ^onesSum(0, ip))
+	} else {
+		ip = make([]byte, 40, 40+len(udp))
+		ip[0], ip[6], ip[7] = 0x60, 17, 64
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+		s16, d16 := src.As16(), dst.As16()
+		copy(ip[8:], s16[:])
+		copy(ip[24:], d16[:])
+		// The UDP checksum is mandatory over IPv6
+		sum := onesSum(0, ip[8:40])
+		sum = onesSum(sum, []byte{0, 0, byte(len(udp) >> 8), byte(len(udp)), 0, 0, 0, 17})
+		cs := ^onesSum(sum, udp)
+		if cs == 0 {
+			cs = 0xffff
+		}
+		binary.BigEndian.PutUint16(udp[6:], cs)
+	}
+	ip = append(ip, udp...)
+
+	var h [16]byte
+	binary.LittleEndian.PutUint32(h[0:], uint32(p.at.Unix()))
+	binary.LittleEndian.PutUint32(h[4:], uint32(p.at.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(h[8:], uint32(len(ip)))
+	binary.LittleEndian.PutUint32(h[12:], uint32(len(ip)))
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(ip)
+	return err
+}
+
+func unspecifiedLike(a netip.Addr) netip.Addr {
+	if a.Is4() {
+		return netip.IPv4Unspecified()
+	}
+	return netip.IPv6Unspecified()
+}
+
+// onesSum adds b to the ones' complement sum of the internet checksum.
+func onesSum(sum uint16, b []byte) uint16 {
+	s := uint32(sum)
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s > 0xffff {
+		s = s&0xffff + s>>16
+	}
+	return uint16(s)
+}
+
+// pcapReader reads the UDP datagrams of a pcap file, from -capture or
+// from tcpdump.
+type pcapReader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+	nanos bool
+	link  uint32
+}
+
+func newPcapReader(f *os.File) (*pcapReader, error) {
+	p := &pcapReader{r: bufio.NewReader(f)}
+	var h [24]byte
+	if _, err := io.ReadFull(p.r, h[:]); err != nil {
+		return nil, err
+	}
+	switch {
+	case binary.LittleEndian.Uint32(h[:]) == 0xa1b2c3d4:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(h[:]) == 0xa1b2c3d4:
+		p.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(h[:]) == 0xa1b23c4d:
+		p.order, p.nanos = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(h[:]) == 0xa1b23c4d:
+		p.order, p.nanos = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("not a pcap file (pcapng isn't supported, convert it with editcap -F pcap)")
+	}
+	p.link = p.order.Uint32(h[20:]) & 0xffff
+	switch p.link {
+	case PcapLinkRaw, PcapLinkEthernet, PcapLinkSLL:
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type %d", p.link)
+	}
+	return p, nil
+}
+
+// next returns the next UDP datagram, skipping everything else.
+func (p *pcapReader) next() (capturedPacket, error) {
+	for {
+		var h [16]byte
+		if _, err := io.ReadFull(p.r, h[:]); err != nil {
+			return capturedPacket{}, err
+		}
+		frame := make([]byte, p.order.Uint32(h[8:]))
+		if _, err := io.ReadFull(p.r, frame); err != nil {
+			return capturedPacket{}, err
+		}
+		sub := int64(p.order.Uint32(h[4:]))
+		if !p.nanos {
+			sub *= 1000
+		}
+		at := time.Unix(int64(p.order.Uint32(h[0:])), sub)
+
+		switch p.link {
+		case PcapLinkEthernet:
+			frame = skipLinkHeader(frame, 14, 12)
+		case PcapLinkSLL:
+			frame = skipLinkHeader(frame, 16, 14)
+		}
+		if pkt, ok := parseUDP(frame); ok {
+			pkt.at = at
+			return pkt, nil
+		}
+	}
+}
+
+// skipLinkHeader strips a link layer header of n bytes whose EtherType is
+// at typeAt, nil for frames that don't carry IP.
+func skipLinkHeader(frame []byte, n, typeAt int) []byte {
+	if len(frame) < n {
+		return nil
+	}
+	switch binary.BigEndian.Uint16(frame[typeAt:]) {
+	case 0x0800, 0x86dd:
+		return frame[n:]
+	}
+	return nil
+}
+
+// parseUDP decodes an IPv4 or IPv6 packet carrying an unfragmented UDP
+// datagram.
+func parseUDP(b []byte) (capturedPacket, bool) {
+	var p capturedPacket
+	if len(b) < 1 {
+		return p, false
+	}
+	var udp []byte
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if len(b) < ihl+8 || b[9] != 17 || binary.BigEndian.Uint16(b[6:])&0x3fff != 0 {
+			return p, false
+		}
+		src, dst := netip.AddrFrom4([4]byte(b[12:16])), netip.AddrFrom4([4]byte(b[16:20]))
+		udp = b[ihl:]
+		p.src, p.dst = netip.AddrPortFrom(src, 0), netip.AddrPortFrom(dst, 0)
+	case 6:
+		if len(b) < 48 || b[6] != 17 {
+			return p, false
+		}
+		src, dst := netip.AddrFrom16([16]byte(b[8:24])), netip.AddrFrom16([16]byte(b[24:40]))
+		udp = b[40:]
+		p.src, p.dst = netip.AddrPortFrom(src, 0), netip.AddrPortFrom(dst, 0)
+	default:
+		return p, false
+	}
+	n := int(binary.BigEndian.Uint16(udp[4:]))
+	if n < 8 || n > len(udp) {
+		return p, false
+	}
+	p.src = netip.AddrPortFrom(p.src.Addr(), binary.BigEndian.Uint16(udp[0:]))
+	p.dst = netip.AddrPortFrom(p.dst.Addr(), binary.BigEndian.Uint16(udp[2:]))
+	p.data = udp[8:n]
+	return p, true
+}
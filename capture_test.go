@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestCaptureHideInjective anonymizes the addresses of a large loadgen run:
+// every sender must keep its own documentation address, for every packet.
+func TestCaptureHideInjective(t *testing.T) {
+	c := &capture{senders: map[netip.Addr]netip.Addr{}}
+	seen := map[netip.Addr]netip.Addr{}
+	check := func(a netip.Addr) {
+		t.Helper()
+		h := c.hide(a)
+		if other, ok := seen[h]; ok && other != a {
+			t.Fatalf("%s and %s both hidden as %s", other, a, h)
+		}
+		seen[h] = a
+		if a.Is4() != h.Is4() {
+			t.Fatalf("%s hidden as %s, another family", a, h)
+		}
+		if !a.Is4() {
+			return
+		}
+		if h4 := h.As4(); h4[3] == 0 && h4[0] != 198 {
+			t.Fatalf("%s hidden as the network address %s", a, h)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		check(netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}))
+		check(netip.AddrFrom16([16]byte{0xfd, 14: byte(i >> 8), 15: byte(i)}))
+	}
+	for i := 0; i < 300; i++ { // seen again
+		check(netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}))
+	}
+	if len(seen) != 2000 {
+		t.Fatalf("%d hidden addresses for 2000 senders", len(seen))
+	}
+	if h := c.hide(netip.AddrFrom4([4]byte{10, 0, 0, 0})); h != netip.MustParseAddr("192.0.2.1") {
+		t.Fatalf("first sender hidden as %s, want 192.0.2.1", h)
+	}
+	if h := c.hide(netip.AddrFrom4([4]byte{10, 0, 1, 0})); h != netip.MustParseAddr("198.51.100.3") {
+		t.Fatalf("257th sender hidden as %s, want 198.51.100.3", h)
+	}
+}
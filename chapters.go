@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	RecordSessionLayout  = "go-irl-20060102-150405" // session names are their start time in this layout
+	RecordMaxMarkers     = 1000                     // per session
+	RecordMaxMarkerLabel = 200
+)
+
+var errNotRecording = errors.New("not recording")
+
+// recordSession describes one recorded stream session: its files and the
+// chapter markers dropped while it was recorded. It is kept next to the
+// recording as <session>.json, rewritten on every change.
+type recordSession struct {
+	Name     string          `json:"session"`
+	Started  time.Time       `json:"started"`
+	Ended    *time.Time      `json:"ended,omitempty"`
+	Segments []recordSegment `json:"segments"`
+	Markers  []recordMarker  `json:"markers"`
+}
+
+type recordSegment struct {
+	File            string    `json:"file"`
+	Started         time.Time `json:"started"`
+	OffsetSeconds   float64   `json:"offsetSeconds"` // from the start of the session
+	DurationSeconds float64   `json:"durationSeconds"`
+	Bytes           int64     `json:"bytes"`
+}
+
+type recordMarker struct {
+	Time                 time.Time `json:"time"`
+	OffsetSeconds        float64   `json:"offsetSeconds"` // from the start of the session
+	Segment              string    `json:"segment"`
+	SegmentOffsetSeconds float64   `json:"segmentOffsetSeconds"`
+	Label                string    `json:"label,omitempty"`
+}
+
+// lastSession is the most recently finished session, served at
+// /api/markers between sessions. Guarded by rec.mu.
+var lastSession *recordSession
+
+func (s *recordSession) addSegment(file string, now time.Time) {
+	s.Segments = append(s.Segments, recordSegment{
+		File:          file,
+		Started:       now,
+		OffsetSeconds: now.Sub(s.Started).Seconds(),
+	})
+}
+
+func (s *recordSession) endSegment(now time.Time) {
+	if len(s.Segments) > 0 {
+		seg := &s.Segments[len(s.Segments)-1]
+		seg.DurationSeconds = now.Sub(seg.Started).Seconds()
+	}
+}
+
+func (s *recordSession) addBytes(n int64) {
+	if len(s.Segments) > 0 {
+		s.Segments[len(s.Segments)-1].Bytes += n
+	}
+}
+
+// startSession begins a new session. Must be called with r.mu held.
+func (r *recorder) startSession(now time.Time) {
+	r.session = &recordSession{
+		Name:     now.Format(RecordSessionLayout),
+		Started:  now,
+		Segments: []recordSegment{},
+		Markers:  []recordMarker{},
+	}
+	r.psi = newPSITables()
+	r.partialN = 0
+}
+
+// endSession finishes the current file and the session. Must be called
+// with r.mu held.
+func (r *recorder) endSession() {
+	r.finish()
+	if r.session == nil {
+		return
+	}
+	ended := r.last
+	r.session.Ended = &ended
+	r.saveSession()
+	lastSession, r.session = r.session, nil
+}
+
+// saveSession writes the session's sidecar JSON. Local sidecars are
+// replaced atomically on every change; on S3 the sidecar is only uploaded
+// once the session ended. Must be called with r.mu held.
+func (r *recorder) saveSession() {
+	data, _ := json.MarshalIndent(r.session, "", "  ")
+	name := r.session.Name + ".json"
+	if r.s3 != nil {
+		if r.session.Ended == nil {
+			return
+		}
+		go func() {
+			if _, _, err := r.s3.do(http.MethodPut, r.s3.key(name), nil, data); err != nil {
+				log.Printf("[record] Failed to upload %s: %v", name, err)
+			}
+		}()
+		return
+	}
+	path := filepath.Join(r.dir, name)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		log.Printf("[record] Failed to write %s: %v", path, err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("[record] Failed to write %s: %v", path, err)
+	}
+}
+
+// addMarker drops a chapter marker at the current position of the
+// recording.
+func (r *recorder) addMarker(label string) (recordMarker, error) {
+	label = strings.TrimSpace(label)
+	if len(label) > RecordMaxMarkerLabel {
+		return recordMarker{}, errors.New("label too long")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.session == nil || r.w == nil {
+		return recordMarker{}, errNotRecording
+	}
+	if len(r.session.Markers) >= RecordMaxMarkers {
+		return recordMarker{}, errors.New("too many markers in this session")
+	}
+	now := time.Now()
+	seg := r.session.Segments[len(r.session.Segments)-1]
+	m := recordMarker{
+		Time:                 now,
+		OffsetSeconds:        now.Sub(r.session.Started).Seconds(),
+		Segment:              seg.File,
+		SegmentOffsetSeconds: now.Sub(seg.Started).Seconds(),
+		Label:                label,
+	}
+	r.session.Markers = append(r.session.Markers, m)
+	r.saveSession()
+	emitEvent("record.marker", map[string]any{
+		"session":       r.session.Name,
+		"segment":       m.Segment,
+		"offsetSeconds": int(m.OffsetSeconds),
+		"label":         label,
+	})
+	return m, nil
+}
+
+// snapshot returns a copy of the current session, or of the last one
+// between sessions. ok is false if nothing was recorded yet.
+func (r *recorder) snapshot() (recordSession, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.session
+	if s == nil {
+		s = lastSession
+	}
+	if s == nil {
+		return recordSession{}, false
+	}
+	cp := *s
+	cp.Segments = append([]recordSegment{}, s.Segments...)
+	cp.Markers = append([]recordMarker{}, s.Markers...)
+	return cp, true
+}
+
+// handleMarkers serves /api/markers: GET returns the current recording
+// session with its markers, POST {"label": "..."} drops a marker.
+func handleMarkers(w http.ResponseWriter, r *http.Request) {
+	if rec == nil {
+		http.Error(w, "recording is not enabled, see -record", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s, ok := rec.snapshot()
+		if !ok {
+			http.Error(w, "nothing recorded yet", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, s)
+	case http.MethodPost:
+		var req struct {
+			Label string `json:"label"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+				http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		m, err := rec.addMarker(req.Label)
+		switch {
+		case err == errNotRecording:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeJSON(w, m)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
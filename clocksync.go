@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ClockReportPeriod = 5 * time.Second
+
+	// Extended keepalives (as sent by e.g. Moblin) carry a 64-bit sender
+	// timestamp in milliseconds since the Unix epoch after the type field.
+	SRTLAKeepaliveTSLen = 2 + 8
+
+	// Timestamps further off than this are treated as not being wall clock
+	// time (e.g. a monotonic counter) and ignored.
+	MaxPlausibleSkew = 24 * time.Hour
+)
+
+// keepaliveTimestamp extracts the sender's wall clock from an extended SRTLA
+// keepalive.
+func keepaliveTimestamp(pkt []byte, now time.Time) (time.Time, bool) {
+	if len(pkt) < SRTLAKeepaliveTSLen {
+		return time.Time{}, false
+	}
+	ms := binary.BigEndian.Uint64(pkt[2:SRTLAKeepaliveTSLen])
+	if ms > uint64(1<<62) {
+		return time.Time{}, false
+	}
+	ts := time.UnixMilli(int64(ms))
+	if d := ts.Sub(now); d > MaxPlausibleSkew || d < -MaxPlausibleSkew {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
+type senderClock struct {
+	Group    string  `json:"group"`
+	Addr     string  `json:"addr"`
+	Label    string  `json:"label,omitempty"`
+	OffsetMs float64 `json:"offsetMs"` // sender clock - server clock
+}
+
+// clockSnapshot is served by the server at /api/clock.
+type clockSnapshot struct {
+	ServerTime time.Time     `json:"serverTime"`
+	Senders    []senderClock `json:"senders"`
+}
+
+// clockMessage is broadcast to the browser source so skew between the
+// sender, the server and the client can be shown next to the stats.
+type clockMessage struct {
+	Timestamp      time.Time     `json:"timestamp"`
+	Type           string        `json:"type"`           // always "clock"
+	ServerOffsetMs *float64      `json:"serverOffsetMs"` // server clock - client clock
+	ServerRttMs    *float64      `json:"serverRttMs"`
+	Senders        []senderClock `json:"senders"` // relative to the server clock
+}
+
+func collectClock() clockSnapshot {
+	snap := clockSnapshot{ServerTime: time.Now(), Senders: []senderClock{}}
+
+	for _, g := range groupList() {
+		g.mu.Lock()
+		for _, c := range g.conns {
+			if c.clockSampled.IsZero() {
+				continue
+			}
+			label, _ := c.label()
+			snap.Senders = append(snap.Senders, senderClock{
+				Group:    fmt.Sprintf("%p", g),
+				Addr:     c.addr.String(),
+				Label:    label,
+				OffsetMs: float64(c.clockOffset) / float64(time.Millisecond),
+			})
+		}
+		g.mu.Unlock()
+	}
+	return snap
+}
+
+func handleClock(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, collectClock())
+}
+
+// fetchServerClock performs an NTP style exchange against a server's
+// /api/clock and returns its snapshot together with the estimated offset of
+// the server clock relative to ours and the round-trip time.
+func fetchServerClock(client *http.Client, serverAPI string) (clockSnapshot, time.Duration, time.Duration, error) {
+	var snap clockSnapshot
+
+	t0 := time.Now()
+	resp, err := client.Get(strings.TrimRight(serverAPI, "/") + "/api/clock")
+	if err != nil {
+		return snap, 0, 0, err
+	}
+	defer resp.Body.Close()
+	t1 := time.Now()
+
+	if resp.StatusCode != http.StatusOK {
+		return snap, 0, 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return snap, 0, 0, err
+	}
+
+	rtt := t1.Sub(t0)
+	offset := snap.ServerTime.Sub(t0.Add(rtt / 2))
+	return snap, offset, rtt, nil
+}
+
+// runClockReporter periodically publishes a clockMessage. With an empty
+// serverAPI the SRTLA receiver runs in this process (standalone mode) and
+// the server offset is zero by definition.
+func runClockReporter(serverAPI string) {
+	client := &http.Client{Timeout: ClockReportPeriod}
+	ticker := time.NewTicker(ClockReportPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if powerIdle() {
+			continue
+		}
+		msg := clockMessage{Timestamp: time.Now(), Type: "clock"}
+
+		if serverAPI == "" {
+			zero := 0.0
+			msg.ServerOffsetMs, msg.ServerRttMs = &zero, &zero
+			msg.Senders = collectClock().Senders
+		} else {
+			snap, offset, rtt, err := fetchServerClock(client, serverAPI)
+			if err != nil {
+				log.Printf("Clock sync with %s failed: %v", serverAPI, err)
+				continue
+			}
+			offsetMs := float64(offset) / float64(time.Millisecond)
+			rttMs := float64(rtt) / float64(time.Millisecond)
+			msg.ServerOffsetMs, msg.ServerRttMs = &offsetMs, &rttMs
+			msg.Senders = snap.Senders
+		}
+
+		publishMessage(msg)
+	}
+}
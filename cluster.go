@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ClusterSyncPeriod   = 1 * time.Second // every node announces the groups it owns this often
+	ClusterOwnerTimeout = 3 * ClusterSyncPeriod
+	ClusterLookupGrace  = 2 * time.Second // a REG2 for an unknown group waits this long for an announcement
+	ClusterLinkTimeout  = 2 * ConnTimeout
+	ClusterMaxSkew      = 10 * time.Second // messages with timestamps further off are dropped as replays
+
+	clusterMsgAnnounce = 'A' // owner -> all peers: digests of the groups it owns
+	clusterMsgForward  = 'F' // relay -> owner: a packet from a link
+	clusterMsgOut      = 'O' // owner -> relay: a packet for a link
+
+	clusterDigestLen = 16
+	clusterAddrLen   = 18 // IPv6 (or v4-mapped) address and port
+	clusterMACLen    = 16
+	clusterHeaderLen = 1 + 8 // type and timestamp
+)
+
+// clusterConfig enables cluster mode in server mode, see -cluster-port.
+type clusterConfig struct {
+	Port   int
+	Peers  []string // host:port of the other nodes' cluster ports
+	Secret string   // authenticates cluster messages
+}
+
+// cluster lets several server mode instances behind a UDP load balancer
+// share their SRTLA groups, so the links of a bonded group can land on
+// any node. Each group lives on the node that received its REG1 (the
+// owner), which keeps all registration and ACK state for it. The nodes
+// announce their groups to each other; a node that receives a REG2 or
+// packets for a group of another node relays them to the owner, and the
+// owner's replies to that link go back through the relaying node, so the
+// sender only ever talks to the address it sent to.
+type cluster struct {
+	secret []byte
+	sock   *net.UDPConn
+	inner  packetConn // the public SRTLA socket
+	peers  []*net.UDPAddr
+
+	mu       sync.Mutex
+	owners   map[[clusterDigestLen]byte]clusterOwner // groups of other nodes
+	lookups  map[[clusterDigestLen]byte]time.Time    // REG2s for unknown groups, first seen
+	relayed  map[netip.AddrPort]*clusterLink         // links of other nodes' groups received here
+	remote   map[netip.AddrPort]*clusterLink         // links of our groups received by another node
+	lastSeen map[string]time.Time                    // peer -> last valid message
+
+	nRemote atomic.Int32 // len(remote), checked on every write without locking
+}
+
+type clusterOwner struct {
+	node *net.UDPAddr
+	seen time.Time
+}
+
+type clusterLink struct {
+	node *net.UDPAddr // the owner for relayed links, the relay for remote ones
+	last time.Time
+}
+
+// clusterNode is set in cluster mode.
+var clusterNode *cluster
+
+// startCluster opens the cluster port and returns the SRTLA socket wrapped
+// so that writes to links received by other nodes go through them.
+func startCluster(ctx context.Context, cfg clusterConfig, inner packetConn) packetConn {
+	if cfg.Secret == "" {
+		log.Fatalf("ERROR: cluster mode requires -cluster-secret")
+	}
+	c := &cluster{
+		secret:   []byte(cfg.Secret),
+		inner:    inner,
+		owners:   map[[clusterDigestLen]byte]clusterOwner{},
+		lookups:  map[[clusterDigestLen]byte]time.Time{},
+		relayed:  map[netip.AddrPort]*clusterLink{},
+		remote:   map[netip.AddrPort]*clusterLink{},
+		lastSeen: map[string]time.Time{},
+	}
+	for _, p := range cfg.Peers {
+		addr, err := net.ResolveUDPAddr("udp", p)
+		if err != nil {
+			log.Fatalf("ERROR: invalid -cluster-peers entry %q: %v", p, err)
+		}
+		c.peers = append(c.peers, addr)
+	}
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6unspecified, Port: cfg.Port})
+	if err != nil {
+		log.Fatalf("Failed to listen on cluster port %d: %v", cfg.Port, err)
+	}
+	_ = sock.SetReadBuffer(recvBufSize)
+	_ = sock.SetWriteBuffer(sendBufSize)
+	c.sock = sock
+	recordListener("cluster", "udp", sock.LocalAddr())
+	clusterNode = c
+	log.Printf("[cluster] Listening on %s, %d peers", sock.LocalAddr(), len(c.peers))
+
+	go supervise("cluster-reader", func() { c.read(ctx) })
+	go supervise("cluster-sync", func() {
+		ticker := time.NewTicker(ClusterSyncPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				sock.Close()
+				return
+			case <-ticker.C:
+				c.announce()
+				c.expire()
+			}
+		}
+	})
+	return &clusterSock{packetConn: inner, c: c}
+}
+
+func groupDigest(id []byte) [clusterDigestLen]byte {
+	sum := sha256.Sum256(id)
+	var d [clusterDigestLen]byte
+	copy(d[:], sum[:])
+	return d
+}
+
+// send authenticates and sends one cluster message.
+func (c *cluster) send(to *net.UDPAddr, typ byte, addr netip.AddrPort, payload []byte) {
+	msg := make([]byte, 0, clusterHeaderLen+clusterAddrLen+len(payload)+clusterMACLen)
+	msg = append(msg, typ)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(time.Now().UnixNano()))
+	if typ != clusterMsgAnnounce {
+		a := addr.Addr().As16()
+		msg = append(msg, a[:]...)
+		msg = binary.BigEndian.AppendUint16(msg, addr.Port())
+	}
+	msg = append(msg, payload...)
+	msg = append(msg, c.mac(msg)...)
+	if _, err := c.sock.WriteToUDP(msg, to); err != nil {
+		log.Printf("[cluster] Failed to send to %s: %v", to, err)
+	}
+}
+
+func (c *cluster) mac(msg []byte) []byte {
+	h := hmac.New(sha256.New, c.secret)
+	h.Write(msg)
+	return h.Sum(nil)[:clusterMACLen]
+}
+
+// announce tells every peer which groups this node owns.
+func (c *cluster) announce() {
+	groups := groupList()
+	payload := make([]byte, 0, len(groups)*clusterDigestLen)
+	for _, g := range groups {
+		d := groupDigest(g.id[:])
+		payload = append(payload, d[:]...)
+	}
+	for _, p := range c.peers {
+		c.send(p, clusterMsgAnnounce, netip.AddrPort{}, payload)
+	}
+}
+
+func (c *cluster) read(ctx context.Context) {
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := c.sock.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("[cluster] read error: %v", err)
+			continue
+		}
+		c.handle(buf[:n], from)
+	}
+}
+
+func (c *cluster) handle(msg []byte, from *net.UDPAddr) {
+	if len(msg) < clusterHeaderLen+clusterMACLen {
+		return
+	}
+	body, mac := msg[:len(msg)-clusterMACLen], msg[len(msg)-clusterMACLen:]
+	if !hmac.Equal(mac, c.mac(body)) {
+		log.Printf("[cluster] Dropped a message from %s with a bad MAC, check -cluster-secret", from)
+		return
+	}
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(body[1:9])))
+	if skew := time.Since(ts); skew > ClusterMaxSkew || skew < -ClusterMaxSkew {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	c.lastSeen[clusterKey(from)] = now
+	c.mu.Unlock()
+
+	typ, body := body[0], body[clusterHeaderLen:]
+	if typ == clusterMsgAnnounce {
+		c.mu.Lock()
+		for len(body) >= clusterDigestLen {
+			var d [clusterDigestLen]byte
+			copy(d[:], body)
+			c.owners[d] = clusterOwner{node: from, seen: now}
+			delete(c.lookups, d)
+			body = body[clusterDigestLen:]
+		}
+		c.mu.Unlock()
+		return
+	}
+
+	if len(body) < clusterAddrLen {
+		return
+	}
+	link := netip.AddrPortFrom(netip.AddrFrom16([16]byte(body[:16])).Unmap(), binary.BigEndian.Uint16(body[16:18]))
+	pkt := body[clusterAddrLen:]
+	if len(pkt) > MTU {
+		return
+	}
+	switch typ {
+	case clusterMsgForward:
+		c.mu.Lock()
+		if l, ok := c.remote[link]; ok {
+			l.node, l.last = from, now
+		} else {
+			c.remote[link] = &clusterLink{node: from, last: now}
+			c.nRemote.Store(int32(len(c.remote)))
+		}
+		c.mu.Unlock()
+		pb := getPacketBuf()
+		n := copy(pb.b[:], pkt)
+		pb.readAt = now.UnixNano()
+		if !handleSRTLAIncoming(pb, n, link) {
+			putPacketBuf(pb)
+		}
+	case clusterMsgOut:
+		c.inner.WriteToUDP(pkt, net.UDPAddrFromAddrPort(link))
+	}
+}
+
+// isRemote reports whether packets from addr were relayed by another node.
+func (c *cluster) isRemote(addr netip.AddrPort) bool {
+	if c.nRemote.Load() == 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.remote[addr]
+	return ok
+}
+
+// forwardReg2 relays a REG2 for a group that is not ours to its owner. It
+// reports whether the REG2 was handled, false means the group is unknown
+// in the whole cluster and the sender should get REG_NGP.
+func (c *cluster) forwardReg2(addr netip.AddrPort, pkt, id []byte) bool {
+	if c.isRemote(addr) {
+		return false // the relaying node already asked us, it is really gone
+	}
+	d := groupDigest(id)
+	now := time.Now()
+	c.mu.Lock()
+	owner, ok := c.owners[d]
+	if !ok {
+		// The owner's announcement may still be on its way
+		first, waiting := c.lookups[d]
+		if !waiting {
+			c.lookups[d] = now
+		}
+		c.mu.Unlock()
+		return !waiting || now.Sub(first) < ClusterLookupGrace
+	}
+	delete(c.lookups, d)
+	c.relayed[addr] = &clusterLink{node: owner.node, last: now}
+	c.mu.Unlock()
+	log.Printf("[%s] [cluster] Relaying link to the group's owner %s", net.UDPAddrFromAddrPort(addr), owner.node)
+	c.send(owner.node, clusterMsgForward, addr, pkt)
+	return true
+}
+
+// relay forwards a packet from a link of another node's group. It reports
+// whether the packet was taken care of by the cluster.
+func (c *cluster) relay(pkt []byte, addr netip.AddrPort) bool {
+	c.mu.Lock()
+	l, ok := c.relayed[addr]
+	if ok {
+		l.last = time.Now()
+	}
+	_, remote := c.remote[addr]
+	c.mu.Unlock()
+	if ok {
+		c.send(l.node, clusterMsgForward, addr, pkt)
+		return true
+	}
+	return remote // a link of a group we no longer have, drop it
+}
+
+// dropRelay stops relaying addr, e.g. because it registers a new group
+// here.
+func (c *cluster) dropRelay(addr netip.AddrPort) {
+	c.mu.Lock()
+	delete(c.relayed, addr)
+	c.mu.Unlock()
+}
+
+// remoteNode returns the node a link of one of our groups was relayed by.
+func (c *cluster) remoteNode(addr netip.AddrPort) *net.UDPAddr {
+	if c.nRemote.Load() == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l, ok := c.remote[addr]; ok {
+		return l.node
+	}
+	return nil
+}
+
+func (c *cluster) expire() {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for d, o := range c.owners {
+		if now.Sub(o.seen) > ClusterOwnerTimeout {
+			delete(c.owners, d)
+		}
+	}
+	for d, first := range c.lookups {
+		if now.Sub(first) > ClusterOwnerTimeout {
+			delete(c.lookups, d)
+		}
+	}
+	for addr, l := range c.relayed {
+		if now.Sub(l.last) > ClusterLinkTimeout {
+			delete(c.relayed, addr)
+		}
+	}
+	for addr, l := range c.remote {
+		if now.Sub(l.last) > ClusterLinkTimeout {
+			delete(c.remote, addr)
+		}
+	}
+	c.nRemote.Store(int32(len(c.remote)))
+}
+
+// clusterSock is the SRTLA socket in cluster mode: writes to links that
+// reached us through another node are sent back through that node.
+type clusterSock struct {
+	packetConn
+	c *cluster
+}
+
+func (s *clusterSock) ReadBatch(bufs [][]byte, sizes []int, addrs []netip.AddrPort) (int, error) {
+	return readBatch(s.packetConn, bufs, sizes, addrs)
+}
+
+func (s *clusterSock) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	if node := s.c.remoteNode(addr.AddrPort()); node != nil {
+		s.c.send(node, clusterMsgOut, addr.AddrPort(), b)
+		return len(b), nil
+	}
+	return s.packetConn.WriteToUDP(b, addr)
+}
+
+func (s *clusterSock) WriteToAll(b []byte, addrs []*net.UDPAddr) error {
+	var slots [MaxConnsPerGroup]*net.UDPAddr
+	local := slots[:0]
+	for _, addr := range addrs {
+		if node := s.c.remoteNode(addr.AddrPort()); node != nil {
+			s.c.send(node, clusterMsgOut, addr.AddrPort(), b)
+		} else {
+			local = append(local, addr)
+		}
+	}
+	return writeToAll(s.packetConn, b, local)
+}
+
+type clusterPeerStatus struct {
+	Addr     string     `json:"addr"`
+	Up       bool       `json:"up"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+	Groups   int        `json:"groups"`
+}
+
+type clusterStatus struct {
+	Peers        []clusterPeerStatus `json:"peers"`
+	LocalGroups  int                 `json:"localGroups"`
+	RelayedLinks int                 `json:"relayedLinks"` // received here, for other nodes' groups
+	RemoteLinks  int                 `json:"remoteLinks"`  // of our groups, received by other nodes
+}
+
+// handleCluster serves /api/cluster.
+func handleCluster(w http.ResponseWriter, r *http.Request) {
+	c := clusterNode
+	if c == nil {
+		http.Error(w, "cluster mode is not enabled, see -cluster-port", http.StatusNotFound)
+		return
+	}
+	now := time.Now()
+	st := clusterStatus{Peers: []clusterPeerStatus{}, LocalGroups: len(groupList())}
+	c.mu.Lock()
+	groups := map[string]int{}
+	for _, o := range c.owners {
+		groups[clusterKey(o.node)]++
+	}
+	for _, p := range c.peers {
+		ps := clusterPeerStatus{Addr: p.String(), Groups: groups[clusterKey(p)]}
+		if seen, ok := c.lastSeen[clusterKey(p)]; ok {
+			ps.LastSeen = &seen
+			ps.Up = now.Sub(seen) <= ClusterOwnerTimeout
+		}
+		st.Peers = append(st.Peers, ps)
+	}
+	st.RelayedLinks, st.RemoteLinks = len(c.relayed), len(c.remote)
+	c.mu.Unlock()
+	writeJSON(w, st)
+}
+
+// parseClusterPeers splits -cluster-peers.
+func parseClusterPeers(s string) []string {
+	var peers []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// clusterKey identifies a node by its address, the same whether it was
+// configured or seen as the source of a message on the dual-stack socket.
+func clusterKey(addr *net.UDPAddr) string {
+	ap := addr.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()).String()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// flagRoles are the roles each role-specific flag applies to. Flags not
+// listed, such as -api-port, the logging or the TLS flags, apply to all.
+var flagRoles = map[string]string{
+	"srt-port":        "server,client",
+	"srt-host":        "server",
+	"srt-ingest-port": "server",
+	"cluster-port":    "server",
+	"cluster-peers":   "server",
+	"cluster-secret":  "server",
+
+	"srtla-port":        "server,standalone",
+	"cleanup-period":    "server,standalone",
+	"group-timeout":     "server,standalone",
+	"detect-protocols":  "server,standalone",
+	"dedup":             "server,standalone",
+	"ack-max-delay":     "server,standalone",
+	"anomaly-z":         "server,standalone",
+	"reorder-delay":     "server,standalone",
+	"ddns":              "server,standalone",
+	"labels-file":       "server,standalone",
+	"max-memory":        "server,standalone",
+	"backpressure":      "server,standalone",
+	"udp-offload":       "server,standalone",
+	"verbose":           "server,standalone",
+	"demo":              "server,standalone",
+	"capture":           "server,standalone",
+	"capture-anonymize": "server,standalone",
+	"demo-input":        "server,standalone",
+
+	"srt-backup-port": "client",
+	"server-api":      "client",
+
+	"bs-port":            "client,standalone",
+	"udp-port":           "client,standalone",
+	"pipe-output":        "client,standalone",
+	"dvr":                "client,standalone",
+	"preview-interval":   "client,standalone",
+	"ffmpeg":             "client,standalone",
+	"input":              "client,standalone",
+	"record":             "client,standalone",
+	"record-spill":       "client,standalone",
+	"record-segment":     "client,standalone",
+	"record-max-gb":      "client,standalone",
+	"record-max-age":     "client,standalone",
+	"record-min-free-gb": "client,standalone",
+	"upload":             "client,standalone",
+	"upload-delete":      "client,standalone",
+	"play-port":          "client,standalone",
+	"srt-output":         "client,standalone",
+	"output":             "client,standalone",
+	"filter":             "client,standalone",
+	"obs-websocket":      "client,standalone",
+	"obs-password":       "client,standalone",
+	"audio-alerts":       "client,standalone",
+	"tts":                "client,standalone",
+	"theme":              "client,standalone",
+	"theme-vars":         "client,standalone",
+	"passphrase":         "client,standalone",
+	"compat":             "client,standalone",
+	"stream-key":         "client,standalone",
+	"preset":             "client,standalone",
+	"mediamtx-srt":       "client,standalone",
+	"mediamtx-api":       "client,standalone",
+	"mediamtx-path":      "client,standalone",
+	"mediamtx-user":      "client,standalone",
+	"mediamtx-pass":      "client,standalone",
+	"low-bitrate":        "client,standalone",
+	"idle-timeout":       "client,standalone",
+	"test-signal":        "client,standalone",
+	"noalbs-publisher":   "client,standalone",
+	"stats-interval":     "client,standalone",
+	"stats-summary":      "client,standalone",
+
+	"ws-port": "client,standalone,director",
+
+	"schedule":         "server,client,standalone",
+	"schedule-policy":  "server,client,standalone",
+	"schedule-webhook": "server,client,standalone",
+	"integrity":        "server,client,standalone",
+	"profile":          "server,client,standalone",
+	"firewall":         "server,client,standalone",
+	"control-token":    "server,client,standalone",
+
+	"director-servers": "director",
+	"director-probes":  "server,standalone",
+}
+
+var modeSummaries = map[string]string{
+	ModeServer:     "Receives SRTLA from the internet and forwards the SRT stream to -srt-host:-srt-port, e.g. a go-irl client.",
+	ModeClient:     "Receives the SRT stream of a go-irl server on -srt-port and feeds OBS, the Browser Source and the WebSocket.",
+	ModeStandalone: "Receives SRTLA and feeds OBS, the Browser Source and the WebSocket on one machine.",
+	ModeDirector:   "Points bonding senders at the ingest server with the lowest RTT.",
+}
+
+// flagRoleNote is the "(client/standalone)" note ending the usage of the
+// global flags, redundant in the help of a mode command.
+var flagRoleNote = regexp.MustCompile(` \((?:server|client|standalone)(?:/(?:server|client|standalone)| mode)*\)$`)
+
+// isModeCommand reports whether arg names roles, as in `go-irl server` or
+// `go-irl server,client`.
+func isModeCommand(arg string) bool {
+	if arg == "" || strings.HasPrefix(arg, "-") {
+		return false
+	}
+	_, err := parseModes(arg)
+	return err == nil
+}
+
+// useModeCommand replaces the global flag set with one for the roles in
+// command: only their flags, with help about them. The flags share the
+// global values, so the rest of main reads them as before.
+func useModeCommand(command string) {
+	set, _ := parseModes(command)
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "mode" || !flagApplies(f.Name, set) {
+			return
+		}
+		fs.Var(f.Value, f.Name, flagRoleNote.ReplaceAllString(f.Usage, ""))
+	})
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s %s [flags]\n\n", os.Args[0], command)
+		for _, m := range []string{ModeServer, ModeClient, ModeStandalone, ModeDirector} {
+			if set[m] {
+				fmt.Fprintf(out, "%s: %s\n", m, modeSummaries[m])
+			}
+		}
+		fmt.Fprintf(out, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	flag.CommandLine = fs
+	*mode = command
+}
+
+func flagApplies(name string, set map[string]bool) bool {
+	r, ok := flagRoles[name]
+	if !ok {
+		return true
+	}
+	for _, m := range strings.Split(r, ",") {
+		if set[m] {
+			return true
+		}
+	}
+	return false
+}
+
+// usage is the help of `go-irl -h`, which still takes every flag with
+// -mode.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, m := range []string{ModeStandalone, ModeServer, ModeClient, ModeDirector} {
+		fmt.Fprintf(out, "  %-11s %s\n", m, modeSummaries[m])
+	}
+	fmt.Fprintf(out, "  %-11s %s\n", "bond", "Sends a local SRT stream over several links to an SRTLA server.")
+	fmt.Fprintf(out, "  %-11s %s\n", "send", "Sends a file or a local encoder's stream to an SRTLA server.")
+	fmt.Fprintf(out, "  %-11s %s\n", "fleet", "Serves a dashboard of several go-irl instances.")
+	fmt.Fprintf(out, "  %-11s %s\n", "loadgen", "Emulates SRTLA senders to load test a server.")
+	fmt.Fprintf(out, "  %-11s %s\n", "replay", "Replays a session captured with -capture against an SRTLA server.")
+	fmt.Fprintf(out, "  %-11s %s\n", "tray", "Runs go-irl in the background with a tray icon (Windows, macOS).")
+	fmt.Fprintf(out, "  %-11s %s\n", "analyze", "Decodes the SRTLA and SRT control flow of a capture and flags protocol problems.")
+	fmt.Fprintf(out, "  %-11s %s\n", "config", "Checks a config file and prints the effective configuration.")
+	fmt.Fprintf(out, "  %-11s %s\n", "update", "Updates go-irl to the latest release.")
+	fmt.Fprintf(out, "\nRun %s <command> -h for the flags of a command. Without a command, go-irl runs -mode (standalone by default) and takes the flags of all modes:\n\n", os.Args[0])
+	flag.PrintDefaults()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// BELABOX cloud / belabox-receiver conventions: backpacks publish SRTLA to
+// port 5000 with a streamid of "publish/live/<key>" (older belaUI versions
+// send "live/<key>" or just "<key>").
+const (
+	BelaboxSRTLAPort      = 5000
+	BelaboxPublishPrefix  = "publish/"
+	BelaboxLiveApp        = "live/"
+	BelaboxDefaultFeedKey = "feed1"
+)
+
+// acceptStreamID decides whether a publisher with the given streamid may
+// connect. want is the streamid configured on the listener (empty accepts
+// everything). Compatibility modes replace it at startup.
+var acceptStreamID = func(want, got string) bool {
+	return want == "" || sameResource(want, got)
+}
+
+// belaboxStreamKey extracts the stream key from a BELABOX style streamid.
+func belaboxStreamKey(sid string) (string, bool) {
+	id := strings.TrimPrefix(parseStreamID(sid).Resource, BelaboxPublishPrefix)
+	if strings.HasPrefix(id, "play/") {
+		return "", false
+	}
+	id = strings.TrimPrefix(id, BelaboxLiveApp)
+	if id == "" || strings.Contains(id, "/") {
+		return "", false
+	}
+	return id, true
+}
+
+// enableBelaboxCompat makes the ingest behave like a BELABOX cloud relay so
+// backpacks configured in belaUI can point at go-irl unchanged. key limits
+// the accepted stream key; empty accepts any key.
+func enableBelaboxCompat(key string) {
+	acceptStreamID = func(want, got string) bool {
+		gotKey, ok := belaboxStreamKey(got)
+		if !ok {
+			return false
+		}
+		if key != "" && gotKey != key {
+			return false
+		}
+		if want == "" {
+			return true
+		}
+		wantKey, ok := belaboxStreamKey(want)
+		return ok && wantKey == gotKey
+	}
+
+	shown := key
+	if shown == "" {
+		shown = BelaboxDefaultFeedKey
+	}
+	log.Printf("[belabox compat] Accepting BELABOX publishers, streamid %s", belaboxStreamID(key))
+	log.Printf("[belabox compat] In belaUI use: relay server <this host>, SRTLA port %d, streamid %s%s%s",
+		*srtlaPort, BelaboxPublishPrefix, BelaboxLiveApp, shown)
+}
+
+func belaboxStreamID(key string) string {
+	if key == "" {
+		return fmt.Sprintf("%s%s<any key>", BelaboxPublishPrefix, BelaboxLiveApp)
+	}
+	return BelaboxPublishPrefix + BelaboxLiveApp + key
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configEntry is one option of a -config file, with the values of a
+// repeatable flag in order.
+type configEntry struct {
+	line   int
+	name   string
+	values []string
+}
+
+// configKey is what a flag name looks like in a config file. TOML style
+// underscores are accepted for the dashes.
+var configKey = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// parseConfig reads the options of a -config file: one flag per line, as
+// `name: value` (YAML) or `name = "value"` (TOML), without the dash.
+// Repeatable flags take a list, `[a, b]` or YAML `- item` lines below the
+// name. '#' starts a comment. Only this flat subset of both formats is
+// understood, since every option is a flag; nested maps and TOML tables are
+// refused rather than misread.
+func parseConfig(data string) ([]configEntry, []error) {
+	var entries []configEntry
+	var errs []error
+	var list *configEntry // entry waiting for its "- item" lines
+	for i, raw := range strings.Split(data, "\n") {
+		n := i + 1
+		line, err := stripConfigComment(raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%d: %v", n, err))
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || trimmed == "---":
+			continue
+		case strings.HasPrefix(trimmed, "- ") || trimmed == "-":
+			if list == nil {
+				errs = append(errs, fmt.Errorf("%d: list item without an option name above it", n))
+				continue
+			}
+			v, err := configScalar(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%d: %v", n, err))
+				continue
+			}
+			list.values = append(list.values, v)
+			continue
+		case strings.HasPrefix(trimmed, "["):
+			errs = append(errs, fmt.Errorf("%d: TOML tables are not supported, options go at the top level", n))
+			continue
+		case line != strings.TrimLeft(line, " \t"):
+			errs = append(errs, fmt.Errorf("%d: nested options are not supported, options go at the top level", n))
+			continue
+		}
+		list = nil
+
+		sep := strings.IndexAny(trimmed, ":=")
+		if sep < 0 {
+			errs = append(errs, fmt.Errorf("%d: expected name: value or name = value", n))
+			continue
+		}
+		name := strings.Trim(strings.TrimSpace(trimmed[:sep]), `"'`)
+		if !configKey.MatchString(name) {
+			errs = append(errs, fmt.Errorf("%d: invalid option name %q", n, name))
+			continue
+		}
+		e := configEntry{line: n, name: strings.ReplaceAll(name, "_", "-")}
+		value := strings.TrimSpace(trimmed[sep+1:])
+		switch {
+		case value == "" && trimmed[sep] == ':':
+			entries = append(entries, e)
+			list = &entries[len(entries)-1] // a YAML list or an empty value
+			continue
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			for _, item := range splitConfigList(value[1 : len(value)-1]) {
+				v, err := configScalar(item)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%d: %v", n, err))
+					continue
+				}
+				e.values = append(e.values, v)
+			}
+		default:
+			v, err := configScalar(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%d: %v", n, err))
+				continue
+			}
+			e.values = []string{v}
+		}
+		entries = append(entries, e)
+	}
+	return entries, errs
+}
+
+// stripConfigComment cuts a '#' comment off line. Like in YAML, a '#'
+// inside a word, e.g. in a URL, doesn't start one.
+func stripConfigComment(line string) (string, error) {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case quote == '"' && escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i], nil
+		}
+	}
+	if quote != 0 {
+		return "", fmt.Errorf("unterminated %c quote", quote)
+	}
+	return line, nil
+}
+
+// splitConfigList splits the inside of [a, "b, c"] at the commas outside
+// quotes.
+func splitConfigList(s string) []string {
+	var items []string
+	var quote rune
+	escaped := false
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote == '"' && escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+// configScalar unquotes a value: "..." with backslash escapes as in YAML
+// and TOML, '...' literally with a doubled ' for a quote as in YAML.
+func configScalar(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", s)
+		}
+		return v, nil
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
+
+// flagOrigins tells where each flag that isn't at its default was set: on
+// the command line, by its variable or on a line of the -config file.
+var flagOrigins = map[string]string{}
+
+// OriginCommandLine is the origin of flags given as arguments.
+const OriginCommandLine = "command line"
+
+// repeatableFlag is a flag that collects its values, e.g. -srt-output.
+type repeatableFlag interface {
+	list() []string
+}
+
+func (o *srtOutputs) list() []string { return *o }
+func (s *sinkList) list() []string   { return *s }
+
+func (a *eventActions) list() []string {
+	var l []string
+	for _, act := range *a {
+		l = append(l, act.pattern+"="+act.command)
+	}
+	return l
+}
+
+func (f *filterStages) list() []string {
+	var l []string
+	for _, s := range *f {
+		if s.arg != "" {
+			l = append(l, s.name+":"+s.arg)
+		} else {
+			l = append(l, s.name)
+		}
+	}
+	return l
+}
+
+func isRepeatable(f *flag.Flag) bool {
+	_, ok := f.Value.(repeatableFlag)
+	return ok
+}
+
+// invalidValue explains why v is no value for f. The flag package only
+// says "parse error" for its own types, so those name what they take.
+func invalidValue(f *flag.Flag, v string, err error) error {
+	kind := ""
+	if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+		kind = "true or false"
+	} else {
+		switch typ, _ := flag.UnquoteUsage(f); typ {
+		case "int", "uint":
+			kind = "a whole number"
+		case "float":
+			kind = "a number"
+		case "duration":
+			kind = "a duration like 500ms or 1m30s"
+		}
+	}
+	if kind != "" {
+		return fmt.Errorf("%s takes %s, not %q", f.Name, kind, v)
+	}
+	return fmt.Errorf("%s: %v", f.Name, err)
+}
+
+// flagsSet returns the flags given so far, on the command line or by the
+// environment, and notes the ones from the command line in flagOrigins.
+// With a mode subcommand, the mode counts as given.
+func flagsSet(all *flag.FlagSet) map[string]bool {
+	set := map[string]bool{}
+	flag.CommandLine.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+		if flagOrigins[f.Name] == "" {
+			flagOrigins[f.Name] = OriginCommandLine
+		}
+	})
+	if flag.CommandLine != all {
+		set["mode"] = true
+		flagOrigins["mode"] = OriginCommandLine
+	}
+	return set
+}
+
+// EnvPrefix starts the environment variable of every flag, e.g.
+// GOIRL_SRTLA_PORT for -srtla-port.
+const EnvPrefix = "GOIRL_"
+
+func flagEnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadEnv sets the flags that were not given on the command line from
+// their GOIRL_ variables, so secrets like the passphrase don't have to be
+// on the command line, where every user of the machine can read them.
+// Repeatable flags take one value per line. Variables of flags that don't
+// apply to the mode of a mode subcommand are skipped, since containers
+// often share them; variables that match no flag are warned about.
+func loadEnv(all *flag.FlagSet) (warnings []string, errs []error) {
+	onCommandLine := flagsSet(all)
+	known := map[string]bool{}
+	all.VisitAll(func(f *flag.Flag) {
+		name := flagEnvName(f.Name)
+		known[name] = true
+		v, ok := os.LookupEnv(name)
+		if !ok || onCommandLine[f.Name] || flag.CommandLine.Lookup(f.Name) == nil {
+			return
+		}
+		values := []string{v}
+		if isRepeatable(f) {
+			values = strings.Split(strings.TrimRight(v, "\n"), "\n")
+		}
+		for _, value := range values {
+			if err := flag.CommandLine.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", name, invalidValue(f, value, err)))
+				return
+			}
+			flagOrigins[f.Name] = name
+		}
+	})
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, EnvPrefix) && !known[name] {
+			warnings = append(warnings, fmt.Sprintf("%s matches no flag and is ignored", name))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings, errs
+}
+
+// loadConfig sets the flags from the -config file at path that were not
+// given on the command line or by the environment, so a unit file or a
+// second instance only has to pass what differs. all is the full flag
+// set; options of other modes than the one of a mode subcommand are
+// skipped with a warning. It goes on after a bad option, so every problem
+// of the file is reported at once.
+func loadConfig(path string, all *flag.FlagSet) (warnings []string, errs []error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []error{err}
+	}
+	entries, errs := parseConfig(string(data))
+	for i, err := range errs {
+		errs[i] = fmt.Errorf("%s:%v", path, err)
+	}
+	defer func() {
+		// by line, whether the line didn't parse or its option is wrong
+		sort.SliceStable(errs, func(i, j int) bool { return configErrorLine(errs[i], path) < configErrorLine(errs[j], path) })
+	}()
+	onCommandLine := flagsSet(all)
+
+	seen := map[string]int{}
+	for _, e := range entries {
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("%s:%d: %s", path, e.line, fmt.Sprintf(format, args...)))
+		}
+		if prev, ok := seen[e.name]; ok {
+			fail("%s is already set on line %d", e.name, prev)
+			continue
+		}
+		seen[e.name] = e.line
+		f := all.Lookup(e.name)
+		switch {
+		case f == nil || e.name == "config":
+			fail("unknown option %s", e.name)
+			continue
+		case len(e.values) > 1 && !isRepeatable(f):
+			fail("%s takes one value, not a list", e.name)
+			continue
+		case onCommandLine[e.name]:
+			continue
+		case flag.CommandLine.Lookup(e.name) == nil:
+			warnings = append(warnings, fmt.Sprintf("%s in %s is ignored in %s mode, it applies to %s", e.name, path, *mode, strings.ReplaceAll(flagRoles[e.name], ",", "/")))
+			continue
+		}
+		for _, v := range e.values {
+			if err := flag.CommandLine.Set(e.name, v); err != nil {
+				fail("%v", invalidValue(f, v, err))
+				break
+			}
+			flagOrigins[e.name] = fmt.Sprintf("%s:%d", path, e.line)
+		}
+	}
+	return warnings, errs
+}
+
+func configErrorLine(err error, path string) int {
+	line, _, _ := strings.Cut(strings.TrimPrefix(err.Error(), path+":"), ":")
+	n, _ := strconv.Atoi(line)
+	return n
+}
+
+// loadOptions fills in the flags not given on the command line, from the
+// environment and then from the -config file, which may itself be given
+// as GOIRL_CONFIG. It returns every problem it finds.
+func loadOptions(all *flag.FlagSet) (warnings []string, errs []error) {
+	warnings, errs = loadEnv(all)
+	if *configPath != "" {
+		moreWarnings, moreErrs := loadConfig(*configPath, all)
+		warnings = append(warnings, moreWarnings...)
+		for _, err := range moreErrs {
+			errs = append(errs, fmt.Errorf("-config: %w", err))
+		}
+	}
+	return warnings, errs
+}
+
+// applyOptions is loadOptions for a run: it logs the warnings and exits
+// on errors.
+func applyOptions(all *flag.FlagSet) {
+	warnings, errs := loadOptions(all)
+	for _, w := range warnings {
+		log.Printf("WARNING: %s", w)
+	}
+	if len(errs) == 0 {
+		return
+	}
+	for _, err := range errs[:len(errs)-1] {
+		log.Printf("ERROR: %v", err)
+	}
+	log.Fatalf("ERROR: %v", errs[len(errs)-1])
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// secretFlags are masked when config check prints the configuration.
+var secretFlags = map[string]bool{
+	"cluster-secret": true,
+	"control-token":  true,
+	"log-http-token": true,
+	"mediamtx-pass":  true,
+	"obs-password":   true,
+	"passphrase":     true,
+	"stream-key":     true,
+}
+
+// runConfig runs `go-irl config check`: it loads the options the way a run
+// would, from the command line, the environment and the -config file,
+// reports every problem with its file and line, and prints the resulting
+// configuration. The flags are the schema: an option has to name a flag
+// of the mode and its value has to parse as one.
+func runConfig(args []string) {
+	configUsage := func() {
+		fmt.Fprintf(os.Stderr, `Usage: go-irl config check [-all] [-show-secrets] [mode] [flags] [file]
+
+Checks the config file, or -config, together with the GOIRL_ variables and
+the flags as a run with the same arguments would, and prints the effective
+configuration in the -config format. Exits 1 on errors.
+
+  -all           Also print the options left at their default
+  -show-secrets  Print passphrases, passwords and tokens instead of masking them
+`)
+	}
+	if len(args) == 0 || args[0] != "check" {
+		configUsage()
+		os.Exit(2)
+	}
+	args = args[1:]
+	showAll, showSecrets := false, false
+options:
+	for len(args) > 0 {
+		switch strings.TrimPrefix(args[0], "-") {
+		case "-all", "all":
+			showAll = true
+		case "-show-secrets", "show-secrets":
+			showSecrets = true
+		case "-help", "help", "h":
+			configUsage()
+			os.Exit(0)
+		default:
+			break options
+		}
+		args = args[1:]
+	}
+	allFlags := flag.CommandLine
+	if len(args) > 0 && isModeCommand(args[0]) {
+		useModeCommand(args[0])
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	switch flag.CommandLine.NArg() {
+	case 0:
+	case 1:
+		flag.CommandLine.Set("config", flag.CommandLine.Arg(0))
+	default:
+		configUsage()
+		os.Exit(2)
+	}
+
+	warnings, errs := loadOptions(allFlags)
+	var problems []string
+	for _, err := range errs {
+		problems = append(problems, err.Error())
+	}
+	if *configPath == "" {
+		problems = append(problems, "no config file, give one or -config")
+	}
+	var err error
+	if roles, err = parseModes(*mode); err != nil {
+		problems = append(problems, err.Error())
+	} else if len(errs) == 0 { // a run stops before checking the flags too
+		moreWarnings, moreProblems := validateFlags()
+		warnings = append(warnings, moreWarnings...)
+		problems = append(problems, moreProblems...)
+	}
+
+	printConfig(allFlags, showAll, showSecrets)
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", w)
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%s: OK\n", *configPath)
+}
+
+// printConfig writes the options in effect as a YAML -config file, each
+// with a comment telling where it was set.
+func printConfig(all *flag.FlagSet, showAll, showSecrets bool) {
+	m := *mode
+	if m == "" {
+		m = ModeStandalone
+	}
+	fmt.Printf("# effective configuration of go-irl %s\n", m)
+	all.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || (f.Name != "mode" && flag.CommandLine.Lookup(f.Name) == nil) {
+			return
+		}
+		origin := flagOrigins[f.Name]
+		if origin == "" {
+			if !showAll {
+				return
+			}
+			origin = "default"
+		}
+		show := func(v string) string {
+			switch {
+			case showSecrets:
+				return configValue(v)
+			case secretFlags[f.Name] && v != "":
+				return "xxxxx"
+			}
+			return configValue(redactURL(v))
+		}
+		r, ok := f.Value.(repeatableFlag)
+		if !ok {
+			fmt.Printf("%s: %s  # %s\n", f.Name, show(f.Value.String()), origin)
+			return
+		}
+		values := r.list()
+		if len(values) == 0 {
+			fmt.Printf("%s: []  # %s\n", f.Name, origin)
+			return
+		}
+		fmt.Printf("%s:  # %s\n", f.Name, origin)
+		for _, v := range values {
+			fmt.Printf("  - %s\n", show(v))
+		}
+	})
+}
+
+// configValue quotes v where parseConfig would read it differently bare.
+func configValue(v string) string {
+	if v == "" || v != strings.TrimSpace(v) || strings.ContainsAny(v, "\"'\n\t") ||
+		strings.Contains(v, " #") || strings.HasPrefix(v, "#") || strings.HasPrefix(v, "[") ||
+		strings.HasPrefix(v, "- ") || v == "-" {
+		return strconv.Quote(v)
+	}
+	return v
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// controlToken authorizes WebSocket clients to send commands and API
+// clients to change settings, see -control-token. Without it both are
+// disabled.
+var controlToken string
+
+// brbActive is the state of the BRB (be right back) scene, switched by
+// the brb command and followed by the Browser Source.
+var brbActive atomic.Bool
+
+// activeProxy is the SRT proxy whose publisher the kick command closes.
+var activeProxy atomic.Pointer[failoverWriter]
+
+// controlCommand is a command sent by a WebSocket client:
+//
+//	{"type": "command", "id": "1", "command": "record.stop"}
+//
+// id is echoed in the command_result, so the client can match the two.
+type controlCommand struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	Label   string `json:"label"`  // chapter
+	Active  *bool  `json:"active"` // brb, toggles without it
+}
+
+type commandResult struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"` // always "command_result"
+	ID        string    `json:"id,omitempty"`
+	Command   string    `json:"command"`
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	Result    any       `json:"result,omitempty"`
+}
+
+type brbMessage struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"` // always "brb"
+	Active    bool      `json:"active"`
+}
+
+var errNoRecorder = errors.New("recording is not enabled, see -record")
+
+// controlAuthorized reports whether token is the -control-token.
+func controlAuthorized(token string) bool {
+	return controlToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(controlToken)) == 1
+}
+
+// requireControl guards an API route that changes state with the
+// -control-token, sent as a bearer token like a WebSocket client's. GET
+// and HEAD requests pass, for routes that also serve reads.
+func requireControl(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		case controlToken == "":
+			http.Error(w, "changes through the API are disabled, set -control-token", http.StatusForbidden)
+			return
+		case !controlAuthorized(widgetToken(r)):
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "wrong or missing -control-token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// wsRequestToken returns the token a WebSocket client connected with,
+// ?token= for browsers, which can't set headers on WebSockets.
+func wsRequestToken(r *http.Request) string {
+	if token := widgetToken(r); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// runCommand dispatches cmd to its subsystem and returns the result for
+// the client.
+func runCommand(c *wsClient, cmd controlCommand) commandResult {
+	res := commandResult{Timestamp: time.Now(), Type: "command_result", ID: cmd.ID, Command: cmd.Command}
+	var err error
+	switch {
+	case !c.control:
+		err = errors.New("not authorized, connect with the -control-token or send an auth message")
+	default:
+		res.Result, err = dispatchCommand(cmd)
+	}
+	if err != nil {
+		res.Error = err.Error()
+		log.Printf("[control] %s from %s failed: %v", cmd.Command, c.remote, err)
+	} else {
+		res.OK = true
+		log.Printf("[control] %s from %s", cmd.Command, c.remote)
+		emitEvent("control.command", map[string]any{"command": cmd.Command, "remote": c.remote})
+	}
+	return res
+}
+
+func dispatchCommand(cmd controlCommand) (any, error) {
+	switch cmd.Command {
+	case "record.start", "record.stop":
+		if rec == nil {
+			return nil, errNoRecorder
+		}
+		rec.setStopped(cmd.Command == "record.stop")
+		return nil, nil
+	case "chapter":
+		if rec == nil {
+			return nil, errNoRecorder
+		}
+		return rec.addMarker(cmd.Label)
+	case "brb":
+		active := !brbActive.Load()
+		if cmd.Active != nil {
+			active = *cmd.Active
+		}
+		brbActive.Store(active)
+		publishMessage(brbMessage{Timestamp: time.Now(), Type: "brb", Active: active})
+		return map[string]bool{"active": active}, nil
+	case "kick":
+		f := activeProxy.Load()
+		if f == nil || !f.kick() {
+			return nil, errors.New("no publisher connected")
+		}
+		return nil, nil
+	}
+	return nil, errors.New("unknown command")
+}
+
+// handleControlMessage handles the auth and command messages of c. It is
+// called by the read loop of c, so c.send is still open.
+func handleControlMessage(c *wsClient, typ string, data []byte) {
+	switch typ {
+	case "auth":
+		var msg struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(data, &msg)
+		c.control = controlAuthorized(msg.Token)
+		c.reply(commandResult{Timestamp: time.Now(), Type: "command_result", Command: "auth", OK: c.control})
+	case "command":
+		var cmd controlCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			return
+		}
+		c.reply(runCommand(c, cmd))
+	}
+}
+
+// brbWelcome tells new clients that BRB is on.
+func brbWelcome() []byte {
+	if !brbActive.Load() {
+		return nil
+	}
+	data, _ := json.Marshal(brbMessage{Timestamp: time.Now(), Type: "brb", Active: true})
+	return data
+}
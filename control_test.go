@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withControlToken sets the -control-token for the test.
+func withControlToken(t *testing.T, token string) {
+	saved := controlToken
+	controlToken = token
+	t.Cleanup(func() { controlToken = saved })
+}
+
+func apiRequest(h http.HandlerFunc, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestRequireControl(t *testing.T) {
+	reached := false
+	h := requireControl(func(w http.ResponseWriter, r *http.Request) { reached = true })
+	for _, tc := range []struct {
+		setting, method, token string
+		want                   int
+	}{
+		{"", "GET", "", http.StatusOK},
+		{"", "PUT", "", http.StatusForbidden},
+		{"", "PUT", "anything", http.StatusForbidden},
+		{"s3cret", "HEAD", "", http.StatusOK},
+		{"s3cret", "PUT", "", http.StatusUnauthorized},
+		{"s3cret", "POST", "wrong", http.StatusUnauthorized},
+		{"s3cret", "DELETE", "s3cret", http.StatusOK},
+		{"s3cret", "PUT", "s3cret", http.StatusOK},
+	} {
+		withControlToken(t, tc.setting)
+		reached = false
+		rec := apiRequest(h, tc.method, "/api/toggles/record", tc.token)
+		if rec.Code != tc.want || reached != (tc.want == http.StatusOK) {
+			t.Errorf("-control-token %q, %s with %q: status %d, handler reached %v, want %d", tc.setting, tc.method, tc.token, rec.Code, reached, tc.want)
+		}
+	}
+}
+
+// TestWidgetRegisterNeedsControl checks that only the -control-token
+// registers new widgets, while a registered source keeps its name with its
+// own token.
+func TestWidgetRegisterNeedsControl(t *testing.T) {
+	withControlToken(t, "s3cret")
+	t.Cleanup(func() {
+		widgets.mu.Lock()
+		delete(widgets.sources, "score")
+		widgets.mu.Unlock()
+	})
+	register := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/widgets/score", strings.NewReader(`{}`))
+		req.SetPathValue("name", "score")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handleWidgetRegister(rec, req)
+		return rec
+	}
+
+	if rec := register(""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("registering without a token: status %d", rec.Code)
+	}
+	rec := register("s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("registering with the control token: status %d %s", rec.Code, rec.Body)
+	}
+	widgets.mu.Lock()
+	token := widgets.sources["score"].token
+	widgets.mu.Unlock()
+	if rec := register(token); rec.Code != http.StatusOK {
+		t.Fatalf("re-registering with the widget token: status %d", rec.Code)
+	}
+	if rec := register("wrong"); rec.Code != http.StatusConflict {
+		t.Fatalf("re-registering with a wrong token: status %d", rec.Code)
+	}
+}
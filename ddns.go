@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DDNSCheckPeriod    = 5 * time.Minute // how often the public IP is looked up
+	DDNSRetryPeriod    = 1 * time.Minute // after a failed lookup or update
+	DDNSForcePeriod    = 24 * time.Hour  // update even if the IP did not change, DuckDNS expires idle names
+	DDNSRequestTimeout = 30 * time.Second
+
+	ddnsIPLookupURL = "https://api.ipify.org"
+	cloudflareAPI   = "https://api.cloudflare.com/client/v4"
+	duckDNSUpdate   = "https://www.duckdns.org/update"
+)
+
+// ddnsProvider points a hostname at the public IP, see -ddns.
+type ddnsProvider interface {
+	String() string
+	hostname() string
+	update(ip netip.Addr) error
+}
+
+// ddnsStatus is served at /api/ddns.
+type ddnsStatus struct {
+	Provider   string     `json:"provider"`
+	Hostname   string     `json:"hostname"`
+	Ingest     string     `json:"ingest"` // what senders should use
+	IP         string     `json:"ip,omitempty"`
+	LastCheck  *time.Time `json:"lastCheck,omitempty"`
+	LastUpdate *time.Time `json:"lastUpdate,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type ddnsUpdater struct {
+	provider ddnsProvider
+	client   *http.Client
+
+	mu     sync.Mutex
+	status ddnsStatus
+}
+
+// ddns keeps the -ddns hostname up to date, nil unless it is set.
+var ddns *ddnsUpdater
+
+func parseDDNSProvider(spec string) (ddnsProvider, error) {
+	kind, name, _ := strings.Cut(spec, ":")
+	if name == "" {
+		return nil, fmt.Errorf("expected cloudflare:<hostname> or duckdns:<subdomain>")
+	}
+	client := &http.Client{Timeout: DDNSRequestTimeout}
+	switch kind {
+	case "cloudflare":
+		token := os.Getenv("CLOUDFLARE_API_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("CLOUDFLARE_API_TOKEN must be set")
+		}
+		return &cloudflareDNS{name: strings.ToLower(name), token: token, client: client}, nil
+	case "duckdns":
+		token := os.Getenv("DUCKDNS_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("DUCKDNS_TOKEN must be set")
+		}
+		return &duckDNS{subdomain: strings.TrimSuffix(strings.ToLower(name), ".duckdns.org"), token: token, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q (expected cloudflare or duckdns)", kind)
+}
+
+// startDDNS looks up the public IP every DDNSCheckPeriod and updates the
+// provider's record when it changed.
+func startDDNS(p ddnsProvider, ingestPort int) {
+	ingest := fmt.Sprintf("%s:%d", p.hostname(), ingestPort)
+	ddns = &ddnsUpdater{
+		provider: p,
+		client:   &http.Client{Timeout: DDNSRequestTimeout},
+		status:   ddnsStatus{Provider: p.String(), Hostname: p.hostname(), Ingest: ingest},
+	}
+	log.Printf("[ddns] Keeping %s pointed at this network's public IP (%s), senders can use srtla://%s", p.hostname(), p, ingest)
+	go supervise("ddns", ddns.run)
+}
+
+func (d *ddnsUpdater) run() {
+	var current netip.Addr
+	var updated time.Time
+	for {
+		wait := DDNSCheckPeriod
+		ip, err := d.publicIP()
+		if err == nil && (ip != current || time.Since(updated) >= DDNSForcePeriod) {
+			if err = d.provider.update(ip); err == nil {
+				if ip != current {
+					log.Printf("[ddns] %s now points at %s", d.provider.hostname(), ip)
+					emitEvent("ddns.updated", map[string]any{"hostname": d.provider.hostname(), "ip": ip.String(), "previous": addrOrEmpty(current)})
+				}
+				current, updated = ip, time.Now()
+			}
+		}
+		if err != nil {
+			log.Printf("[ddns] Update failed, retrying in %s: %v", DDNSRetryPeriod, err)
+			emitEvent("ddns.failed", map[string]any{"hostname": d.provider.hostname(), "error": err.Error()})
+			wait = DDNSRetryPeriod
+		}
+
+		now := time.Now()
+		d.mu.Lock()
+		d.status.LastCheck, d.status.Error = &now, ""
+		if current.IsValid() {
+			d.status.IP = current.String()
+			d.status.LastUpdate = &updated
+		}
+		if err != nil {
+			d.status.Error = err.Error()
+		}
+		d.mu.Unlock()
+
+		time.Sleep(wait)
+	}
+}
+
+func addrOrEmpty(a netip.Addr) string {
+	if !a.IsValid() {
+		return ""
+	}
+	return a.String()
+}
+
+func (d *ddnsUpdater) publicIP() (netip.Addr, error) {
+	resp, err := d.client.Get(ddnsIPLookupURL)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("public IP lookup: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("public IP lookup: %s", resp.Status)
+	}
+	ip, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil || !ip.Is4() {
+		return netip.Addr{}, fmt.Errorf("public IP lookup: unexpected answer %q", bytes.TrimSpace(body))
+	}
+	return ip, nil
+}
+
+// handleDDNS serves /api/ddns with the hostname and the IP it points at.
+func handleDDNS(w http.ResponseWriter, r *http.Request) {
+	if ddns == nil {
+		http.Error(w, "dynamic DNS is not enabled (-ddns)", http.StatusNotFound)
+		return
+	}
+	ddns.mu.Lock()
+	status := ddns.status
+	ddns.mu.Unlock()
+	writeJSON(w, status)
+}
+
+// duckDNS updates <subdomain>.duckdns.org, with the token from
+// DUCKDNS_TOKEN.
+type duckDNS struct {
+	subdomain, token string
+	client           *http.Client
+}
+
+func (p *duckDNS) String() string   { return "duckdns" }
+func (p *duckDNS) hostname() string { return p.subdomain + ".duckdns.org" }
+
+func (p *duckDNS) update(ip netip.Addr) error {
+	q := url.Values{"domains": {p.subdomain}, "token": {p.token}, "ip": {ip.String()}}
+	resp, err := p.client.Get(duckDNSUpdate + "?" + q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+	// DuckDNS answers 200 with OK or KO, it gives no reason
+	if resp.StatusCode != http.StatusOK || !bytes.HasPrefix(body, []byte("OK")) {
+		return fmt.Errorf("duckdns: %s %s (check the subdomain and DUCKDNS_TOKEN)", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// cloudflareDNS updates the A record of a hostname in a Cloudflare zone,
+// creating it if needed. CLOUDFLARE_API_TOKEN needs the Zone:Read and
+// DNS:Edit permissions.
+type cloudflareDNS struct {
+	name, token string
+	client      *http.Client
+
+	zoneID, recordID string            // looked up on the first update
+	txtIDs           map[string]string // ACME challenge value -> zone/dns_records/ID, see acme.go
+}
+
+func (p *cloudflareDNS) String() string   { return "cloudflare" }
+func (p *cloudflareDNS) hostname() string { return p.name }
+
+func (p *cloudflareDNS) update(ip netip.Addr) error {
+	if p.zoneID == "" {
+		if err := p.lookup(); err != nil {
+			return err
+		}
+	}
+	record := map[string]any{"type": "A", "name": p.name, "content": ip.String(), "ttl": 60, "proxied": false}
+	if p.recordID == "" {
+		var created struct {
+			ID string `json:"id"`
+		}
+		if err := p.call(http.MethodPost, "/zones/"+p.zoneID+"/dns_records", record, &created); err != nil {
+			return err
+		}
+		p.recordID = created.ID
+		return nil
+	}
+	if err := p.call(http.MethodPut, "/zones/"+p.zoneID+"/dns_records/"+p.recordID, record, nil); err != nil {
+		p.zoneID, p.recordID = "", "" // look them up again, the record may have been removed
+		return err
+	}
+	return nil
+}
+
+// lookup finds the zone of p.name and the record's ID if it exists.
+func (p *cloudflareDNS) lookup() error {
+	zoneID, err := p.zone(p.name)
+	if err != nil {
+		return err
+	}
+	var records []struct {
+		ID string `json:"id"`
+	}
+	q := url.Values{"type": {"A"}, "name": {p.name}}
+	if err := p.call(http.MethodGet, "/zones/"+zoneID+"/dns_records?"+q.Encode(), nil, &records); err != nil {
+		return err
+	}
+	p.zoneID = zoneID
+	if len(records) > 0 {
+		p.recordID = records[0].ID
+	}
+	return nil
+}
+
+// zone returns the ID of the zone name is in, trying every parent domain.
+func (p *cloudflareDNS) zone(name string) (string, error) {
+	var zones []struct {
+		ID string `json:"id"`
+	}
+	for zone := name; strings.Contains(zone, "."); zone = zone[strings.Index(zone, ".")+1:] {
+		if err := p.call(http.MethodGet, "/zones?name="+url.QueryEscape(zone), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("cloudflare: no zone for %s in this account", name)
+}
+
+// call sends a Cloudflare API request and decodes the result into out.
+func (p *cloudflareDNS) call(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, _ := json.Marshal(in)
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, cloudflareAPI+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("cloudflare: %s", resp.Status)
+	}
+	if !res.Success {
+		msgs := []string{}
+		for _, e := range res.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return fmt.Errorf("cloudflare: %s %s", resp.Status, strings.Join(msgs, "; "))
+	}
+	if out != nil {
+		return json.Unmarshal(res.Result, out)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import "encoding/binary"
+
+// DedupWindow is how many recent sequence numbers a group remembers to
+// recognize duplicates.
+const DedupWindow = 8192
+
+// srtRetransmitFlag is the R bit in the second word of an SRT data packet
+// header.
+const srtRetransmitFlag = 1 << 26
+
+// dedupPackets drops copies of data packets that senders duplicate over
+// several links, see -dedup.
+var dedupPackets bool
+
+// dedupWindow remembers the sequence numbers of recently forwarded data
+// packets. Slot sn%DedupWindow holds sn once it has been seen.
+type dedupWindow struct {
+	seen [DedupWindow]int32
+}
+
+func newDedupWindow() *dedupWindow {
+	w := new(dedupWindow)
+	for i := range w.seen {
+		w.seen[i] = -1
+	}
+	return w
+}
+
+// duplicate reports whether pkt is a copy of a data packet that was already
+// forwarded, and remembers it otherwise. Retransmissions are never treated
+// as duplicates: the SRT server asked for them, possibly because the first
+// copy was lost between here and there.
+func (w *dedupWindow) duplicate(pkt []byte, sn int32) bool {
+	if len(pkt) >= 8 && binary.BigEndian.Uint32(pkt[4:])&srtRetransmitFlag != 0 {
+		return false
+	}
+	slot := &w.seen[uint32(sn)%DedupWindow]
+	if *slot == sn {
+		return true
+	}
+	*slot = sn
+	return false
+}
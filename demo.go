@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	mathrand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// DemoBitrateKbps is the bitrate of the stream -demo sends without
+// -demo-input.
+const DemoBitrateKbps = 5000
+
+// demoLinkSpec is a simulated uplink of the -demo sender.
+type demoLinkSpec struct {
+	name    string
+	label   string
+	delay   time.Duration // one way, added to every packet
+	jitter  time.Duration // random extra delay up to this much
+	lossPct float64
+	capKbps int
+
+	// the link drops out for outage at the start of every outageEvery but
+	// the first; 0 for a link that never drops
+	outageEvery time.Duration
+	outage      time.Duration
+}
+
+// demoLinks resemble a typical IRL backpack: a good 5G modem, a congested
+// LTE modem whose loss comes and goes, and venue WiFi that drops out.
+var demoLinks = []demoLinkSpec{
+	{name: "demo-5g", label: "5G", delay: 20 * time.Millisecond, jitter: 5 * time.Millisecond, lossPct: 0.2},
+	{name: "demo-lte", label: "LTE", delay: 45 * time.Millisecond, jitter: 30 * time.Millisecond, lossPct: 3, capKbps: 2500},
+	{name: "demo-wifi", label: "Venue WiFi", delay: 10 * time.Millisecond, jitter: 60 * time.Millisecond, lossPct: 6, capKbps: 1500, outageEvery: 90 * time.Second, outage: 15 * time.Second},
+}
+
+// linkImpairment makes a bond link behave like a worse network: it delays,
+// jitters and drops what the link sends. A nil impairment passes packets
+// through unchanged.
+type linkImpairment struct {
+	spec    demoLinkSpec
+	started time.Time
+	mu      sync.Mutex
+	rng     *mathrand.Rand
+	down    bool
+}
+
+func newLinkImpairment(spec demoLinkSpec) *linkImpairment {
+	return &linkImpairment{spec: spec, started: time.Now(), rng: mathrand.New(mathrand.NewSource(time.Now().UnixNano()))}
+}
+
+func (m *linkImpairment) write(conn *net.UDPConn, pkt []byte) {
+	if m == nil {
+		conn.Write(pkt)
+		return
+	}
+	now := time.Now()
+	m.mu.Lock()
+	down := m.inOutage(now)
+	if down != m.down {
+		m.down = down
+		if down {
+			log.Printf("[demo] [%s] Simulating an outage for %v", m.spec.name, m.spec.outage)
+		} else {
+			log.Printf("[demo] [%s] Outage over", m.spec.name)
+		}
+	}
+	// The loss comes and goes in waves of a minute
+	loss := m.spec.lossPct * (1 + 0.8*math.Sin(now.Sub(m.started).Seconds()*2*math.Pi/60))
+	drop := down || m.rng.Float64()*100 < loss
+	delay := m.spec.delay
+	if m.spec.jitter > 0 {
+		delay += time.Duration(m.rng.Int63n(int64(m.spec.jitter)))
+	}
+	m.mu.Unlock()
+	if drop {
+		return
+	}
+	b := append([]byte(nil), pkt...)
+	time.AfterFunc(delay, func() { conn.Write(b) })
+}
+
+// inOutage must be called with m.mu held.
+func (m *linkImpairment) inOutage(now time.Time) bool {
+	if m.spec.outageEvery <= 0 {
+		return false
+	}
+	since := now.Sub(m.started)
+	return since >= m.spec.outageEvery && since%m.spec.outageEvery < m.spec.outage
+}
+
+// runDemo sends a stream to the local SRTLA port over the demoLinks, once
+// the port is up, for screenshots, tutorials and UI work without a phone.
+func runDemo(srtlaPort int, input string) {
+	if _, err := waitReady(StartupTimeout, nil, ReadySRTLA); err != nil {
+		log.Printf("[demo] %v, starting the demo sender anyway", err)
+	}
+	in, err := openDemoInput(input)
+	if err != nil {
+		log.Printf("[demo] Failed to open -demo-input: %v", err)
+		return
+	}
+
+	b := newBondSender(bondConfig{Server: fmt.Sprintf("127.0.0.1:%d", srtlaPort), Listen: "127.0.0.1:0"})
+	b.mu.Lock()
+	for _, spec := range demoLinks {
+		l := b.newLink(spec.name)
+		l.localIP = net.IPv4(127, 0, 0, 1)
+		l.label, l.capKbps, l.impair = spec.label, spec.capKbps, newLinkImpairment(spec)
+		b.links = append(b.links, l)
+	}
+	b.mu.Unlock()
+	b.start()
+
+	log.Printf("[demo] Sending %s over %d simulated links", demoInputName(input), len(demoLinks))
+	config := srt.DefaultConfig()
+	if *passphrase != "" && runsProxy() {
+		config.Passphrase = *passphrase
+	}
+	sendStream(in, b.local.LocalAddr().String(), config, &stats{interval: statsInterval})
+}
+
+func openDemoInput(input string) (io.Reader, error) {
+	if input == "" {
+		return newNullStream(DemoBitrateKbps), nil
+	}
+	return openInput(input)
+}
+
+func demoInputName(input string) string {
+	if input == "" {
+		return fmt.Sprintf("a %d kbps stream of MPEG-TS null packets", DemoBitrateKbps)
+	}
+	return input
+}
+
+// nullStream is an endless MPEG-TS stream of null packets at a fixed
+// bitrate, paced like a live encoder.
+type nullStream struct {
+	period time.Duration // per SRT packet
+	next   time.Time
+}
+
+func newNullStream(kbps int) *nullStream {
+	return &nullStream{period: time.Duration(float64(SRTChunkSize*8) / float64(kbps*1000) * float64(time.Second))}
+}
+
+func (s *nullStream) Read(b []byte) (int, error) {
+	now := time.Now()
+	if s.next.IsZero() || now.Sub(s.next) > time.Second {
+		s.next = now // don't catch up after a stall
+	}
+	time.Sleep(time.Until(s.next))
+	s.next = s.next.Add(s.period)
+
+	n := min(len(b), SRTChunkSize) / TSPacketLen * TSPacketLen
+	for i := 0; i < n; i += TSPacketLen {
+		p := b[i : i+TSPacketLen]
+		p[0], p[1], p[2], p[3] = 0x47, 0x1f, 0xff, 0x10 // PID 0x1FFF, payload only
+		clear(p[4:])
+	}
+	return n, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// DetectLogInterval rate limits the log line for senders using a protocol
+// go-irl does not speak.
+const DetectLogInterval = time.Minute
+
+// srtlaRelay relays plain SRT senders that connect to the SRTLA port, see
+// -detect-protocols. Nil when detection is off.
+var srtlaRelay *srtRelay
+
+var lastUnsupportedLog time.Time // used by the SRTLA reader only
+
+// startProtocolDetection lets plain SRT senders use the SRTLA port too.
+// Their flows are told apart by the first packet: SRTLA senders start with
+// a registration, SRT callers with a handshake.
+func startProtocolDetection(ctx context.Context) {
+	srtlaRelay = newSRTRelay("srt-detect", srtAddr, srtlaSock)
+	go supervise("srt-detect-cleanup", func() { srtlaRelay.runCleanup(ctx) })
+}
+
+// detectIngest handles a packet from an address that is not part of any
+// SRTLA group. Called by the SRTLA reader goroutine only.
+func detectIngest(pkt []byte, addr netip.AddrPort) {
+	if srtlaRelay == nil || srtlaRelay.forward(pkt, addr) {
+		return
+	}
+	switch {
+	case getSRTType(pkt) == SRTTypeHandshake && len(pkt) >= SRTHandshakeSize:
+		srtlaRelay.accept(pkt, addr)
+	case isRTPMpegTS(pkt):
+		// RIST's simple profile is RTP carrying MPEG-TS
+		if time.Since(lastUnsupportedLog) >= DetectLogInterval {
+			lastUnsupportedLog = time.Now()
+			peer := net.UDPAddrFromAddrPort(addr)
+			log.Printf("[%s] RIST sender detected, which is not supported: send SRT or SRTLA instead", peer)
+			emitEvent("ingest.unsupported_protocol", map[string]any{"addr": peer.String(), "protocol": "rist"})
+		}
+	}
+}
+
+// isRTPMpegTS reports whether pkt looks like an RTP version 2 packet with
+// the MP2T payload type.
+func isRTPMpegTS(pkt []byte) bool {
+	const rtpHeaderLen, payloadTypeMP2T = 12, 33
+	return len(pkt) >= rtpHeaderLen && pkt[0]&0xc0 == 0x80 && pkt[1]&0x7f == payloadTypeMP2T
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+type groupDiagnostics struct {
+	Group       string       `json:"group"` // same %p identifier used in the logs
+	Conns       int          `json:"conns"`
+	Links       []linkStatus `json:"links"`
+	Readers     int          `json:"readers"`
+	SocketOpen  bool         `json:"socketOpen"`
+	AgeSeconds  float64      `json:"ageSeconds"`
+	IdleSeconds float64      `json:"idleSeconds"`
+	Memory      groupMemory  `json:"memory"`
+	Overloaded  bool         `json:"overloaded"` // see -backpressure
+	Forwarding  forwardStats `json:"forwarding"`
+}
+
+type diagnostics struct {
+	Goroutines        int                   `json:"goroutines"`
+	SRTReadersActive  int64                 `json:"srtReadersActive"`
+	SRTSocketsOpen    int64                 `json:"srtSocketsOpen"`
+	GroupWorkers      int64                 `json:"groupWorkers"`
+	MemoryBytes       int64                 `json:"memoryBytes"`    // sum over the groups
+	MemoryCapBytes    int64                 `json:"memoryCapBytes"` // -max-memory, 0 if unset
+	Groups            []groupDiagnostics    `json:"groups"`
+	SubsystemRestarts map[string]int        `json:"subsystemRestarts"`
+	Leaks             []string              `json:"leaks"`
+	WebSocketClients  []wsClientDiagnostics `json:"webSocketClients"`
+	Problems          []problem             `json:"problems"`                  // ongoing failures with a troubleshooting hint
+	InternalSRTAddr   string                `json:"internalSrtAddr,omitempty"` // standalone mode's internal SRT listener
+}
+
+func collectDiagnostics() diagnostics {
+	now := time.Now()
+	d := diagnostics{
+		Goroutines:        runtime.NumGoroutine(),
+		SRTReadersActive:  srtReadersActive.Load(),
+		SRTSocketsOpen:    srtSocketsOpen.Load(),
+		GroupWorkers:      groupWorkersActive.Load(),
+		MemoryCapBytes:    maxMemory,
+		Groups:            []groupDiagnostics{},
+		SubsystemRestarts: subsystemRestarts(),
+		Leaks:             []string{},
+		WebSocketClients:  []wsClientDiagnostics{},
+		Problems:          activeProblems(),
+	}
+	if addr := srtListenHost(InternalSRTHost); addr != InternalSRTHost {
+		d.InternalSRTAddr = addr
+	}
+	if statsHub != nil {
+		d.WebSocketClients = statsHub.diagnostics()
+	}
+
+	snapshot := groupList()
+
+	var readers, sockets int64
+	for _, g := range snapshot {
+		g.mu.Lock()
+		gd := groupDiagnostics{
+			Group:       fmt.Sprintf("%p", g),
+			Conns:       len(g.conns),
+			Readers:     g.readers,
+			SocketOpen:  g.srtSock != nil,
+			AgeSeconds:  now.Sub(g.createdAt).Seconds(),
+			IdleSeconds: now.Sub(g.lastActivity).Seconds(),
+			Links:       []linkStatus{},
+		}
+		for _, c := range g.conns {
+			gd.Links = append(gd.Links, c.status(gd.Group, now))
+		}
+		g.mu.Unlock()
+		gd.Memory = g.memory()
+		gd.Overloaded = g.overloaded.Load()
+		gd.Forwarding = g.forwardStats(now)
+		d.MemoryBytes += gd.Memory.Total
+
+		readers += int64(gd.Readers)
+		if gd.SocketOpen {
+			sockets++
+		}
+		if gd.Readers > 1 {
+			d.Leaks = append(d.Leaks, fmt.Sprintf("group %s has %d SRT readers", gd.Group, gd.Readers))
+		}
+		d.Groups = append(d.Groups, gd)
+	}
+
+	// Readers and sockets are counted when created and released when their
+	// goroutine exits or the socket is closed, so anything above what the
+	// live groups account for belongs to a group that is already gone.
+	if d.SRTReadersActive > readers {
+		d.Leaks = append(d.Leaks, fmt.Sprintf("%d SRT readers not owned by any group", d.SRTReadersActive-readers))
+	}
+	if d.SRTSocketsOpen > sockets {
+		d.Leaks = append(d.Leaks, fmt.Sprintf("%d SRT sockets not owned by any group", d.SRTSocketsOpen-sockets))
+	}
+	if d.GroupWorkers > int64(len(snapshot)) {
+		d.Leaks = append(d.Leaks, fmt.Sprintf("%d group workers not owned by any group", d.GroupWorkers-int64(len(snapshot))))
+	}
+	return d
+}
+
+func handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, collectDiagnostics())
+}
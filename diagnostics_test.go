@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// fetchDiagnostics returns what /api/diagnostics serves.
+func fetchDiagnostics(t *testing.T) diagnostics {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleDiagnostics(rec, httptest.NewRequest("GET", "/api/diagnostics", nil))
+	var d diagnostics
+	if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil {
+		t.Fatalf("decoding the diagnostics: %v", err)
+	}
+	return d
+}
+
+// waitReleased waits for the SRT readers and sockets to drop to what the
+// live groups hold and checks that the diagnostics see no leaks.
+func waitReleased(t *testing.T, readers, sockets int64) {
+	t.Helper()
+	eventually(t, func() bool {
+		return srtReadersActive.Load() == readers && srtSocketsOpen.Load() == sockets
+	}, "%d SRT readers and %d sockets, have %d and %d", readers, sockets, srtReadersActive.Load(), srtSocketsOpen.Load())
+	d := fetchDiagnostics(t)
+	if len(d.Leaks) != 0 {
+		t.Fatalf("diagnostics report leaks: %v", d.Leaks)
+	}
+	if d.SRTReadersActive != readers || d.SRTSocketsOpen != sockets {
+		t.Fatalf("diagnostics report %d SRT readers and %d sockets, want %d and %d", d.SRTReadersActive, d.SRTSocketsOpen, readers, sockets)
+	}
+}
+
+// TestGroupTeardownReleasesReaders tears groups down each way a group ends
+// and checks that their SRT readers and sockets go with them.
+func TestGroupTeardownReleasesReaders(t *testing.T) {
+	h := newSRTLAHarness(t)
+	var gs []*Group
+	for i := 0; i < 3; i++ {
+		addr := netip.AddrPortFrom(netip.AddrFrom4([4]byte{192, 0, 2, byte(10 + i)}), 40000)
+		gs = append(gs, h.register(addr))
+		h.deliver(addr, srtData(1))
+		h.srtConn(i)
+	}
+	waitReleased(t, 3, 3)
+	if d := fetchDiagnostics(t); len(d.Groups) != 3 || d.Groups[0].Readers != 1 || !d.Groups[0].SocketOpen {
+		t.Fatalf("diagnostics groups %+v, want 3 with a reader and a socket each", d.Groups)
+	}
+
+	removeGroup(gs[0]) // e.g. a re-registration
+	waitReleased(t, 2, 2)
+
+	h.srtConn(1).Close() // the SRT server went away
+	eventually(t, func() bool { return findGroupByID(gs[1].id[:]) == nil }, "the group to end")
+	waitReleased(t, 1, 1)
+
+	h.clk.Advance(ConnTimeout + emptyGroupGrace + 1)
+	cleanup() // the links timed out
+	waitReleased(t, 0, 0)
+	eventually(t, func() bool { return groupWorkersActive.Load() == 0 }, "the group workers to end")
+}
+
+// TestReplacedSocketReaderExits replaces a group's SRT socket while its
+// reader is blocked on it: the old reader must end on the closed socket
+// without tearing the group down, and the group must not end up with two.
+func TestReplacedSocketReaderExits(t *testing.T) {
+	h := newSRTLAHarness(t)
+	g := h.register(link1)
+	h.deliver(link1, srtData(1))
+	old := h.srtConn(0)
+	waitReleased(t, 1, 1)
+
+	g.mu.Lock()
+	closeSRTSock(g.srtSock)
+	g.srtSock = nil
+	g.mu.Unlock()
+
+	h.deliver(link1, srtData(2)) // dials the replacement
+	fresh := h.srtConn(1)
+	eventually(t, func() bool { return len(fresh.written()) == 1 }, "the packet on the new socket")
+	waitReleased(t, 1, 1)
+	if findGroupByID(g.id[:]) == nil {
+		t.Fatal("the old reader tore the group down")
+	}
+	g.mu.Lock()
+	readers := g.readers
+	g.mu.Unlock()
+	if readers != 1 {
+		t.Fatalf("group has %d SRT readers, want 1", readers)
+	}
+	if len(old.written()) != 1 {
+		t.Fatalf("%d packets on the replaced socket, want 1", len(old.written()))
+	}
+}
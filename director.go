@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DirectorProbes        = 3 // keepalive probes per link and server
+	DirectorProbeInterval = 100 * time.Millisecond
+	DirectorProbeTimeout  = 1 * time.Second
+	DirectorReportPeriod  = 30 * time.Second // senders measure and report again this often
+	DirectorLossPenaltyMs = 1000.0           // added to a server's score for every link that cannot reach it
+	DirectorMaxSenders    = 1000
+	DirectorSenderTimeout = 5 * time.Minute // forget senders that stopped reporting
+
+	// A server answers the probes of each source address up to this
+	// burst, refilled at this rate, so it can't be used to reflect a
+	// flood at a spoofed address
+	DirectorProbeBurst   = 2 * DirectorProbes
+	DirectorProbeRate    = 1.0 // per second
+	DirectorProbeSources = 4096
+)
+
+// directorServer is an ingest server the director can hand out, see
+// -director-servers.
+type directorServer struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"` // SRTLA host:port
+}
+
+// probeResult is a sender's measurement of one server over one link.
+type probeResult struct {
+	Server      string  `json:"server"`
+	Link        string  `json:"link"`
+	RttMs       float64 `json:"rttMs"` // median of the answered probes, 0 if none was answered
+	LossPercent float64 `json:"lossPercent"`
+}
+
+// directorReport is what a sender posts to /api/director/report.
+type directorReport struct {
+	Sender  string        `json:"sender"`
+	Current string        `json:"current,omitempty"` // server it is streaming to, if any
+	Results []probeResult `json:"results"`
+}
+
+// directorChoice is the director's answer to a report.
+type directorChoice struct {
+	Server  string             `json:"server"`
+	Addr    string             `json:"addr"`
+	ScoreMs float64            `json:"scoreMs"`
+	Scores  map[string]float64 `json:"scores"` // every reachable server
+}
+
+// directorSender is the director's view of one sender, listed at
+// /api/director/senders and in the dashboard.
+type directorSender struct {
+	Sender      string             `json:"sender"`
+	Current     string             `json:"current"`
+	Recommended string             `json:"recommended"`
+	Scores      map[string]float64 `json:"scores"`
+	Results     []probeResult      `json:"results"`
+	LastReport  time.Time          `json:"lastReport"`
+	LastHandoff *time.Time         `json:"lastHandoff,omitempty"`
+	Handoffs    int                `json:"handoffs"`
+}
+
+// directorMessage broadcasts the senders on the WebSocket after every
+// report.
+type directorMessage struct {
+	Timestamp time.Time        `json:"timestamp"`
+	Type      string           `json:"type"` // always "director"
+	Senders   []directorSender `json:"senders"`
+}
+
+type director struct {
+	servers []directorServer
+
+	mu      sync.Mutex
+	senders map[string]*directorSender
+}
+
+func parseDirectorServers(s string) ([]directorServer, error) {
+	var servers []directorServer
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(kv, "=")
+		if !ok {
+			name, addr = kv, kv
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("%q: %w", kv, err)
+		}
+		servers = append(servers, directorServer{Name: name, Addr: addr})
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers")
+	}
+	return servers, nil
+}
+
+func startDirectorMode() {
+	servers, err := parseDirectorServers(*directorServers)
+	if err != nil {
+		log.Fatalf("ERROR: director mode requires -director-servers name=host:port,...: %v", err)
+	}
+	if *apiPort <= 0 {
+		log.Fatalf("ERROR: director mode requires -api-port")
+	}
+	d := &director{servers: servers, senders: map[string]*directorSender{}}
+	for _, s := range servers {
+		log.Printf("[director] Ingest server %s at %s", s.Name, s.Addr)
+		recordTarget("director "+s.Name, "udp", s.Addr)
+	}
+	runStatsHub(*wsPort)
+	apiMux.HandleFunc("GET /api/director/servers", d.handleServers)
+	apiMux.HandleFunc("POST /api/director/report", d.handleReport)
+	apiMux.HandleFunc("GET /api/director/senders", d.handleSenders)
+	apiMux.HandleFunc("GET /director", func(w http.ResponseWriter, r *http.Request) {
+		localizePage(w, r, directorDashboardHTML)
+	})
+	log.Printf("[director] Dashboard: http://%s/director", net.JoinHostPort(*apiHost, fmt.Sprint(*apiPort)))
+}
+
+func (d *director) handleServers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{"servers": d.servers, "probes": DirectorProbes, "reportSeconds": DirectorReportPeriod.Seconds()})
+}
+
+func (d *director) handleReport(w http.ResponseWriter, r *http.Request) {
+	var rep directorReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&rep); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rep.Sender == "" {
+		http.Error(w, "sender missing", http.StatusBadRequest)
+		return
+	}
+	choice, ok := d.choose(rep.Results)
+	if !ok {
+		http.Error(w, "no server is reachable from any link", http.StatusServiceUnavailable)
+		return
+	}
+	if !d.record(rep, choice) {
+		http.Error(w, "too many senders", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, choice)
+}
+
+// choose scores every server by the mean RTT of the links that reached
+// it, plus DirectorLossPenaltyMs for every link that did not and the
+// probe loss of those that did, and picks the lowest.
+func (d *director) choose(results []probeResult) (directorChoice, bool) {
+	links := map[string]bool{}
+	for _, res := range results {
+		links[res.Link] = true
+	}
+	choice := directorChoice{Scores: map[string]float64{}, ScoreMs: math.Inf(1)}
+	for _, s := range d.servers {
+		var sum float64
+		var reached int
+		for _, res := range results {
+			if res.Server != s.Name || res.RttMs <= 0 {
+				continue
+			}
+			sum += res.RttMs + res.LossPercent/100*DirectorLossPenaltyMs
+			reached++
+		}
+		if reached == 0 {
+			continue
+		}
+		score := sum/float64(reached) + float64(len(links)-reached)*DirectorLossPenaltyMs
+		choice.Scores[s.Name] = math.Round(score*10) / 10
+		if score < choice.ScoreMs {
+			choice.Server, choice.Addr, choice.ScoreMs = s.Name, s.Addr, math.Round(score*10)/10
+		}
+	}
+	return choice, choice.Server != ""
+}
+
+// record updates the sender's state and emits director.handoff when its
+// current server changed.
+func (d *director) record(rep directorReport, choice directorChoice) bool {
+	now := time.Now()
+	d.mu.Lock()
+	for name, s := range d.senders {
+		if now.Sub(s.LastReport) > DirectorSenderTimeout {
+			delete(d.senders, name)
+		}
+	}
+	s, ok := d.senders[rep.Sender]
+	if !ok {
+		if len(d.senders) >= DirectorMaxSenders {
+			d.mu.Unlock()
+			return false
+		}
+		s = &directorSender{Sender: rep.Sender}
+		d.senders[rep.Sender] = s
+	}
+	from := s.Current
+	handoff := rep.Current != "" && from != "" && rep.Current != from
+	if handoff {
+		s.LastHandoff = &now
+		s.Handoffs++
+	}
+	if rep.Current != "" {
+		s.Current = rep.Current
+	}
+	s.Recommended, s.Scores, s.Results, s.LastReport = choice.Server, choice.Scores, rep.Results, now
+	msg := directorMessage{Timestamp: now, Type: "director", Senders: d.snapshotLocked()}
+	d.mu.Unlock()
+
+	if !ok {
+		log.Printf("[director] %s: best ingest %s (%.0f ms)", rep.Sender, choice.Server, choice.ScoreMs)
+	}
+	if handoff {
+		log.Printf("[director] %s handed off from %s to %s", rep.Sender, from, rep.Current)
+		emitEvent("director.handoff", map[string]any{"sender": rep.Sender, "from": from, "to": rep.Current})
+	}
+	publishMessage(msg)
+	return true
+}
+
+// snapshotLocked must be called with d.mu held.
+func (d *director) snapshotLocked() []directorSender {
+	out := make([]directorSender, 0, len(d.senders))
+	for _, s := range d.senders {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Sender < out[j].Sender })
+	return out
+}
+
+func (d *director) handleSenders(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	out := d.snapshotLocked()
+	d.mu.Unlock()
+	writeJSON(w, out)
+}
+
+// directorClient is the sender side: it fetches the server list, probes
+// every server over every link and asks the director which one to use.
+type directorClient struct {
+	url    string
+	sender string
+	client *http.Client
+}
+
+func newDirectorClient(url, sender string) *directorClient {
+	return &directorClient{url: strings.TrimRight(url, "/"), sender: sender, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// selectServer probes every server from the links linkIPs returns for it
+// (name -> local IP) and returns the director's choice.
+func (dc *directorClient) selectServer(linkIPs func(server *net.UDPAddr) map[string]net.IP, current string) (directorChoice, error) {
+	resp, err := dc.client.Get(dc.url + "/api/director/servers")
+	if err != nil {
+		return directorChoice{}, err
+	}
+	var list struct {
+		Servers []directorServer `json:"servers"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return directorChoice{}, fmt.Errorf("server list: %w", err)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	rep := directorReport{Sender: dc.sender, Current: current, Results: []probeResult{}}
+	for _, s := range list.Servers {
+		raddr, err := net.ResolveUDPAddr("udp", s.Addr)
+		if err != nil {
+			log.Printf("[director] Failed to resolve %s: %v", s.Addr, err)
+			continue
+		}
+		for name, ip := range linkIPs(raddr) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res := probeServer(raddr, ip)
+				res.Server, res.Link = s.Name, name
+				mu.Lock()
+				rep.Results = append(rep.Results, res)
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+
+	body, _ := json.Marshal(rep)
+	resp, err = dc.client.Post(dc.url+"/api/director/report", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return directorChoice{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		return directorChoice{}, fmt.Errorf("director: %s: %s", resp.Status, bytes.TrimSpace(msg.Bytes()))
+	}
+	var choice directorChoice
+	if err := json.NewDecoder(resp.Body).Decode(&choice); err != nil {
+		return directorChoice{}, err
+	}
+	return choice, nil
+}
+
+// probeServer measures the RTT to an SRTLA server from ip with extended
+// keepalives, which go-irl servers echo even without a registration.
+func probeServer(raddr *net.UDPAddr, ip net.IP) probeResult {
+	var res probeResult
+	conn, err := net.DialUDP("udp", &net.UDPAddr{IP: ip}, raddr)
+	if err != nil {
+		res.LossPercent = 100
+		return res
+	}
+	defer conn.Close()
+
+	sent := map[uint64]time.Time{}
+	var rtts []float64
+	done := make(chan struct{})
+	var mu sync.Mutex
+	go func() {
+		defer close(done)
+		buf := make([]byte, MTU)
+		conn.SetReadDeadline(time.Now().Add(DirectorProbes*DirectorProbeInterval + DirectorProbeTimeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if n < SRTLAKeepaliveTSLen || getSRTType(buf) != SRTLATypeKeepalive {
+				continue
+			}
+			ms := binary.BigEndian.Uint64(buf[2:SRTLAKeepaliveTSLen])
+			mu.Lock()
+			if at, ok := sent[ms]; ok {
+				rtts = append(rtts, float64(time.Since(at).Microseconds())/1000)
+				delete(sent, ms)
+			}
+			full := len(rtts) == DirectorProbes
+			mu.Unlock()
+			if full {
+				return
+			}
+		}
+	}()
+	for i := 0; i < DirectorProbes; i++ {
+		now := time.Now()
+		// Unique per probe, so echoes can be matched to their probe
+		ms := uint64(now.UnixMilli()) + uint64(i)
+		pkt := binary.BigEndian.AppendUint16(nil, SRTLATypeKeepalive)
+		pkt = binary.BigEndian.AppendUint64(pkt, ms)
+		mu.Lock()
+		sent[ms] = now
+		mu.Unlock()
+		conn.Write(pkt)
+		time.Sleep(DirectorProbeInterval)
+	}
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	res.LossPercent = 100 * float64(DirectorProbes-len(rtts)) / DirectorProbes
+	if len(rtts) > 0 {
+		sort.Float64s(rtts)
+		res.RttMs = math.Round(rtts[len(rtts)/2]*10) / 10
+	}
+	return res
+}
+
+// probeAnswers rate limits the server's echoes of probes from addresses
+// without a registration, per source address. Without -director-probes it
+// is nil and the probes go unanswered.
+var probeAnswers *probeLimiter
+
+type probeBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+type probeLimiter struct {
+	mu      sync.Mutex
+	sources map[netip.Addr]*probeBucket
+}
+
+func newProbeLimiter() *probeLimiter {
+	return &probeLimiter{sources: map[netip.Addr]*probeBucket{}}
+}
+
+// allow reports whether a probe from addr may be answered at now.
+func (p *probeLimiter) allow(addr netip.Addr, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := p.sources[addr]
+	if b == nil {
+		if len(p.sources) >= DirectorProbeSources {
+			p.pruneLocked(now)
+			if len(p.sources) >= DirectorProbeSources {
+				return false
+			}
+		}
+		b = &probeBucket{tokens: DirectorProbeBurst, at: now}
+		p.sources[addr] = b
+	}
+	b.tokens = math.Min(DirectorProbeBurst, b.tokens+now.Sub(b.at).Seconds()*DirectorProbeRate)
+	b.at = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// pruneLocked forgets the sources whose bucket has filled up again.
+func (p *probeLimiter) pruneLocked(now time.Time) {
+	for addr, b := range p.sources {
+		if b.tokens+now.Sub(b.at).Seconds()*DirectorProbeRate >= DirectorProbeBurst {
+			delete(p.sources, addr)
+		}
+	}
+}
+
+// linkIPs returns the local address of every link usable to reach server,
+// or of every interface -links=auto would use before any was added.
+func (b *bondSender) linkIPs(server *net.UDPAddr) map[string]net.IP {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ips := map[string]net.IP{}
+	for _, l := range b.links {
+		if l.iface == "" {
+			ips[l.name] = l.localIP
+		} else if ip, err := interfaceIP(l.iface, server); err == nil {
+			ips[l.name] = ip
+		}
+	}
+	if b.auto {
+		ifaces, _ := net.Interfaces()
+		for _, ifi := range ifaces {
+			if ifi.Flags&net.FlagLoopback != 0 || b.excluded(ifi.Name) || b.hasLink(ifi.Name) {
+				continue
+			}
+			if ip, err := interfaceIP(ifi.Name, server); err == nil {
+				ips[ifi.Name] = ip
+			}
+		}
+	}
+	return ips
+}
+
+// runDirector picks the initial server with the director, retrying until
+// one is reachable, and then reports every DirectorReportPeriod. The
+// sender only moves to a better server while none of its links is
+// registered with the current one, so a working stream is not cut.
+func (b *bondSender) runDirector(dc *directorClient, ready chan<- struct{}) {
+	for {
+		// Report again soon after a switch, so the handoff shows up
+		wait := DirectorReportPeriod
+		choice, err := dc.selectServer(b.linkIPs, b.serverName)
+		switch {
+		case err != nil:
+			log.Printf("[bond] Ingest selection failed: %v", err)
+			if b.serverName == "" {
+				wait = BondRegTimeout
+			}
+		case b.serverName == "":
+			b.switchServer(choice)
+			close(ready)
+		case choice.Server != b.serverName && !b.anyReady():
+			b.switchServer(choice)
+			wait = BondRegTimeout
+		case choice.Server != b.serverName:
+			log.Printf("[bond] Director recommends %s, staying on %s while it works", choice.Server, b.serverName)
+		}
+		time.Sleep(wait)
+	}
+}
+
+// switchServer moves every link to the server the director chose.
+func (b *bondSender) switchServer(choice directorChoice) {
+	raddr, err := net.ResolveUDPAddr("udp", choice.Addr)
+	if err != nil {
+		log.Printf("[bond] Failed to resolve %s: %v", choice.Addr, err)
+		return
+	}
+	b.mu.Lock()
+	from := b.serverName
+	b.server, b.serverName, b.groupID = raddr, choice.Server, nil
+	for _, l := range b.links {
+		b.dropLink(l)
+	}
+	b.mu.Unlock()
+
+	log.Printf("[bond] Ingest server %s at %s (%.0f ms)", choice.Server, raddr, choice.ScoreMs)
+	if from != "" {
+		emitEvent("bond.server_switched", map[string]any{"from": from, "to": choice.Server, "addr": raddr.String()})
+	}
+}
+
+func (b *bondSender) anyReady() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, l := range b.links {
+		if l.ready {
+			return true
+		}
+	}
+	return false
+}
+
+// directorDashboardHTML shows the senders and their ingest servers,
+// refreshed from /api/director/senders.
+const directorDashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{director.title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #111; color: #eee; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: .4em .8em; text-align: left; border-bottom: 1px solid #333; vertical-align: top; }
+.handoff { color: #fc5; } .best { color: #5f5; }
+</style>
+</head>
+<body>
+<h1>{{director.title}}</h1>
+<table>
+<thead><tr><th>{{director.sender}}</th><th>{{director.streaming_to}}</th><th>{{director.recommended}}</th><th>{{director.scores}}</th><th>{{director.handoffs}}</th><th>{{director.last_report}}</th></tr></thead>
+<tbody id="rows"></tbody>
+</table>
+<script>
+function esc(s) { return String(s).replace(/[&<>"]/g, c => ({"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"})[c]); }
+function fmt(s, vals) { return s.replace(/\{(\w+)\}/g, (m, k) => k in vals ? vals[k] : m); }
+function ago(t) { return fmt({{js:director.ago}}, {seconds: Math.round((Date.now() - new Date(t)) / 1000)}); }
+async function refresh() {
+  const senders = await (await fetch("api/director/senders")).json();
+  document.getElementById("rows").innerHTML = senders.map(s => {
+    const scores = Object.entries(s.scores || {}).sort((a, b) => a[1] - b[1])
+      .map(([n, v]) => (n === s.recommended ? '<span class="best">' : "<span>") + esc(n) + " " + Math.round(v) + " ms</span>").join("<br>");
+    const handoff = s.lastHandoff ? ' <span class="handoff">' + esc(fmt({{js:director.last_handoff}}, {ago: ago(s.lastHandoff)})) + "</span>" : "";
+    return "<tr><td>" + esc(s.sender) + "</td><td>" + esc(s.current || "-") + "</td><td>" + esc(s.recommended) + "</td>" +
+      "<td>" + scores + "</td><td>" + s.handoffs + handoff + "</td><td>" + ago(s.lastReport) + "</td></tr>";
+  }).join("");
+}
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// probePkt returns a director RTT probe, a timestamped keepalive.
+func probePkt(ms uint64) []byte {
+	pkt := binary.BigEndian.AppendUint16(nil, SRTLATypeKeepalive)
+	return binary.BigEndian.AppendUint64(pkt, ms)
+}
+
+// withProbeAnswers enables -director-probes for the test.
+func withProbeAnswers(t *testing.T) {
+	probeAnswers = newProbeLimiter()
+	t.Cleanup(func() { probeAnswers = nil })
+}
+
+// TestDirectorProbesIgnoredByDefault checks that a server not set up for a
+// director doesn't echo probes from unregistered addresses.
+func TestDirectorProbesIgnoredByDefault(t *testing.T) {
+	h := newSRTLAHarness(t)
+	for i := 0; i < 3; i++ {
+		h.deliver(link1, probePkt(uint64(i)))
+	}
+	if replies := h.sock.sent(link1); len(replies) != 0 {
+		t.Fatalf("%d probe echoes without -director-probes", len(replies))
+	}
+}
+
+// TestDirectorProbesRateLimited checks that with -director-probes the
+// probes are echoed up to the burst per source address, and again as the
+// bucket refills.
+func TestDirectorProbesRateLimited(t *testing.T) {
+	h := newSRTLAHarness(t)
+	withProbeAnswers(t)
+
+	for i := 0; i < 100; i++ {
+		h.deliver(link1, probePkt(uint64(i)))
+	}
+	replies := h.sock.sent(link1)
+	if len(replies) != DirectorProbeBurst {
+		t.Fatalf("%d echoes of a probe flood, want %d", len(replies), DirectorProbeBurst)
+	}
+	if binary.BigEndian.Uint64(replies[0][2:]) != 0 {
+		t.Fatalf("echo %x, want the probe", replies[0])
+	}
+
+	// Another port of the same address shares its bucket, another
+	// address has its own
+	samePeer := netip.AddrPortFrom(link1.Addr(), link1.Port()+1)
+	h.deliver(samePeer, probePkt(1))
+	h.deliver(link2, probePkt(1))
+	if len(h.sock.sent(samePeer)) != 0 || len(h.sock.sent(link2)) != 1 {
+		t.Fatal("the limit is not per source address")
+	}
+
+	h.clk.Advance(2 * time.Second)
+	for i := 0; i < 10; i++ {
+		h.deliver(link1, probePkt(uint64(i)))
+	}
+	if n := len(h.sock.sent(link1)); n != 2 {
+		t.Fatalf("%d echoes 2s later, want 2", n)
+	}
+}
+
+// TestProbeLimiterBoundsSources checks that the limiter keeps no more than
+// DirectorProbeSources addresses, forgetting those that went quiet first.
+func TestProbeLimiterBoundsSources(t *testing.T) {
+	p := newProbeLimiter()
+	now := time.Unix(1700000000, 0)
+	addr := func(i int) netip.Addr {
+		return netip.AddrFrom4([4]byte{10, byte(i >> 16), byte(i >> 8), byte(i)})
+	}
+	for i := 0; i < DirectorProbeSources+100; i++ {
+		p.allow(addr(i), now)
+	}
+	if len(p.sources) != DirectorProbeSources {
+		t.Fatalf("%d sources tracked, want %d", len(p.sources), DirectorProbeSources)
+	}
+	if p.allow(addr(DirectorProbeSources+200), now) {
+		t.Fatal("a new source was answered while the table is full")
+	}
+	now = now.Add(DirectorProbeBurst * time.Second)
+	if !p.allow(addr(DirectorProbeSources+200), now) {
+		t.Fatal("a new source was not answered once the others went quiet")
+	}
+}
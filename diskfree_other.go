@@ -0,0 +1,6 @@
+//go:build !linux && !darwin
+
+package main
+
+// diskFree is not available here; -record-min-free-gb has no effect.
+func diskFree(dir string) (int64, bool) { return 0, false }
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TSPacketLen = 188
+
+	DVRSegmentTarget   = 2 * time.Second // segments are cut at the first keyframe after this
+	DVRSegmentMax      = 6 * time.Second // or at any payload start after this, for streams without keyframe flags
+	DVRPlaylistEntries = 6
+)
+
+// dvr keeps the last -dvr of the received MPEG-TS stream, cut into HLS
+// segments, for the timeshift endpoint and the preview. Nil unless one of
+// them is enabled.
+var dvr *dvrBuffer
+
+// timeshift serves the DVR buffer as HLS, see -dvr.
+var timeshift bool
+
+type dvrSegment struct {
+	seq   uint64
+	start time.Time
+	dur   time.Duration
+	data  []byte
+}
+
+// dvrBuffer is an output (see openSink) that segments the stream at
+// keyframes, i.e. at payload starts with the random access indicator set.
+// Every segment starts with the most recent PAT and PMT so it can be
+// decoded on its own.
+type dvrBuffer struct {
+	mu   sync.Mutex
+	keep time.Duration
+	segs []*dvrSegment // completed, oldest first
+	cur  *dvrSegment
+	seq  uint64
+
+	partial  [TSPacketLen]byte // incomplete TS packet from the last write
+	partialN int
+
+	psi psiTables
+}
+
+func newDVRBuffer(keep time.Duration) *dvrBuffer {
+	return &dvrBuffer{keep: keep, psi: newPSITables()}
+}
+
+func (d *dvrBuffer) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	n := len(b)
+
+	if d.partialN > 0 {
+		c := copy(d.partial[d.partialN:], b)
+		d.partialN += c
+		b = b[c:]
+		if d.partialN < TSPacketLen {
+			return n, nil
+		}
+		d.addPacket(d.partial[:], now)
+		d.partialN = 0
+	}
+	for len(b) > 0 {
+		if b[0] != 0x47 {
+			b = b[1:] // resync on the next sync byte
+			continue
+		}
+		if len(b) < TSPacketLen {
+			d.partialN = copy(d.partial[:], b)
+			break
+		}
+		d.addPacket(b[:TSPacketLen], now)
+		b = b[TSPacketLen:]
+	}
+	return n, nil
+}
+
+func (d *dvrBuffer) Close() error { return nil }
+
+// addPacket appends one TS packet. Must be called with d.mu held.
+func (d *dvrBuffer) addPacket(pkt []byte, now time.Time) {
+	pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+	pusi := pkt[1]&0x40 != 0
+	d.psi.observe(pkt)
+
+	if d.cur != nil && pusi {
+		age := now.Sub(d.cur.start)
+		if (age >= DVRSegmentTarget && randomAccess(pkt)) || age >= DVRSegmentMax {
+			d.cur.dur = age
+			d.segs = append(d.segs, d.cur)
+			d.cur = nil
+			d.trim(now)
+		}
+	}
+	if d.cur == nil {
+		if !pusi {
+			return // wait for a payload start
+		}
+		d.cur = &dvrSegment{seq: d.seq, start: now}
+		d.seq++
+		if pid != 0 {
+			d.cur.data = d.psi.appendTables(d.cur.data)
+		}
+	}
+	d.cur.data = append(d.cur.data, pkt...)
+}
+
+// psiTables keeps the most recent PAT and PMTs of a stream, so a segment
+// cut out of it can start with them and be decoded on its own.
+type psiTables struct {
+	pat     []byte
+	pmtPIDs map[uint16]bool
+	pmts    map[uint16][]byte
+}
+
+func newPSITables() psiTables {
+	return psiTables{pmtPIDs: map[uint16]bool{}, pmts: map[uint16][]byte{}}
+}
+
+func (t *psiTables) observe(pkt []byte) {
+	pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+	if pkt[1]&0x40 == 0 {
+		return
+	}
+	switch {
+	case pid == 0:
+		t.pat = append(t.pat[:0], pkt...)
+		t.parsePAT(pkt)
+	case t.pmtPIDs[pid]:
+		t.pmts[pid] = append(t.pmts[pid][:0], pkt...)
+	}
+}
+
+// appendTables appends the PAT and PMTs to b, if a PAT was seen yet.
+func (t *psiTables) appendTables(b []byte) []byte {
+	if t.pat == nil {
+		return b
+	}
+	b = append(b, t.pat...)
+	for _, pmt := range t.pmts {
+		b = append(b, pmt...)
+	}
+	return b
+}
+
+// parsePAT remembers the PMT PIDs of a single packet PAT.
+func (t *psiTables) parsePAT(pkt []byte) {
+	p := 4
+	if pkt[3]&0x20 != 0 {
+		p += 1 + int(pkt[4]) // adaptation field
+	}
+	if p >= TSPacketLen {
+		return
+	}
+	p += 1 + int(pkt[p]) // pointer field
+	if p+8 > TSPacketLen {
+		return
+	}
+	sectionLen := int(pkt[p+1]&0x0f)<<8 | int(pkt[p+2])
+	end := min(p+3+sectionLen-4, TSPacketLen) // without the CRC
+	clear(t.pmtPIDs)
+	for q := p + 8; q+4 <= end; q += 4 {
+		program := uint16(pkt[q])<<8 | uint16(pkt[q+1])
+		if program != 0 {
+			t.pmtPIDs[uint16(pkt[q+2]&0x1f)<<8|uint16(pkt[q+3])] = true
+		}
+	}
+	for pid := range t.pmts {
+		if !t.pmtPIDs[pid] {
+			delete(t.pmts, pid)
+		}
+	}
+}
+
+// randomAccess reports whether the adaptation field of pkt has the random
+// access indicator set, which encoders use to flag keyframes.
+func randomAccess(pkt []byte) bool {
+	return pkt[3]&0x20 != 0 && pkt[4] > 0 && pkt[5]&0x40 != 0
+}
+
+// trim drops segments that are older than d.keep. Must be called with d.mu
+// held.
+func (d *dvrBuffer) trim(now time.Time) {
+	i := 0
+	for i < len(d.segs) && now.Sub(d.segs[i].start.Add(d.segs[i].dur)) > d.keep {
+		d.segs[i] = nil
+		i++
+	}
+	d.segs = d.segs[i:]
+}
+
+// playlist returns a live HLS playlist that trails the stream by delay.
+func (d *dvrBuffer) playlist(delay time.Duration, now time.Time) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	end := len(d.segs)
+	for end > 1 && d.segs[end-1].start.After(now.Add(-delay)) {
+		end--
+	}
+	end = max(end, min(DVRPlaylistEntries, len(d.segs))) // delay beyond the buffer: its start
+	start := max(end-DVRPlaylistEntries, 0)
+	segs := d.segs[start:end]
+
+	target := DVRSegmentTarget
+	for _, s := range segs {
+		target = max(target, s.dur)
+	}
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	var first uint64
+	if len(segs) > 0 {
+		first = segs[0].seq
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+	for _, s := range segs {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\ntimeshift/%d.ts\n", s.dur.Seconds(), s.seq)
+	}
+	return b.String()
+}
+
+// latest returns the newest completed segment.
+func (d *dvrBuffer) latest() (uint64, []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.segs) == 0 {
+		return 0, nil
+	}
+	s := d.segs[len(d.segs)-1]
+	return s.seq, s.data
+}
+
+func (d *dvrBuffer) segment(seq uint64) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range d.segs {
+		if s.seq == seq {
+			return s.data
+		}
+	}
+	return nil
+}
+
+// parseDelay accepts plain seconds ("90") as well as durations ("1m30s").
+func parseDelay(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// registerTimeshiftRoutes serves /timeshift.m3u8?delay=<seconds or
+// duration> and its segments on mux.
+func registerTimeshiftRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/timeshift.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		delay, err := parseDelay(r.URL.Query().Get("delay"))
+		if err != nil || delay < 0 {
+			http.Error(w, "invalid delay", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write([]byte(dvr.playlist(delay, time.Now())))
+	})
+	mux.HandleFunc("/timeshift/", func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/timeshift/"), ".ts")
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if !ok || err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		data := dvr.segment(seq)
+		if data == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp2t")
+		w.Write(data)
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// event is a structured notification about something that happened inside
+// go-irl (a subsystem crashed, a group is about to be removed, ...). Events
+// are logged and fanned out to every subscriber, e.g. the WebSocket hub.
+type event struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Type      string         `json:"type"` // always "event"
+	Name      string         `json:"name"`
+	Fields    map[string]any `json:"fields,omitempty"`
+}
+
+var (
+	eventSubsMu sync.RWMutex
+	eventSubs   []func(event)
+	msgSubs     []func([]byte)
+)
+
+// subscribeEvents registers fn to be called for every emitted event. fn is
+// called synchronously from the emitting goroutine and must not block.
+func subscribeEvents(fn func(event)) {
+	eventSubsMu.Lock()
+	eventSubs = append(eventSubs, fn)
+	eventSubsMu.Unlock()
+}
+
+// subscribeMessages registers fn to receive every message published with
+// publishMessage (including events) as JSON. fn must not block.
+func subscribeMessages(fn func([]byte)) {
+	eventSubsMu.Lock()
+	msgSubs = append(msgSubs, fn)
+	eventSubsMu.Unlock()
+}
+
+// publishMessage fans v out as JSON to all message subscribers, typically
+// the WebSocket hub feeding the browser source.
+func publishMessage(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode message: %v", err)
+		return
+	}
+
+	eventSubsMu.RLock()
+	subs := msgSubs
+	eventSubsMu.RUnlock()
+	for _, fn := range subs {
+		fn(data)
+	}
+}
+
+func emitEvent(name string, fields map[string]any) {
+	ev := event{
+		Timestamp: time.Now(),
+		Type:      "event",
+		Name:      name,
+		Fields:    fields,
+	}
+
+	if len(fields) > 0 {
+		data, _ := json.Marshal(fields)
+		log.Printf("[event] %s %s", name, data)
+	} else {
+		log.Printf("[event] %s", name)
+	}
+
+	eventSubsMu.RLock()
+	subs := eventSubs
+	eventSubsMu.RUnlock()
+	for _, fn := range subs {
+		fn(ev)
+	}
+
+	publishMessage(ev)
+}
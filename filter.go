@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// filter is a stage of the pipeline between the sources and the sinks.
+// Process gets the data of one Read of the active source, normally whole
+// TS packets, and returns what goes on to the next stage, nil to drop it.
+// It may return p changed in place or a buffer of its own, which is only
+// valid until the next call. Process is never called concurrently, Stats
+// may be at any time.
+type filter interface {
+	Process(p []byte) []byte
+	Stats() map[string]any // filter specific counters for /api/filters
+}
+
+// filterFactories are the filters of -filter by name. A factory gets what
+// follows the name's colon, e.g. 0x1fff,481 for pid-drop:0x1fff,481.
+var filterFactories = map[string]func(arg string) (filter, error){
+	"ts-analyze": newTSAnalyzeFilter,
+	"pid-drop":   newPIDDropFilter,
+}
+
+// filterStage is a filter in its place in the pipeline, with the counters
+// every filter gets and the switch to bypass it at runtime.
+type filterStage struct {
+	name    string
+	arg     string
+	filter  filter
+	enabled atomic.Bool
+
+	inChunks, inBytes   atomic.Uint64
+	outChunks, outBytes atomic.Uint64
+}
+
+func (s *filterStage) setEnabled(on bool) {
+	if s.enabled.Swap(on) == on {
+		return
+	}
+	log.Printf("[filter] %s %s", s.name, map[bool]string{true: "enabled", false: "disabled"}[on])
+	emitEvent("filter.toggled", map[string]any{"filter": s.name, "enabled": on})
+}
+
+func (s *filterStage) process(p []byte) []byte {
+	s.inChunks.Add(1)
+	s.inBytes.Add(uint64(len(p)))
+	p = s.filter.Process(p)
+	if len(p) > 0 {
+		s.outChunks.Add(1)
+		s.outBytes.Add(uint64(len(p)))
+	}
+	return p
+}
+
+// filterStages collects repeated -filter flags, in the order the stream
+// passes them.
+type filterStages []*filterStage
+
+// filters are the stages of -filter, for the pipeline and the API.
+var filters filterStages
+
+func (f *filterStages) String() string {
+	names := make([]string, len(*f))
+	for i, s := range *f {
+		names[i] = s.name
+	}
+	return strings.Join(names, " ")
+}
+
+func (f *filterStages) Set(v string) error {
+	name, arg, _ := strings.Cut(v, ":")
+	newFilter, ok := filterFactories[name]
+	if !ok {
+		names := make([]string, 0, len(filterFactories))
+		for n := range filterFactories {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown filter %q, known are %s", name, strings.Join(names, ", "))
+	}
+	if f.stage(name) != nil {
+		return fmt.Errorf("filter %s is given twice", name)
+	}
+	flt, err := newFilter(arg)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	s := &filterStage{name: name, arg: arg, filter: flt}
+	s.enabled.Store(true)
+	*f = append(*f, s)
+	return nil
+}
+
+func (f filterStages) stage(name string) *filterStage {
+	for _, s := range f {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// filterWriter runs the enabled filters in order before writing to the
+// sinks.
+type filterWriter struct {
+	filters []*filterStage
+	sinks   writer
+}
+
+func (f filterWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for _, s := range f.filters {
+		if !s.enabled.Load() {
+			continue
+		}
+		if p = s.process(p); len(p) == 0 {
+			return n, nil
+		}
+	}
+	if _, err := f.sinks.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+type filterInfo struct {
+	Name      string         `json:"name"`
+	Arg       string         `json:"arg,omitempty"`
+	Position  int            `json:"position"` // 0 is the first after the source
+	Enabled   bool           `json:"enabled"`
+	InChunks  uint64         `json:"inChunks"`
+	InBytes   uint64         `json:"inBytes"`
+	OutChunks uint64         `json:"outChunks"`
+	OutBytes  uint64         `json:"outBytes"`
+	Stats     map[string]any `json:"stats"`
+}
+
+func (s *filterStage) info(pos int) filterInfo {
+	return filterInfo{
+		Name:      s.name,
+		Arg:       s.arg,
+		Position:  pos,
+		Enabled:   s.enabled.Load(),
+		InChunks:  s.inChunks.Load(),
+		InBytes:   s.inBytes.Load(),
+		OutChunks: s.outChunks.Load(),
+		OutBytes:  s.outBytes.Load(),
+		Stats:     s.filter.Stats(),
+	}
+}
+
+func handleFilters(w http.ResponseWriter, r *http.Request) {
+	list := make([]filterInfo, len(filters))
+	for i, s := range filters {
+		list[i] = s.info(i)
+	}
+	writeJSON(w, list)
+}
+
+// handleFilterConfig turns a filter on or off: {"enabled": false} lets the
+// stream bypass it until it is enabled again.
+func handleFilterConfig(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Enabled == nil {
+		http.Error(w, "enabled is missing", http.StatusBadRequest)
+		return
+	}
+	for i, s := range filters {
+		if s.name == name {
+			s.setEnabled(*req.Enabled)
+			writeJSON(w, s.info(i))
+			return
+		}
+	}
+	http.Error(w, "unknown filter "+name, http.StatusNotFound)
+}
+
+// tsContinuity follows the continuity counters of a TS stream. The
+// counter only advances on packets with payload; a repeated counter is a
+// legal duplicate, and the discontinuity indicator starts over.
+type tsContinuity map[int]int // PID -> last continuity counter
+
+// missing returns how many packets are missing before pkt on its PID.
+func (c tsContinuity) missing(pkt []byte) int {
+	pid := tsPID(pkt)
+	if pid == TSNullPID || pkt[3]&0x10 == 0 {
+		return 0
+	}
+	cc := int(pkt[3] & 0x0f)
+	discontinuity := pkt[3]&0x20 != 0 && pkt[4] > 0 && pkt[5]&0x80 != 0
+	last, seen := c[pid]
+	c[pid] = cc
+	if !seen || discontinuity || cc == last {
+		return 0
+	}
+	return (cc - last - 1) & 0x0f
+}
+
+func tsPID(pkt []byte) int {
+	return int(pkt[1]&0x1f)<<8 | int(pkt[2])
+}
+
+// tsAnalyzeFilter passes the stream unchanged and counts, per PID, the
+// packets, continuity errors, transport errors and scrambled packets.
+type tsAnalyzeFilter struct {
+	mu         sync.Mutex
+	cc         tsContinuity
+	pids       map[int]*tsPIDStats
+	syncErrors uint64 // chunks that are not whole TS packets
+}
+
+type tsPIDStats struct {
+	Packets         uint64 `json:"packets"`
+	ContinuityLost  uint64 `json:"continuityLost"` // packets missing by the counters
+	TransportErrors uint64 `json:"transportErrors"`
+	Scrambled       uint64 `json:"scrambled"`
+}
+
+func newTSAnalyzeFilter(arg string) (filter, error) {
+	if arg != "" {
+		return nil, fmt.Errorf("takes no argument")
+	}
+	return &tsAnalyzeFilter{cc: tsContinuity{}, pids: map[int]*tsPIDStats{}}, nil
+}
+
+func (a *tsAnalyzeFilter) Process(p []byte) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(p)%TSPacketLen != 0 {
+		a.syncErrors++
+		return p
+	}
+	for off := 0; off < len(p); off += TSPacketLen {
+		pkt := p[off : off+TSPacketLen]
+		if pkt[0] != 0x47 {
+			a.syncErrors++
+			return p
+		}
+		pid := tsPID(pkt)
+		st := a.pids[pid]
+		if st == nil {
+			st = &tsPIDStats{}
+			a.pids[pid] = st
+		}
+		st.Packets++
+		st.ContinuityLost += uint64(a.cc.missing(pkt))
+		if pkt[1]&0x80 != 0 {
+			st.TransportErrors++
+		}
+		if pkt[3]&0xc0 != 0 {
+			st.Scrambled++
+		}
+	}
+	return p
+}
+
+func (a *tsAnalyzeFilter) Stats() map[string]any {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	pids := make(map[string]tsPIDStats, len(a.pids))
+	for pid, st := range a.pids {
+		pids[fmt.Sprintf("0x%04x", pid)] = *st
+	}
+	return map[string]any{"syncErrors": a.syncErrors, "pids": pids}
+}
+
+// pidDropFilter removes the TS packets of some PIDs, e.g. stuffing
+// (0x1fff) or a data stream a downstream player chokes on. The PMT still
+// lists a dropped stream; players treat it as silent.
+type pidDropFilter struct {
+	drop    map[int]bool
+	buf     []byte
+	dropped atomic.Uint64
+}
+
+func newPIDDropFilter(arg string) (filter, error) {
+	f := &pidDropFilter{drop: map[int]bool{}}
+	for _, s := range strings.Split(arg, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		pid, err := strconv.ParseInt(s, 0, 32)
+		if err != nil || pid < 0 || pid > TSNullPID {
+			return nil, fmt.Errorf("invalid PID %q, expected 0-8191 or 0x0000-0x1fff", s)
+		}
+		f.drop[int(pid)] = true
+	}
+	if len(f.drop) == 0 {
+		return nil, fmt.Errorf("needs the PIDs to drop, e.g. pid-drop:0x1fff")
+	}
+	return f, nil
+}
+
+func (f *pidDropFilter) Process(p []byte) []byte {
+	if len(p)%TSPacketLen != 0 {
+		return p // not whole TS packets, leave it alone
+	}
+	out := f.buf[:0]
+	for off := 0; off < len(p); off += TSPacketLen {
+		pkt := p[off : off+TSPacketLen]
+		if pkt[0] == 0x47 && f.drop[tsPID(pkt)] {
+			f.dropped.Add(1)
+			continue
+		}
+		out = append(out, pkt...)
+	}
+	f.buf = out
+	return out
+}
+
+func (f *pidDropFilter) Stats() map[string]any {
+	return map[string]any{"droppedPackets": f.dropped.Load()}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Values of -firewall.
+const (
+	FirewallAsk = "ask"
+	FirewallAdd = "add"
+	FirewallOff = "off"
+)
+
+// firewallPorts are the UDP ports the running roles receive the stream on
+// from other machines.
+func firewallPorts() []int {
+	var ports []int
+	if runsReceiver() {
+		ports = append(ports, *srtlaPort)
+	}
+	if roles[ModeServer] {
+		ports = append(ports, *srtIngestPort, *clusterPort)
+	}
+	if roles[ModeClient] && *inputAddr == "" {
+		ports = append(ports, *srtPort, *srtBackupPort)
+	}
+	if runsProxy() {
+		ports = append(ports, *playPort)
+		if u, err := url.Parse(*inputAddr); err == nil && u.Scheme == "udp" {
+			port, _ := strconv.Atoi(u.Port())
+			ports = append(ports, port)
+		}
+	}
+	ports = slices.DeleteFunc(ports, func(p int) bool { return p <= 0 })
+	slices.Sort(ports)
+	return slices.Compact(ports)
+}
+
+// firewallState is what go-irl remembers about the firewall between runs,
+// so it asks once per program and set of ports.
+type firewallState struct {
+	Program  string `json:"program"`
+	Ports    []int  `json:"ports"`
+	Declined bool   `json:"declined,omitempty"`
+}
+
+func firewallStatePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "go-irl", "firewall.json")
+}
+
+func loadFirewallState() firewallState {
+	var s firewallState
+	if path := firewallStatePath(); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			json.Unmarshal(data, &s)
+		}
+	}
+	return s
+}
+
+func saveFirewallState(s firewallState) {
+	path := firewallStatePath()
+	if path == "" {
+		return
+	}
+	data, _ := json.MarshalIndent(s, "", "  ")
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err == nil {
+		err = os.WriteFile(path, data, 0o644)
+	}
+	if err != nil {
+		log.Printf("[firewall] Failed to save %s: %v", path, err)
+	}
+}
+
+// askConsent asks a yes/no question on the terminal. asked is false when
+// go-irl doesn't run in one, e.g. as a service.
+func askConsent(question string) (yes, asked bool) {
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false, false
+	}
+	fmt.Fprintf(os.Stderr, "%s [Y/n] ", question)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, false
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "" || answer == "y" || answer == "yes", true
+}
+
+func formatPorts(ports []int) string {
+	s := make([]string, len(ports))
+	for i, p := range ports {
+		s[i] = fmt.Sprint(p)
+	}
+	return strings.Join(s, ",")
+}
+
+// setupFirewall makes sure the desktop firewall doesn't silently drop the
+// stream, which is nearly always why "it doesn't receive packets" on
+// Windows and macOS. The checks are in the firewall_*.go files.
+func setupFirewall() {
+	switch *firewallMode {
+	case FirewallAsk, FirewallAdd:
+	case FirewallOff:
+		return
+	default:
+		log.Fatalf("ERROR: unknown -firewall %q, use %s, %s or %s", *firewallMode, FirewallAsk, FirewallAdd, FirewallOff)
+	}
+	if ports := firewallPorts(); len(ports) > 0 {
+		checkFirewall(*firewallMode, ports)
+	}
+}
@@ -0,0 +1,122 @@
+//go:build darwin
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// localFirewallHint is added to the hint for a silent SRTLA port.
+const localFirewallHint = " The macOS firewall may block it too: allow incoming connections for go-irl in System Settings > Network > Firewall > Options."
+
+const (
+	SocketFilterFW = "/usr/libexec/ApplicationFirewall/socketfilterfw"
+
+	LocalNetworkProbePeriod = 5 * time.Second
+	LocalNetworkSubject     = "the local network"
+)
+
+// checkFirewall warns when the application firewall blocks go-irl, and
+// unblocks it with -firewall=add when run as root. It also asks for the
+// local network permission right away instead of in the middle of a
+// stream.
+func checkFirewall(mode string, ports []int) {
+	go supervise("local-network", probeLocalNetwork)
+
+	out, err := exec.Command(SocketFilterFW, "--getglobalstate").Output()
+	if err != nil || strings.Contains(string(out), "State = 0") {
+		return // no application firewall
+	}
+	if out, _ := exec.Command(SocketFilterFW, "--getblockall").Output(); strings.Contains(string(out), "ENABLED") {
+		log.Printf("WARNING: The macOS firewall blocks all incoming connections, so no stream reaches UDP %s. Turn off \"Block all incoming connections\" in System Settings > Network > Firewall > Options.", formatPorts(ports))
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	out, _ = exec.Command(SocketFilterFW, "--getappblocked", exe).Output()
+	switch state := string(out); {
+	case strings.Contains(state, "permitted"):
+		return
+	case mode == FirewallAdd && os.Geteuid() == 0:
+		exec.Command(SocketFilterFW, "--add", exe).Run()
+		if err := exec.Command(SocketFilterFW, "--unblockapp", exe).Run(); err != nil {
+			log.Printf("[firewall] Failed to unblock go-irl in the macOS firewall: %v", err)
+			return
+		}
+		log.Printf("[firewall] The macOS firewall now lets go-irl receive on UDP %s", formatPorts(ports))
+	case strings.Contains(state, "blocked"):
+		log.Printf("WARNING: The macOS firewall blocks go-irl, so no stream reaches UDP %s. Allow it in System Settings > Network > Firewall > Options, or run: sudo %s --unblockapp %s", formatPorts(ports), SocketFilterFW, exe)
+	default:
+		log.Printf("[firewall] macOS will ask whether go-irl may accept incoming network connections. Click Allow, or no stream reaches UDP %s.", formatPorts(ports))
+	}
+}
+
+// probeLocalNetwork sends a datagram to the local network, which makes
+// macOS ask for the local network permission at startup. While it is
+// denied, sends to local addresses fail with EHOSTUNREACH; the problem is
+// reported with a hint until the user allows it.
+func probeLocalNetwork() {
+	target := localNetworkProbeAddr()
+	if target == nil {
+		return
+	}
+	for denied := false; ; time.Sleep(LocalNetworkProbePeriod) {
+		err := sendProbe(target)
+		switch {
+		case errors.Is(err, syscall.EHOSTUNREACH):
+			if !denied {
+				reportProblem(FailureLocalNetwork, LocalNetworkSubject, err)
+				denied = true
+			}
+		case err == nil || !denied:
+			if denied {
+				clearProblem(LocalNetworkSubject)
+			}
+			return // allowed, or not a permission problem
+		}
+	}
+}
+
+func sendProbe(target *net.UDPAddr) error {
+	conn, err := net.DialUDP("udp4", nil, target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte{0})
+	return err
+}
+
+// localNetworkProbeAddr returns the discard port of another address in
+// the subnet of the first private IPv4 interface, nil without one.
+func localNetworkProbeAddr() *net.UDPAddr {
+	ifaces, _ := net.Interfaces()
+	for _, ifc := range ifaces {
+		if ifc.Flags&net.FlagUp == 0 || ifc.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := ifc.Addrs()
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil || !ipnet.IP.IsPrivate() {
+				continue
+			}
+			ip := ipnet.IP.To4().Mask(ipnet.Mask)
+			ip[3]++
+			if ip.Equal(ipnet.IP.To4()) {
+				ip[3]++
+			}
+			return &net.UDPAddr{IP: ip, Port: 9}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !darwin
+
+package main
+
+// localFirewallHint is added to the hint for a silent SRTLA port.
+const localFirewallHint = ""
+
+// checkFirewall does nothing here: server firewalls are set up by whoever
+// runs the server, and the hint for a silent port names them.
+func checkFirewall(mode string, ports []int) {}
@@ -0,0 +1,134 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+)
+
+// localFirewallHint is added to the hint for a silent SRTLA port.
+const localFirewallHint = " Windows Defender Firewall may block it too: allow go-irl for private and public networks, or run go-irl once with -firewall=add."
+
+// firewallRuleName names the inbound rule go-irl adds for port.
+func firewallRuleName(port int) string {
+	return fmt.Sprintf("go-irl UDP %d", port)
+}
+
+// checkFirewall adds Windows Firewall rules for the UDP ports that have
+// none, on first run with the user's consent. Windows asks for
+// administrator permission to add them.
+func checkFirewall(mode string, ports []int) {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Printf("[firewall] Can't check the firewall rules: %v", err)
+		return
+	}
+	state := loadFirewallState()
+	moved := state.Program != "" && !strings.EqualFold(state.Program, exe)
+	var missing []int
+	for _, port := range ports {
+		if moved || !firewallRuleExists(port) {
+			missing = append(missing, port) // a rule for the old path doesn't apply to this one
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	hint := fmt.Sprintf("Run go-irl once with -firewall=add to allow UDP %s in Windows Defender Firewall.", formatPorts(missing))
+	if mode == FirewallAsk {
+		if state.Declined && !moved && slices.Equal(state.Ports, ports) {
+			log.Printf("[firewall] No firewall rule allows UDP %s. %s", formatPorts(missing), hint)
+			return
+		}
+		yes, asked := askConsent(fmt.Sprintf("Windows Firewall blocks incoming streams on UDP %s until a rule allows them. Add the rule now? Windows asks for administrator permission.", formatPorts(missing)))
+		if !asked {
+			log.Printf("[firewall] No firewall rule allows UDP %s. %s", formatPorts(missing), hint)
+			return
+		}
+		if !yes {
+			saveFirewallState(firewallState{Program: exe, Ports: ports, Declined: true})
+			log.Printf("[firewall] Not adding firewall rules. %s", hint)
+			return
+		}
+	}
+	if err := addFirewallRules(exe, missing); err != nil {
+		log.Printf("[firewall] Failed to add the firewall rules: %v. %s", err, hint)
+		return
+	}
+	saveFirewallState(firewallState{Program: exe, Ports: ports})
+	log.Printf("[firewall] Windows Firewall now allows go-irl to receive on UDP %s", formatPorts(missing))
+}
+
+func firewallRuleExists(port int) bool {
+	return exec.Command("netsh", "advfirewall", "firewall", "show", "rule", "name="+firewallRuleName(port)).Run() == nil
+}
+
+// addFirewallRules replaces the rules of ports with ones for exe. It tries
+// without elevation first, which works when go-irl runs as administrator.
+func addFirewallRules(exe string, ports []int) error {
+	var cmds [][]string
+	for _, port := range ports {
+		name := "name=" + firewallRuleName(port)
+		cmds = append(cmds,
+			[]string{"netsh", "advfirewall", "firewall", "delete", "rule", name},
+			[]string{"netsh", "advfirewall", "firewall", "add", "rule", name, "dir=in", "action=allow",
+				"protocol=UDP", fmt.Sprintf("localport=%d", port), "program=" + exe, "profile=any"})
+	}
+	direct := true
+	for _, c := range cmds {
+		if err := exec.Command(c[0], c[1:]...).Run(); err != nil && c[3] == "add" {
+			direct = false
+			break
+		}
+	}
+	if !direct {
+		if err := runElevated(cmds); err != nil {
+			return err
+		}
+	}
+	for _, port := range ports {
+		if !firewallRuleExists(port) {
+			return fmt.Errorf("the rule for UDP %d is missing, administrator permission declined?", port)
+		}
+	}
+	return nil
+}
+
+// runElevated runs cmds in one cmd.exe started through the UAC prompt and
+// waits for it.
+func runElevated(cmds [][]string) error {
+	lines := make([]string, len(cmds))
+	for i, c := range cmds {
+		args := make([]string, len(c))
+		for j, a := range c {
+			args[j] = syscall.EscapeArg(a)
+		}
+		lines[i] = strings.Join(args, " ")
+	}
+	script := strings.ReplaceAll("/c "+strings.Join(lines, " & "), "'", "''")
+	ps := "$p = Start-Process -FilePath cmd.exe -ArgumentList '" + script + "' -Verb RunAs -Wait -PassThru -WindowStyle Hidden; exit $p.ExitCode"
+
+	// -EncodedCommand takes UTF-16LE in base64, which needs no quoting
+	u := utf16.Encode([]rune(ps))
+	b := make([]byte, 2*len(u))
+	for i, r := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-EncodedCommand", base64.StdEncoding.EncodeToString(b)).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
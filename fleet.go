@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	FleetPollPeriod   = 2 * time.Second
+	FleetStreamMaxAge = 10 * time.Second // an instance is streaming if its bitrate estimate is this fresh
+)
+
+// fleetPeer is an instance the aggregator polls, see -instance.
+type fleetPeer struct {
+	name string
+	url  string // base URL of its -api-port
+}
+
+type fleetPeers []fleetPeer
+
+func (p *fleetPeers) String() string {
+	var parts []string
+	for _, peer := range *p {
+		parts = append(parts, peer.name+"="+peer.url)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p *fleetPeers) Set(s string) error {
+	name, addr, ok := strings.Cut(s, "=")
+	if !ok {
+		name, addr = s, s
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	if name == "" || addr == "http://" {
+		return fmt.Errorf("expected name=http://host:port, e.g. alice=http://203.0.113.5:9990")
+	}
+	*p = append(*p, fleetPeer{name: name, url: strings.TrimRight(addr, "/")})
+	return nil
+}
+
+// fleetInstance is the state of one polled instance.
+type fleetInstance struct {
+	Name        string          `json:"name"`
+	URL         string          `json:"url"`
+	Up          bool            `json:"up"`
+	LastSeen    *time.Time      `json:"lastSeen,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	LatencyMs   float64         `json:"latencyMs"`
+	Groups      int             `json:"groups"`
+	Conns       int             `json:"conns"`
+	MemoryBytes int64           `json:"memoryBytes"`
+	Restarts    int             `json:"restarts"` // subsystem restarts since the instance started
+	Leaks       []string        `json:"leaks"`
+	Problems    []problem       `json:"problems"`
+	Streaming   bool            `json:"streaming"`
+	Bitrate     *bitrateMessage `json:"bitrate,omitempty"`
+}
+
+// fleetMessage combines the stats of all instances. It is served at
+// /api/fleet and broadcast on the WebSocket.
+type fleetMessage struct {
+	Timestamp   time.Time       `json:"timestamp"`
+	Type        string          `json:"type"` // always "fleet"
+	InstancesUp int             `json:"instancesUp"`
+	Streaming   int             `json:"streaming"`
+	Groups      int             `json:"groups"`
+	Conns       int             `json:"conns"`
+	RecvKbps    float64         `json:"recvKbps"`
+	Instances   []fleetInstance `json:"instances"`
+}
+
+type fleet struct {
+	client *http.Client
+
+	mu        sync.Mutex
+	instances []fleetInstance
+	last      fleetMessage
+}
+
+func runFleet(args []string) {
+	fs := flag.NewFlagSet("fleet", flag.ExitOnError)
+	var peers fleetPeers
+	fs.Var(&peers, "instance", "An instance to collect stats from, name=http://host:api-port; repeatable")
+	port := fs.Int("port", 9980, "Port of the fleet dashboard and /api/fleet")
+	host := fs.String("host", "127.0.0.1", "Address the dashboard binds to")
+	poll := fs.Duration("poll", FleetPollPeriod, "How often every instance is polled")
+	wsPort := fs.Int("ws-port", 0, "WebSocket port publishing the combined stats, 0 disables it")
+	fs.Parse(args)
+
+	if len(peers) == 0 {
+		log.Fatalf("ERROR: fleet requires at least one -instance")
+	}
+	f := &fleet{client: &http.Client{Timeout: max(*poll, time.Second)}}
+	for _, p := range peers {
+		f.instances = append(f.instances, fleetInstance{Name: p.name, URL: p.url, Leaks: []string{}})
+	}
+	runStatsHub(*wsPort)
+	go supervise("fleet", func() {
+		for {
+			f.pollAll()
+			time.Sleep(*poll)
+		}
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/fleet", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		msg := f.last
+		f.mu.Unlock()
+		writeJSON(w, msg)
+	})
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		localizePage(w, r, fleetDashboardHTML)
+	})
+	mux.HandleFunc("GET /i18n.json", handleI18n)
+	addr := net.JoinHostPort(*host, strconv.Itoa(*port))
+	log.Printf("[fleet] Collecting stats from %d instances every %s", len(peers), *poll)
+	log.Printf("[fleet] Dashboard: http://%s/", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("Failed to start the fleet dashboard: %v", err)
+		}
+	}()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	<-signalChan
+}
+
+func (f *fleet) pollAll() {
+	f.mu.Lock()
+	insts := append([]fleetInstance(nil), f.instances...)
+	f.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i := range insts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.poll(&insts[i])
+		}()
+	}
+	wg.Wait()
+
+	msg := fleetMessage{Timestamp: time.Now(), Type: "fleet", Instances: insts}
+	for _, inst := range insts {
+		if !inst.Up {
+			continue
+		}
+		msg.InstancesUp++
+		msg.Groups += inst.Groups
+		msg.Conns += inst.Conns
+		if inst.Streaming {
+			msg.Streaming++
+			msg.RecvKbps += inst.Bitrate.RecvKbps
+		}
+	}
+	f.mu.Lock()
+	f.instances, f.last = insts, msg
+	f.mu.Unlock()
+	publishMessage(msg)
+}
+
+// poll refreshes inst from its /api/diagnostics and /api/bitrate.
+func (f *fleet) poll(inst *fleetInstance) {
+	wasUp := inst.Up
+	start := time.Now()
+	var d diagnostics
+	err := f.getJSON(inst.URL+"/api/diagnostics", &d)
+	if err != nil {
+		inst.Up, inst.Error, inst.Streaming, inst.Bitrate = false, err.Error(), false, nil
+		if wasUp {
+			log.Printf("[fleet] %s is down: %v", inst.Name, err)
+			emitEvent("fleet.instance_down", map[string]any{"instance": inst.Name, "error": err.Error()})
+		}
+		return
+	}
+	now := time.Now()
+	inst.Up, inst.Error, inst.LastSeen = true, "", &now
+	inst.LatencyMs = float64(now.Sub(start).Microseconds()) / 1000
+	inst.Groups, inst.Conns, inst.MemoryBytes, inst.Leaks, inst.Problems = len(d.Groups), 0, d.MemoryBytes, d.Leaks, d.Problems
+	for _, g := range d.Groups {
+		inst.Conns += g.Conns
+	}
+	inst.Restarts = 0
+	for _, n := range d.SubsystemRestarts {
+		inst.Restarts += n
+	}
+	if !wasUp {
+		log.Printf("[fleet] %s is up", inst.Name)
+		emitEvent("fleet.instance_up", map[string]any{"instance": inst.Name})
+	}
+
+	// 503 means the instance has no stream
+	var b bitrateMessage
+	if err := f.getJSON(inst.URL+"/api/bitrate", &b); err == nil && now.Sub(b.Timestamp) < FleetStreamMaxAge {
+		inst.Streaming, inst.Bitrate = true, &b
+	} else {
+		inst.Streaming, inst.Bitrate = false, nil
+	}
+}
+
+func (f *fleet) getJSON(url string, v any) error {
+	resp, err := f.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// fleetDashboardHTML is the combined dashboard, refreshed from /api/fleet.
+const fleetDashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{fleet.title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #111; color: #eee; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: .4em .8em; text-align: left; border-bottom: 1px solid #333; }
+.down { color: #f55; } .live { color: #5f5; } .idle { color: #aaa; } .hint { color: #fa5; }
+#summary { margin-bottom: 1em; font-size: 1.2em; }
+</style>
+</head>
+<body>
+<h1>{{fleet.title}}</h1>
+<div id="summary"></div>
+<table>
+<thead><tr><th>{{fleet.instance}}</th><th>{{fleet.status}}</th><th>{{fleet.bitrate}}</th><th>{{fleet.loss}}</th><th>{{fleet.rtt}}</th><th>{{fleet.groups}}</th><th>{{fleet.connections}}</th><th>{{fleet.memory}}</th><th>{{fleet.restarts}}</th><th>{{fleet.api_latency}}</th><th>{{fleet.problems}}</th></tr></thead>
+<tbody id="rows"></tbody>
+</table>
+<script>
+function esc(s) { return String(s).replace(/[&<>"]/g, c => ({"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"})[c]); }
+function fmt(s, vals) { return s.replace(/\{(\w+)\}/g, (m, k) => k in vals ? vals[k] : m); }
+async function refresh() {
+  try {
+    const f = await (await fetch("api/fleet")).json();
+    document.getElementById("summary").textContent = fmt({{js:fleet.summary}}, {
+      up: f.instancesUp, total: (f.instances || []).length, streaming: f.streaming,
+      kbps: Math.round(f.recvKbps), conns: f.conns,
+    });
+    document.getElementById("rows").innerHTML = (f.instances || []).map(i => {
+      const status = !i.up ? '<span class="down">{{fleet.down}}</span>' : i.streaming ? '<span class="live">{{fleet.live}}</span>' : '<span class="idle">{{fleet.idle}}</span>';
+      const b = i.bitrate;
+      return "<tr><td>" + esc(i.name) + "</td><td title=\"" + esc(i.error || "") + "\">" + status + "</td>" +
+        "<td>" + (b ? Math.round(b.recvKbps) + " kbps" : "") + "</td>" +
+        "<td>" + (b ? b.lossPercent.toFixed(1) + " %" : "") + "</td>" +
+        "<td>" + (b ? Math.round(b.rttMs) + " ms" : "") + "</td>" +
+        "<td>" + (i.up ? i.groups : "") + "</td><td>" + (i.up ? i.conns : "") + "</td>" +
+        "<td>" + (i.up ? Math.round(i.memoryBytes / 1048576) + " MB" : "") + "</td>" +
+        "<td>" + (i.up ? i.restarts : "") + "</td><td>" + (i.up ? Math.round(i.latencyMs) + " ms" : "") + "</td>" +
+        "<td class=\"hint\">" + (i.up ? (i.problems || []).map(p => esc(p.hint)).join("<br>") : "") + "</td></tr>";
+    }).join("");
+  } catch (e) {
+    document.getElementById("summary").textContent = fmt({{js:fleet.lost}}, {error: e});
+  }
+}
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
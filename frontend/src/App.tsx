@@ -1,3 +1,4 @@
+import { useRef } from "react";
 import { Graph } from "./Graph";
 import { SimpleText } from "./SimpleText";
 import { useWebSocket } from "./useWebSocket";
@@ -7,28 +8,62 @@ function App() {
   const displayType = urlParams.get("type") || "simple";
   const wsPort = urlParams.get("wsport") || "8888";
 
-  const ENDPOINT = `ws://localhost:${wsPort}/ws`;
+  // wsurl is set behind a reverse proxy, see /api/endpoints. Served over
+  // HTTPS, the WebSocket uses TLS too, with the same hostname the
+  // certificate was issued for
+  const ENDPOINT =
+    urlParams.get("wsurl") ||
+    (window.location.protocol === "https:"
+      ? `wss://${window.location.hostname}:${wsPort}/ws`
+      : `ws://localhost:${wsPort}/ws`);
 
   const onlineSceneName = urlParams.get("onlineSceneName") || "ONLINE";
   const offlineSceneName = urlParams.get("offlineSceneName") || "OFFLINE";
+  const brbSceneName = urlParams.get("brbSceneName") || "BRB";
+  // With alerts=audio the cues of -audio-alerts are played; enable
+  // "Control audio via OBS" to route them to the monitoring output
+  const playAlerts = urlParams.get("alerts") === "audio";
+
+  // While BRB is on, the connection state doesn't switch scenes; the
+  // scene it would have picked is restored when BRB ends
+  const brb = useRef(false);
+  const scene = useRef(onlineSceneName);
+  const setScene = (name: string) => {
+    scene.current = name;
+    if (!brb.current) {
+      window.obsstudio?.setCurrentScene(name);
+    }
+  };
 
   const { messages, isDisconnected } = useWebSocket({
     url: ENDPOINT,
     onConnected: () => {
       console.log("connected");
-      window.obsstudio?.setCurrentScene(onlineSceneName);
+      setScene(onlineSceneName);
     },
     onDisconnected: () => {
       console.log("disconnected");
-      window.obsstudio?.setCurrentScene(offlineSceneName);
+      setScene(offlineSceneName);
     },
     onGoodConnection: () => {
       console.log("good connection");
-      window.obsstudio?.setCurrentScene(onlineSceneName);
+      setScene(onlineSceneName);
     },
     onPoorConnection: () => {
       console.log("poor connection");
-      window.obsstudio?.setCurrentScene(offlineSceneName);
+      setScene(offlineSceneName);
+    },
+    onAudioAlert: (url) => {
+      if (playAlerts) {
+        new Audio(url).play().catch((e) => console.error("alert", e));
+      }
+    },
+    onBrb: (active) => {
+      console.log(active ? "brb" : "brb ended");
+      brb.current = active;
+      window.obsstudio?.setCurrentScene(
+        active ? brbSceneName : scene.current
+      );
     },
   });
 
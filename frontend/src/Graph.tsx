@@ -1,5 +1,7 @@
 import { useEffect, useRef } from "react";
 import * as echarts from "echarts";
+import { t } from "./i18n";
+import { cssVar, themeColor } from "./theme";
 
 type DataItem = {
   timepointUnixMs: number;
@@ -26,7 +28,7 @@ export const Graph = ({
     const chart = echarts.init(chartRef.current);
 
     const option = {
-      backgroundColor: "rgba(0, 0, 0, 0.9)",
+      backgroundColor: themeColor("graph"),
       animation: false,
       tooltip: { show: false },
       legend: {
@@ -41,7 +43,7 @@ export const Graph = ({
       xAxis: {
         type: "time",
         axisLabel: { show: false },
-        axisLine: { lineStyle: { color: "#757575" } },
+        axisLine: { lineStyle: { color: themeColor("axis") } },
         min: Date.now() - DURATION,
         max: Date.now(),
       },
@@ -85,7 +87,7 @@ export const Graph = ({
           yAxisIndex: 0,
           data: nonNullData.map((d) => [d.timepointUnixMs, d.bitrate]),
           itemStyle: {
-            color: "#42A5F5",
+            color: themeColor("bitrate"),
           },
         },
         {
@@ -98,7 +100,7 @@ export const Graph = ({
             d.rtt < 20 ? 20 : d.rtt,
           ]),
           itemStyle: {
-            color: "#66BB6A",
+            color: themeColor("rtt"),
           },
         },
         {
@@ -111,7 +113,7 @@ export const Graph = ({
             d.loss === 0 ? -Infinity : d.loss,
           ]),
           itemStyle: {
-            color: "#FFB74D",
+            color: themeColor("loss"),
           },
           symbolSize: 5,
         },
@@ -145,12 +147,12 @@ export const Graph = ({
           bottom: 8,
           left: 16,
           backgroundColor: isDisconnected
-            ? "#CFD8DC"
+            ? cssVar("idle")
             : (nonNullData[nonNullData.length - 1]?.loss ?? 0) > 0.2
-            ? "#E57373"
+            ? cssVar("bad")
             : (nonNullData[nonNullData.length - 1]?.loss ?? 0) > 0.05
-            ? "#FFC107"
-            : "#8BC34A",
+            ? cssVar("warn")
+            : cssVar("good"),
           borderRadius: 12,
           width: 12,
           height: 12,
@@ -165,7 +167,8 @@ export const Graph = ({
             left: 0,
             fontFamily: "monospace",
             fontSize: 20,
-            color: "#CFD8DC",
+            color: cssVar("text"),
+            textShadow: cssVar("shadow"),
             gap: 8,
             justifyContent: "space-between",
             width: "100%",
@@ -178,29 +181,29 @@ export const Graph = ({
               textAlign: "right",
               width: 120,
               whiteSpace: "pre",
-              color: "#42A5F5",
+              color: cssVar("bitrate"),
             }}
           >
             {lastItem.bitrate.toFixed(1)}
-            Mbps
+            {t("overlay.mbps")}
           </div>
           <div
             style={{
               textAlign: "right",
               width: 100,
               whiteSpace: "pre",
-              color: "#66BB6A",
+              color: cssVar("rtt"),
             }}
           >
             {lastItem.rtt.toFixed(0)}
-            ms
+            {t("overlay.ms")}
           </div>
           <div
             style={{
               textAlign: "right",
               width: 100,
               whiteSpace: "pre",
-              color: "#FFB74D",
+              color: cssVar("loss"),
             }}
           >
             {(lastItem.loss * 100).toFixed(1)}%
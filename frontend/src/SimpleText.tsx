@@ -1,3 +1,6 @@
+import { t } from "./i18n";
+import { cssVar } from "./theme";
+
 interface SimpleTextProps {
   data: Array<{
     timepointUnixMs: number;
@@ -19,7 +22,7 @@ export function SimpleText({ data, isDisconnected }: SimpleTextProps) {
         height: "28px",
         borderRadius: 5,
         overflow: "hidden",
-        backgroundColor: "rgba(20, 20, 20, 0.8)",
+        backgroundColor: cssVar("panel"),
         display: "flex",
         lineHeight: "28px",
         alignItems: "center",
@@ -28,12 +31,12 @@ export function SimpleText({ data, isDisconnected }: SimpleTextProps) {
       <div
         style={{
           backgroundColor: isDisconnected
-            ? "#CFD8DC"
+            ? cssVar("idle")
             : (nonNullData[nonNullData.length - 1]?.loss ?? 0) > 0.2
-            ? "#E57373"
+            ? cssVar("bad")
             : (nonNullData[nonNullData.length - 1]?.loss ?? 0) > 0.05
-            ? "#FFC107"
-            : "#8BC34A",
+            ? cssVar("warn")
+            : cssVar("good"),
           borderRadius: 12,
           width: 12,
           height: 12,
@@ -46,7 +49,8 @@ export function SimpleText({ data, isDisconnected }: SimpleTextProps) {
             display: isDisconnected ? "none" : "flex",
             fontFamily: "monospace",
             fontSize: 20,
-            color: "#CFD8DC",
+            color: cssVar("text"),
+            textShadow: cssVar("shadow"),
             gap: 8,
             justifyContent: "space-between",
             width: "100%",
@@ -59,29 +63,29 @@ export function SimpleText({ data, isDisconnected }: SimpleTextProps) {
               textAlign: "right",
               width: 120,
               whiteSpace: "pre",
-              color: "#42A5F5",
+              color: cssVar("bitrate"),
             }}
           >
             {lastItem.bitrate.toFixed(1)}
-            Mbps
+            {t("overlay.mbps")}
           </div>
           <div
             style={{
               textAlign: "right",
               width: 100,
               whiteSpace: "pre",
-              color: "#66BB6A",
+              color: cssVar("rtt"),
             }}
           >
             {lastItem.rtt.toFixed(0)}
-            ms
+            {t("overlay.ms")}
           </div>
           <div
             style={{
               textAlign: "right",
               width: 100,
               whiteSpace: "pre",
-              color: "#FFB74D",
+              color: cssVar("loss"),
             }}
           >
             {(lastItem.loss * 100).toFixed(1)}%
@@ -0,0 +1,26 @@
+// Messages come from /i18n.json (see -lang), for the language of -lang
+// or ?lang= of the page. The fallbacks are English, for the dev server.
+let messages: Record<string, string> = {
+  "overlay.mbps": "Mbps",
+  "overlay.ms": "ms",
+};
+
+export function t(key: string): string {
+  return messages[key] ?? key;
+}
+
+// loadMessages fetches the catalog; the overlay renders in English when
+// it can't be loaded
+export async function loadMessages() {
+  try {
+    const res = await fetch("i18n.json" + window.location.search);
+    if (!res.ok) {
+      return;
+    }
+    const data = await res.json();
+    messages = { ...messages, ...data.messages };
+    document.documentElement.lang = data.lang;
+  } catch (e) {
+    console.error("loading messages failed", e);
+  }
+}
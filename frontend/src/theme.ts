@@ -0,0 +1,37 @@
+// The palette comes from /theme.css (see -theme), as --goirl-* CSS
+// variables. The fallbacks are the dark theme, for the dev server.
+const fallbacks: Record<string, string> = {
+  panel: "rgba(20, 20, 20, 0.8)",
+  graph: "rgba(0, 0, 0, 0.9)",
+  text: "#CFD8DC",
+  shadow: "none",
+  bitrate: "#42A5F5",
+  rtt: "#66BB6A",
+  loss: "#FFB74D",
+  good: "#8BC34A",
+  warn: "#FFC107",
+  bad: "#E57373",
+  idle: "#CFD8DC",
+  axis: "#757575",
+};
+
+// cssVar is for inline styles
+export function cssVar(name: string): string {
+  return `var(--goirl-${name}, ${fallbacks[name]})`;
+}
+
+// themeColor resolves the variable, for echarts, which can't use var()
+export function themeColor(name: string): string {
+  const value = getComputedStyle(document.documentElement)
+    .getPropertyValue(`--goirl-${name}`)
+    .trim();
+  return value || fallbacks[name];
+}
+
+// loadTheme adds /theme.css, passing on ?theme= of the page
+export function loadTheme() {
+  const link = document.createElement("link");
+  link.rel = "stylesheet";
+  link.href = "theme.css" + window.location.search;
+  document.head.appendChild(link);
+}
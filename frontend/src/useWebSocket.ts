@@ -16,12 +16,16 @@ export function useWebSocket({
   onDisconnected,
   onPoorConnection,
   onGoodConnection,
+  onBrb,
+  onAudioAlert,
 }: {
   url: string;
   onConnected?: () => void;
   onDisconnected?: () => void;
   onPoorConnection?: () => void;
   onGoodConnection?: () => void;
+  onBrb?: (active: boolean) => void;
+  onAudioAlert?: (url: string) => void;
 }) {
   const [messages, setMessages] = useState<
     (z.infer<typeof WebSocketMessageSchema> | null)[]
@@ -43,8 +47,21 @@ export function useWebSocket({
   };
 
   const handleMessage = (event: MessageEvent) => {
+    const data = JSON.parse(event.data);
+    if (data?.type === "brb") {
+      // Switched by a control client, see -control-token
+      onBrb?.(data.active === true);
+      return;
+    }
+    if (data?.type === "audio_alert" && typeof data.url === "string") {
+      onAudioAlert?.(data.url);
+      return;
+    }
     setMessages((prev) => {
-      const data = JSON.parse(event.data);
+      if (data?.type !== "reader" && data?.type !== "writer") {
+        // Events, clock reports, ... are not stats samples
+        return prev;
+      }
       const parsed = WebSocketMessageSchema.safeParse(data);
       if (!parsed.success) {
         console.error(parsed.error.errors);
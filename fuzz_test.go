@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Seeds for the packet fuzzers: one of each packet type a sender sends to
+// the SRTLA port.
+func srtlaSeeds() [][]byte {
+	reg1 := srtlaReg(SRTLATypeReg1, bytes.Repeat([]byte{0xab}, SRTLAIDLen/2))
+	reg2 := srtlaReg(SRTLATypeReg2, bytes.Repeat([]byte{0xcd}, SRTLAIDLen))
+
+	keepaliveTS := make([]byte, SRTLAKeepaliveTSLen)
+	binary.BigEndian.PutUint16(keepaliveTS, SRTLATypeKeepalive)
+	binary.BigEndian.PutUint64(keepaliveTS[2:], 1700000000000)
+
+	ack := make([]byte, 4+RecvACKInterval*4)
+	binary.BigEndian.PutUint32(ack, uint32(SRTLATypeACK)<<16)
+	for i := 0; i < RecvACKInterval; i++ {
+		binary.BigEndian.PutUint32(ack[4+i*4:], uint32(i))
+	}
+
+	return [][]byte{
+		reg1,
+		reg2,
+		keepalivePkt,
+		keepaliveTS,
+		labelKeepalive("wifi"),
+		ack,
+		srtHandshakePkt(SRTHandshakeInduction, 0x1234),
+		srtHandshakePkt(SRTHandshakeConclusion, 0x5678),
+		srtData(1),
+		srtControl(SRTTypeACK),
+		srtControl(SRTTypeShutdown),
+		{},
+		{0x92},
+	}
+}
+
+// srtHandshakePkt returns an SRT handshake of the given type from the
+// socket sourceID.
+func srtHandshakePkt(typ, sourceID uint32) []byte {
+	pkt := make([]byte, SRTHandshakeSize)
+	binary.BigEndian.PutUint16(pkt, SRTTypeHandshake)
+	binary.BigEndian.PutUint32(pkt[16:], 5)
+	binary.BigEndian.PutUint32(pkt[28:], MTU)
+	binary.BigEndian.PutUint32(pkt[36:], typ)
+	binary.BigEndian.PutUint32(pkt[40:], sourceID)
+	return pkt
+}
+
+// FuzzHandleSRTLAIncoming feeds arbitrary datagrams to the packet path, from
+// a registered link and from an unknown address, next to a live group.
+func FuzzHandleSRTLAIncoming(f *testing.F) {
+	for _, seed := range srtlaSeeds() {
+		f.Add(seed, true)
+		f.Add(seed, false)
+	}
+	f.Fuzz(func(t *testing.T, pkt []byte, fromLink bool) {
+		if len(pkt) > MTU {
+			pkt = pkt[:MTU] // the reader's buffers are one MTU
+		}
+		h := newSRTLAHarness(t)
+		g := h.register(link1)
+		from := link2
+		if fromLink {
+			from = link1
+		}
+		h.deliver(from, pkt)
+		h.deliver(link1, srtData(1)) // the group still forwards
+
+		conn := h.srtConn(0)
+		eventually(t, func() bool {
+			for _, p := range conn.written() {
+				if bytes.Equal(p, srtData(1)) {
+					return true
+				}
+			}
+			return findGroupByID(g.id[:]) == nil // e.g. ended by a shutdown
+		}, "the data packet")
+		if n := len(groupList()); n > 2 {
+			t.Fatalf("%d groups after one packet", n)
+		}
+	})
+}
+
+// FuzzParseSRTHandshake checks that the handshake parser accepts exactly
+// the handshake packets and reads their fields where they are.
+func FuzzParseSRTHandshake(f *testing.F) {
+	for _, seed := range srtlaSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, pkt []byte) {
+		hs, err := parseSRTHandshake(pkt)
+		isHandshake := len(pkt) >= SRTHandshakeSize && getSRTType(pkt) == SRTTypeHandshake
+		if (err == nil) != isHandshake {
+			t.Fatalf("parseSRTHandshake(%d bytes, type 0x%04x) error %v", len(pkt), getSRTType(pkt), err)
+		}
+		if err != nil {
+			return
+		}
+		if hs.Type != binary.BigEndian.Uint32(pkt[36:]) || hs.SourceID != binary.BigEndian.Uint32(pkt[40:]) {
+			t.Fatalf("parsed %+v from %x", hs, pkt[:SRTHandshakeSize])
+		}
+
+		// The socket ID tracking runs every handshake through the parser
+		var s srtSession
+		g := &Group{}
+		s.fromSender(g, pkt)
+		s.fromServer(g, pkt)
+	})
+}
+
+// FuzzSRTLARegID checks that only REG1 and REG2 packets of the exact length
+// yield an ID, and that it is the one in the packet.
+func FuzzSRTLARegID(f *testing.F) {
+	for _, seed := range srtlaSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, pkt []byte) {
+		for _, typ := range []uint16{SRTLATypeReg1, SRTLATypeReg2} {
+			id := srtlaRegID(pkt, typ)
+			want := len(pkt) == 2+SRTLAIDLen && getSRTType(pkt) == typ
+			if (id != nil) != want {
+				t.Fatalf("srtlaRegID(%d bytes, type 0x%04x, 0x%04x) = %x", len(pkt), getSRTType(pkt), typ, id)
+			}
+			if id != nil && !bytes.Equal(id, pkt[2:]) {
+				t.Fatalf("ID %x, want %x", id, pkt[2:])
+			}
+		}
+	})
+}
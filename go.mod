@@ -3,12 +3,14 @@ module go-irl
 go 1.24.4
 
 require (
+	fyne.io/systray v1.12.2
 	github.com/datarhei/gosrt v0.9.0
 	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.33.0
+	golang.org/x/sys v0.30.0
 )
 
 require (
 	github.com/benburkert/openpgp v0.0.0-20160410205803-c2471f86866c // indirect
-	golang.org/x/crypto v0.33.0 // indirect
-	golang.org/x/sys v0.30.0 // indirect
+	github.com/godbus/dbus/v5 v5.1.0 // indirect
 )
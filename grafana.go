@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// GrafanaAnnotatedEvents are the events the dashboard marks on its graphs.
+const GrafanaAnnotatedEvents = "stream.started|stream.stopped|stream.low_bitrate|link.degrading|link.recovered|conn.removed|group.removed|group.overloaded"
+
+// grafanaDashboard builds a dashboard of the /metrics this instance
+// exports, with panels only for what its mode and flags enable. It is meant
+// for Grafana's Import, which asks for the Prometheus data source.
+func grafanaDashboard() map[string]any {
+	ds := map[string]any{"type": "prometheus", "uid": "${DS_PROMETHEUS}"}
+	sel := `instance=~"$instance"`
+	var panels []map[string]any
+	y := 0
+	row := func(title string) {
+		panels = append(panels, map[string]any{
+			"id": len(panels) + 1, "type": "row", "title": title, "collapsed": false, "panels": []any{},
+			"gridPos": map[string]int{"x": 0, "y": y, "w": 24, "h": 1},
+		})
+		y++
+	}
+	// panel adds a time series of the queries (expression, legend), width
+	// columns of 24 wide, on a new line when it doesn't fit the current one.
+	x := 0
+	panel := func(title, unit string, width int, queries ...[2]string) {
+		targets := []map[string]any{}
+		for i, q := range queries {
+			targets = append(targets, map[string]any{
+				"datasource": ds, "expr": q[0], "legendFormat": q[1], "refId": string(rune('A' + i)),
+			})
+		}
+		if x+width > 24 {
+			x, y = 0, y+8
+		}
+		panels = append(panels, map[string]any{
+			"id": len(panels) + 1, "type": "timeseries", "title": title, "datasource": ds, "targets": targets,
+			"fieldConfig": map[string]any{"defaults": map[string]any{"unit": unit}, "overrides": []any{}},
+			"gridPos":     map[string]int{"x": x, "y": y, "w": width, "h": 8},
+		})
+		x += width
+		if x >= 24 {
+			x, y = 0, y+8
+		}
+	}
+	flush := func() {
+		if x > 0 {
+			x, y = 0, y+8
+		}
+	}
+
+	receiver := runsReceiver()
+	proxy := runsProxy()
+	if proxy {
+		row("Stream")
+		panel("Bitrate", "bps", 12, [2]string{`rate(goirl_stream_received_bytes_total{` + sel + `}[$__rate_interval]) * 8`, "{{instance}}"})
+		panel("RTT", "s", 12, [2]string{`goirl_stream_rtt_seconds{` + sel + `}`, "RTT"}, [2]string{`goirl_stream_buffer_seconds{` + sel + `}`, "buffer"})
+		panel("Packet loss", "percentunit", 12, [2]string{
+			`rate(goirl_stream_lost_packets_total{` + sel + `}[$__rate_interval]) / (rate(goirl_stream_received_packets_total{` + sel + `}[$__rate_interval]) + rate(goirl_stream_lost_packets_total{` + sel + `}[$__rate_interval]))`, "lost",
+		})
+		panel("Retransmitted and dropped", "pps", 12,
+			[2]string{`rate(goirl_stream_retransmitted_packets_total{` + sel + `}[$__rate_interval])`, "retransmitted"},
+			[2]string{`rate(goirl_stream_dropped_packets_total{` + sel + `}[$__rate_interval])`, "dropped"})
+		flush()
+	}
+	if receiver {
+		row("SRTLA links")
+		panel("Link bitrate", "bps", 24, [2]string{`rate(goirl_link_received_bytes_total{` + sel + `}[$__rate_interval]) * 8`, "{{label}} {{addr}}"})
+		panel("Connections", "short", 12, [2]string{`goirl_group_connections{` + sel + `}`, "{{group}}"})
+		panel("Worker queue", "short", 12,
+			[2]string{`goirl_group_queue_depth{` + sel + `}`, "depth {{group}}"},
+			[2]string{`rate(goirl_group_queue_drops_total{` + sel + `}[$__rate_interval])`, "drops/s {{group}}"})
+		panel("Misrouted packets", "pps", 12, [2]string{`rate(goirl_group_misrouted_dropped_total{` + sel + `}[$__rate_interval])`, "{{group}}"})
+		if *dedup {
+			panel("Duplicates dropped", "pps", 12, [2]string{`rate(goirl_group_duplicates_dropped_total{` + sel + `}[$__rate_interval])`, "{{group}}"})
+		}
+		if *reorderDelayFlag > 0 {
+			panel("Reorder buffer", "short", 12,
+				[2]string{`goirl_group_reorder_held{` + sel + `}`, "held {{group}}"},
+				[2]string{`rate(goirl_group_reorder_gaps_skipped_total{` + sel + `}[$__rate_interval])`, "gaps skipped/s {{group}}"})
+		}
+		if *maxMemoryMB > 0 {
+			panel("Memory in flight", "bytes", 12, [2]string{`goirl_group_memory_bytes{` + sel + `}`, "{{group}}"})
+		}
+		if *backpressure != BackpressureOff {
+			panel("Overloaded", "short", 12, [2]string{`goirl_group_overloaded{` + sel + `}`, "{{group}}"})
+		}
+		flush()
+	}
+	row("Web")
+	panel("WebSocket clients", "short", 12, [2]string{`goirl_ws_clients{` + sel + `}`, "clients"})
+	panel("HTTP requests", "reqps", 12, [2]string{`sum by (server, code) (rate(goirl_http_requests_total{` + sel + `}[$__rate_interval]))`, "{{server}} {{code}}"})
+
+	return map[string]any{
+		"__inputs": []any{map[string]any{
+			"name": "DS_PROMETHEUS", "label": "Prometheus", "type": "datasource", "pluginId": "prometheus", "pluginName": "Prometheus",
+		}},
+		"title":         "go-irl",
+		"uid":           "go-irl",
+		"tags":          []string{"go-irl", "srtla"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"refresh":       "10s",
+		"time":          map[string]string{"from": "now-1h", "to": "now"},
+		"templating": map[string]any{"list": []any{map[string]any{
+			"name": "instance", "label": "Instance", "type": "query", "datasource": ds,
+			"query": "label_values(goirl_groups, instance)", "refresh": 2, "multi": true, "includeAll": true,
+		}}},
+		"annotations": map[string]any{"list": []any{map[string]any{
+			"name": "Events", "datasource": ds, "enable": true, "iconColor": "orange",
+			"expr":        `changes(goirl_events_total{` + sel + `,name=~"` + strings.ReplaceAll(GrafanaAnnotatedEvents, ".", `\\.`) + `"}[1m]) > 0`,
+			"titleFormat": "{{name}}", "textFormat": "{{instance}}", "useValueForTime": false, "step": "30s",
+		}}},
+		"panels": panels,
+	}
+}
+
+func handleGrafanaDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("download") {
+		w.Header().Set("Content-Disposition", `attachment; filename="go-irl-dashboard.json"`)
+	}
+	writeJSON(w, grafanaDashboard())
+}
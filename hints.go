@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// FirewallHintDelay is how long the SRTLA port may stay silent after
+// startup before go-irl suspects a firewall.
+const FirewallHintDelay = 2 * time.Minute
+
+// failureKind is a failure signature that has a known fix.
+type failureKind string
+
+const (
+	FailurePortInUse        failureKind = "port_in_use"
+	FailurePortDenied       failureKind = "port_denied"
+	FailureConnRefused      failureKind = "connection_refused"
+	FailureHandshakeTimeout failureKind = "handshake_timeout"
+	FailureWrongPassphrase  failureKind = "wrong_passphrase"
+	FailureUnresolved       failureKind = "unresolved_host"
+	FailureNoPackets        failureKind = "no_packets" // firewall suspected
+	FailureLocalNetwork     failureKind = "local_network_denied"
+)
+
+// failureHints tell what to do about each kind of failure; %[1]s is the
+// port or address it happened at.
+var failureHints = map[failureKind]string{
+	FailurePortInUse:        "%[1]s is already in use, by another program or a second go-irl. Stop it, or pick another port.",
+	FailurePortDenied:       "%[1]s needs privileges: ports below 1024 need root or CAP_NET_BIND_SERVICE. Pick a port above 1023.",
+	FailureConnRefused:      "Nothing listens at %[1]s. Start the SRT receiver there (go-irl client, OBS, MediaMTX) first, or check its host and port.",
+	FailureHandshakeTimeout: "%[1]s doesn't answer the SRT handshake. Check that the receiver is running and that its firewall lets UDP through.",
+	FailureWrongPassphrase:  "The SRT passphrase doesn't match at %[1]s. Use the same passphrase on both ends: -passphrase, or passphrase= in the SRT URL.",
+	FailureUnresolved:       "%[1]s can't be resolved. Check the spelling of the host name and this machine's DNS.",
+	FailureNoPackets:        "No packets have reached %[1]s since go-irl started. Check that %[1]s is open in your VPS firewall and your provider's security group, and that the app points at this server's public address." + localFirewallHint,
+	FailureLocalNetwork:     "macOS doesn't let go-irl reach %[1]s. Allow go-irl in System Settings > Privacy & Security > Local Network, then restart it.",
+}
+
+// srtRejections are the handshake rejections a caller gets back for a
+// wrong or missing passphrase, as gosrt puts them in its dial error.
+var srtRejections = []string{
+	"REJECT (" + strconv.FormatUint(uint64(srt.REJ_BADSECRET), 32) + ")",
+	"REJECT (" + strconv.FormatUint(uint64(srt.REJ_UNSECURE), 32) + ")",
+}
+
+// classifyError tells the failure signature of err, or "" for errors
+// without a hint.
+func classifyError(err error) failureKind {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE) || strings.Contains(msg, "address already in use") || strings.Contains(msg, "Only one usage of each socket address"):
+		return FailurePortInUse
+	case errors.Is(err, syscall.EACCES) && strings.Contains(msg, "bind"):
+		return FailurePortDenied
+	case errors.Is(err, syscall.ECONNREFUSED) || strings.Contains(msg, "connection refused"):
+		return FailureConnRefused
+	case runtime.GOOS == "darwin" && errors.Is(err, syscall.EHOSTUNREACH):
+		return FailureLocalNetwork // denied local network permission
+	case errors.As(err, &dnsErr):
+		return FailureUnresolved
+	case strings.Contains(msg, "server didn't respond"):
+		return FailureHandshakeTimeout
+	case strings.Contains(msg, "passphrase is missing") || strings.Contains(msg, "crypto context"):
+		return FailureWrongPassphrase
+	}
+	for _, r := range srtRejections {
+		if strings.Contains(msg, r) {
+			return FailureWrongPassphrase
+		}
+	}
+	return ""
+}
+
+func hintFor(kind failureKind, subject string) string {
+	h, ok := failureHints[kind]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(h, subject)
+}
+
+// problem is an ongoing failure with a hint, as listed in /api/diagnostics.
+type problem struct {
+	Kind    failureKind `json:"kind"`
+	Subject string      `json:"subject"`
+	Hint    string      `json:"hint"`
+	Error   string      `json:"error,omitempty"`
+	Since   time.Time   `json:"since"`
+	Count   int         `json:"count"` // how often it happened
+}
+
+var problems = struct {
+	mu     sync.Mutex
+	active map[string]*problem // by subject
+}{active: map[string]*problem{}}
+
+// reportError records err at subject as a problem if it has a hint.
+func reportError(subject string, err error) {
+	reportProblem(classifyError(err), subject, err)
+}
+
+// reportProblem records a problem at subject. The hint is logged and
+// emitted as a troubleshoot.hint event when the problem is new; repeats are
+// only counted.
+func reportProblem(kind failureKind, subject string, err error) {
+	hint := hintFor(kind, subject)
+	if hint == "" {
+		return
+	}
+	problems.mu.Lock()
+	p := problems.active[subject]
+	if p != nil && p.Kind == kind {
+		p.Count++
+		problems.mu.Unlock()
+		return
+	}
+	p = &problem{Kind: kind, Subject: subject, Hint: hint, Since: time.Now(), Count: 1}
+	if err != nil {
+		p.Error = err.Error()
+	}
+	problems.active[subject] = p
+	problems.mu.Unlock()
+
+	log.Printf("[hint] %s", hint)
+	emitEvent("troubleshoot.hint", map[string]any{"kind": string(kind), "subject": subject, "hint": hint, "error": p.Error})
+}
+
+// clearProblem forgets the problem at subject once it works again.
+func clearProblem(subject string) {
+	problems.mu.Lock()
+	p := problems.active[subject]
+	delete(problems.active, subject)
+	problems.mu.Unlock()
+	if p != nil {
+		log.Printf("[hint] %s works again", subject)
+		emitEvent("troubleshoot.resolved", map[string]any{"kind": string(p.Kind), "subject": subject})
+	}
+}
+
+func activeProblems() []problem {
+	problems.mu.Lock()
+	defer problems.mu.Unlock()
+	list := make([]problem, 0, len(problems.active))
+	for _, p := range problems.active {
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Since.Before(list[j].Since) })
+	return list
+}
+
+// fatalWithHint logs the hint for err at subject, if it has one, and exits
+// like log.Fatalf.
+func fatalWithHint(err error, subject string, format string, args ...any) {
+	if hint := hintFor(classifyError(err), subject); hint != "" {
+		log.Printf("[hint] %s", hint)
+	}
+	log.Fatalf(format, args...)
+}
+
+// watchSilence reports FailureNoPackets at subject unless seen is set
+// within FirewallHintDelay. Whoever sets seen later clears the problem.
+func watchSilence(subject string, seen *atomic.Bool) {
+	time.AfterFunc(FirewallHintDelay, func() {
+		if !seen.Load() {
+			reportProblem(FailureNoPackets, subject, nil)
+		}
+	})
+}
+
+// listenSubject names a listening port the way the hints phrase it, e.g.
+// "UDP port 5000".
+func listenSubject(network string, port int) string {
+	return fmt.Sprintf("%s port %d", strings.ToUpper(network), port)
+}
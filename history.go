@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HistoryInterval   = 5 * time.Second // bitrate sample period of the link timeline
+	HistoryMaxSamples = 4320            // per link, 6 hours at HistoryInterval
+	HistoryMaxGroups  = 8               // closed groups are forgotten beyond this
+)
+
+// linkInterval is a period a link was registered. Down is nil while it
+// still is.
+type linkInterval struct {
+	Up     time.Time  `json:"up"`
+	Down   *time.Time `json:"down,omitempty"`
+	Reason string     `json:"reason,omitempty"` // why it went down
+}
+
+type bitrateSample struct {
+	Time time.Time `json:"t"`
+	Kbps float64   `json:"kbps"`
+}
+
+// linkHistory is the timeline of one address of a group. A link that
+// times out and registers again from the same address gets another
+// interval.
+type linkHistory struct {
+	Addr      string          `json:"addr"`
+	Label     string          `json:"label,omitempty"`
+	Intervals []linkInterval  `json:"intervals"`
+	Samples   []bitrateSample `json:"samples"`
+
+	lastBytes uint64
+	lastTime  time.Time
+}
+
+type groupHistory struct {
+	Group   string         `json:"group"`
+	Created time.Time      `json:"created"`
+	Closed  *time.Time     `json:"closed,omitempty"`
+	Links   []*linkHistory `json:"links"`
+}
+
+// historyStore keeps the link timelines of the current and the last
+// closed groups. Its lock is taken with a group's mu held, never the other
+// way round.
+type historyStore struct {
+	mu     sync.Mutex
+	groups []*groupHistory
+}
+
+var linkHistories = &historyStore{}
+
+// find must be called with h.mu held.
+func (h *historyStore) find(g *Group) *groupHistory {
+	key := fmt.Sprintf("%p", g)
+	for _, gh := range h.groups {
+		if gh.Group == key && gh.Closed == nil {
+			return gh
+		}
+	}
+	return nil
+}
+
+// group must be called with h.mu held.
+func (h *historyStore) group(g *Group) *groupHistory {
+	if gh := h.find(g); gh != nil {
+		return gh
+	}
+	gh := &groupHistory{Group: fmt.Sprintf("%p", g), Created: g.createdAt, Links: []*linkHistory{}}
+	h.groups = append(h.groups, gh)
+	closed := 0
+	for _, gh := range h.groups {
+		if gh.Closed != nil {
+			closed++
+		}
+	}
+	for i := 0; closed > HistoryMaxGroups && i < len(h.groups); {
+		if h.groups[i].Closed != nil {
+			h.groups = append(h.groups[:i], h.groups[i+1:]...)
+			closed--
+			continue
+		}
+		i++
+	}
+	return gh
+}
+
+// link must be called with h.mu held.
+func (gh *groupHistory) link(addr string) *linkHistory {
+	for _, lh := range gh.Links {
+		if lh.Addr == addr {
+			return lh
+		}
+	}
+	lh := &linkHistory{Addr: addr, Intervals: []linkInterval{}, Samples: []bitrateSample{}}
+	gh.Links = append(gh.Links, lh)
+	return lh
+}
+
+func (lh *linkHistory) open() bool {
+	return len(lh.Intervals) > 0 && lh.Intervals[len(lh.Intervals)-1].Down == nil
+}
+
+func (lh *linkHistory) close(now time.Time, reason string) {
+	if lh.open() {
+		last := &lh.Intervals[len(lh.Intervals)-1]
+		last.Down, last.Reason = &now, reason
+	}
+}
+
+// connUp starts an interval for c. Must be called with g.mu held.
+func (h *historyStore) connUp(g *Group, c *Conn, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	lh := h.group(g).link(c.addr.String())
+	if !lh.open() {
+		lh.Intervals = append(lh.Intervals, linkInterval{Up: now})
+	}
+	lh.lastBytes, lh.lastTime = c.rxBytes, now
+}
+
+// connDown ends the interval of c. Must be called with g.mu held.
+func (h *historyStore) connDown(g *Group, c *Conn, now time.Time, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	lh := h.group(g).link(c.addr.String())
+	lh.Label, _ = c.label()
+	lh.close(now, reason)
+}
+
+// groupClosed ends the intervals of a group's links. Must be called with
+// g.mu held.
+func (h *historyStore) groupClosed(g *Group, now time.Time, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	gh := h.find(g)
+	if gh == nil {
+		return
+	}
+	for _, lh := range gh.Links {
+		lh.close(now, reason)
+	}
+	gh.Closed = &now
+}
+
+// sample adds a bitrate sample to the timeline of every registered link.
+func (h *historyStore) sample(now time.Time) {
+	for _, g := range groupList() {
+		g.mu.Lock()
+		if g.closed {
+			g.mu.Unlock()
+			continue
+		}
+		h.mu.Lock()
+		gh := h.group(g)
+		for _, c := range g.conns {
+			lh := gh.link(c.addr.String())
+			if !lh.open() {
+				lh.Intervals = append(lh.Intervals, linkInterval{Up: now})
+				lh.lastBytes, lh.lastTime = c.rxBytes, now
+				continue
+			}
+			lh.Label, _ = c.label()
+			kbps := 0.0
+			if secs := now.Sub(lh.lastTime).Seconds(); secs > 0 {
+				kbps = float64(c.rxBytes-lh.lastBytes) * 8 / 1000 / secs
+			}
+			lh.lastBytes, lh.lastTime = c.rxBytes, now
+			if len(lh.Samples) >= HistoryMaxSamples {
+				lh.Samples = append(lh.Samples[:0], lh.Samples[1:]...)
+			}
+			lh.Samples = append(lh.Samples, bitrateSample{Time: now, Kbps: kbps})
+		}
+		h.mu.Unlock()
+		g.mu.Unlock()
+	}
+}
+
+// snapshot returns a deep copy of the timelines, newest group first.
+func (h *historyStore) snapshot() []groupHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := []groupHistory{}
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		gh := *h.groups[i]
+		gh.Links = make([]*linkHistory, len(h.groups[i].Links))
+		for j, lh := range h.groups[i].Links {
+			cp := *lh
+			cp.Intervals = append([]linkInterval{}, lh.Intervals...)
+			cp.Samples = append([]bitrateSample{}, lh.Samples...)
+			gh.Links[j] = &cp
+		}
+		out = append(out, gh)
+	}
+	return out
+}
+
+func runHistory(ctx context.Context) {
+	ticker := clk.NewTicker(HistoryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			linkHistories.sample(clk.Now())
+		}
+	}
+}
+
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, linkHistories.snapshot())
+}
+
+func handleHistoryPage(w http.ResponseWriter, r *http.Request) {
+	localizePage(w, r, historyPageHTML)
+}
+
+// historyPageHTML draws the timeline of every group from /api/history: a
+// row per link with its registered intervals and its bitrate.
+const historyPageHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{history.title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #111; color: #eee; margin: 2em; }
+h2 { font-size: 1em; color: #aaa; margin-top: 2em; }
+svg { width: 100%; display: block; }
+.name { fill: #eee; font-size: 12px; }
+.axis { fill: #888; font-size: 11px; }
+.up { fill: #2e7d32; }
+.rate { fill: none; stroke: #90caf9; stroke-width: 1.5; }
+.down { fill: #e57373; font-size: 11px; }
+#empty { color: #888; }
+</style>
+</head>
+<body>
+<h1>{{history.title}}</h1>
+<p id="empty" hidden>{{history.empty}}</p>
+<div id="groups"></div>
+<script>
+const W = 1000, ROW = 36, LEFT = 200;
+const reasons = {"timed out": {{js:history.timed_out}}, "group closed": {{js:history.group_closed}}, "sender shutdown": {{js:history.sender_shutdown}}, "server shutdown": {{js:history.server_shutdown}}};
+function esc(s) { return String(s).replace(/[&<>"]/g, c => ({"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"})[c]); }
+function time(t) { return new Date(t).toLocaleTimeString(); }
+function draw(g) {
+  const start = Date.parse(g.created), end = g.closed ? Date.parse(g.closed) : Date.now();
+  const span = Math.max(end - start, 1000);
+  const x = t => LEFT + (W - LEFT) * (Date.parse(t) - start) / span;
+  const max = Math.max(1, ...g.links.flatMap(l => l.samples.map(s => s.kbps)));
+  let svg = "";
+  g.links.forEach((l, i) => {
+    const y = i * ROW;
+    svg += '<text class="name" x="0" y="' + (y + 22) + '">' + esc(l.label || l.addr) + "</text>";
+    for (const iv of l.intervals) {
+      const x1 = x(iv.up), x2 = iv.down ? x(iv.down) : W;
+      svg += '<rect class="up" x="' + x1 + '" y="' + (y + 8) + '" width="' + Math.max(x2 - x1, 1) + '" height="' + (ROW - 12) + '">' +
+        "<title>" + esc(time(iv.up) + " – " + (iv.down ? time(iv.down) + " (" + (reasons[iv.reason] || iv.reason) + ")" : "")) + "</title></rect>";
+      if (iv.down && iv.reason === "timed out") {
+        svg += '<text class="down" x="' + (x2 + 3) + '" y="' + (y + 22) + '">✕ ' + esc(time(iv.down)) + "</text>";
+      }
+    }
+    const pts = l.samples.map(s => x(s.t) + "," + (y + ROW - 4 - (ROW - 12) * s.kbps / max)).join(" ");
+    svg += '<polyline class="rate" points="' + pts + '"></polyline>';
+  });
+  const h = g.links.length * ROW + 20;
+  svg += '<text class="axis" x="' + LEFT + '" y="' + (h - 4) + '">' + esc(time(g.created)) + "</text>";
+  svg += '<text class="axis" x="' + W + '" y="' + (h - 4) + '" text-anchor="end">' + esc(g.closed ? time(g.closed) : {{js:history.now}}) + "</text>";
+  return "<h2>" + {{js:history.group}} + " " + esc(g.group) + " · " + {{js:history.peak}} + " " + Math.round(max) + " kbps</h2>" +
+    '<svg viewBox="0 0 ' + (W + 80) + " " + h + '">' + svg + "</svg>";
+}
+async function refresh() {
+  const groups = await (await fetch("api/history")).json();
+  document.getElementById("empty").hidden = groups.length > 0;
+  document.getElementById("groups").innerHTML = groups.map(draw).join("");
+}
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultLang is the default of -lang. Its catalog has every message, the
+// others fall back to it for the ones they lack.
+const DefaultLang = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogs holds the message catalogs by language, e.g. "ja" or "pt".
+var catalogs = loadCatalogs()
+
+// uiLang is the language of the overlay and dashboards, see -lang.
+var uiLang = DefaultLang
+
+func loadCatalogs() map[string]map[string]string {
+	out := map[string]map[string]string{}
+	files, _ := localeFiles.ReadDir("locales")
+	for _, f := range files {
+		data, err := localeFiles.ReadFile("locales/" + f.Name())
+		if err != nil {
+			continue
+		}
+		var msgs map[string]string
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			log.Printf("[i18n] Skipping %s: %v", f.Name(), err)
+			continue
+		}
+		out[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = msgs
+	}
+	return out
+}
+
+func langNames() []string {
+	var names []string
+	for name := range catalogs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matchLang returns the catalog for a language tag like "pt-BR", trying
+// the tag and then its base language; "" when there is none.
+func matchLang(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for tag != "" {
+		if _, ok := catalogs[tag]; ok {
+			return tag
+		}
+		base, _, found := strings.Cut(tag, "-")
+		if !found {
+			break
+		}
+		tag = base
+	}
+	return ""
+}
+
+// requestLang is the language for r: ?lang= or else -lang.
+func requestLang(r *http.Request) string {
+	if lang := matchLang(r.URL.Query().Get("lang")); lang != "" {
+		return lang
+	}
+	return uiLang
+}
+
+// messages returns the catalog of lang completed with DefaultLang.
+func messages(lang string) map[string]string {
+	out := map[string]string{}
+	for k, v := range catalogs[DefaultLang] {
+		out[k] = v
+	}
+	for k, v := range catalogs[lang] {
+		out[k] = v
+	}
+	return out
+}
+
+// handleI18n serves /i18n.json, the messages for the language of the
+// request, for the Browser Source and custom overlays.
+func handleI18n(w http.ResponseWriter, r *http.Request) {
+	lang := requestLang(r)
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, map[string]any{
+		"lang":      lang,
+		"languages": langNames(),
+		"messages":  messages(lang),
+	})
+}
+
+var placeholderRe = regexp.MustCompile(`\{\{(js:)?([a-z_.]+)\}\}`)
+
+// localizePage fills the placeholders of a dashboard page with the
+// messages for r: {{key}} as HTML text, {{js:key}} as a JavaScript string
+// literal.
+func localizePage(w http.ResponseWriter, r *http.Request, page string) {
+	lang := requestLang(r)
+	msgs := messages(lang)
+	out := placeholderRe.ReplaceAllStringFunc(page, func(m string) string {
+		sub := placeholderRe.FindStringSubmatch(m)
+		msg, ok := msgs[sub[2]]
+		if !ok {
+			msg = sub[2]
+		}
+		if sub[1] != "" {
+			data, _ := json.Marshal(msg) // escapes < and >, safe in a script
+			return string(data)
+		}
+		return html.EscapeString(msg)
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", lang)
+	w.Write([]byte(out))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const IdleCheckPeriod = 10 * time.Second
+
+// Idle mode: after -idle-timeout without incoming traffic periodic work
+// pauses and memory goes back to the OS. The SRT ingest listeners stay
+// bound; the next connection attempt (or an SRTLA registration) wakes
+// everything up again.
+var (
+	idle atomic.Bool
+
+	idleMu     sync.Mutex
+	lastActive time.Time
+)
+
+func powerIdle() bool {
+	return idle.Load()
+}
+
+// runIdleMonitor puts the instance to sleep once nothing came in for timeout.
+func runIdleMonitor(timeout time.Duration) {
+	idleMu.Lock()
+	lastActive = time.Now()
+	idleMu.Unlock()
+
+	ticker := time.NewTicker(IdleCheckPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		if powerIdle() {
+			continue
+		}
+		idleMu.Lock()
+		if ingestLive() {
+			lastActive = time.Now()
+		}
+		inactive := time.Since(lastActive)
+		idleMu.Unlock()
+		if inactive >= timeout {
+			enterIdle(inactive)
+		}
+	}
+}
+
+func enterIdle(inactive time.Duration) {
+	if !idle.CompareAndSwap(false, true) {
+		return
+	}
+	log.Printf("[idle] No traffic for %s, sleeping until the next connection attempt", inactive.Round(time.Second))
+	emitEvent("power.idle", map[string]any{"inactiveSeconds": int(inactive.Seconds())})
+
+	// Give the memory of the torn down sessions and buffers back to the OS
+	debug.FreeOSMemory()
+}
+
+// wakeUp leaves idle mode. It is cheap to call when not idle.
+func wakeUp(reason string) {
+	if !idle.CompareAndSwap(true, false) {
+		return
+	}
+	log.Printf("[idle] Waking up: %s", reason)
+	emitEvent("power.wake", map[string]any{"reason": reason})
+
+	idleMu.Lock()
+	lastActive = time.Now()
+	idleMu.Unlock()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// watchInterfaces has no event source outside Linux; interface changes are
+// picked up by the periodic rescan instead.
+func watchInterfaces() (<-chan struct{}, error) {
+	return nil, nil
+}
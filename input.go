@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+)
+
+// SRTChunkSize is how much MPEG-TS goes into one SRT packet: seven TS
+// packets, as encoders send it.
+const SRTChunkSize = 7 * TSPacketLen
+
+// openInput opens the local MPEG-TS source described by addr for the send
+// subcommand: udp://host:port listens for datagrams, "-" reads stdin and
+// file://path plays a file in real time.
+// Every Read returns at most one SRT packet worth of data.
+func openInput(addr string) (io.ReadCloser, error) {
+	if addr == "-" {
+		return chunkReader{os.Stdin}, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "udp":
+		return listenUDP(u.Host)
+	case "file":
+		return openFileInput(addr)
+	}
+	return nil, fmt.Errorf("unsupported input %q", addr)
+}
+
+// chunkReader cuts a byte stream into SRT packet sized chunks, so packets
+// always carry whole TS packets.
+type chunkReader struct {
+	io.ReadCloser
+}
+
+func (c chunkReader) Read(p []byte) (int, error) {
+	n, err := io.ReadFull(c.ReadCloser, p[:min(len(p), SRTChunkSize)])
+	if err == io.ErrUnexpectedEOF {
+		err = nil // hand out the tail, the next Read reports EOF
+	}
+	return n, err
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+const (
+	IntegrityBlock       = 1024 // sequence numbers per digest
+	IntegrityHistory     = 64   // completed digests kept per stream
+	IntegrityCheckPeriod = 5 * time.Second
+)
+
+// integrityCheck enables -integrity: the SRTLA receiver and the SRT proxy
+// both hash every data packet's payload, per block of sequence numbers,
+// and the proxy compares its digests with the receiver's to confirm the
+// stream arrives bit-exact.
+var integrityCheck bool
+
+type integrityDigest struct {
+	Block   uint32 `json:"block"` // sequence number / IntegrityBlock
+	Packets int    `json:"packets"`
+	Sum     uint64 `json:"sum,string"`
+}
+
+type integrityBlockState struct {
+	seen    [IntegrityBlock / 64]uint64
+	packets int
+	sum     uint64
+}
+
+// integrityTracker digests one stream. A block is complete once packets two
+// blocks further on arrive, which leaves room for reordering and
+// retransmissions; each sequence number is counted once.
+type integrityTracker struct {
+	mu        sync.Mutex
+	open      map[uint32]*integrityBlockState
+	highest   uint32
+	started   bool
+	done      []integrityDigest // the most recent IntegrityHistory, oldest first
+	encrypted bool              // payloads are encrypted, so the two ends can't be compared
+}
+
+func newIntegrityTracker() *integrityTracker {
+	return &integrityTracker{open: map[uint32]*integrityBlockState{}}
+}
+
+// packetHash is FNV-1a over the sequence number and the payload.
+func packetHash(sn uint32, payload []byte) uint64 {
+	h := uint64(14695981039346656037)
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], sn)
+	for _, c := range b {
+		h = (h ^ uint64(c)) * 1099511628211
+	}
+	for _, c := range payload {
+		h = (h ^ uint64(c)) * 1099511628211
+	}
+	return h
+}
+
+func (t *integrityTracker) add(sn uint32, payload []byte) {
+	block, idx := sn/IntegrityBlock, sn%IntegrityBlock
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case !t.started:
+		t.started, t.highest = true, block
+	case block < t.highest && t.highest-block > 1<<20:
+		// The sequence numbers wrapped around
+		t.closeBefore(t.highest + 2)
+		t.highest = block
+	case block+1 < t.highest:
+		return // this block is complete already
+	}
+
+	b := t.open[block]
+	if b == nil {
+		b = new(integrityBlockState)
+		t.open[block] = b
+	}
+	if b.seen[idx/64]&(1<<(idx%64)) != 0 {
+		return // retransmission or duplicate
+	}
+	b.seen[idx/64] |= 1 << (idx % 64)
+	b.packets++
+	b.sum += packetHash(sn, payload)
+
+	if block > t.highest {
+		t.highest = block
+		t.closeBefore(block - 1)
+	}
+}
+
+// closeBefore completes every open block before limit. Must be called with
+// t.mu held.
+func (t *integrityTracker) closeBefore(limit uint32) {
+	for n, b := range t.open {
+		if n >= limit {
+			continue
+		}
+		t.done = append(t.done, integrityDigest{Block: n, Packets: b.packets, Sum: b.sum})
+		delete(t.open, n)
+	}
+	if len(t.done) > IntegrityHistory {
+		t.done = append(t.done[:0], t.done[len(t.done)-IntegrityHistory:]...)
+	}
+}
+
+func (t *integrityTracker) setEncrypted() {
+	t.mu.Lock()
+	t.encrypted = true
+	t.mu.Unlock()
+}
+
+func (t *integrityTracker) digests() ([]integrityDigest, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]integrityDigest(nil), t.done...), t.encrypted
+}
+
+// addSRTPacket digests a data packet as the SRTLA receiver forwards it.
+func (t *integrityTracker) addSRTPacket(pkt []byte, sn int32) {
+	if len(pkt) < SRTMinLen {
+		return
+	}
+	if kk := binary.BigEndian.Uint32(pkt[4:]) >> 27 & 3; kk != 0 {
+		t.setEncrypted()
+		return
+	}
+	t.add(uint32(sn), pkt[SRTMinLen:])
+}
+
+// Server side: the digests of every group, served at /api/integrity.
+
+type groupIntegrity struct {
+	Group     string            `json:"group"`
+	Encrypted bool              `json:"encrypted"`
+	Digests   []integrityDigest `json:"digests"`
+}
+
+func collectIntegrity() []groupIntegrity {
+	out := []groupIntegrity{}
+	for _, g := range groupList() {
+		if g.integrity == nil {
+			continue
+		}
+		digests, encrypted := g.integrity.digests()
+		out = append(out, groupIntegrity{Group: fmt.Sprintf("%p", g), Encrypted: encrypted, Digests: digests})
+	}
+	return out
+}
+
+func handleIntegrity(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, collectIntegrity())
+}
+
+// Client side: the SRT proxy digests what it receives.
+
+// integrityLocal digests the stream currently received by the SRT proxy.
+var integrityLocal atomic.Pointer[integrityTracker]
+
+// integrityConn reads the SRT stream packet by packet so every payload can
+// be digested with its sequence number.
+type integrityConn struct {
+	srt.Conn
+	tracker *integrityTracker
+	pending []byte
+}
+
+func newIntegrityConn(c srt.Conn) *integrityConn {
+	t := newIntegrityTracker()
+	integrityLocal.Store(t)
+	return &integrityConn{Conn: c, tracker: t}
+}
+
+func (c *integrityConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+		c.tracker.add(pkt.Header().PacketSequenceNumber.Val(), pkt.Data())
+		c.pending = append(c.pending[:0], pkt.Data()...)
+		pkt.Decommission()
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+var _ srt.Conn = (*integrityConn)(nil)
+
+type integrityMessage struct {
+	Timestamp        time.Time `json:"timestamp"`
+	Type             string    `json:"type"` // "integrity"
+	BlocksOK         int       `json:"blocksOk"`
+	BlocksCorrupt    int       `json:"blocksCorrupt"`
+	BlocksIncomplete int       `json:"blocksIncomplete"` // packets lost between the hops
+	Encrypted        bool      `json:"encrypted,omitempty"`
+}
+
+func fetchServerIntegrity(client *http.Client, serverAPI string) ([]groupIntegrity, error) {
+	resp, err := client.Get(strings.TrimRight(serverAPI, "/") + "/api/integrity")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var groups []groupIntegrity
+	err = json.NewDecoder(resp.Body).Decode(&groups)
+	return groups, err
+}
+
+// runIntegrityCheck compares the proxy's digests with the SRTLA receiver's,
+// fetched from serverAPI or, with an empty serverAPI, from this process
+// (standalone mode). Results are logged, and published as integrity
+// messages. The stream's group is found by its block numbers, since the
+// sequence numbers of different streams are unrelated.
+func runIntegrityCheck(serverAPI string) {
+	client := &http.Client{Timeout: IntegrityCheckPeriod}
+	ticker := time.NewTicker(IntegrityCheckPeriod)
+	defer ticker.Stop()
+
+	compared := map[uint32]bool{}
+	var tracker *integrityTracker
+	msg := integrityMessage{Type: "integrity"}
+	for range ticker.C {
+		local := integrityLocal.Load()
+		if local == nil || powerIdle() {
+			continue
+		}
+		if local != tracker {
+			tracker = local
+			clear(compared)
+		}
+
+		var groups []groupIntegrity
+		if serverAPI == "" {
+			groups = collectIntegrity()
+		} else {
+			var err error
+			if groups, err = fetchServerIntegrity(client, serverAPI); err != nil {
+				log.Printf("[integrity] Fetching the server's digests from %s failed: %v", serverAPI, err)
+				continue
+			}
+		}
+
+		digests, _ := local.digests()
+		for _, d := range digests {
+			if compared[d.Block] {
+				continue
+			}
+			remote, encrypted, ok := findDigest(groups, d)
+			switch {
+			case !ok:
+				continue // not complete on the server yet
+			case encrypted:
+				msg.Encrypted = true
+			case remote.Sum == d.Sum && remote.Packets == d.Packets:
+				msg.BlocksOK++
+			case remote.Packets != d.Packets:
+				msg.BlocksIncomplete++
+			default:
+				msg.BlocksCorrupt++
+				log.Printf("[integrity] Block %d differs between server and client (%d packets, sums %x and %x)", d.Block, d.Packets, remote.Sum, d.Sum)
+				emitEvent("integrity.mismatch", map[string]any{"block": d.Block, "packets": d.Packets})
+			}
+			compared[d.Block] = true
+		}
+		for block := range compared {
+			if len(digests) > 0 && block+2*IntegrityHistory < digests[0].Block {
+				delete(compared, block)
+			}
+		}
+
+		msg.Timestamp = time.Now()
+		publishMessage(msg)
+	}
+}
+
+// findDigest returns the server's digest for d's block, preferring one that
+// matches.
+func findDigest(groups []groupIntegrity, d integrityDigest) (integrityDigest, bool, bool) {
+	var found integrityDigest
+	var encrypted, ok bool
+	for _, g := range groups {
+		if g.Encrypted {
+			encrypted = true
+			continue
+		}
+		for _, r := range g.Digests {
+			if r.Block != d.Block {
+				continue
+			}
+			if r.Sum == d.Sum {
+				return r, false, true
+			}
+			if !ok || abs(r.Packets-d.Packets) < abs(found.Packets-d.Packets) {
+				found, ok = r, true
+			}
+		}
+	}
+	if !ok && encrypted {
+		return found, true, true
+	}
+	return found, false, ok
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
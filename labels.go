@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Label keepalives carry the sender's name for a link ("Verizon",
+	// "Home WiFi") after the type field and this magic. Stock SRTLA
+	// receivers echo them like any other keepalive.
+	SRTLALabelMagic = "GLBL"
+	MaxLinkLabelLen = 64
+
+	BondLabelPeriod = 10 * time.Second // how often bond senders repeat a link's label
+)
+
+// linkLabel is a label assigned to the links from a subnet.
+type linkLabel struct {
+	Subnet netip.Prefix `json:"subnet"`
+	Label  string       `json:"label"`
+}
+
+// linkLabels are the labels assigned with the API, kept in -labels-file.
+type linkLabels struct {
+	mu     sync.RWMutex
+	path   string
+	labels []linkLabel
+}
+
+var assignedLabels = &linkLabels{}
+
+// loadLinkLabels reads the assigned labels from path, which may not exist
+// yet.
+func loadLinkLabels(path string) error {
+	assignedLabels.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var labels []linkLabel
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	assignedLabels.labels = labels
+	return nil
+}
+
+// lookup returns the label of the most specific subnet containing addr.
+func (l *linkLabels) lookup(addr netip.Addr) string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	best := -1
+	label := ""
+	for _, ll := range l.labels {
+		if ll.Subnet.Contains(addr.Unmap()) && ll.Subnet.Bits() > best {
+			best, label = ll.Subnet.Bits(), ll.Label
+		}
+	}
+	return label
+}
+
+func (l *linkLabels) list() []linkLabel {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]linkLabel{}, l.labels...)
+}
+
+// set assigns label to subnet, removing the assignment when label is
+// empty, and saves the labels.
+func (l *linkLabels) set(subnet netip.Prefix, label string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	labels := []linkLabel{}
+	for _, ll := range l.labels {
+		if ll.Subnet != subnet {
+			labels = append(labels, ll)
+		}
+	}
+	if label != "" {
+		labels = append(labels, linkLabel{Subnet: subnet, Label: label})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Subnet.String() < labels[j].Subnet.String() })
+	l.labels = labels
+	return l.save()
+}
+
+// save must be called with l.mu held.
+func (l *linkLabels) save() error {
+	if l.path == "" {
+		return nil
+	}
+	data, _ := json.MarshalIndent(l.labels, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(l.path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(l.path+".tmp", l.path)
+}
+
+// keepaliveLabel returns the label of a label keepalive.
+func keepaliveLabel(pkt []byte) (string, bool) {
+	n := 2 + len(SRTLALabelMagic)
+	if len(pkt) < n || getSRTType(pkt) != SRTLATypeKeepalive || string(pkt[2:n]) != SRTLALabelMagic {
+		return "", false
+	}
+	return cleanLabel(string(pkt[n:])), true
+}
+
+func labelKeepalive(label string) []byte {
+	pkt := binary.BigEndian.AppendUint16(nil, SRTLATypeKeepalive)
+	pkt = append(pkt, SRTLALabelMagic...)
+	return append(pkt, label...)
+}
+
+// cleanLabel drops control characters and cuts label to MaxLinkLabelLen
+// bytes.
+func cleanLabel(label string) string {
+	label = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, label)
+	label = strings.TrimSpace(label)
+	for len(label) > MaxLinkLabelLen {
+		_, size := utf8.DecodeLastRuneInString(label)
+		label = label[:len(label)-size]
+	}
+	return label
+}
+
+// label returns the name of c: the one assigned to its subnet, or else
+// the one its sender gave it. Must be called with the group's mu held.
+func (c *Conn) label() (string, string) {
+	if l := assignedLabels.lookup(c.addrPort.Addr()); l != "" {
+		return l, "assigned"
+	}
+	if c.senderLabel != "" {
+		return c.senderLabel, "sender"
+	}
+	return "", ""
+}
+
+// linkStatus is a link of an SRTLA group, for /api/links, the links
+// WebSocket message and /api/diagnostics.
+type linkStatus struct {
+	Group       string  `json:"group"`
+	Addr        string  `json:"addr"`
+	Label       string  `json:"label,omitempty"`
+	LabelSource string  `json:"labelSource,omitempty"` // "assigned" or "sender"
+	IdleSeconds float64 `json:"idleSeconds"`
+}
+
+// status must be called with the group's mu held.
+func (c *Conn) status(group string, now time.Time) linkStatus {
+	label, source := c.label()
+	return linkStatus{
+		Group:       group,
+		Addr:        c.addr.String(),
+		Label:       label,
+		LabelSource: source,
+		IdleSeconds: now.Sub(c.lastRcvd).Seconds(),
+	}
+}
+
+type linksMessage struct {
+	Timestamp time.Time    `json:"timestamp"`
+	Type      string       `json:"type"` // always "links"
+	Links     []linkStatus `json:"links"`
+}
+
+func collectLinks() []linkStatus {
+	now := clk.Now()
+	links := []linkStatus{}
+	for _, g := range groupList() {
+		g.mu.Lock()
+		for _, c := range g.conns {
+			links = append(links, c.status(fmt.Sprintf("%p", g), now))
+		}
+		g.mu.Unlock()
+	}
+	return links
+}
+
+// publishLinks tells the overlays about a new, removed or renamed link.
+func publishLinks() {
+	publishMessage(linksMessage{Timestamp: time.Now(), Type: "links", Links: collectLinks()})
+}
+
+func linksWelcome() []byte {
+	links := collectLinks()
+	if len(links) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(linksMessage{Timestamp: time.Now(), Type: "links", Links: links})
+	return data
+}
+
+func handleLinks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, collectLinks())
+}
+
+func handleLabels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, assignedLabels.list())
+}
+
+// handleLabelAssign assigns a label to a subnet or an address:
+// {"subnet": "100.64.0.0/10", "label": "Starlink"}, an empty label
+// removes the assignment.
+func handleLabelAssign(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Subnet string `json:"subnet"`
+		Label  string `json:"label"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefixes, err := parsePrefixes(req.Subnet)
+	if err != nil || len(prefixes) != 1 {
+		http.Error(w, "subnet must be an IP or a CIDR", http.StatusBadRequest)
+		return
+	}
+	label := cleanLabel(req.Label)
+	if err := assignedLabels.set(prefixes[0], label); err != nil {
+		log.Printf("[labels] Saving failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[labels] %s is now %q", prefixes[0], label)
+	publishLinks()
+	writeJSON(w, assignedLabels.list())
+}
+
+func handleLinksPage(w http.ResponseWriter, r *http.Request) {
+	localizePage(w, r, linksPageHTML)
+}
+
+// linksPageHTML lists the SRTLA links and assigns labels to their
+// subnets, refreshed from /api/links.
+const linksPageHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{links.title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; background: #111; color: #eee; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { padding: .4em .8em; text-align: left; border-bottom: 1px solid #333; }
+input, button { background: #222; color: #eee; border: 1px solid #555; padding: .3em .5em; }
+.source { color: #aaa; }
+</style>
+</head>
+<body>
+<h1>{{links.title}}</h1>
+<table>
+<thead><tr><th>{{links.group}}</th><th>{{links.address}}</th><th>{{links.label}}</th><th>{{links.idle}}</th></tr></thead>
+<tbody id="links"></tbody>
+</table>
+<h2>{{links.assigned}}</h2>
+<table>
+<thead><tr><th>{{links.subnet}}</th><th>{{links.label}}</th><th></th></tr></thead>
+<tbody id="labels"></tbody>
+</table>
+<form id="assign">
+<input id="subnet" placeholder="100.64.0.0/10" required>
+<input id="label" placeholder="Starlink" maxlength="64">
+<button>{{links.save}}</button>
+</form>
+<script>
+function esc(s) { return String(s).replace(/[&<>"]/g, c => ({"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"})[c]); }
+function host(addr) { return addr.replace(/^\[?(.*?)\]?:\d+$/, "$1"); }
+const sources = {assigned: {{js:links.source_assigned}}, sender: {{js:links.source_sender}}};
+const token = new URLSearchParams(location.search).get("token");
+async function assign(subnet, label) {
+  const headers = token ? {Authorization: "Bearer " + token} : {};
+  const res = await fetch("api/labels", {method: "PUT", headers, body: JSON.stringify({subnet, label})});
+  if (res.status === 401 || res.status === 403) {
+    alert({{js:links.not_authorized}});
+  }
+  refresh();
+}
+async function refresh() {
+  const links = await (await fetch("api/links")).json();
+  document.getElementById("links").innerHTML = links.map(l =>
+    "<tr><td>" + esc(l.group) + "</td><td><a href=\"#\" data-host=\"" + esc(host(l.addr)) + "\">" + esc(l.addr) + "</a></td>" +
+    "<td>" + esc(l.label || "") + (l.labelSource ? ' <span class="source">(' + esc(sources[l.labelSource]) + ")</span>" : "") + "</td>" +
+    "<td>" + l.idleSeconds.toFixed(1) + " s</td></tr>").join("");
+  const labels = await (await fetch("api/labels")).json();
+  document.getElementById("labels").innerHTML = labels.map(l =>
+    "<tr><td>" + esc(l.subnet) + "</td><td>" + esc(l.label) + "</td><td><button data-remove=\"" + esc(l.subnet) + "\">{{links.remove}}</button></td></tr>").join("");
+}
+document.addEventListener("click", e => {
+  if (e.target.dataset.host) {
+    e.preventDefault();
+    document.getElementById("subnet").value = e.target.dataset.host;
+    document.getElementById("label").focus();
+  } else if (e.target.dataset.remove) {
+    assign(e.target.dataset.remove, "");
+  }
+});
+document.getElementById("assign").addEventListener("submit", e => {
+  e.preventDefault();
+  assign(document.getElementById("subnet").value, document.getElementById("label").value);
+});
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	LoadgenPayloadSize = 1316 // 7 MPEG-TS packets, what IRL senders typically use
+	LoadgenTick        = 5 * time.Millisecond
+	LoadgenRegTimeout  = 1 * time.Second
+	LoadgenRegRetries  = 5
+)
+
+type loadgenCounters struct {
+	sentPkts  atomic.Int64
+	sentBytes atomic.Int64
+	ackedPkts atomic.Int64
+	sendErrs  atomic.Int64
+}
+
+// loadgenSender emulates one SRTLA sender (phone/encoder) bonding several
+// links into a single group.
+type loadgenSender struct {
+	server   *net.UDPAddr
+	links    []*net.UDPConn
+	groupID  []byte
+	counters *loadgenCounters
+}
+
+// runLoadgen implements the "loadgen" subcommand: it registers K SRTLA
+// senders with M links each against a running server and pushes synthetic
+// SRT data packets at the requested bitrate. Throughput and loss are derived
+// from the SRTLA ACKs returned by the server, i.e. they measure what the
+// server actually accepted for forwarding.
+func runLoadgen(args []string) {
+	fs := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	server := fs.String("server", "127.0.0.1:5000", "SRTLA server address (host:port)")
+	senders := fs.Int("senders", 1, "Number of simultaneous SRTLA senders")
+	links := fs.Int("links", 3, "Number of links per sender")
+	bitrate := fs.Int("bitrate", 6000, "Bitrate per sender in kbps")
+	duration := fs.Duration("duration", 30*time.Second, "Test duration")
+	fs.Parse(args)
+
+	if *senders <= 0 || *links <= 0 || *links > MaxConnsPerGroup || *bitrate <= 0 {
+		log.Fatalf("ERROR: loadgen requires -senders > 0, 1 <= -links <= %d and -bitrate > 0", MaxConnsPerGroup)
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", *server)
+	if err != nil {
+		log.Fatalf("ERROR: failed to resolve -server: %v", err)
+	}
+
+	log.Printf("[loadgen] %d senders x %d links at %d kbps against %s for %s",
+		*senders, *links, *bitrate, raddr, *duration)
+
+	counters := &loadgenCounters{}
+	var all []*loadgenSender
+	for i := 0; i < *senders; i++ {
+		s, err := newLoadgenSender(raddr, *links, counters)
+		if err != nil {
+			log.Printf("[loadgen] [sender %d] Registration failed: %v", i, err)
+			continue
+		}
+		all = append(all, s)
+	}
+	if len(all) == 0 {
+		log.Fatalf("ERROR: no sender could register with %s", raddr)
+	}
+	log.Printf("[loadgen] %d/%d senders registered", len(all), *senders)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, s := range all {
+		wg.Add(1)
+		go func(s *loadgenSender) {
+			defer wg.Done()
+			s.run(*bitrate*1000, stop)
+		}(s)
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(time.Second)
+	deadline := time.After(*duration)
+	var lastSent, lastAcked, lastBytes int64
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			sent, acked, bytes := counters.sentPkts.Load(), counters.ackedPkts.Load(), counters.sentBytes.Load()
+			log.Printf("[loadgen] sent %.2f Mbps (%d pkt/s)  acked %d pkt/s  loss %.2f%%",
+				float64(bytes-lastBytes)*8/1e6, sent-lastSent, acked-lastAcked, lossPercent(sent-lastSent, acked-lastAcked))
+			lastSent, lastAcked, lastBytes = sent, acked, bytes
+		case <-deadline:
+			break loop
+		}
+	}
+	ticker.Stop()
+	close(stop)
+	wg.Wait()
+
+	// Give in-flight ACKs a moment to arrive before closing the sockets
+	time.Sleep(500 * time.Millisecond)
+	for _, s := range all {
+		s.close()
+	}
+
+	elapsed := time.Since(start).Seconds()
+	sent, acked := counters.sentPkts.Load(), counters.ackedPkts.Load()
+	fmt.Printf("\nSenders:     %d x %d links\n", len(all), *links)
+	fmt.Printf("Sent:        %d packets, %.2f Mbps\n", sent, float64(counters.sentBytes.Load())*8/1e6/elapsed)
+	fmt.Printf("Acked:       %d packets, %.2f Mbps\n", acked, float64(acked)*float64(SRTMinLen+LoadgenPayloadSize)*8/1e6/elapsed)
+	fmt.Printf("Loss:        %.2f%%\n", lossPercent(sent, acked))
+	fmt.Printf("Send errors: %d\n", counters.sendErrs.Load())
+}
+
+func lossPercent(sent, acked int64) float64 {
+	if sent <= 0 || acked >= sent {
+		return 0
+	}
+	return float64(sent-acked) * 100 / float64(sent)
+}
+
+func newLoadgenSender(server *net.UDPAddr, links int, counters *loadgenCounters) (*loadgenSender, error) {
+	s := &loadgenSender{server: server, counters: counters}
+	for i := 0; i < links; i++ {
+		conn, err := net.DialUDP("udp", nil, server)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.links = append(s.links, conn)
+	}
+
+	// REG1 from the first link announces the sender-chosen half of the ID
+	reg1 := make([]byte, SRTLAReg1Len)
+	binary.BigEndian.PutUint16(reg1[:2], SRTLATypeReg1)
+	copy(reg1[2:2+SRTLAIDLen/2], randomBytes(SRTLAIDLen/2))
+	resp, err := loadgenExchange(s.links[0], reg1, SRTLATypeReg2)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("REG1: %w", err)
+	}
+	s.groupID = append([]byte(nil), resp[2:2+SRTLAIDLen]...)
+
+	// REG2 on every link joins it to the group
+	reg2 := make([]byte, SRTLAReg2Len)
+	binary.BigEndian.PutUint16(reg2[:2], SRTLATypeReg2)
+	copy(reg2[2:], s.groupID)
+	for i, conn := range s.links {
+		if _, err := loadgenExchange(conn, reg2, SRTLATypeReg3); err != nil {
+			s.close()
+			return nil, fmt.Errorf("REG2 on link %d: %w", i, err)
+		}
+	}
+
+	for _, conn := range s.links {
+		conn.SetReadDeadline(time.Time{})
+		go s.readAcks(conn)
+	}
+	return s, nil
+}
+
+// loadgenExchange sends req and waits for a reply of type want, retrying a
+// few times since registration packets can get lost like any other.
+func loadgenExchange(conn *net.UDPConn, req []byte, want uint16) ([]byte, error) {
+	buf := make([]byte, MTU)
+	for i := 0; i < LoadgenRegRetries; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(LoadgenRegTimeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break // timed out, resend
+			}
+			switch getSRTType(buf[:n]) {
+			case want:
+				if want == SRTLATypeReg2 && n != SRTLAReg2Len {
+					continue
+				}
+				return append([]byte(nil), buf[:n]...), nil
+			case SRTLATypeRegErr:
+				return nil, fmt.Errorf("server replied REG_ERR")
+			case SRTLATypeRegNGP:
+				return nil, fmt.Errorf("server replied REG_NGP")
+			}
+		}
+	}
+	return nil, fmt.Errorf("no reply after %d attempts", LoadgenRegRetries)
+}
+
+func (s *loadgenSender) readAcks(conn *net.UDPConn) {
+	buf := make([]byte, MTU)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		pkt := buf[:n]
+		switch getSRTType(pkt) {
+		case SRTLATypeACK:
+			if n >= 4 {
+				s.counters.ackedPkts.Add(int64((n - 4) / 4))
+			}
+		case SRTLATypeKeepalive:
+			conn.Write(pkt)
+		}
+	}
+}
+
+// run paces synthetic SRT data packets round-robin over the links until
+// stop is closed.
+func (s *loadgenSender) run(bps int, stop <-chan struct{}) {
+	pkt := make([]byte, SRTMinLen+LoadgenPayloadSize)
+	pktBits := float64(len(pkt) * 8)
+	perTick := float64(bps) * LoadgenTick.Seconds() / pktBits
+
+	ticker := time.NewTicker(LoadgenTick)
+	defer ticker.Stop()
+
+	var seq uint32
+	var budget float64
+	link := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		budget += perTick
+		for ; budget >= 1; budget-- {
+			binary.BigEndian.PutUint32(pkt[0:4], seq&0x7fffffff) // data packet: bit 31 clear
+			seq++
+			if _, err := s.links[link].Write(pkt); err != nil {
+				s.counters.sendErrs.Add(1)
+			} else {
+				s.counters.sentPkts.Add(1)
+				s.counters.sentBytes.Add(int64(len(pkt)))
+			}
+			link = (link + 1) % len(s.links)
+		}
+	}
+}
+
+func (s *loadgenSender) close() {
+	for _, conn := range s.links {
+		conn.Close()
+	}
+}
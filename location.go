@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	LocationTrackLen    = 512             // points kept for the map widget
+	LocationMinInterval = 1 * time.Second // faster updates are dropped
+)
+
+// locationPoint is one GPS fix, as posted by a companion app.
+type locationPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Lat       float64   `json:"lat"`
+	Lon       float64   `json:"lon"`
+	AccuracyM *float64  `json:"accuracyM,omitempty"`
+	AltitudeM *float64  `json:"altitudeM,omitempty"`
+	SpeedMps  *float64  `json:"speedMps,omitempty"`
+	Heading   *float64  `json:"heading,omitempty"` // degrees clockwise from north
+}
+
+// locationMessage is published for every accepted fix. While the location
+// is hidden a single message with Hidden set and no point is published
+// instead, so overlays can take the map down.
+type locationMessage struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Type      string         `json:"type"` // always "location"
+	Hidden    bool           `json:"hidden"`
+	Point     *locationPoint `json:"point,omitempty"`
+}
+
+// locationTrack is served at /api/location and sent to WebSocket clients
+// when they connect, so a map widget can draw the recent route.
+type locationTrack struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Type      string          `json:"type"` // always "location_track"
+	Hidden    bool            `json:"hidden"`
+	Points    []locationPoint `json:"points"`
+}
+
+var location = struct {
+	mu       sync.Mutex
+	track    []locationPoint
+	accepted time.Time
+	hidden   bool
+}{}
+
+var (
+	errLocationRate   = errors.New("location updates are limited to one per second")
+	errLocationHidden = errors.New("location is hidden")
+	errLocationRange  = errors.New("lat must be within ±90 and lon within ±180")
+)
+
+// updateLocation records a fix and publishes it. Fixes are dropped while
+// the location is hidden so nothing from that time can leak later.
+func updateLocation(p locationPoint) error {
+	if p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180 {
+		return errLocationRange
+	}
+	now := time.Now()
+	if p.Timestamp.IsZero() {
+		p.Timestamp = now
+	}
+
+	location.mu.Lock()
+	if location.hidden {
+		location.mu.Unlock()
+		return errLocationHidden
+	}
+	if now.Sub(location.accepted) < LocationMinInterval {
+		location.mu.Unlock()
+		return errLocationRate
+	}
+	location.accepted = now
+	if len(location.track) == LocationTrackLen {
+		copy(location.track, location.track[1:])
+		location.track = location.track[:LocationTrackLen-1]
+	}
+	location.track = append(location.track, p)
+	location.mu.Unlock()
+
+	publishMessage(locationMessage{Timestamp: now, Type: "location", Point: &p})
+	return nil
+}
+
+// setLocationHidden toggles privacy mode. Hiding also forgets the track.
+func setLocationHidden(hidden bool) {
+	location.mu.Lock()
+	changed := location.hidden != hidden
+	location.hidden = hidden
+	if hidden {
+		location.track = nil
+	}
+	location.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	if hidden {
+		log.Printf("[location] Hidden, track cleared")
+	} else {
+		log.Printf("[location] Visible again")
+	}
+	emitEvent("location.privacy", map[string]any{"hidden": hidden})
+	publishMessage(locationMessage{Timestamp: time.Now(), Type: "location", Hidden: hidden})
+}
+
+func locationSnapshot() locationTrack {
+	location.mu.Lock()
+	defer location.mu.Unlock()
+	points := make([]locationPoint, len(location.track))
+	copy(points, location.track)
+	return locationTrack{Timestamp: time.Now(), Type: "location_track", Hidden: location.hidden, Points: points}
+}
+
+// locationWelcome returns the track for a newly connected WebSocket client,
+// or nil if there is nothing to show.
+func locationWelcome() []byte {
+	snap := locationSnapshot()
+	if len(snap.Points) == 0 && !snap.Hidden {
+		return nil
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// handleLocation serves /api/location: GET returns the track, POST adds a
+// fix.
+func handleLocation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, locationSnapshot())
+	case http.MethodPost:
+		var p locationPoint
+		r.Body = http.MaxBytesReader(w, r.Body, MetadataMaxBytes)
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch err := updateLocation(p); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case errLocationRate:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case errLocationHidden:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleLocationPrivacy serves PUT /api/location/privacy {"hidden": true}.
+func handleLocationPrivacy(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Hidden *bool `json:"hidden"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Hidden == nil {
+		http.Error(w, `expected {"hidden": true|false}`, http.StatusBadRequest)
+		return
+	}
+	setLocationHidden(*req.Hidden)
+	writeJSON(w, locationSnapshot())
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	LogShipQueue   = 4096 // lines waiting per target; more are dropped and counted
+	LogShipBatch   = 256  // lines per HTTP request
+	LogShipFlush   = 2 * time.Second
+	LogShipRetry   = 5 * time.Second
+	LogShipTimeout = 10 * time.Second
+	LogShipExit    = 3 * time.Second // longest go-irl waits for the log to go out when it exits
+
+	SyslogFacilityDaemon = 3
+)
+
+// logEntry is a log line split into the parts go-irl's log lines have:
+// "2026/01/02 15:04:05 [tag] message".
+type logEntry struct {
+	Time    time.Time `json:"timestamp"`
+	Host    string    `json:"host"`
+	Level   string    `json:"level"` // error, warning or info
+	Tag     string    `json:"tag,omitempty"`
+	Message string    `json:"message"`
+}
+
+var (
+	logDatePrefix = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(\.\d+)? `)
+	logTag        = regexp.MustCompile(`^\[([A-Za-z][A-Za-z0-9 _-]*)\] `)
+	logHostname   = func() string { h, _ := os.Hostname(); return h }()
+)
+
+func parseLogLine(line string, now time.Time) logEntry {
+	msg := logDatePrefix.ReplaceAllString(strings.TrimRight(line, "\n"), "")
+	e := logEntry{Time: now, Host: logHostname, Level: "info", Message: msg}
+	if m := logTag.FindStringSubmatch(msg); m != nil {
+		e.Tag, e.Message = m[1], msg[len(m[0]):]
+	}
+	switch {
+	case strings.Contains(e.Message, "ERROR") || strings.HasPrefix(e.Message, "Failed") || strings.Contains(e.Message, " failed"):
+		e.Level = "error"
+	case strings.HasPrefix(strings.ToLower(e.Message), "warning"):
+		e.Level = "warning"
+	}
+	return e
+}
+
+// logShipper sends the log to a remote target from its own goroutine. A
+// target that is slow or down never holds up logging: lines it can't take
+// are dropped and counted.
+type logShipper struct {
+	name    string // the target, for its own log lines
+	queue   chan logEntry
+	dropped atomic.Uint64
+	ship    func(batch []logEntry) error
+	down    bool
+	pending atomic.Int64  // lines queued but not sent yet
+	flush   chan struct{} // closed on exit, to send without waiting for LogShipFlush
+}
+
+var logShippers []*logShipper
+
+func (s *logShipper) enqueue(e logEntry) {
+	select {
+	case s.queue <- e:
+		s.pending.Add(1)
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *logShipper) run() {
+	var batch []logEntry
+	for {
+		if len(batch) == 0 {
+			batch = append(batch, <-s.queue)
+		}
+		timer := time.NewTimer(LogShipFlush)
+	collect:
+		for len(batch) < LogShipBatch {
+			select {
+			case e := <-s.queue:
+				batch = append(batch, e)
+			case <-timer.C:
+				break collect
+			case <-s.flush:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		if err := s.ship(batch); err != nil {
+			// Logged once per outage; the line is queued for this target
+			// too, and sent once it is back
+			if !s.down {
+				s.down = true
+				log.Printf("[logship] Sending the log to %s failed, retrying every %v: %v", s.name, LogShipRetry, err)
+			}
+			time.Sleep(LogShipRetry)
+			continue
+		}
+		s.pending.Add(-int64(len(batch)))
+		batch = batch[:0]
+		if s.down {
+			s.down = false
+			log.Printf("[logship] Sending the log to %s again, %d lines were dropped meanwhile", s.name, s.dropped.Swap(0))
+		}
+	}
+}
+
+// logTee writes the log to stderr and hands a copy of every line to the
+// shippers. log calls Write once per line and never concurrently.
+type logTee struct {
+	out      io.Writer
+	shippers []*logShipper
+}
+
+func (t *logTee) Write(p []byte) (int, error) {
+	e := parseLogLine(string(p), time.Now())
+	for _, s := range t.shippers {
+		s.enqueue(e)
+	}
+	return t.out.Write(p)
+}
+
+// startLogShipping sends the log to the syslog server at syslogURL
+// (udp://, tcp:// or tls://host:port) and as JSON lines to httpURL. Either
+// may be empty.
+func startLogShipping(syslogURL, httpURL, httpToken string) error {
+	tee := &logTee{out: os.Stderr}
+	if syslogURL != "" {
+		ship, err := newSyslogShipper(syslogURL)
+		if err != nil {
+			return fmt.Errorf("-syslog: %w", err)
+		}
+		u, _ := url.Parse(syslogURL)
+		tee.shippers = append(tee.shippers, &logShipper{name: u.Redacted(), ship: ship})
+	}
+	if httpURL != "" {
+		ship, err := newHTTPLogShipper(httpURL, httpToken)
+		if err != nil {
+			return fmt.Errorf("-log-http: %w", err)
+		}
+		u, _ := url.Parse(httpURL)
+		tee.shippers = append(tee.shippers, &logShipper{name: u.Redacted(), ship: ship})
+	}
+	if len(tee.shippers) == 0 {
+		return nil
+	}
+	log.SetOutput(tee)
+	for _, s := range tee.shippers {
+		s.queue = make(chan logEntry, LogShipQueue)
+		s.flush = make(chan struct{})
+		go supervise("logship", s.run)
+		log.Printf("[logship] Sending the log to %s", s.name)
+	}
+	logShippers = tee.shippers
+	return nil
+}
+
+// flushLogShipping sends what is still queued before go-irl exits, for at
+// most LogShipExit.
+func flushLogShipping() {
+	deadline := time.Now().Add(LogShipExit)
+	for _, s := range logShippers {
+		close(s.flush)
+		for s.pending.Load() > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// newSyslogShipper formats entries as RFC 5424 messages. Over UDP each is
+// a datagram, over TCP and TLS they are framed by octet counting (RFC 5425,
+// RFC 6587).
+func newSyslogShipper(rawURL string) (func([]logEntry) error, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "udp", "tcp":
+		if port == "" {
+			port = "514"
+		}
+	case "tls":
+		if port == "" {
+			port = "6514"
+		}
+	default:
+		return nil, fmt.Errorf("expected udp://, tcp:// or tls://host[:port], got %q", rawURL)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host in %q", rawURL)
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	pid := os.Getpid()
+
+	var conn net.Conn
+	return func(batch []logEntry) error {
+		if conn == nil {
+			dialer := &net.Dialer{Timeout: LogShipTimeout}
+			var err error
+			switch u.Scheme {
+			case "tls":
+				conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: u.Hostname()})
+			default:
+				conn, err = dialer.Dial(u.Scheme, addr)
+			}
+			if err != nil {
+				conn = nil
+				return err
+			}
+		}
+		conn.SetWriteDeadline(time.Now().Add(LogShipTimeout))
+		var buf bytes.Buffer
+		for _, e := range batch {
+			msg := syslogMessage(e, pid)
+			if u.Scheme == "udp" {
+				if _, err := conn.Write([]byte(msg)); err != nil {
+					conn.Close()
+					conn = nil
+					return err
+				}
+				continue
+			}
+			fmt.Fprintf(&buf, "%d %s", len(msg), msg)
+		}
+		if buf.Len() > 0 {
+			if _, err := conn.Write(buf.Bytes()); err != nil {
+				conn.Close()
+				conn = nil
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// syslogMessage formats e as an RFC 5424 message with the tag as MSGID.
+func syslogMessage(e logEntry, pid int) string {
+	severity := 6 // informational
+	switch e.Level {
+	case "error":
+		severity = 3
+	case "warning":
+		severity = 4
+	}
+	msgID := "-"
+	if e.Tag != "" {
+		msgID = strings.ReplaceAll(e.Tag, " ", "_")
+	}
+	host := e.Host
+	if host == "" {
+		host = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s go-irl %d %s - %s", SyslogFacilityDaemon*8+severity, e.Time.UTC().Format(time.RFC3339Nano), host, pid, msgID, e.Message)
+}
+
+// newHTTPLogShipper POSTs entries as newline-delimited JSON, which log
+// collectors such as Vector, Fluent Bit or Logstash take with their HTTP
+// inputs.
+func newHTTPLogShipper(rawURL, token string) (func([]logEntry) error, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("expected an http:// or https:// URL, got %q", rawURL)
+	}
+	client := &http.Client{Timeout: LogShipTimeout}
+	return func(batch []logEntry) error {
+		var body bytes.Buffer
+		enc := json.NewEncoder(&body)
+		for _, e := range batch {
+			enc.Encode(e)
+		}
+		req, err := http.NewRequest(http.MethodPost, rawURL, &body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s", resp.Status)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard) // the packet path logs every registration
+	}
+	os.Exit(m.Run())
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MediaMTX defaults, as in its stock mediamtx.yml.
+const (
+	MediaMTXSRTAddr      = "127.0.0.1:8890"
+	MediaMTXAPIURL       = "http://127.0.0.1:9997"
+	MediaMTXDefaultPath  = "irl"
+	MediaMTXHealthPeriod = 10 * time.Second
+	MediaMTXTimeout      = 5 * time.Second
+)
+
+var mediaMTXPathRe = regexp.MustCompile(`^[A-Za-z0-9_.~/-]+$`)
+
+// mediaMTX feeds a MediaMTX instance, see -preset mediamtx: the stream is
+// published to Path over SRT, and the path's state is read from the
+// control API.
+type mediaMTX struct {
+	SRT  string // host:port of its SRT listener
+	API  string // base URL of its control API
+	Path string
+	User string // for the streamid and the API, optional
+	Pass string
+
+	client *http.Client
+
+	mu     sync.Mutex
+	status mediaMTXStatus
+}
+
+// mediaMTXStatus is the path's state, served at /api/mediamtx.
+type mediaMTXStatus struct {
+	Path          string    `json:"path"`
+	Output        string    `json:"output"`
+	Checked       time.Time `json:"checked"`
+	Reachable     bool      `json:"reachable"` // the API answered
+	Ready         bool      `json:"ready"`     // the path has a publisher with tracks
+	Tracks        []string  `json:"tracks"`
+	BytesReceived uint64    `json:"bytesReceived"`
+	Readers       int       `json:"readers"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// mediaMTXPreset is set by -preset mediamtx.
+var mediaMTXPreset *mediaMTX
+
+func newMediaMTX(srtAddr, api, path, user, pass string) (*mediaMTX, error) {
+	path = strings.Trim(path, "/")
+	if !mediaMTXPathRe.MatchString(path) {
+		return nil, fmt.Errorf("invalid MediaMTX path %q", path)
+	}
+	if (user == "") != (pass == "") {
+		return nil, fmt.Errorf("-mediamtx-user and -mediamtx-pass go together")
+	}
+	if _, err := url.Parse(api); err != nil {
+		return nil, fmt.Errorf("invalid -mediamtx-api: %w", err)
+	}
+	m := &mediaMTX{SRT: srtAddr, API: strings.TrimSuffix(api, "/"), Path: path, User: user, Pass: pass, client: &http.Client{Timeout: MediaMTXTimeout}}
+	m.status = mediaMTXStatus{Path: path, Output: redactURL(m.outputURL()), Tracks: []string{}}
+	return m, nil
+}
+
+// outputURL is the -srt-output for the path. MediaMTX takes the streamid
+// publish:<path>[:<user>:<pass>]; the path needs no escaping.
+func (m *mediaMTX) outputURL() string {
+	sid := "publish:" + m.Path
+	if m.User != "" {
+		sid += ":" + url.QueryEscape(m.User) + ":" + url.QueryEscape(m.Pass)
+	}
+	return "srt://" + m.SRT + "?streamid=" + sid
+}
+
+func (m *mediaMTX) request(method, path string, body any) (*http.Response, error) {
+	var rd *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		rd = bytes.NewReader(data)
+	} else {
+		rd = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, m.API+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if m.User != "" {
+		req.SetBasicAuth(m.User, m.Pass)
+	}
+	return m.client.Do(req)
+}
+
+// ensurePath creates the path in MediaMTX's configuration unless it is
+// there, for setups without the catch-all all_others path.
+func (m *mediaMTX) ensurePath() error {
+	resp, err := m.request(http.MethodGet, "/v3/config/paths/get/"+m.Path, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+	default:
+		return fmt.Errorf("reading the path configuration: %s", resp.Status)
+	}
+	resp, err = m.request(http.MethodPost, "/v3/config/paths/add/"+m.Path, map[string]any{"source": "publisher"})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("adding the path: %s", resp.Status)
+	}
+	log.Printf("[mediamtx] Created path %s", m.Path)
+	return nil
+}
+
+// check reads the path's state and emits mediamtx.ready and
+// mediamtx.not_ready when it changes.
+func (m *mediaMTX) check() {
+	st := mediaMTXStatus{Path: m.Path, Output: redactURL(m.outputURL()), Checked: time.Now(), Tracks: []string{}}
+	resp, err := m.request(http.MethodGet, "/v3/paths/get/"+m.Path, nil)
+	if err != nil {
+		st.Error = err.Error()
+	} else {
+		st.Reachable = true
+		var info struct {
+			Ready         bool     `json:"ready"`
+			Tracks        []string `json:"tracks"`
+			BytesReceived uint64   `json:"bytesReceived"`
+			Readers       []any    `json:"readers"`
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+				st.Error = "invalid API response: " + err.Error()
+			}
+		case http.StatusNotFound:
+			st.Error = "no publisher on the path"
+		default:
+			st.Error = "API: " + resp.Status
+		}
+		resp.Body.Close()
+		st.Ready = info.Ready
+		st.BytesReceived = info.BytesReceived
+		st.Readers = len(info.Readers)
+		if info.Tracks != nil {
+			st.Tracks = info.Tracks
+		}
+	}
+
+	m.mu.Lock()
+	was := m.status
+	m.status = st
+	m.mu.Unlock()
+	if st.Ready && !was.Ready {
+		log.Printf("[mediamtx] Path %s is ready (%s)", m.Path, strings.Join(st.Tracks, ", "))
+		emitEvent("mediamtx.ready", map[string]any{"path": m.Path, "tracks": st.Tracks})
+	} else if !st.Ready && (was.Ready || was.Checked.IsZero()) {
+		log.Printf("[mediamtx] Path %s is not ready: %s", m.Path, st.Error)
+		emitEvent("mediamtx.not_ready", map[string]any{"path": m.Path, "error": st.Error})
+	}
+}
+
+func (m *mediaMTX) run() {
+	if m.User != "" {
+		if err := m.ensurePath(); err != nil {
+			log.Printf("[mediamtx] Could not make sure path %s exists: %v", m.Path, err)
+		}
+	}
+	ticker := time.NewTicker(MediaMTXHealthPeriod)
+	defer ticker.Stop()
+	for {
+		m.check()
+		<-ticker.C
+	}
+}
+
+// enableMediaMTXPreset publishes the stream to m and watches its path.
+func enableMediaMTXPreset(m *mediaMTX) {
+	mediaMTXPreset = m
+	srtOutputFlags = append(srtOutputFlags, m.outputURL())
+	log.Printf("[mediamtx] Publishing to srt://%s, path %s; players use rtsp://<host>:8554/%s or srt://<host>:8890?streamid=read:%s", m.SRT, m.Path, m.Path, m.Path)
+	go supervise("mediamtx", m.run)
+}
+
+func handleMediaMTX(w http.ResponseWriter, r *http.Request) {
+	if mediaMTXPreset == nil {
+		http.Error(w, "the mediamtx preset is not enabled, see -preset", http.StatusNotFound)
+		return
+	}
+	mediaMTXPreset.mu.Lock()
+	st := mediaMTXPreset.status
+	mediaMTXPreset.mu.Unlock()
+	writeJSON(w, st)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"unsafe"
+)
+
+// maxMemory caps the memory all groups together may hold in flight, in
+// bytes. Zero means no cap.
+var maxMemory int64
+
+// groupFixedBytes approximates what every group holds regardless of
+// traffic: the group itself, its work queue, the worker's batch, the SRT
+// reader's buffer and, if enabled, the GSO send buffer, dedup window
+// and reorder buffer.
+func groupFixedBytes() int64 {
+	n := int64(unsafe.Sizeof(Group{})) +
+		GroupWorkQueueLen*int64(unsafe.Sizeof((*packetBuf)(nil))) +
+		SRTLAReadBatch*int64(unsafe.Sizeof([]byte(nil))+unsafe.Sizeof((*packetBuf)(nil))) +
+		MTU
+	if udpOffload {
+		n += gsoBufBytes
+	}
+	if dedupPackets {
+		n += int64(unsafe.Sizeof(dedupWindow{}))
+	}
+	if reorderDelay > 0 {
+		n += int64(unsafe.Sizeof(reorderBuffer{}))
+	}
+	return n
+}
+
+// groupMemory is an estimate of the memory a group holds.
+type groupMemory struct {
+	Fixed  int64 `json:"fixed"`
+	Conns  int64 `json:"conns"`
+	Queued int64 `json:"queued"` // packets waiting for the worker or in the reorder buffer
+	Kernel int64 `json:"kernel"` // the SRT socket's send and receive queues
+	Total  int64 `json:"total"`
+}
+
+func (g *Group) memory() groupMemory {
+	g.mu.Lock()
+	conns := len(g.conns)
+	sock := g.srtSock
+	g.mu.Unlock()
+
+	m := groupMemory{
+		Fixed:  groupFixedBytes(),
+		Conns:  int64(conns) * int64(unsafe.Sizeof(Conn{})),
+		Queued: (int64(len(g.work)) + g.reorderHeld()) * int64(unsafe.Sizeof(packetBuf{})),
+	}
+	if sock != nil {
+		m.Kernel = socketQueuedBytes(sock)
+	}
+	m.Total = m.Fixed + m.Conns + m.Queued + m.Kernel
+	return m
+}
+
+// memoryAdmits reports whether a new group fits under -max-memory.
+func memoryAdmits() bool {
+	if maxMemory <= 0 {
+		return true
+	}
+	var total int64
+	for _, g := range groupList() {
+		total += g.memory().Total
+	}
+	return total+groupFixedBytes() <= maxMemory
+}
+
+// enforceMemoryCap sheds the most recently registered groups until the
+// remaining ones fit under -max-memory. Older groups are kept because they
+// are the streams that are most likely live.
+func enforceMemoryCap() {
+	if maxMemory <= 0 {
+		return
+	}
+	groups := groupList()
+	usage := make(map[*Group]int64, len(groups))
+	var total int64
+	for _, g := range groups {
+		usage[g] = g.memory().Total
+		total += usage[g]
+	}
+	if total <= maxMemory {
+		return
+	}
+
+	newest := append([]*Group(nil), groups...)
+	sort.Slice(newest, func(i, j int) bool { return newest[i].createdAt.After(newest[j].createdAt) })
+	for _, g := range newest {
+		if total <= maxMemory {
+			break
+		}
+		log.Printf("[group %p] Removed (memory cap of %d MB exceeded, %d MB in use)", g, maxMemory>>20, total>>20)
+		emitEvent("group.removed", map[string]any{
+			"group":  fmt.Sprintf("%p", g),
+			"reason": "memory cap",
+			"bytes":  usage[g],
+		})
+		removeGroup(g)
+		total -= usage[g]
+	}
+}
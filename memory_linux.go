@@ -0,0 +1,32 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketQueuedBytes returns how much memory the kernel has charged to the
+// socket for queued datagrams, in both directions (SO_MEMINFO).
+func socketQueuedBytes(conn srtConn) int64 {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var info [unix.SK_MEMINFO_VARS]uint32
+	var errno syscall.Errno
+	rc.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(info))
+		_, _, errno = unix.Syscall6(unix.SYS_GETSOCKOPT, fd, unix.SOL_SOCKET, unix.SO_MEMINFO,
+			uintptr(unsafe.Pointer(&info[0])), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if errno != 0 {
+		return 0
+	}
+	return int64(info[unix.SK_MEMINFO_RMEM_ALLOC]) + int64(info[unix.SK_MEMINFO_WMEM_ALLOC])
+}
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+// socketQueuedBytes is not available here; only userspace memory is counted.
+func socketQueuedBytes(conn srtConn) int64 { return 0 }
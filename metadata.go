@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MetadataMaxKeys  = 64
+	MetadataMaxBytes = 64 << 10 // per request body
+)
+
+// metadataMessage carries the free-form stream metadata (location, segment
+// title, sponsor message, ...) to the browser source. It is sent whenever
+// the metadata changes and to every WebSocket client when it connects.
+type metadataMessage struct {
+	Timestamp time.Time                  `json:"timestamp"`
+	Type      string                     `json:"type"` // always "metadata"
+	Fields    map[string]json.RawMessage `json:"fields"`
+}
+
+var metadata = struct {
+	mu      sync.Mutex
+	fields  map[string]json.RawMessage
+	updated time.Time
+}{fields: map[string]json.RawMessage{}}
+
+func metadataSnapshot() metadataMessage {
+	metadata.mu.Lock()
+	defer metadata.mu.Unlock()
+	fields := make(map[string]json.RawMessage, len(metadata.fields))
+	for k, v := range metadata.fields {
+		fields[k] = v
+	}
+	return metadataMessage{Timestamp: metadata.updated, Type: "metadata", Fields: fields}
+}
+
+// metadataWelcome returns the current metadata for a newly connected
+// WebSocket client, or nil if none was set.
+func metadataWelcome() []byte {
+	msg := metadataSnapshot()
+	if len(msg.Fields) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// updateMetadata applies fields to the metadata, replacing all of it if
+// replace is set. A null value removes its key.
+func updateMetadata(fields map[string]json.RawMessage, replace bool) (metadataMessage, bool) {
+	metadata.mu.Lock()
+	next := map[string]json.RawMessage{}
+	if !replace {
+		for k, v := range metadata.fields {
+			next[k] = v
+		}
+	}
+	for k, v := range fields {
+		if string(v) == "null" {
+			delete(next, k)
+		} else {
+			next[k] = v
+		}
+	}
+	if len(next) > MetadataMaxKeys {
+		metadata.mu.Unlock()
+		return metadataMessage{}, false
+	}
+	metadata.fields = next
+	metadata.updated = time.Now()
+	metadata.mu.Unlock()
+
+	msg := metadataSnapshot()
+	publishMessage(msg)
+	return msg, true
+}
+
+// handleMetadata serves /api/metadata. GET returns the metadata, PATCH
+// merges a JSON object into it, PUT replaces it and DELETE clears it.
+func handleMetadata(w http.ResponseWriter, r *http.Request) {
+	var fields map[string]json.RawMessage
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, metadataSnapshot())
+		return
+	case http.MethodPatch, http.MethodPut:
+		r.Body = http.MaxBytesReader(w, r.Body, MetadataMaxBytes)
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil || fields == nil {
+			http.Error(w, "expected a JSON object", http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "GET, PATCH, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	msg, ok := updateMetadata(fields, r.Method != http.MethodPatch)
+	if !ok {
+		http.Error(w, "too many metadata keys", http.StatusRequestEntityTooLarge)
+		return
+	}
+	keys := make([]string, 0, len(msg.Fields))
+	for k := range msg.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	log.Printf("[metadata] Updated from %s, keys: %v", clientIP(r), keys)
+	writeJSON(w, msg)
+}
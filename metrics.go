@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsMaxWindow is the period the "max" forwarding stats cover.
+const StatsMaxWindow = 10 * time.Second
+
+// windowMax tracks the maximum of a value over the last StatsMaxWindow to
+// 2*StatsMaxWindow. It has a single writer; readers may be anywhere.
+type windowMax struct {
+	start     atomic.Int64 // unix nanoseconds the current window began
+	cur, prev atomic.Int64
+}
+
+func (m *windowMax) observe(v int64, now time.Time) {
+	t := now.UnixNano()
+	if age := t - m.start.Load(); age >= int64(StatsMaxWindow) {
+		if age < 2*int64(StatsMaxWindow) {
+			m.prev.Store(m.cur.Load())
+		} else {
+			m.prev.Store(0)
+		}
+		m.cur.Store(0)
+		m.start.Store(t)
+	}
+	if v > m.cur.Load() {
+		m.cur.Store(v)
+	}
+}
+
+func (m *windowMax) value(now time.Time) time.Duration {
+	switch age := now.UnixNano() - m.start.Load(); {
+	case age >= 2*int64(StatsMaxWindow):
+		return 0
+	case age >= int64(StatsMaxWindow):
+		return time.Duration(m.cur.Load())
+	}
+	return time.Duration(max(m.cur.Load(), m.prev.Load()))
+}
+
+func (g *Group) reorderHeld() int64 {
+	if g.reorder == nil {
+		return 0
+	}
+	return g.reorder.held.Load()
+}
+
+// forwardStats shows where a group's packets spend time and get lost on
+// their way through the server: queued for the group worker, dropped as
+// duplicates, held back for reordering. If these stay low while the stream
+// is bad, the bottleneck is the network, not the server.
+type forwardStats struct {
+	QueueDepth         int     `json:"queueDepth"`
+	QueueCapacity      int     `json:"queueCapacity"`
+	QueueDrops         uint64  `json:"queueDrops"`
+	QueueMaxWaitMs     float64 `json:"queueMaxWaitMs"`
+	DuplicatesDropped  uint64  `json:"duplicatesDropped"`
+	MisroutedDropped   uint64  `json:"misroutedDropped"`
+	ReorderHeld        int64   `json:"reorderHeld"`
+	ReorderMaxWaitMs   float64 `json:"reorderMaxWaitMs"`
+	ReorderGapsSkipped uint64  `json:"reorderGapsSkipped"`
+}
+
+func (g *Group) forwardStats(now time.Time) forwardStats {
+	s := forwardStats{
+		QueueDepth:        len(g.work),
+		QueueCapacity:     cap(g.work),
+		QueueDrops:        g.workDrops.Load(),
+		QueueMaxWaitMs:    durationMs(g.queueWait.value(now)),
+		DuplicatesDropped: g.dupDrops.Load(),
+		MisroutedDropped:  g.session.misrouted.Load(),
+	}
+	if r := g.reorder; r != nil {
+		s.ReorderHeld = r.held.Load()
+		s.ReorderMaxWaitMs = durationMs(r.wait.value(now))
+		s.ReorderGapsSkipped = r.skipped.Load()
+	}
+	return s
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// httpRequests counts the requests of the HTTP servers by server name and
+// status code.
+var httpRequests = struct {
+	mu     sync.Mutex
+	counts map[[2]string]uint64
+}{counts: map[[2]string]uint64{}}
+
+// countRequests counts the requests h handles under name.
+func countRequests(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+		httpRequests.mu.Lock()
+		httpRequests.counts[[2]string{name, fmt.Sprint(rec.code)}]++
+		httpRequests.mu.Unlock()
+	})
+}
+
+// statusRecorder remembers the status code of a response. WebSocket
+// upgrades hijack the connection and count as 101.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not implement http.Hijacker")
+	}
+	r.code = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// eventCounts counts the emitted events by name, so dashboards can mark
+// them as annotations.
+var eventCounts = struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}{counts: map[string]uint64{}}
+
+func countEvent(ev event) {
+	eventCounts.mu.Lock()
+	eventCounts.counts[ev.Name]++
+	eventCounts.mu.Unlock()
+}
+
+func writeEventMetrics(b *strings.Builder) {
+	eventCounts.mu.Lock()
+	defer eventCounts.mu.Unlock()
+	names := make([]string, 0, len(eventCounts.counts))
+	for name := range eventCounts.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(b, "# HELP goirl_events_total Emitted events by name.\n# TYPE goirl_events_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(b, "goirl_events_total{name=%q} %d\n", name, eventCounts.counts[name])
+	}
+}
+
+// writeStreamMetrics adds the ingest connection's SRT stats to b, once
+// there was one.
+func writeStreamMetrics(b *strings.Builder, now time.Time) {
+	ingestStats.mu.Lock()
+	at, s := ingestStats.at, ingestStats.stats
+	ingestStats.mu.Unlock()
+	if at.IsZero() {
+		return
+	}
+	single := func(name, typ, help string, v float64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, typ, name, v)
+	}
+	live := 0.0
+	if last := srtLastData.Load(); last != 0 && now.Sub(time.Unix(0, last)) < StreamStopTimeout {
+		live = 1
+	}
+	single("goirl_stream_live", "gauge", "1 while the stream is coming in.", live)
+	single("goirl_stream_received_bytes_total", "counter", "Bytes received on the current ingest connection.", float64(s.Accumulated.ByteRecv))
+	single("goirl_stream_received_packets_total", "counter", "Packets received on the current ingest connection.", float64(s.Accumulated.PktRecv))
+	single("goirl_stream_lost_packets_total", "counter", "Packets lost on the current ingest connection.", float64(s.Accumulated.PktRecvLoss))
+	single("goirl_stream_retransmitted_packets_total", "counter", "Retransmitted packets received on the current ingest connection.", float64(s.Accumulated.PktRecvRetrans))
+	single("goirl_stream_dropped_packets_total", "counter", "Packets dropped as too late on the current ingest connection.", float64(s.Accumulated.PktRecvDrop))
+	single("goirl_stream_rtt_seconds", "gauge", "RTT of the ingest connection.", s.Instantaneous.MsRTT/1000)
+	single("goirl_stream_buffer_seconds", "gauge", "Receive buffer of the ingest connection.", float64(s.Instantaneous.MsRecvBuf)/1000)
+}
+
+// writeHTTPMetrics adds the HTTP servers' and the stats WebSocket's
+// metrics to b.
+func writeHTTPMetrics(b *strings.Builder) {
+	httpRequests.mu.Lock()
+	keys := make([][2]string, 0, len(httpRequests.counts))
+	for k := range httpRequests.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i][0]+keys[i][1] < keys[j][0]+keys[j][1] })
+	fmt.Fprintf(b, "# HELP goirl_http_requests_total HTTP requests by server and status code.\n# TYPE goirl_http_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(b, "goirl_http_requests_total{server=%q,code=%q} %d\n", k[0], k[1], httpRequests.counts[k])
+	}
+	httpRequests.mu.Unlock()
+
+	if statsHub == nil {
+		return
+	}
+	single := func(name, typ, help string, v float64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, typ, name, v)
+	}
+	single("goirl_log_suppressed_total", "counter", "Packet-path log lines held back by the rate limit.", float64(hotLogs.suppressed.Load()))
+	single("goirl_ws_clients", "gauge", "Connected WebSocket clients.", float64(statsHub.clientCount()))
+	single("goirl_ws_broadcast_queue_depth", "gauge", "Messages waiting for the WebSocket hub.", float64(len(statsHub.broadcast)))
+	single("goirl_ws_broadcast_queue_capacity", "gauge", "Capacity of the WebSocket hub queue.", float64(cap(statsHub.broadcast)))
+	single("goirl_ws_broadcast_dropped_total", "counter", "Stats and event messages dropped because the WebSocket hub queue was full.", float64(statsHub.broadcastDrops.Load()))
+	single("goirl_ws_client_dropped_total", "counter", "Messages not sent to a WebSocket client because its queue was full.", float64(statsHub.clientDrops.Load()))
+	single("goirl_ws_messages_sent_total", "counter", "Messages written to WebSocket clients.", float64(statsHub.sent.Load()))
+}
+
+// handleMetrics serves the SRTLA receiver's and the stream's state in the
+// Prometheus text format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	groups := groupList()
+
+	var b strings.Builder
+	metric := func(name, typ, help string, value func(g *Group) float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, g := range groups {
+			fmt.Fprintf(&b, "%s{group=\"%p\"} %g\n", name, g, value(g))
+		}
+	}
+	stats := make(map[*Group]forwardStats, len(groups))
+	for _, g := range groups {
+		stats[g] = g.forwardStats(now)
+	}
+
+	fmt.Fprintf(&b, "# HELP goirl_groups SRTLA groups.\n# TYPE goirl_groups gauge\ngoirl_groups %d\n", len(groups))
+	metric("goirl_group_connections", "gauge", "Connections of the group.", func(g *Group) float64 {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return float64(len(g.conns))
+	})
+	metric("goirl_group_queue_depth", "gauge", "Packets waiting for the group worker.", func(g *Group) float64 {
+		return float64(stats[g].QueueDepth)
+	})
+	metric("goirl_group_queue_drops_total", "counter", "Packets dropped because the group worker queue was full.", func(g *Group) float64 {
+		return float64(stats[g].QueueDrops)
+	})
+	metric("goirl_group_queue_max_wait_seconds", "gauge", "Longest time a packet waited for the group worker recently.", func(g *Group) float64 {
+		return stats[g].QueueMaxWaitMs / 1000
+	})
+	metric("goirl_group_duplicates_dropped_total", "counter", "Duplicate data packets not forwarded (-dedup).", func(g *Group) float64 {
+		return float64(stats[g].DuplicatesDropped)
+	})
+	metric("goirl_group_misrouted_dropped_total", "counter", "SRT packets dropped for a socket ID outside the group's session.", func(g *Group) float64 {
+		return float64(stats[g].MisroutedDropped)
+	})
+	metric("goirl_group_reorder_held", "gauge", "Packets held back by the reorder buffer (-reorder-delay).", func(g *Group) float64 {
+		return float64(stats[g].ReorderHeld)
+	})
+	metric("goirl_group_reorder_max_wait_seconds", "gauge", "Longest time the reorder buffer held a packet back recently.", func(g *Group) float64 {
+		return stats[g].ReorderMaxWaitMs / 1000
+	})
+	metric("goirl_group_reorder_gaps_skipped_total", "counter", "Missing packets the reorder buffer stopped waiting for.", func(g *Group) float64 {
+		return float64(stats[g].ReorderGapsSkipped)
+	})
+	metric("goirl_group_overloaded", "gauge", "1 while the group is considered overloaded (-backpressure).", func(g *Group) float64 {
+		if g.overloaded.Load() {
+			return 1
+		}
+		return 0
+	})
+	metric("goirl_group_memory_bytes", "gauge", "Approximate memory the group holds in flight.", func(g *Group) float64 {
+		return float64(g.memory().Total)
+	})
+	fmt.Fprintf(&b, "# HELP goirl_link_received_bytes_total Bytes received on an SRTLA link.\n# TYPE goirl_link_received_bytes_total counter\n")
+	for _, g := range groups {
+		g.mu.Lock()
+		for _, c := range g.conns {
+			label, _ := c.label()
+			fmt.Fprintf(&b, "goirl_link_received_bytes_total{group=\"%p\",addr=%q,label=%q} %d\n", g, c.addr.String(), label, c.rxBytes)
+		}
+		g.mu.Unlock()
+	}
+	writeStreamMetrics(&b, now)
+	writeEventMetrics(&b)
+	writeHTTPMetrics(&b)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
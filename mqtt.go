@@ -0,0 +1,400 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MQTTStatePeriod      = 5 * time.Second  // also keeps the connection alive, well within MQTTKeepAlive
+	MQTTKeepAlive        = 60 * time.Second // the broker drops us after 1.5 times this without a packet
+	MQTTRetryPeriod      = 10 * time.Second
+	MQTTTimeout          = 10 * time.Second
+	MQTTDefaultTopic     = "go-irl"
+	MQTTDefaultDiscovery = "homeassistant"
+)
+
+// mqttPublisher publishes the stream state to an MQTT broker, see -mqtt:
+// <topic>/status is online or offline (the will), <topic>/state the state
+// as JSON every MQTTStatePeriod and on changes, and <topic>/event every
+// event. With a discovery prefix Home Assistant finds the sensors by
+// itself.
+type mqttPublisher struct {
+	addr      string // host:port
+	tls       bool
+	user      string
+	pass      string
+	topic     string
+	discovery string // Home Assistant discovery prefix, "" disables it
+	node      string // node and client ID, from the topic
+
+	events chan event
+
+	mu       sync.Mutex
+	degraded map[string]bool // link address -> link.degrading without link.recovered
+}
+
+var mqttNodeRe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+func newMQTTPublisher(rawURL, topic, discovery string) (*mqttPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	p := &mqttPublisher{
+		topic:     strings.Trim(topic, "/"),
+		discovery: strings.Trim(discovery, "/"),
+		events:    make(chan event, 64),
+		degraded:  map[string]bool{},
+	}
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl":
+		p.tls, port = true, "8883"
+	default:
+		return nil, fmt.Errorf("expected mqtt://[user:pass@]host[:port] or mqtts://, got %q", rawURL)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing broker host in %q", rawURL)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	p.addr = net.JoinHostPort(u.Hostname(), port)
+	if u.User != nil {
+		p.user = u.User.Username()
+		p.pass, _ = u.User.Password()
+	}
+	if p.topic == "" {
+		return nil, fmt.Errorf("-mqtt-topic must not be empty")
+	}
+	p.node = strings.Trim(mqttNodeRe.ReplaceAllString(p.topic, "_"), "_")
+	return p, nil
+}
+
+// mqttState is what <topic>/state carries.
+type mqttState struct {
+	Live          bool       `json:"live"`
+	BitrateKbps   float64    `json:"bitrateKbps"`
+	RttMs         float64    `json:"rttMs"`
+	LossPercent   float64    `json:"lossPercent"`
+	UptimeSeconds float64    `json:"uptimeSeconds"`
+	Links         []mqttLink `json:"links"`
+	DegradedLinks int        `json:"degradedLinks"`
+	Timestamp     time.Time  `json:"timestamp"`
+}
+
+type mqttLink struct {
+	Addr        string  `json:"addr"`
+	Label       string  `json:"label,omitempty"`
+	IdleSeconds float64 `json:"idleSeconds"`
+	Degraded    bool    `json:"degraded"`
+}
+
+func (p *mqttPublisher) state() mqttState {
+	now := time.Now()
+	st := mqttState{Links: []mqttLink{}, Timestamp: now}
+
+	// The proxy's stream in client and standalone mode, the SRTLA links in
+	// server mode
+	last := srtLastData.Load()
+	st.Live = last != 0 && now.Sub(time.Unix(0, last)) < StreamStopTimeout
+	if st.Live {
+		streamMetrics.mu.Lock()
+		m := streamMetrics.last
+		streamMetrics.mu.Unlock()
+		st.BitrateKbps, st.RttMs, st.LossPercent, st.UptimeSeconds = m.BitrateKbps1s, m.RttMs, m.LossPercent, m.UptimeSeconds
+	}
+	links := collectLinks()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, l := range links {
+		if l.IdleSeconds < StreamStopTimeout.Seconds() {
+			st.Live = true
+		}
+		ml := mqttLink{Addr: l.Addr, Label: l.Label, IdleSeconds: l.IdleSeconds, Degraded: p.degraded[l.Addr]}
+		if ml.Degraded {
+			st.DegradedLinks++
+		}
+		st.Links = append(st.Links, ml)
+	}
+	return st
+}
+
+// onEvent queues ev for publishing. It runs on the emitting goroutine, so
+// it never blocks; events are dropped while the broker is behind.
+func (p *mqttPublisher) onEvent(ev event) {
+	if addr, ok := ev.Fields["addr"].(string); ok {
+		p.mu.Lock()
+		switch ev.Name {
+		case "link.degrading":
+			p.degraded[addr] = true
+		case "link.recovered", "conn.removed":
+			delete(p.degraded, addr)
+		}
+		p.mu.Unlock()
+	}
+	select {
+	case p.events <- ev:
+	default:
+	}
+}
+
+func startMQTT(p *mqttPublisher) {
+	subscribeEvents(p.onEvent)
+	go supervise("mqtt", p.run)
+}
+
+func (p *mqttPublisher) run() {
+	for {
+		err := p.session()
+		log.Printf("[mqtt] Disconnected from %s: %v, retrying in %v", p.addr, err, MQTTRetryPeriod)
+		time.Sleep(MQTTRetryPeriod)
+	}
+}
+
+// session connects to the broker and publishes until the connection
+// breaks.
+func (p *mqttPublisher) session() error {
+	c, err := dialMQTT(p.addr, p.tls, p.node, p.user, p.pass, p.topic+"/status", "offline")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	log.Printf("[mqtt] Connected to %s, publishing to %s/#", p.addr, p.topic)
+
+	// The broker sends nothing we need, but reading notices it closing
+	// the connection
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, c)
+		if err == nil {
+			err = io.EOF
+		}
+		done <- err
+	}()
+
+	if p.discovery != "" {
+		for _, d := range p.discoveryConfigs() {
+			if err := c.publish(d.topic, d.payload, true); err != nil {
+				return err
+			}
+		}
+	}
+	if err := c.publish(p.topic+"/status", []byte("online"), true); err != nil {
+		return err
+	}
+	publishState := func() error {
+		data, _ := json.Marshal(p.state())
+		return c.publish(p.topic+"/state", data, true)
+	}
+	if err := publishState(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(MQTTStatePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if err := publishState(); err != nil {
+				return err
+			}
+		case ev := <-p.events:
+			data, _ := json.Marshal(ev)
+			if err := c.publish(p.topic+"/event", data, false); err != nil {
+				return err
+			}
+			if strings.HasPrefix(ev.Name, "stream.") || strings.HasPrefix(ev.Name, "link.") || strings.HasPrefix(ev.Name, "conn.") {
+				if err := publishState(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+type mqttConfigMessage struct {
+	topic   string
+	payload []byte
+}
+
+// discoveryConfigs returns the Home Assistant MQTT discovery messages of
+// the go-irl device and its sensors.
+func (p *mqttPublisher) discoveryConfigs() []mqttConfigMessage {
+	device := map[string]any{"identifiers": []string{p.node}, "name": "go-irl " + p.node, "manufacturer": "go-irl", "sw_version": version}
+	entity := func(component, id, name string, extra map[string]any) mqttConfigMessage {
+		cfg := map[string]any{
+			"name":               name,
+			"unique_id":          p.node + "_" + id,
+			"object_id":          p.node + "_" + id,
+			"state_topic":        p.topic + "/state",
+			"availability_topic": p.topic + "/status",
+			"device":             device,
+		}
+		for k, v := range extra {
+			cfg[k] = v
+		}
+		data, _ := json.Marshal(cfg)
+		return mqttConfigMessage{fmt.Sprintf("%s/%s/%s/%s/config", p.discovery, component, p.node, id), data}
+	}
+	return []mqttConfigMessage{
+		entity("binary_sensor", "live", "Live", map[string]any{
+			"device_class":   "running",
+			"value_template": "{{ 'ON' if value_json.live else 'OFF' }}",
+		}),
+		entity("sensor", "bitrate", "Bitrate", map[string]any{
+			"device_class": "data_rate", "state_class": "measurement", "unit_of_measurement": "kbit/s",
+			"value_template": "{{ value_json.bitrateKbps | round(0) }}",
+		}),
+		entity("sensor", "rtt", "RTT", map[string]any{
+			"device_class": "duration", "state_class": "measurement", "unit_of_measurement": "ms",
+			"value_template": "{{ value_json.rttMs }}",
+		}),
+		entity("sensor", "loss", "Packet loss", map[string]any{
+			"state_class": "measurement", "unit_of_measurement": "%", "icon": "mdi:lan-disconnect",
+			"value_template": "{{ value_json.lossPercent }}",
+		}),
+		entity("sensor", "links", "Links", map[string]any{
+			"state_class": "measurement", "icon": "mdi:signal-cellular-3",
+			"value_template":           "{{ value_json.links | count }}",
+			"json_attributes_topic":    p.topic + "/state",
+			"json_attributes_template": "{{ {'links': value_json.links} | tojson }}",
+		}),
+		entity("sensor", "degraded_links", "Degraded links", map[string]any{
+			"state_class": "measurement", "icon": "mdi:signal-cellular-outline",
+			"value_template": "{{ value_json.degradedLinks }}",
+		}),
+	}
+}
+
+// mqttConn is an MQTT 3.1.1 connection that only publishes at QoS 0.
+type mqttConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func dialMQTT(addr string, useTLS bool, clientID, user, pass, willTopic, willMsg string) (*mqttConn, error) {
+	dialer := &net.Dialer{Timeout: MQTTTimeout}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Clean session with a retained will
+	flags := byte(0x02 | 0x04 | 0x20)
+	body := mqttString(nil, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+	if user != "" {
+		flags |= 0x80
+		if pass != "" {
+			flags |= 0x40
+		}
+	}
+	body = append(body, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(MQTTKeepAlive/time.Second))
+	body = mqttString(body, clientID)
+	body = mqttString(body, willTopic)
+	body = mqttString(body, willMsg)
+	if user != "" {
+		body = mqttString(body, user)
+		if pass != "" {
+			body = mqttString(body, pass)
+		}
+	}
+
+	conn.SetDeadline(time.Now().Add(MQTTTimeout))
+	if _, err := conn.Write(mqttPacket(0x10, body)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading CONNACK: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	if ack[0] != 0x20 {
+		conn.Close()
+		return nil, errors.New("broker answered CONNECT without a CONNACK")
+	}
+	if ack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("broker refused the connection: %s", mqttConnackReason(ack[3]))
+	}
+	return &mqttConn{Conn: conn}, nil
+}
+
+func mqttConnackReason(rc byte) string {
+	switch rc {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client ID rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("code %d", rc)
+}
+
+func (c *mqttConn) publish(topic string, payload []byte, retain bool) error {
+	header := byte(0x30)
+	if retain {
+		header |= 0x01
+	}
+	pkt := mqttPacket(header, append(mqttString(nil, topic), payload...))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.SetWriteDeadline(time.Now().Add(MQTTTimeout))
+	_, err := c.Write(pkt)
+	return err
+}
+
+// mqttPacket prepends the fixed header with the variable-length remaining
+// length to body.
+func mqttPacket(header byte, body []byte) []byte {
+	pkt := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(pkt, body...)
+}
+
+func mqttString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
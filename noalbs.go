@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+const DefaultNOALBSPublisher = "go-irl"
+
+// slsStat is a publisher in the stats of srt-live-server (SLS), one of the
+// stream servers NOALBS reads the bitrate and RTT of for its scene
+// switching. NOALBS expects every field.
+type slsStat struct {
+	Bitrate       int64   `json:"bitrate"` // kbps
+	BytesRcvDrop  uint64  `json:"bytesRcvDrop"`
+	BytesRcvLoss  uint64  `json:"bytesRcvLoss"`
+	MbpsBandwidth float64 `json:"mbpsBandwidth"`
+	MbpsRecvRate  float64 `json:"mbpsRecvRate"`
+	MsRcvBuf      uint64  `json:"msRcvBuf"`
+	PktRcvDrop    uint64  `json:"pktRcvDrop"`
+	PktRcvLoss    uint64  `json:"pktRcvLoss"`
+	Rtt           float64 `json:"rtt"`
+	Uptime        int64   `json:"uptime"` // seconds
+}
+
+type slsStats struct {
+	Publishers map[string]slsStat `json:"publishers"`
+	Status     string             `json:"status"`
+}
+
+// handleSLSStats serves the stream like SLS serves a publisher. Without a
+// stream the publisher is missing, which NOALBS takes as offline.
+func handleSLSStats(w http.ResponseWriter, r *http.Request) {
+	out := slsStats{Publishers: map[string]slsStat{}, Status: "ok"}
+
+	now := time.Now()
+	last := srtLastData.Load()
+	ingestStats.mu.Lock()
+	at, s := ingestStats.at, ingestStats.stats
+	ingestStats.mu.Unlock()
+	streamMetrics.mu.Lock()
+	m := streamMetrics.last
+	streamMetrics.mu.Unlock()
+
+	live := last != 0 && now.Sub(time.Unix(0, last)) < StreamStopTimeout
+	if live && now.Sub(at) < StreamStopTimeout {
+		out.Publishers[*noalbsPublisher] = slsStat{
+			Bitrate:       int64(m.BitrateKbps1s),
+			BytesRcvDrop:  s.Accumulated.ByteRecvDrop,
+			BytesRcvLoss:  s.Accumulated.ByteRecvLoss,
+			MbpsBandwidth: s.Instantaneous.MbpsLinkCapacity,
+			MbpsRecvRate:  s.Instantaneous.MbpsRecvRate,
+			MsRcvBuf:      s.Instantaneous.MsRecvBuf,
+			PktRcvDrop:    s.Accumulated.PktRecvDrop,
+			PktRcvLoss:    s.Accumulated.PktRecvLoss,
+			Rtt:           s.Instantaneous.MsRTT,
+			Uptime:        int64(m.UptimeSeconds),
+		}
+	}
+	writeJSON(w, out)
+}
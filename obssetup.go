@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	OBSMediaSourceKind = "ffmpeg_source"
+	OBSDefaultSource   = "SRTLA Stream"
+	OBSTimeout         = 5 * time.Second
+
+	// Media Source settings for a live feed that drops out now and then:
+	// a small buffer keeps the delay low, and OBS reopens the input a
+	// second after it failed instead of the default 10.
+	OBSBufferingMB        = 1
+	OBSReconnectDelaySecs = 1
+
+	// obs-websocket request status codes
+	OBSStatusResourceNotFound = 600
+)
+
+// obsSetup is what /obs-setup serves: the settings of the Media Source for
+// the stream and the Browser Source URL, as obs-websocket keys and as the
+// fields of the properties dialog.
+type obsSetup struct {
+	Output        string         `json:"output"` // "udp" or "srt"
+	InputKind     string         `json:"inputKind"`
+	InputSettings map[string]any `json:"inputSettings"`
+	Dialog        []obsField     `json:"dialog"`
+	BrowserSource string         `json:"browserSource"`
+	Configurable  bool           `json:"configurable"` // POST /obs-setup can apply the settings through obs-websocket
+}
+
+type obsField struct {
+	Label string `json:"label"`
+	Value any    `json:"value"`
+}
+
+// obsMediaSource returns the Media Source settings for output, "udp" or
+// "srt", or the output there is when it is empty.
+func obsMediaSource(output string) (obsSetup, error) {
+	if output == "" {
+		output = "udp"
+		if *udpPort <= 0 {
+			output = "srt"
+		}
+	}
+	var input string
+	switch {
+	case output == "udp" && *udpPort > 0:
+		// overrun_nonfatal keeps the source alive when OBS stalls for a
+		// moment, e.g. while a scene loads, instead of failing the input
+		input = fmt.Sprintf("udp://127.0.0.1:%d?overrun_nonfatal=1&fifo_size=50000", *udpPort)
+	case output == "srt" && *playPort > 0:
+		input = fmt.Sprintf("srt://127.0.0.1:%d?mode=caller", *playPort)
+	case output == "udp" || output == "srt":
+		return obsSetup{}, fmt.Errorf("the %s output is disabled, see -udp-port and -play-port", output)
+	default:
+		return obsSetup{}, fmt.Errorf("unknown output %q (expected udp or srt)", output)
+	}
+
+	settings := map[string]any{
+		"is_local_file":       false,
+		"input":               input,
+		"input_format":        "mpegts",
+		"buffering_mb":        OBSBufferingMB,
+		"reconnect_delay_sec": OBSReconnectDelaySecs,
+		"restart_on_activate": false,
+		"close_when_inactive": false,
+		"clear_on_media_end":  false,
+		"hw_decode":           true,
+	}
+	return obsSetup{
+		Output:        output,
+		InputKind:     OBSMediaSourceKind,
+		InputSettings: settings,
+		Dialog: []obsField{
+			{"Local File", false},
+			{"Input", input},
+			{"Input Format", "mpegts"},
+			{"Network Buffering", fmt.Sprintf("%d MB", OBSBufferingMB)},
+			{"Reconnect Delay", fmt.Sprintf("%d S", OBSReconnectDelaySecs)},
+			{"Restart playback when source becomes active", false},
+			{"Use hardware decoding when available", true},
+			{"Show nothing when playback ends", false},
+			{"Close file when inactive", false},
+		},
+		BrowserSource: webURL("http", "localhost", *bsPort, fmt.Sprintf("/app?wsport=%d&onlineSceneName=ONLINE&offlineSceneName=OFFLINE&type=simple", *wsPort)),
+		Configurable:  *obsWebSocket != "",
+	}, nil
+}
+
+func handleOBSSetup(w http.ResponseWriter, r *http.Request) {
+	setup, err := obsMediaSource(r.URL.Query().Get("output"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, setup)
+}
+
+// handleOBSApply creates the Media Source in OBS through obs-websocket, or
+// updates it when it exists: {"source": "SRTLA Stream", "scene": "ONLINE"},
+// both optional. A new source goes into the current scene without a scene.
+// The JSON content type is required so other web pages can't post here.
+func handleOBSApply(w http.ResponseWriter, r *http.Request) {
+	if *obsWebSocket == "" {
+		http.Error(w, "OBS can't be configured without -obs-websocket", http.StatusNotFound)
+		return
+	}
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req struct {
+		Source string `json:"source"`
+		Scene  string `json:"scene"`
+		Output string `json:"output"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Source == "" {
+		req.Source = OBSDefaultSource
+	}
+	setup, err := obsMediaSource(req.Output)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	created, scene, err := applyOBSMediaSource(req.Source, req.Scene, setup)
+	if err != nil {
+		log.Printf("[obs] Configuring %q failed: %v", req.Source, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if created {
+		log.Printf("[obs] Created Media Source %q in scene %q: %s", req.Source, scene, setup.InputSettings["input"])
+	} else {
+		log.Printf("[obs] Updated Media Source %q: %s", req.Source, setup.InputSettings["input"])
+	}
+	writeJSON(w, map[string]any{"source": req.Source, "scene": scene, "created": created, "inputSettings": setup.InputSettings})
+}
+
+// applyOBSMediaSource sets the settings of source, creating it in scene
+// (or the current program scene) when OBS doesn't have it.
+func applyOBSMediaSource(source, scene string, setup obsSetup) (bool, string, error) {
+	c, err := dialOBS(*obsWebSocket, *obsPassword)
+	if err != nil {
+		return false, "", err
+	}
+	defer c.close()
+
+	var existing struct {
+		InputKind string `json:"inputKind"`
+	}
+	code, err := c.request("GetInputSettings", map[string]any{"inputName": source}, &existing)
+	switch {
+	case err == nil:
+		if existing.InputKind != setup.InputKind {
+			return false, "", fmt.Errorf("%q is a %s, not a Media Source", source, existing.InputKind)
+		}
+		_, err = c.request("SetInputSettings", map[string]any{"inputName": source, "inputSettings": setup.InputSettings, "overlay": true}, nil)
+		return false, scene, err
+	case code != OBSStatusResourceNotFound:
+		return false, "", err
+	}
+
+	if scene == "" {
+		var current struct {
+			Name string `json:"currentProgramSceneName"`
+		}
+		if _, err := c.request("GetCurrentProgramScene", nil, &current); err != nil {
+			return false, "", err
+		}
+		scene = current.Name
+	}
+	_, err = c.request("CreateInput", map[string]any{
+		"sceneName":        scene,
+		"inputName":        source,
+		"inputKind":        setup.InputKind,
+		"inputSettings":    setup.InputSettings,
+		"sceneItemEnabled": true,
+	}, nil)
+	return err == nil, scene, err
+}
+
+// obsClient is a connection to obs-websocket 5, just enough to send
+// requests one at a time.
+type obsClient struct {
+	conn *websocket.Conn
+	id   int
+}
+
+type obsMessage struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+}
+
+func dialOBS(url, password string) (*obsClient, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: OBSTimeout, Subprotocols: []string{"obswebsocket.json"}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to obs-websocket: %w", err)
+	}
+	c := &obsClient{conn: conn}
+
+	var hello struct {
+		RPCVersion     int `json:"rpcVersion"`
+		Authentication *struct {
+			Challenge string `json:"challenge"`
+			Salt      string `json:"salt"`
+		} `json:"authentication"`
+	}
+	if err := c.read(0, &hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	identify := map[string]any{"rpcVersion": 1, "eventSubscriptions": 0}
+	if a := hello.Authentication; a != nil {
+		if password == "" {
+			conn.Close()
+			return nil, fmt.Errorf("obs-websocket needs a password, see -obs-password")
+		}
+		secret := sha256.Sum256([]byte(password + a.Salt))
+		auth := sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(secret[:]) + a.Challenge))
+		identify["authentication"] = base64.StdEncoding.EncodeToString(auth[:])
+	}
+	if err := c.write(1, identify); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := c.read(2, nil); err != nil {
+		conn.Close()
+		if websocket.IsCloseError(err, 4009) {
+			return nil, fmt.Errorf("obs-websocket rejected the password")
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *obsClient) write(op int, d any) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(OBSTimeout))
+	return c.conn.WriteJSON(obsMessage{Op: op, D: data})
+}
+
+// read waits for a message with op, skipping others, and decodes its data
+// into v.
+func (c *obsClient) read(op int, v any) error {
+	c.conn.SetReadDeadline(time.Now().Add(OBSTimeout))
+	for {
+		var msg obsMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			return err
+		}
+		if msg.Op != op {
+			continue
+		}
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(msg.D, v)
+	}
+}
+
+// request sends an obs-websocket request and decodes its response data
+// into v. It returns the request status code, which tells a missing source
+// from other errors.
+func (c *obsClient) request(typ string, data map[string]any, v any) (int, error) {
+	c.id++
+	id := strconv.Itoa(c.id)
+	req := map[string]any{"requestType": typ, "requestId": id}
+	if data != nil {
+		req["requestData"] = data
+	}
+	if err := c.write(6, req); err != nil {
+		return 0, err
+	}
+	for {
+		var resp struct {
+			RequestID     string `json:"requestId"`
+			RequestStatus struct {
+				Result  bool   `json:"result"`
+				Code    int    `json:"code"`
+				Comment string `json:"comment"`
+			} `json:"requestStatus"`
+			ResponseData json.RawMessage `json:"responseData"`
+		}
+		if err := c.read(7, &resp); err != nil {
+			return 0, err
+		}
+		if resp.RequestID != id {
+			continue
+		}
+		st := resp.RequestStatus
+		if !st.Result {
+			return st.Code, fmt.Errorf("%s: %s (code %d)", typ, st.Comment, st.Code)
+		}
+		if v != nil && len(resp.ResponseData) > 0 {
+			return st.Code, json.Unmarshal(resp.ResponseData, v)
+		}
+		return st.Code, nil
+	}
+}
+
+func (c *obsClient) close() {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.conn.Close()
+}
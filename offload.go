@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+)
+
+// SRTLAReadBatch is how many datagrams the SRTLA reader takes from the
+// socket per system call where batched reads are available.
+const SRTLAReadBatch = 32
+
+// udpOffload enables batched socket I/O (recvmmsg, sendmmsg and UDP GSO) on
+// platforms that support it, see offload_linux.go. Elsewhere the sockets
+// are used one datagram at a time.
+var udpOffload = true
+
+// Optional capabilities of the sockets returned by listenSRTLA and dialSRT.
+type batchReader interface {
+	ReadBatch(bufs [][]byte, sizes []int, addrs []netip.AddrPort) (int, error)
+}
+
+type segmentWriter interface {
+	WriteSegments(segs [][]byte) error
+}
+
+type broadcaster interface {
+	WriteToAll(b []byte, addrs []*net.UDPAddr) error
+}
+
+// readBatch reads at least one datagram into bufs and returns how many were
+// read.
+func readBatch(pc packetConn, bufs [][]byte, sizes []int, addrs []netip.AddrPort) (int, error) {
+	if br, ok := pc.(batchReader); ok {
+		return br.ReadBatch(bufs, sizes, addrs)
+	}
+	n, addr, err := pc.ReadFromUDPAddrPort(bufs[0])
+	if err != nil {
+		return 0, err
+	}
+	sizes[0], addrs[0] = n, addr
+	return 1, nil
+}
+
+// writeSegments writes segs to conn in order, as few system calls as the
+// socket allows.
+func writeSegments(conn srtConn, segs [][]byte) error {
+	if sw, ok := conn.(segmentWriter); ok {
+		return sw.WriteSegments(segs)
+	}
+	for _, seg := range segs {
+		if _, err := conn.Write(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeToAll sends b to every address. It returns the first error but keeps
+// sending to the remaining addresses.
+func writeToAll(pc packetConn, b []byte, addrs []*net.UDPAddr) error {
+	if bc, ok := pc.(broadcaster); ok {
+		return bc.WriteToAll(b, addrs)
+	}
+	var firstErr error
+	for _, addr := range addrs {
+		if _, err := pc.WriteToUDP(b, addr); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	udpMaxSegments = 64    // UDP_MAX_SEGMENTS in the kernel
+	udpMaxPayload  = 65507 // largest UDP payload, and so largest GSO super-packet
+
+	gsoBufBytes = udpMaxPayload // per group, see offloadSRTSock
+)
+
+// mmsghdr is struct mmsghdr from sys/socket.h.
+type mmsghdr struct {
+	hdr unix.Msghdr
+	n   uint32
+}
+
+// mmsgBatch holds the preallocated message headers for recvmmsg/sendmmsg so
+// the packet path does not allocate.
+type mmsgBatch struct {
+	hdrs  []mmsghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrInet6 // large enough for sockaddr_in too
+}
+
+func (m *mmsgBatch) grow(n int) {
+	if len(m.hdrs) >= n {
+		return
+	}
+	m.hdrs = make([]mmsghdr, n)
+	m.iovs = make([]unix.Iovec, n)
+	m.names = make([]unix.RawSockaddrInet6, n)
+}
+
+// offloadSRTLASock is the public SRTLA socket with batched reads (recvmmsg)
+// and batched broadcasts (sendmmsg). GSO does not help here: it only splits
+// a super-packet for a single destination, while ACK/NAK broadcasts go to
+// as many addresses as the group has connections.
+type offloadSRTLASock struct {
+	*net.UDPConn
+	rc     syscall.RawConn
+	family int
+
+	rd     mmsgBatch
+	rdCnt  int // filled by rdFunc
+	rdN    int
+	rdErr  syscall.Errno
+	rdFunc func(fd uintptr) bool
+
+	wmu    sync.Mutex
+	wr     mmsgBatch
+	wrCnt  int
+	wrN    int
+	wrErr  syscall.Errno
+	wrFunc func(fd uintptr) bool
+}
+
+func wrapSRTLASock(conn *net.UDPConn) packetConn {
+	if !udpOffload {
+		return conn
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return conn
+	}
+	s := &offloadSRTLASock{UDPConn: conn, rc: rc}
+	var sockErr error
+	rc.Control(func(fd uintptr) {
+		s.family, sockErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_DOMAIN)
+	})
+	if sockErr != nil {
+		return conn
+	}
+
+	s.rdFunc = func(fd uintptr) bool {
+		for {
+			r, _, e := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&s.rd.hdrs[0])), uintptr(s.rdCnt), unix.MSG_DONTWAIT, 0, 0)
+			switch e {
+			case unix.EINTR:
+				continue
+			case unix.EAGAIN:
+				return false // wait for the poller
+			}
+			s.rdN, s.rdErr = int(r), e
+			return true
+		}
+	}
+	s.wrFunc = func(fd uintptr) bool {
+		for {
+			r, _, e := unix.Syscall6(unix.SYS_SENDMMSG, fd, uintptr(unsafe.Pointer(&s.wr.hdrs[0])), uintptr(s.wrCnt), unix.MSG_DONTWAIT, 0, 0)
+			switch e {
+			case unix.EINTR:
+				continue
+			case unix.EAGAIN:
+				return false
+			}
+			s.wrN, s.wrErr = int(r), e
+			return true
+		}
+	}
+	return s
+}
+
+// ReadBatch must only be called from one goroutine at a time.
+func (s *offloadSRTLASock) ReadBatch(bufs [][]byte, sizes []int, addrs []netip.AddrPort) (int, error) {
+	s.rd.grow(len(bufs))
+	for i := range bufs {
+		s.rd.iovs[i].Base = &bufs[i][0]
+		s.rd.iovs[i].SetLen(len(bufs[i]))
+		h := &s.rd.hdrs[i].hdr
+		h.Name = (*byte)(unsafe.Pointer(&s.rd.names[i]))
+		h.Namelen = unix.SizeofSockaddrInet6
+		h.Iov = &s.rd.iovs[i]
+		h.SetIovlen(1)
+	}
+	s.rdCnt = len(bufs)
+	if err := s.rc.Read(s.rdFunc); err != nil {
+		return 0, err
+	}
+	if s.rdErr != 0 {
+		return 0, &net.OpError{Op: "recvmmsg", Net: "udp", Err: s.rdErr}
+	}
+	for i := 0; i < s.rdN; i++ {
+		sizes[i] = int(s.rd.hdrs[i].n)
+		addrs[i] = sockaddrAddrPort(&s.rd.names[i])
+	}
+	return s.rdN, nil
+}
+
+func sockaddrAddrPort(sa *unix.RawSockaddrInet6) netip.AddrPort {
+	port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+	p := uint16(port[0])<<8 | uint16(port[1])
+	if sa.Family == unix.AF_INET {
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		return netip.AddrPortFrom(netip.AddrFrom4(sa4.Addr), p)
+	}
+	addr := netip.AddrFrom16(sa.Addr)
+	if sa.Scope_id != 0 {
+		addr = addr.WithZone(strconv.FormatUint(uint64(sa.Scope_id), 10))
+	}
+	return netip.AddrPortFrom(addr, p)
+}
+
+// putSockaddr encodes addr for a socket of the given family.
+func putSockaddr(sa *unix.RawSockaddrInet6, family int, addr *net.UDPAddr) (uint32, bool) {
+	port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+	if family == unix.AF_INET {
+		ip4 := addr.IP.To4()
+		if ip4 == nil {
+			return 0, false
+		}
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		*sa4 = unix.RawSockaddrInet4{Family: unix.AF_INET}
+		port = (*[2]byte)(unsafe.Pointer(&sa4.Port))
+		port[0], port[1] = byte(addr.Port>>8), byte(addr.Port)
+		copy(sa4.Addr[:], ip4)
+		return unix.SizeofSockaddrInet4, true
+	}
+	ip16 := addr.IP.To16()
+	if ip16 == nil {
+		return 0, false
+	}
+	*sa = unix.RawSockaddrInet6{Family: unix.AF_INET6}
+	port[0], port[1] = byte(addr.Port>>8), byte(addr.Port)
+	copy(sa.Addr[:], ip16)
+	if addr.Zone != "" {
+		if idx, err := strconv.ParseUint(addr.Zone, 10, 32); err == nil {
+			sa.Scope_id = uint32(idx)
+		} else if ifi, err := net.InterfaceByName(addr.Zone); err == nil {
+			sa.Scope_id = uint32(ifi.Index)
+		}
+	}
+	return unix.SizeofSockaddrInet6, true
+}
+
+// WriteToAll sends b to every address with as few sendmmsg calls as
+// possible.
+func (s *offloadSRTLASock) WriteToAll(b []byte, addrs []*net.UDPAddr) error {
+	if len(addrs) == 0 || len(b) == 0 {
+		return nil
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	s.wr.grow(len(addrs))
+	cnt := 0
+	var firstErr error
+	for _, addr := range addrs {
+		namelen, ok := putSockaddr(&s.wr.names[cnt], s.family, addr)
+		if !ok {
+			if firstErr == nil {
+				firstErr = &net.AddrError{Err: "address family mismatch", Addr: addr.String()}
+			}
+			continue
+		}
+		s.wr.iovs[cnt].Base = &b[0]
+		s.wr.iovs[cnt].SetLen(len(b))
+		h := &s.wr.hdrs[cnt].hdr
+		h.Name = (*byte)(unsafe.Pointer(&s.wr.names[cnt]))
+		h.Namelen = namelen
+		h.Iov = &s.wr.iovs[cnt]
+		h.SetIovlen(1)
+		cnt++
+	}
+
+	for sent := 0; sent < cnt; {
+		// sendmmsg stops at the first failing message: report it, skip it
+		// and carry on with the rest
+		hdrs := s.wr.hdrs
+		s.wr.hdrs = s.wr.hdrs[sent:]
+		s.wrCnt = cnt - sent
+		err := s.rc.Write(s.wrFunc)
+		s.wr.hdrs = hdrs
+		if err != nil {
+			return err
+		}
+		if s.wrErr != 0 {
+			if firstErr == nil {
+				firstErr = &net.OpError{Op: "sendmmsg", Net: "udp", Err: s.wrErr}
+			}
+			sent++
+			continue
+		}
+		sent += s.wrN
+	}
+	return firstErr
+}
+
+// offloadSRTSock is a group's socket towards the downstream SRT server.
+// Bursts of equally sized packets are handed to the kernel as one UDP GSO
+// super-packet, which the kernel (or the NIC) splits back into the original
+// datagrams.
+type offloadSRTSock struct {
+	*net.UDPConn
+	gso bool
+	buf []byte
+	oob []byte
+}
+
+func wrapSRTSock(conn *net.UDPConn) srtConn {
+	if !udpOffload {
+		return conn
+	}
+	s := &offloadSRTSock{
+		UDPConn: conn,
+		gso:     true,
+		buf:     make([]byte, 0, udpMaxPayload),
+		oob:     make([]byte, unix.CmsgSpace(2)),
+	}
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&s.oob[0]))
+	h.Level = unix.SOL_UDP
+	h.Type = unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	return s
+}
+
+// gsoRun returns how many packets from the start of segs can be sent as
+// one GSO super-packet: all but the last must have the same size, the last
+// may be shorter.
+func gsoRun(segs [][]byte) int {
+	size := len(segs[0])
+	limit := min(udpMaxSegments, udpMaxPayload/max(size, 1), len(segs))
+	n := 1
+	for n < limit {
+		l := len(segs[n])
+		if l > size {
+			break
+		}
+		n++
+		if l < size {
+			break
+		}
+	}
+	return n
+}
+
+// WriteSegments is called by the group worker only.
+func (s *offloadSRTSock) WriteSegments(segs [][]byte) error {
+	for len(segs) > 0 {
+		n := 1
+		if s.gso {
+			n = gsoRun(segs)
+		}
+		if n == 1 {
+			if _, err := s.Write(segs[0]); err != nil {
+				return err
+			}
+			segs = segs[1:]
+			continue
+		}
+
+		buf := s.buf[:0]
+		for _, seg := range segs[:n] {
+			buf = append(buf, seg...)
+		}
+		s.buf = buf
+		size := len(segs[0])
+		binary.NativeEndian.PutUint16(s.oob[unix.CmsgLen(0):], uint16(size))
+		if _, _, err := s.WriteMsgUDP(buf, s.oob, nil); err != nil {
+			if gsoUnsupported(err) {
+				log.Printf("UDP GSO not available on %s, sending packets one by one: %v", s.LocalAddr(), err)
+				s.gso = false
+				continue // resend this run without GSO
+			}
+			return err
+		}
+		segs = segs[n:]
+	}
+	return nil
+}
+
+func gsoUnsupported(err error) bool {
+	return errors.Is(err, unix.EIO) || errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.ENOPROTOOPT) || errors.Is(err, unix.EOPNOTSUPP)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// gsoBufBytes is what a group's GSO send buffer takes; there is none here.
+const gsoBufBytes = 0
+
+func wrapSRTLASock(conn *net.UDPConn) packetConn { return conn }
+
+func wrapSRTSock(conn *net.UDPConn) srtConn { return conn }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The SRT proxy is a pipeline:
+//
+//	sources -> failover -> filters -> sinks
+//
+// Sources and sinks are endpoints named by a URL whose scheme picks the
+// opener below, so a new protocol is one opener and one entry. Of the
+// sources, the one currently delivering data feeds the filters, which pass
+// the stream on to every sink.
+
+// sourceOpener opens an endpoint the stream is read from. Every Read
+// returns at most one SRT packet worth of data.
+type sourceOpener func(addr string) (io.ReadCloser, error)
+
+// sinkOpener opens an endpoint the stream is written to. Sinks that can
+// fall behind queue or drop rather than stall the pipeline.
+type sinkOpener func(addr string) (writer, error)
+
+var sourceOpeners = map[string]sourceOpener{
+	"srt":  openSrtStream,
+	"udp":  func(addr string) (io.ReadCloser, error) { return openUDPInput(addr) },
+	"file": openFileInput,
+}
+
+var sinkOpeners = map[string]sinkOpener{
+	"udp":        func(addr string) (writer, error) { return openUDPWriter(addr) },
+	"srt":        openSRTSink,
+	"pipe":       openLocalOutput,
+	"unix":       openLocalOutput,
+	StdoutOutput: openLocalOutput,
+	"dvr": func(string) (writer, error) {
+		if dvr == nil {
+			return nil, fmt.Errorf("dvr output without -dvr")
+		}
+		return dvr, nil
+	},
+	"record": func(string) (writer, error) {
+		if rec == nil {
+			return nil, fmt.Errorf("record output without -record")
+		}
+		recordTarget("record", "file", *recordDir)
+		return rec, nil
+	},
+}
+
+// endpointScheme returns the scheme of a source or sink address. pipe:path
+// takes any path, so the address isn't parsed as a URL.
+func endpointScheme(addr string) string {
+	if addr == StdoutOutput {
+		return StdoutOutput
+	}
+	scheme, _, _ := strings.Cut(addr, ":")
+	return strings.ToLower(scheme)
+}
+
+func endpointSchemes[T any](openers map[string]T) string {
+	var names []string
+	for name := range openers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// openSource opens the source described by addr, see sourceOpeners.
+func openSource(addr string) (io.ReadCloser, error) {
+	open, ok := sourceOpeners[endpointScheme(addr)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported input %q, known are %s", addr, endpointSchemes(sourceOpeners))
+	}
+	return open(addr)
+}
+
+// openSink opens the sink described by addr: udp:// pushes to a UDP
+// address, srt:// starts a play listener for SRT subscribers or publishes
+// as a caller, pipe:, unix:// and - are the -pipe-output, dvr: and record:
+// the -dvr and -record buffers. See sinkOpeners.
+func openSink(addr string) (writer, error) {
+	open, ok := sinkOpeners[endpointScheme(addr)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported output %q, known are %s", redactURL(addr), endpointSchemes(sinkOpeners))
+	}
+	return open(addr)
+}
+
+func openSRTSink(addr string) (writer, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Query().Get("mode") == "listener" {
+		return openPlayServer(addr)
+	}
+	return openSRTCaller(addr)
+}
+
+// pipeline describes what runPipeline connects.
+type pipeline struct {
+	sources []string // failover in order, see failoverWriter
+	filters []*filterStage
+	sinks   []string
+}
+
+// runPipeline opens the sinks of p and reads from each of its sources,
+// writing the stream of whichever source is currently delivering data
+// through the filters to every sink. With a single SRT source and a UDP
+// sink this is a plain SRT to UDP proxy.
+func runPipeline(p pipeline, wsPort int) <-chan error {
+	hub := runStatsHub(wsPort)
+
+	doneChan := make(chan error, 1)
+
+	var w multiWriter
+	for _, to := range p.sinks {
+		out, err := openSink(to)
+		if err != nil {
+			w.Close()
+			doneChan <- fmt.Errorf("to: %w", err)
+			return doneChan
+		}
+		w = append(w, out)
+	}
+
+	f := &failoverWriter{
+		w:        filterWriter{filters: p.filters, sinks: w},
+		froms:    p.sources,
+		readers:  make([]io.ReadCloser, len(p.sources)),
+		lastData: make([]time.Time, len(p.sources)),
+		stats: &stats{
+			interval:        statsInterval,
+			summaryInterval: statsSummaryInterval,
+			writer:          w,
+			hub:             hub,
+		},
+	}
+	activeProxy.Store(f)
+	for _, s := range p.filters {
+		registerToggle("filter."+s.name, "the "+s.name+" filter", s.enabled.Load, s.setEnabled)
+	}
+	for i, from := range p.sources {
+		go f.runSource(i, from, doneChan)
+	}
+
+	return doneChan
+}
+
+// sinkList collects repeated -output flags.
+type sinkList []string
+
+func (s *sinkList) String() string { return strings.Join(*s, " ") }
+
+func (s *sinkList) Set(v string) error {
+	if _, ok := sinkOpeners[endpointScheme(v)]; !ok {
+		return fmt.Errorf("unsupported output %q, known are %s", redactURL(v), endpointSchemes(sinkOpeners))
+	}
+	*s = append(*s, v)
+	return nil
+}
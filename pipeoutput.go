@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// PipeQueueLen is the number of packets buffered for a pipe or socket
+// reader before new packets are dropped for it. Local readers get a bigger
+// queue than players, for very high bitrates.
+const PipeQueueLen = 8192
+
+// openLocalOutput opens a proxy output of -pipe-output: - writes to
+// stdout, unix:///path listens on a unix domain socket, pipe:path writes to
+// a named pipe (FIFO).
+func openLocalOutput(addr string) (writer, error) {
+	if addr == StdoutOutput {
+		return openStdoutOutput(), nil
+	}
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		return openUnixOutput(path)
+	}
+	return openFifoOutput(strings.TrimPrefix(addr, "pipe:"))
+}
+
+// fifoOutput writes the stream to a named pipe. The pipe is opened in the
+// background whenever a reader opens it; while none has it open, the
+// stream is not written. Closing the reader doesn't stop the proxy.
+type fifoOutput struct {
+	path    string
+	queue   chan []byte
+	reading atomic.Bool
+	dropped atomic.Int64
+	closed  chan struct{}
+	close   sync.Once
+}
+
+func openFifoOutput(path string) (*fifoOutput, error) {
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := mkfifo(path); err != nil {
+			return nil, fmt.Errorf("failed to create the pipe %s: %w", path, err)
+		}
+		log.Printf("[pipe] Created the named pipe %s", path)
+	} else if err != nil {
+		return nil, err
+	} else if fi.Mode()&fs.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s exists and is not a named pipe", path)
+	}
+	recordTarget("pipe-output", "file", path)
+	p := &fifoOutput{path: path, queue: make(chan []byte, PipeQueueLen), closed: make(chan struct{})}
+	go supervise("pipe-output", p.run)
+	return p, nil
+}
+
+func (p *fifoOutput) run() {
+	for {
+		// blocks until a reader opens the pipe
+		f, err := os.OpenFile(p.path, os.O_WRONLY, 0)
+		select {
+		case <-p.closed:
+			if f != nil {
+				f.Close()
+			}
+			return
+		default:
+		}
+		if err != nil {
+			log.Printf("[pipe] Failed to open %s: %v", p.path, err)
+			return
+		}
+		log.Printf("[pipe] Reader opened %s", p.path)
+		p.reading.Store(true)
+		if !p.drain(f) {
+			f.Close()
+			return
+		}
+		p.reading.Store(false)
+		f.Close()
+		// what was queued for the old reader is stale for the next
+		for len(p.queue) > 0 {
+			<-p.queue
+		}
+		log.Printf("[pipe] Reader closed %s (%d packets dropped)", p.path, p.dropped.Swap(0))
+	}
+}
+
+// drain writes the queue to f until the reader closes the pipe, and
+// returns false once the output is closed.
+func (p *fifoOutput) drain(f *os.File) bool {
+	for {
+		select {
+		case pkt := <-p.queue:
+			if _, err := f.Write(pkt); err != nil {
+				return true
+			}
+		case <-p.closed:
+			return false
+		}
+	}
+}
+
+// Write queues b for the reader. It never fails so a slow or missing
+// reader cannot stop the proxy.
+func (p *fifoOutput) Write(b []byte) (int, error) {
+	if !p.reading.Load() {
+		return len(b), nil
+	}
+	pkt := make([]byte, len(b))
+	copy(pkt, b)
+	select {
+	case p.queue <- pkt:
+	default:
+		p.dropped.Add(1)
+	}
+	return len(b), nil
+}
+
+func (p *fifoOutput) Close() error {
+	p.close.Do(func() {
+		close(p.closed)
+		// a run still waiting for a reader wakes up when the pipe is opened
+		if f, err := os.OpenFile(p.path, os.O_RDONLY|nonblockFlag, 0); err == nil {
+			f.Close()
+		}
+	})
+	return nil
+}
+
+// StdoutOutput is the -pipe-output that writes the stream to stdout.
+const StdoutOutput = "-"
+
+// stdoutOutput writes the stream to stdout for piping into a player, e.g.
+// go-irl client -pipe-output=- | ffplay -. When the reader exits, go-irl
+// exits too, like other tools writing to a pipe.
+type stdoutOutput struct {
+	queue   chan []byte
+	dropped atomic.Int64
+	closed  chan struct{}
+	close   sync.Once
+}
+
+func openStdoutOutput() *stdoutOutput {
+	// A write to a closed pipe returns EPIPE instead of killing go-irl, so
+	// it can shut down properly
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
+	recordTarget("pipe-output", "file", "stdout")
+	s := &stdoutOutput{queue: make(chan []byte, PipeQueueLen), closed: make(chan struct{})}
+	go supervise("stdout-output", s.run)
+	return s
+}
+
+func (s *stdoutOutput) run() {
+	for {
+		select {
+		case pkt := <-s.queue:
+			if _, err := os.Stdout.Write(pkt); err != nil {
+				log.Printf("[stdout] The reader of stdout is gone (%v), exiting", err)
+				requestExit()
+				return
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// Write queues b for stdout; a reader that falls behind loses packets
+// rather than stall the proxy.
+func (s *stdoutOutput) Write(b []byte) (int, error) {
+	pkt := make([]byte, len(b))
+	copy(pkt, b)
+	select {
+	case s.queue <- pkt:
+	default:
+		s.dropped.Add(1)
+	}
+	return len(b), nil
+}
+
+func (s *stdoutOutput) Close() error {
+	s.close.Do(func() {
+		close(s.closed)
+		if n := s.dropped.Load(); n > 0 {
+			log.Printf("[stdout] %d packets dropped while stdout was behind", n)
+		}
+	})
+	return nil
+}
+
+type unixReader struct {
+	conn    net.Conn
+	queue   chan []byte
+	dropped int
+}
+
+// unixOutput is a unix domain socket that tools like ffmpeg connect to,
+// e.g. ffmpeg -i unix:///tmp/go-irl.sock. Every packet written to it is
+// fanned out to all connected readers; a slow reader only loses its own
+// packets.
+type unixOutput struct {
+	ln      net.Listener
+	path    string
+	mu      sync.Mutex
+	readers map[*unixReader]struct{}
+}
+
+func openUnixOutput(path string) (*unixOutput, error) {
+	// A socket left behind by a previous run would fail the listen
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	recordListener("unix-output", "unix", ln.Addr())
+	u := &unixOutput{ln: ln, path: path, readers: make(map[*unixReader]struct{})}
+	go supervise("unix-output", u.acceptLoop)
+	return u, nil
+}
+
+func (u *unixOutput) acceptLoop() {
+	for {
+		conn, err := u.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[unix] Listener error: %v", err)
+			}
+			return
+		}
+		u.addReader(conn)
+	}
+}
+
+func (u *unixOutput) addReader(conn net.Conn) {
+	r := &unixReader{conn: conn, queue: make(chan []byte, PipeQueueLen)}
+
+	u.mu.Lock()
+	u.readers[r] = struct{}{}
+	n := len(u.readers)
+	u.mu.Unlock()
+
+	log.Printf("[unix] Reader connected to %s. Total readers: %d", u.path, n)
+
+	go func() {
+		for pkt := range r.queue {
+			if _, err := conn.Write(pkt); err != nil {
+				break
+			}
+		}
+		u.removeReader(r)
+	}()
+}
+
+func (u *unixOutput) removeReader(r *unixReader) {
+	u.mu.Lock()
+	if _, ok := u.readers[r]; !ok {
+		u.mu.Unlock()
+		return
+	}
+	delete(u.readers, r)
+	close(r.queue)
+	n := len(u.readers)
+	u.mu.Unlock()
+
+	r.conn.Close()
+	log.Printf("[unix] Reader disconnected from %s (%d packets dropped). Total readers: %d", u.path, r.dropped, n)
+}
+
+// Write queues b for every reader. It never fails so a broken reader
+// cannot stop the proxy.
+func (u *unixOutput) Write(b []byte) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if len(u.readers) == 0 {
+		return len(b), nil
+	}
+	pkt := make([]byte, len(b))
+	copy(pkt, b)
+	for r := range u.readers {
+		select {
+		case r.queue <- pkt:
+		default:
+			r.dropped++
+		}
+	}
+	return len(b), nil
+}
+
+func (u *unixOutput) Close() error {
+	u.ln.Close()
+	u.mu.Lock()
+	readers := make([]*unixReader, 0, len(u.readers))
+	for r := range u.readers {
+		readers = append(readers, r)
+	}
+	u.mu.Unlock()
+	for _, r := range readers {
+		u.removeReader(r)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+const nonblockFlag = 0
+
+// mkfifo is not available here; unix:// sockets work on Windows 10 and
+// later.
+func mkfifo(path string) error {
+	return errors.New("named pipes need Linux or macOS, use -pipe-output=unix://path instead")
+}
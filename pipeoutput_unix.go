@@ -0,0 +1,13 @@
+//go:build linux || darwin
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// nonblockFlag opens the read end of a named pipe without waiting for a
+// writer.
+const nonblockFlag = unix.O_NONBLOCK
+
+func mkfifo(path string) error {
+	return unix.Mkfifo(path, 0o600)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"sync"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// PlayQueueLen is the number of packets buffered per subscriber before new
+// packets are dropped for that subscriber.
+const PlayQueueLen = 2048
+
+type playSubscriber struct {
+	conn    srt.Conn
+	queue   chan []byte
+	dropped int
+}
+
+// playServer is an SRT listener that players such as OBS or ffplay connect
+// to as subscribers. Every packet written to it is fanned out to all
+// connected subscribers; a slow subscriber only loses its own packets.
+type playServer struct {
+	ln   srt.Listener
+	mu   sync.Mutex
+	subs map[*playSubscriber]struct{}
+}
+
+func openPlayServer(addr string) (*playServer, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config := srt.DefaultConfig()
+	if err := config.UnmarshalQuery(u.RawQuery); err != nil {
+		return nil, err
+	}
+
+	ln, err := srt.Listen("srt", u.Host, config)
+	if err != nil {
+		return nil, err
+	}
+
+	recordListener("srt-play", "srt", ln.Addr())
+	p := &playServer{ln: ln, subs: make(map[*playSubscriber]struct{})}
+	go supervise("srt-play", func() { p.acceptLoop(config) })
+	return p, nil
+}
+
+func (p *playServer) acceptLoop(config srt.Config) {
+	for {
+		req, err := p.ln.Accept2()
+		if err != nil {
+			if err != srt.ErrListenerClosed {
+				log.Printf("SRT play listener error: %v", err)
+			}
+			return
+		}
+
+		// Players usually send no streamid at all, which parses as the
+		// default publish mode; only an explicit m=publish is refused.
+		if req.StreamId() != "" && parseStreamID(req.StreamId()).Mode != StreamModeRequest {
+			log.Printf("Rejected SRT publisher %s on the play listener (streamid %q)", req.RemoteAddr(), req.StreamId())
+			req.Reject(srt.REJX_BAD_MODE)
+			continue
+		}
+		if config.StreamId != "" && !sameResource(config.StreamId, req.StreamId()) {
+			req.Reject(srt.REJX_NOTFOUND)
+			continue
+		}
+		if config.Passphrase != "" {
+			if err := req.SetPassphrase(config.Passphrase); err != nil {
+				reportProblem(FailureWrongPassphrase, p.ln.Addr().String(), err)
+				req.Reject(srt.REJ_BADSECRET)
+				continue
+			}
+		}
+
+		conn, err := req.Accept()
+		if err != nil {
+			continue
+		}
+		clearProblem(p.ln.Addr().String())
+		p.addSubscriber(conn)
+	}
+}
+
+func (p *playServer) addSubscriber(conn srt.Conn) {
+	sub := &playSubscriber{conn: conn, queue: make(chan []byte, PlayQueueLen)}
+
+	p.mu.Lock()
+	p.subs[sub] = struct{}{}
+	n := len(p.subs)
+	p.mu.Unlock()
+
+	log.Printf("SRT player connected from %s. Total players: %d", conn.RemoteAddr(), n)
+
+	go func() {
+		for pkt := range sub.queue {
+			if _, err := conn.Write(pkt); err != nil {
+				break
+			}
+		}
+		p.removeSubscriber(sub)
+	}()
+}
+
+func (p *playServer) removeSubscriber(sub *playSubscriber) {
+	p.mu.Lock()
+	if _, ok := p.subs[sub]; !ok {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.subs, sub)
+	close(sub.queue)
+	n := len(p.subs)
+	p.mu.Unlock()
+
+	sub.conn.Close()
+	log.Printf("SRT player %s disconnected (%d packets dropped). Total players: %d", sub.conn.RemoteAddr(), sub.dropped, n)
+}
+
+// Write queues b for every subscriber. It never fails so a broken player
+// cannot stop the proxy.
+func (p *playServer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.subs) == 0 {
+		return len(b), nil
+	}
+	pkt := make([]byte, len(b))
+	copy(pkt, b)
+	for sub := range p.subs {
+		select {
+		case sub.queue <- pkt:
+		default:
+			sub.dropped++
+		}
+	}
+	return len(b), nil
+}
+
+func (p *playServer) Close() error {
+	p.ln.Close()
+	p.mu.Lock()
+	subs := make([]*playSubscriber, 0, len(p.subs))
+	for sub := range p.subs {
+		subs = append(subs, sub)
+	}
+	p.mu.Unlock()
+	for _, sub := range subs {
+		p.removeSubscriber(sub)
+	}
+	return nil
+}
+
+// multiWriter writes to every output and reports the first error.
+type multiWriter []writer
+
+func (m multiWriter) Write(b []byte) (int, error) {
+	var firstErr error
+	for _, w := range m {
+		if _, err := w.Write(b); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return len(b), nil
+}
+
+func (m multiWriter) Close() error {
+	for _, w := range m {
+		w.Close()
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	PCRHz = 27_000_000
+
+	// A PCR further off than this from where the pacing expects it is a
+	// discontinuity (a splice, a wrap or the file starting over) and
+	// restarts the pacing from there.
+	PlaybackMaxPCRJump = 1 * time.Second
+
+	// Files with no PCR at all cannot be paced and are refused once this
+	// much was read without one.
+	PlaybackMaxUnpaced = 4 << 20
+)
+
+var errNoPCR = errors.New("no PCR found, the file cannot be paced")
+
+// playbackReader plays an MPEG-TS file in real time, paced by the PCR of
+// the first PID that carries one. Every Read returns at most one SRT packet
+// worth of whole TS packets.
+type playbackReader struct {
+	f    *os.File
+	r    *bufio.Reader
+	path string
+	loop bool
+
+	pcrPID   int // -1 until the first PCR
+	pcrBase  uint64
+	wallBase time.Time
+	unpaced  int
+	loops    int
+}
+
+// isFileInput reports whether addr is a file:// input, see openFileInput.
+func isFileInput(addr string) bool {
+	u, err := url.Parse(addr)
+	return err == nil && u.Scheme == "file"
+}
+
+// openFileInput opens file://path, with ?loop=1 to start over at the end.
+func openFileInput(addr string) (io.ReadCloser, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	path := u.Host + u.Path
+	loop, _ := strconv.ParseBool(u.Query().Get("loop"))
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &playbackReader{f: f, r: bufio.NewReaderSize(f, 64<<10), path: path, loop: loop, pcrPID: -1}, nil
+}
+
+func (p *playbackReader) Read(b []byte) (int, error) {
+	b = b[:min(len(b), SRTChunkSize)/TSPacketLen*TSPacketLen]
+	n := 0
+	for n < len(b) {
+		pkt := b[n : n+TSPacketLen]
+		if err := p.readPacket(pkt); err != nil {
+			if n > 0 && err == io.EOF {
+				break // hand out what we have, the next Read reports EOF
+			}
+			return n, err
+		}
+		n += TSPacketLen
+		if p.pace(pkt) {
+			break // send at the PCR's time
+		}
+		if p.pcrPID < 0 && p.unpaced > PlaybackMaxUnpaced {
+			return n, fmt.Errorf("%s: %w", p.path, errNoPCR)
+		}
+	}
+	return n, nil
+}
+
+// readPacket reads the next TS packet into pkt, starting the file over at
+// its end when looping.
+func (p *playbackReader) readPacket(pkt []byte) error {
+	for {
+		c, err := p.r.ReadByte()
+		if err == io.EOF && p.loop {
+			if p.loops++; p.pcrPID < 0 {
+				return fmt.Errorf("%s: %w", p.path, errNoPCR)
+			}
+			if _, err := p.f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			p.r.Reset(p.f)
+			p.wallBase = time.Time{} // the PCR jumps back to the start
+			if p.loops == 1 {
+				log.Printf("[playback] %s ended, looping", p.path)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if c != 0x47 {
+			continue // resync on the next sync byte
+		}
+		pkt[0] = c
+		if _, err := io.ReadFull(p.r, pkt[1:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			if err == io.EOF && p.loop {
+				continue // drop the truncated packet at the end of the file
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// pace sleeps until it is time to send pkt if it carries the reference
+// PCR, and reports whether it did.
+func (p *playbackReader) pace(pkt []byte) bool {
+	pcr, ok := packetPCR(pkt)
+	pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+	if !ok || (p.pcrPID >= 0 && pid != p.pcrPID) {
+		p.unpaced += TSPacketLen
+		return false
+	}
+	p.pcrPID = pid
+	p.unpaced = 0
+
+	now := time.Now()
+	if p.wallBase.IsZero() {
+		p.pcrBase, p.wallBase = pcr, now
+		return true
+	}
+	due := p.wallBase.Add(time.Duration(float64(pcr-p.pcrBase) / PCRHz * float64(time.Second)))
+	if pcr < p.pcrBase || due.Sub(now) > PlaybackMaxPCRJump || now.Sub(due) > PlaybackMaxPCRJump {
+		p.pcrBase, p.wallBase = pcr, now
+		return true
+	}
+	time.Sleep(time.Until(due))
+	return true
+}
+
+// packetPCR returns the PCR of a TS packet in 27 MHz ticks, if it has one.
+func packetPCR(pkt []byte) (uint64, bool) {
+	if pkt[3]&0x20 == 0 || pkt[4] < 7 || pkt[5]&0x10 == 0 {
+		return 0, false
+	}
+	base := uint64(pkt[6])<<25 | uint64(pkt[7])<<17 | uint64(pkt[8])<<9 | uint64(pkt[9])<<1 | uint64(pkt[10])>>7
+	ext := uint64(pkt[10]&1)<<8 | uint64(pkt[11])
+	return base*300 + ext, true
+}
+
+func (p *playbackReader) Close() error {
+	return p.f.Close()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	PreviewTimeout = 10 * time.Second
+	PreviewKeep    = 2 * DVRSegmentMax // the DVR buffer kept for previews only
+)
+
+// preview is the most recent snapshot of the stream, served at
+// /preview.jpg. Decoding H.264 or HEVC is left to ffmpeg, which is run on
+// the newest DVR segment every -preview-interval.
+var preview struct {
+	mu    sync.Mutex
+	jpeg  []byte
+	taken time.Time
+}
+
+// runPreview renders a snapshot every interval until the process exits.
+func runPreview(ffmpeg string, interval time.Duration) {
+	path, err := exec.LookPath(ffmpeg)
+	if err != nil {
+		log.Printf("[preview] %s not found, /preview.jpg is disabled: %v", ffmpeg, err)
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last uint64
+	for range ticker.C {
+		if powerIdle() {
+			continue
+		}
+		seq, data := dvr.latest()
+		if data == nil || seq == last {
+			continue // nothing new since the last snapshot
+		}
+		last = seq
+		jpeg, err := renderPreview(path, data)
+		if err != nil {
+			log.Printf("[preview] Rendering a snapshot failed: %v", err)
+			continue
+		}
+		preview.mu.Lock()
+		preview.jpeg, preview.taken = jpeg, time.Now()
+		preview.mu.Unlock()
+	}
+}
+
+// renderPreview decodes the first frame of an MPEG-TS segment to JPEG.
+func renderPreview(ffmpeg string, ts []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), PreviewTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-loglevel", "error",
+		"-f", "mpegts", "-i", "pipe:0", "-frames:v", "1", "-q:v", "4", "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+	cmd.Stdin = bytes.NewReader(ts)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	preview.mu.Lock()
+	jpeg, taken := preview.jpeg, preview.taken
+	preview.mu.Unlock()
+	if jpeg == nil {
+		http.Error(w, "no preview yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Last-Modified", taken.UTC().Format(http.TimeFormat))
+	w.Write(jpeg)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	ProfileDefault  = ""
+	ProfileLowPower = "low-power"
+
+	LowPowerBufSize       = 2 * 1024 * 1024 // 2 MB, above what a Pi's rmem_max allows anyway
+	LowPowerStatsInterval = 2 * time.Second
+)
+
+// Tunables set by -profile. The defaults favour throughput on desktops and
+// VPSes; low-power trades burst headroom for kernel memory on small ARM
+// boards and sends stats less often.
+var (
+	sendBufSize   = SendBufSize
+	recvBufSize   = RecvBufSize
+	statsInterval = time.Second
+
+	statsSummaryInterval = StatsSummaryInterval // -stats-summary
+)
+
+func applyProfile(name string) error {
+	switch name {
+	case ProfileDefault:
+	case ProfileLowPower:
+		sendBufSize = LowPowerBufSize
+		recvBufSize = LowPowerBufSize
+		statsInterval = LowPowerStatsInterval
+		log.Printf("Using the %s profile: %d KB socket buffers, stats every %s",
+			name, LowPowerBufSize/1024, LowPowerStatsInterval)
+	default:
+		return fmt.Errorf("unknown profile '%s' (expected %s)", name, ProfileLowPower)
+	}
+	return nil
+}
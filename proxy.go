@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Reverse proxy settings, see -trusted-proxies and -base-path. The
+// X-Forwarded-* headers are only believed when the request comes from a
+// trusted proxy, anyone else could set them.
+var (
+	trustedProxies []netip.Prefix
+	basePath       string // e.g. "/irl", without trailing slash
+)
+
+// parsePrefixes parses comma separated IPs and CIDRs, an IP being the
+// prefix of only itself.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// normalizeBasePath turns "irl/" into "/irl"; "" and "/" mean none.
+func normalizeBasePath(p string) (string, error) {
+	p = "/" + strings.Trim(p, "/")
+	if p == "/" {
+		return "", nil
+	}
+	if strings.ContainsAny(p, "?#") {
+		return "", fmt.Errorf("%q is not a path", p)
+	}
+	return p, nil
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// proxied reports whether r came through a trusted proxy.
+func proxied(r *http.Request) bool {
+	return isTrustedProxy(remoteAddr(r))
+}
+
+// clientIP returns the address of the client that sent r: the last
+// X-Forwarded-For hop not added by a trusted proxy, or the peer address.
+func clientIP(r *http.Request) string {
+	addr := remoteAddr(r)
+	if !isTrustedProxy(addr) {
+		if !addr.IsValid() {
+			return r.RemoteAddr
+		}
+		return addr.String()
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// externalBase returns the URL the client used to reach the server up to
+// the base path, e.g. https://irl.example.com/irl.
+func externalBase(r *http.Request) *url.URL {
+	u := &url.URL{Scheme: "http", Host: r.Host, Path: basePath}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	if proxied(r) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			u.Scheme = proto
+		}
+		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+			u.Host, _, _ = strings.Cut(host, ",")
+		}
+		if prefix := r.Header.Get("X-Forwarded-Prefix"); prefix != "" && basePath == "" {
+			if p, err := normalizeBasePath(prefix); err == nil {
+				u.Path = p
+			}
+		}
+	}
+	return u
+}
+
+// withBasePath serves h under basePath. Requests without the prefix are
+// served too, for proxies that strip it and for direct local access.
+func withBasePath(h http.Handler) http.Handler {
+	if basePath == "" {
+		return h
+	}
+	stripped := http.StripPrefix(basePath, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, basePath+"/") {
+			stripped.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handleEndpoints serves /api/endpoints with the absolute URLs of the
+// Browser Source, WebSocket and API as seen by the client. Behind a proxy
+// they all share the client's origin; accessed directly, they are on
+// their own ports of the host the client used.
+func handleEndpoints(w http.ResponseWriter, r *http.Request) {
+	base := externalBase(r)
+	viaProxy := basePath != "" || (proxied(r) && (r.Header.Get("X-Forwarded-Host") != "" || r.Header.Get("X-Forwarded-Proto") != ""))
+	at := func(scheme string, port int, path string) string {
+		u := *base
+		if scheme == "ws" {
+			u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+		}
+		if !viaProxy {
+			u.Host = net.JoinHostPort(hostOnly(base.Host), strconv.Itoa(port))
+		}
+		u.Path += path
+		return u.String()
+	}
+	out := map[string]string{"api": at("http", *apiPort, "/api/")}
+	if *wsPort > 0 {
+		out["ws"] = at("ws", *wsPort, "/ws")
+	}
+	if *bsPort > 0 {
+		app := at("http", *bsPort, "/app")
+		if out["ws"] != "" {
+			app += "?" + url.Values{"wsport": {strconv.Itoa(*wsPort)}, "wsurl": {out["ws"]}}.Encode()
+		}
+		out["app"] = app
+	}
+	writeJSON(w, out)
+}
+
+func hostOnly(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return strings.Trim(hostport, "[]")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HotLogPeriod is how often a packet-path log line is repeated at most.
+const HotLogPeriod = 10 * time.Second
+
+// hotLogLine is the state of one call site of logHot.
+type hotLogLine struct {
+	start      time.Time // of the current period
+	suppressed int
+	last       []any // arguments of the last suppressed line, formatted only for the summary
+}
+
+// hotLogs rate-limits the log lines of the packet path, which a packet
+// storm or a dead socket could otherwise repeat thousands of times a second
+// and make logging the bottleneck.
+var hotLogs = struct {
+	mu         sync.Mutex
+	lines      map[string]*hotLogLine
+	suppressed atomic.Uint64 // all lines not logged, for /metrics
+	once       sync.Once
+}{lines: map[string]*hotLogLine{}}
+
+// logHot logs like log.Printf, but each format at most once per
+// HotLogPeriod. What it held back is summarized at the end of the period
+// with the last message and how often it repeated.
+func logHot(format string, args ...any) {
+	hotLogs.once.Do(func() { go supervise("hot-log", runHotLogFlush) })
+	now := time.Now()
+
+	var summary string
+	hotLogs.mu.Lock()
+	l := hotLogs.lines[format]
+	switch {
+	case l == nil:
+		hotLogs.lines[format] = &hotLogLine{start: now}
+	case now.Sub(l.start) < HotLogPeriod:
+		l.suppressed++
+		l.last = args
+		hotLogs.mu.Unlock()
+		hotLogs.suppressed.Add(1)
+		return
+	default:
+		summary = l.summary(format)
+		l.start, l.suppressed = now, 0
+	}
+	hotLogs.mu.Unlock()
+	if summary != "" {
+		log.Print(summary)
+	}
+	log.Printf(format, args...)
+}
+
+// summary must be called with hotLogs.mu held.
+func (l *hotLogLine) summary(format string) string {
+	if l.suppressed == 0 {
+		return ""
+	}
+	return fmt.Sprintf(format+" (repeated %d times in %v)", append(l.last, l.suppressed, HotLogPeriod)...)
+}
+
+// runHotLogFlush logs the summaries of periods that ended without another
+// line, e.g. when the storm is over, and forgets quiet call sites.
+func runHotLogFlush() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		var summaries []string
+		hotLogs.mu.Lock()
+		for format, l := range hotLogs.lines {
+			if now.Sub(l.start) < HotLogPeriod {
+				continue
+			}
+			if l.suppressed == 0 {
+				delete(hotLogs.lines, format)
+				continue
+			}
+			summaries = append(summaries, l.summary(format))
+			l.start, l.suppressed = now, 0
+		}
+		hotLogs.mu.Unlock()
+		for _, s := range summaries {
+			log.Print(s)
+		}
+	}
+}
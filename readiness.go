@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StartupTimeout is how long a component waits for the ones it depends on
+// before it starts anyway.
+const StartupTimeout = 10 * time.Second
+
+// Components that signal when they are up, see markReady. The HTTP servers
+// use the name they have in listenAndServe.
+const (
+	ReadySRTLA         = "srtla"
+	ReadyBrowserSource = "browser_source"
+	ReadyWebSocket     = "ws"
+)
+
+// readySRTListener names the SRT listener on host:port.
+func readySRTListener(hostPort string) string {
+	return "srt-listener " + hostPort
+}
+
+var readiness = struct {
+	mu    sync.Mutex
+	chans map[string]chan struct{}
+}{chans: map[string]chan struct{}{}}
+
+func readyChan(name string) chan struct{} {
+	readiness.mu.Lock()
+	defer readiness.mu.Unlock()
+	ch, ok := readiness.chans[name]
+	if !ok {
+		ch = make(chan struct{})
+		readiness.chans[name] = ch
+	}
+	return ch
+}
+
+// markReady tells whoever waits for name that it accepts connections now.
+// Only the first call counts.
+func markReady(name string) {
+	ch := readyChan(name)
+	readiness.mu.Lock()
+	defer readiness.mu.Unlock()
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// waitReady blocks until all names are ready. It gives up after timeout,
+// with an error naming the ones that aren't, or with stopped set when
+// failed delivers the error a component stopped with.
+func waitReady(timeout time.Duration, failed <-chan error, names ...string) (stopped bool, err error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for _, name := range names {
+		select {
+		case <-readyChan(name):
+		case err := <-failed:
+			return true, err
+		case <-deadline.C:
+			var missing []string
+			for _, n := range names {
+				select {
+				case <-readyChan(n):
+				default:
+					missing = append(missing, n)
+				}
+			}
+			return false, fmt.Errorf("%s not ready after %v", strings.Join(missing, ", "), timeout)
+		}
+	}
+	return false, nil
+}
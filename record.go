@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RecordBufSize        = 256 << 10
+	RecordIdleClose      = StreamStopTimeout // a recording ends after this long without data
+	RecordSegmentMaxLate = 10 * time.Second  // cut at any payload start this long after a segment boundary without a keyframe
+)
+
+// recordWriter is one recording session, a local file or an S3 object.
+type recordWriter interface {
+	Write(b []byte) (int, error)
+	Flush() error
+	// finish closes the recording. done gets the result once it is stored,
+	// possibly later on another goroutine.
+	finish(done func(error))
+}
+
+// recorder is an output (see openSink) that writes every stream session
+// to its own MPEG-TS file in dir, or to an S3 bucket. A session ends when
+// no data came in for RecordIdleClose; completed local files are handed to
+// onComplete. With segment set, a session is cut into several files at
+// keyframes, aligned to multiples of segment on the clock.
+type recorder struct {
+	dir        string
+	s3         *s3Target
+	spillDir   string
+	segment    time.Duration // 0 records each session to a single file
+	onComplete func(path string)
+
+	mu      sync.Mutex
+	w       recordWriter
+	path    string
+	started time.Time
+	last    time.Time
+	bytes   int64
+	failed  bool // the current session could not be written, skip it
+	stopped bool // by the record.stop command, until record.start
+
+	session *recordSession // nil between sessions
+	nextCut time.Time
+	psi     psiTables
+
+	partial  [TSPacketLen]byte // incomplete TS packet from the last write
+	partialN int
+}
+
+// rec is the recorder, nil unless -record is set.
+var rec *recorder
+
+// newRecorder records to dest, a directory or s3://bucket/prefix. S3
+// parts waiting to be uploaded are spilled to spillDir.
+func newRecorder(dest, spillDir string, segment time.Duration, onComplete func(string)) (*recorder, error) {
+	r := &recorder{onComplete: onComplete, spillDir: spillDir, segment: segment, psi: newPSITables()}
+	if strings.HasPrefix(dest, "s3://") {
+		t, err := parseS3Target(dest)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(spillDir, 0o755); err != nil {
+			return nil, err
+		}
+		r.s3 = t
+	} else {
+		if err := os.MkdirAll(dest, 0o755); err != nil {
+			return nil, err
+		}
+		r.dir = dest
+	}
+	go supervise("recorder", r.closeIdle)
+	return r, nil
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.last = now
+	if r.failed || r.stopped {
+		return len(b), nil
+	}
+	if r.session == nil {
+		r.startSession(now)
+	}
+	if r.segment == 0 {
+		if r.w == nil {
+			r.open(now)
+		}
+		r.write(b)
+		return len(b), nil
+	}
+
+	n := len(b)
+	if r.partialN > 0 {
+		c := copy(r.partial[r.partialN:], b)
+		r.partialN += c
+		b = b[c:]
+		if r.partialN < TSPacketLen {
+			return n, nil
+		}
+		r.writePacket(r.partial[:], now)
+		r.partialN = 0
+	}
+	for len(b) > 0 && !r.failed {
+		if b[0] != 0x47 {
+			b = b[1:] // resync on the next sync byte
+			continue
+		}
+		if len(b) < TSPacketLen {
+			r.partialN = copy(r.partial[:], b)
+			break
+		}
+		r.writePacket(b[:TSPacketLen], now)
+		b = b[TSPacketLen:]
+	}
+	return n, nil
+}
+
+// writePacket writes one TS packet, starting a new segment at the first
+// keyframe after a segment boundary. Must be called with r.mu held.
+func (r *recorder) writePacket(pkt []byte, now time.Time) {
+	r.psi.observe(pkt)
+	pusi := pkt[1]&0x40 != 0
+	if r.w != nil && pusi && !now.Before(r.nextCut) && (randomAccess(pkt) || now.Sub(r.nextCut) >= RecordSegmentMaxLate) {
+		r.finish()
+	}
+	if r.w == nil {
+		r.open(now)
+		if r.failed {
+			return
+		}
+		if pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2]); pid != 0 {
+			r.write(r.psi.appendTables(nil))
+		}
+	}
+	r.write(pkt)
+}
+
+// write writes to the current file, giving up on the session if that
+// fails. Must be called with r.mu held.
+func (r *recorder) write(b []byte) {
+	if r.failed || len(b) == 0 {
+		return
+	}
+	if _, err := r.w.Write(b); err != nil {
+		// A full disk must not take the live outputs down with it
+		log.Printf("[record] Writing %s failed, stopping this recording: %v", r.path, err)
+		emitEvent("record.failed", map[string]any{"file": r.path, "error": err.Error()})
+		r.w.finish(func(error) {})
+		r.w = nil
+		r.failed = true
+		return
+	}
+	r.bytes += int64(len(b))
+	r.session.addBytes(int64(len(b)))
+}
+
+// open starts a new file of the session. Must be called with r.mu held.
+func (r *recorder) open(now time.Time) {
+	name := r.session.Name + ".ts"
+	if r.segment > 0 {
+		name = fmt.Sprintf("%s-%03d.ts", r.session.Name, len(r.session.Segments)+1)
+		r.nextCut = now.Truncate(r.segment).Add(r.segment)
+	}
+	var path string
+	var w recordWriter
+	var err error
+	if r.s3 != nil {
+		path = r.s3.String() + "/" + name
+		w = newS3Recording(r.s3, name, r.spillDir)
+	} else {
+		path = filepath.Join(r.dir, name)
+		w, err = openFileRecording(path)
+	}
+	if err != nil {
+		log.Printf("[record] Failed to start a recording: %v", err)
+		emitEvent("record.failed", map[string]any{"file": path, "error": err.Error()})
+		r.failed = true
+		return
+	}
+	r.w, r.path, r.started, r.bytes = w, path, now, 0
+	r.session.addSegment(name, now)
+	r.saveSession()
+	log.Printf("[record] Recording to %s", path)
+	emitEvent("record.started", map[string]any{"file": path})
+}
+
+// finish ends the current recording. Must be called with r.mu held.
+func (r *recorder) finish() {
+	if r.w == nil {
+		return
+	}
+	path, bytes, dur := r.path, r.bytes, r.last.Sub(r.started)
+	local := r.s3 == nil
+	r.session.endSegment(r.last)
+	r.saveSession()
+	r.w.finish(func(err error) {
+		if err != nil {
+			log.Printf("[record] Closing %s failed: %v", path, err)
+			emitEvent("record.failed", map[string]any{"file": path, "error": err.Error()})
+			return
+		}
+		log.Printf("[record] Finished %s (%d MB, %s)", path, bytes>>20, dur.Round(time.Second))
+		emitEvent("record.completed", map[string]any{"file": path, "bytes": bytes, "durationSeconds": int(dur.Seconds())})
+		if local && r.onComplete != nil {
+			r.onComplete(path)
+		}
+	})
+	r.w = nil
+}
+
+// recording reports whether path is the file being recorded right now.
+func (r *recorder) recording(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w != nil && r.path == path
+}
+
+func (r *recorder) closeIdle() {
+	ticker := time.NewTicker(StreamCheckPeriod)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		r.mu.Lock()
+		if !r.last.IsZero() && now.Sub(r.last) >= RecordIdleClose {
+			r.endSession()
+			r.failed = false
+			r.last = time.Time{}
+		} else if r.w != nil {
+			r.w.Flush() // lose at most a second of the recording on a crash
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *recorder) isStopped() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopped
+}
+
+// setStopped stops recording, ending the current session, or resumes it
+// with a new session when data comes in.
+func (r *recorder) setStopped(stopped bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if stopped == r.stopped {
+		return
+	}
+	r.stopped = stopped
+	if stopped {
+		r.endSession()
+		log.Printf("[record] Recording stopped")
+		emitEvent("record.stopped", nil)
+	} else {
+		log.Printf("[record] Recording resumed")
+		emitEvent("record.resumed", nil)
+	}
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endSession()
+	return nil
+}
+
+// fileRecording is a recording to a local file.
+type fileRecording struct {
+	*bufio.Writer
+	f *os.File
+}
+
+func openFileRecording(path string) (*fileRecording, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileRecording{Writer: bufio.NewWriterSize(f, RecordBufSize), f: f}, nil
+}
+
+func (fr *fileRecording) finish(done func(error)) {
+	err := fr.Flush()
+	if cerr := fr.f.Close(); err == nil {
+		err = cerr
+	}
+	done(err)
+}
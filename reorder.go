@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ReorderSlots is how far, in sequence numbers, the reorder buffer can look
+// ahead of the next packet it waits for. It must be a power of two.
+const ReorderSlots = 1024
+
+// reorderDelay is how long a group holds packets back waiting for an
+// earlier one that is still on its way over a slower link, see
+// -reorder-delay. Zero disables reordering.
+var reorderDelay time.Duration
+
+// reorderBuffer releases a group's data packets in sequence order. A packet
+// that arrives after a gap waits up to reorderDelay for the gap to fill; then
+// the gap is given up on and left to SRT's own retransmission. Control
+// packets and packets from before the current position pass through
+// immediately. Used by the group worker only.
+type reorderBuffer struct {
+	next    int32 // sequence number released next, -1 until the first packet
+	count   int
+	slots   [ReorderSlots]*packetBuf
+	arrived [ReorderSlots]time.Time
+
+	// For the stats, read from other goroutines
+	held    atomic.Int64  // count
+	wait    windowMax     // how long released packets were held back
+	skipped atomic.Uint64 // sequence numbers given up on
+}
+
+func newReorderBuffer() *reorderBuffer {
+	return &reorderBuffer{next: -1}
+}
+
+// seqOffset returns a-b for 31 bit SRT sequence numbers, taking wraparound
+// into account.
+func seqOffset(a, b int32) int32 {
+	return (a - b) << 1 >> 1
+}
+
+// push adds pb and appends whatever can be released in order to out.
+func (r *reorderBuffer) push(pb *packetBuf, now time.Time, out []*packetBuf) []*packetBuf {
+	sn := getSRTSN(pb.b[:pb.n])
+	if sn < 0 {
+		return append(out, pb)
+	}
+	if r.next < 0 {
+		r.next = sn
+	}
+	off := seqOffset(sn, r.next)
+	if off < 0 {
+		return append(out, pb) // late: the SRT server sorts it out
+	}
+	if off >= ReorderSlots {
+		// Too far ahead to hold: release everything and start over here
+		out = r.flush(now, out)
+		r.next = sn
+	}
+	slot := sn & (ReorderSlots - 1)
+	if r.slots[slot] != nil {
+		return append(out, pb) // a copy of a packet that is waiting already
+	}
+	r.slots[slot], r.arrived[slot] = pb, now
+	r.count++
+	return r.release(now, out)
+}
+
+// release appends the packets that are in order from r.next on to out.
+func (r *reorderBuffer) release(now time.Time, out []*packetBuf) []*packetBuf {
+	for r.count > 0 {
+		slot := r.next & (ReorderSlots - 1)
+		pb := r.slots[slot]
+		if pb == nil {
+			break
+		}
+		r.slots[slot] = nil
+		r.count--
+		r.wait.observe(int64(now.Sub(r.arrived[slot])), now)
+		out = append(out, pb)
+		r.next = (r.next + 1) & 0x7fffffff
+	}
+	r.held.Store(int64(r.count))
+	return out
+}
+
+// skip moves past the gap up to the first buffered packet.
+func (r *reorderBuffer) skip() {
+	off := r.firstWaiting()
+	r.skipped.Add(uint64(off))
+	r.next = (r.next + off) & 0x7fffffff
+}
+
+// firstWaiting returns the offset from r.next of the first buffered packet.
+func (r *reorderBuffer) firstWaiting() int32 {
+	for off := int32(0); off < ReorderSlots; off++ {
+		if r.slots[(r.next+off)&(ReorderSlots-1)] != nil {
+			return off
+		}
+	}
+	return -1
+}
+
+// deadline returns when the packet waiting behind the current gap has to be
+// released. ok is false if nothing is waiting.
+func (r *reorderBuffer) deadline() (t time.Time, ok bool) {
+	if r.count == 0 {
+		return time.Time{}, false
+	}
+	off := r.firstWaiting()
+	return r.arrived[(r.next+off)&(ReorderSlots-1)].Add(reorderDelay), true
+}
+
+// expire gives up on gaps whose following packet waited for reorderDelay.
+func (r *reorderBuffer) expire(now time.Time, out []*packetBuf) []*packetBuf {
+	for {
+		t, ok := r.deadline()
+		if !ok || now.Before(t) {
+			return out
+		}
+		r.skip()
+		out = r.release(now, out)
+	}
+}
+
+// flush appends all buffered packets to out in sequence order.
+func (r *reorderBuffer) flush(now time.Time, out []*packetBuf) []*packetBuf {
+	for r.count > 0 {
+		r.skip()
+		out = r.release(now, out)
+	}
+	return out
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ReplayLinger    = 2 * time.Second // wait for the server's last replies
+	ReplayReplyWait = 1 * time.Second // longest a packet waits for the reply it depends on
+)
+
+// replayGroup is a recorded SRTLA sender. The server hands out a new group
+// ID, and its SRT receiver a new cookie and socket ID, so the replay puts
+// those in place of the recorded ones.
+type replayGroup struct {
+	newID      []byte // from the server's REG2
+	cookie     uint32 // from the SRT receiver's induction response
+	peerSocket uint32 // from its conclusion response
+
+	// closed once the value above is known: the recorded sender waited
+	// for these replies, which come later in a replay than in the capture
+	registered, inducted, concluded chan struct{}
+}
+
+func newReplayGroup() *replayGroup {
+	return &replayGroup{registered: make(chan struct{}), inducted: make(chan struct{}), concluded: make(chan struct{})}
+}
+
+// learned closes ch unless it is closed already.
+func learned(ch chan struct{}) {
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// replayLink is a recorded sender address, replayed from a socket of its
+// own.
+type replayLink struct {
+	recorded netip.AddrPort
+	conn     *net.UDPConn
+	group    *replayGroup
+	sent     int
+	replies  map[string]int // by packet type
+}
+
+type replayer struct {
+	server *net.UDPAddr
+	mu     sync.Mutex
+	links  map[netip.AddrPort]*replayLink
+	groups map[string]*replayGroup // by the sender's half of the group ID
+}
+
+// runReplay implements the "replay" subcommand: it sends what the senders
+// of a captured session sent to an SRTLA server, with the recorded timing,
+// to reproduce problems reported from the field.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("file", "", "pcap file recorded with -capture, or by tcpdump on the server")
+	server := fs.String("server", "127.0.0.1:5000", "SRTLA server to replay the session against")
+	port := fs.Int("port", 0, "SRTLA port of the recorded server, 0 takes the destination of the first packet")
+	speed := fs.Float64("speed", 1, "Replay speed, 2 plays twice as fast; 0 sends as fast as possible")
+	fs.Parse(args)
+
+	if *file == "" {
+		log.Fatalf("ERROR: replay requires -file")
+	}
+	raddr, err := net.ResolveUDPAddr("udp", *server)
+	if err != nil {
+		log.Fatalf("ERROR: failed to resolve -server: %v", err)
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	defer f.Close()
+	pr, err := newPcapReader(f)
+	if err != nil {
+		log.Fatalf("ERROR: %s: %v", *file, err)
+	}
+
+	r := &replayer{server: raddr, links: map[netip.AddrPort]*replayLink{}, groups: map[string]*replayGroup{}}
+	log.Printf("[replay] Replaying %s against %s", *file, raddr)
+	var first time.Time
+	start := time.Now()
+	srtlaPort := uint16(*port)
+	for {
+		p, err := pr.next()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			log.Fatalf("ERROR: %s: %v", *file, err)
+		}
+		if srtlaPort == 0 {
+			srtlaPort = p.dst.Port()
+			log.Printf("[replay] Recorded SRTLA port %d", srtlaPort)
+		}
+		if p.dst.Port() != srtlaPort || p.src.Port() == srtlaPort {
+			continue // the server's replies, or other traffic
+		}
+		if first.IsZero() {
+			first = p.at
+		}
+		if *speed > 0 {
+			due := start.Add(time.Duration(float64(p.at.Sub(first)) / *speed))
+			time.Sleep(time.Until(due))
+		}
+		r.send(p)
+	}
+	if first.IsZero() {
+		log.Fatalf("ERROR: %s has no packets to SRTLA port %d", *file, srtlaPort)
+	}
+	time.Sleep(ReplayLinger)
+	r.summary(time.Since(start))
+}
+
+func (r *replayer) send(p capturedPacket) {
+	r.mu.Lock()
+	l := r.links[p.src]
+	if l == nil {
+		conn, err := net.DialUDP("udp", nil, r.server)
+		if err != nil {
+			r.mu.Unlock()
+			log.Printf("[replay] Failed to open a socket for %s: %v", p.src, err)
+			return
+		}
+		l = &replayLink{recorded: p.src, conn: conn, replies: map[string]int{}}
+		r.links[p.src] = l
+		log.Printf("[replay] [%s] Replaying from %s", p.src, conn.LocalAddr())
+		go supervise("replay-link", func() { r.readReplies(l) })
+	}
+	if wait := r.dependency(l, p.data); wait != nil {
+		r.mu.Unlock()
+		select {
+		case <-wait:
+		case <-time.After(ReplayReplyWait):
+		}
+		r.mu.Lock()
+	}
+	pkt := r.rewrite(l, p.data)
+	l.sent++
+	r.mu.Unlock()
+	l.conn.Write(pkt)
+}
+
+// group returns the recorded group of a REG1 or REG2 ID.
+func (r *replayer) group(id []byte) *replayGroup {
+	key := string(id[:SRTLAIDLen/2])
+	g := r.groups[key]
+	if g == nil {
+		g = newReplayGroup()
+		r.groups[key] = g
+	}
+	return g
+}
+
+// dependency must be called with r.mu held. It returns what pkt has to
+// wait for before rewrite knows the new IDs to put in, nil when nothing.
+func (r *replayer) dependency(l *replayLink, pkt []byte) chan struct{} {
+	typ := getSRTType(pkt)
+	if id := srtlaRegID(pkt, SRTLATypeReg2); id != nil {
+		if g := r.group(id); g.newID == nil {
+			return g.registered
+		}
+		return nil
+	}
+	g := l.group
+	switch {
+	case g == nil || len(pkt) < SRTMinLen || typ>>12 == SRTLATypeKeepalive>>12:
+	case typ == SRTTypeHandshake:
+		if hs, err := parseSRTHandshake(pkt); err == nil && hs.Type == SRTHandshakeConclusion && g.cookie == 0 {
+			return g.inducted
+		}
+	case srtDestID(pkt) != 0 && g.peerSocket == 0:
+		return g.concluded
+	}
+	return nil
+}
+
+// rewrite must be called with r.mu held. It replaces the IDs the server
+// and its SRT receiver assigned in the recorded session with those of
+// this one.
+func (r *replayer) rewrite(l *replayLink, pkt []byte) []byte {
+	typ := getSRTType(pkt)
+	switch {
+	case typ == SRTLATypeReg1 || typ == SRTLATypeReg2:
+		id := srtlaRegID(pkt, typ)
+		if id == nil {
+			return pkt
+		}
+		g := r.group(id)
+		l.group = g
+		if typ == SRTLATypeReg2 && g.newID != nil {
+			copy(pkt[2:], g.newID)
+		}
+	case typ>>12 == SRTLATypeKeepalive>>12:
+		// other SRTLA packets carry no IDs to replace
+	case l.group == nil:
+	case typ == SRTTypeHandshake:
+		hs, err := parseSRTHandshake(pkt)
+		if err == nil && hs.Type == SRTHandshakeConclusion && l.group.cookie != 0 {
+			binary.BigEndian.PutUint32(pkt[SRTMinLen+28:], l.group.cookie)
+		}
+	case len(pkt) >= SRTMinLen && l.group.peerSocket != 0:
+		if srtDestID(pkt) != 0 {
+			binary.BigEndian.PutUint32(pkt[12:16], l.group.peerSocket)
+		}
+	}
+	return pkt
+}
+
+func (r *replayer) readReplies(l *replayLink) {
+	buf := make([]byte, MTU)
+	for {
+		n, err := l.conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			time.Sleep(100 * time.Millisecond) // ICMP errors
+			continue
+		}
+		pkt := buf[:n]
+		r.mu.Lock()
+		typ := getSRTType(pkt)
+		name := replyName(pkt)
+		l.replies[name]++
+		switch {
+		case typ == SRTLATypeReg2:
+			if id := srtlaRegID(pkt, typ); id != nil {
+				if g := r.groups[string(id[:SRTLAIDLen/2])]; g != nil && g.newID == nil {
+					g.newID = append([]byte(nil), id...)
+					learned(g.registered)
+				}
+			}
+		case typ == SRTLATypeRegErr || typ == SRTLATypeRegNGP:
+			log.Printf("[replay] [%s] Server answered %s", l.recorded, name)
+		case typ == SRTTypeHandshake && l.group != nil:
+			if hs, err := parseSRTHandshake(pkt); err == nil {
+				switch hs.Type {
+				case SRTHandshakeInduction:
+					l.group.cookie = hs.SynCookie
+					learned(l.group.inducted)
+				case SRTHandshakeConclusion:
+					l.group.peerSocket = hs.SourceID
+					learned(l.group.concluded)
+				}
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// replyName names a packet from the server for the summary.
+func replyName(pkt []byte) string {
+	switch getSRTType(pkt) {
+	case SRTLATypeKeepalive:
+		return "keepalive"
+	case SRTLATypeACK:
+		return "srtla-ack"
+	case SRTLATypeReg2:
+		return "reg2"
+	case SRTLATypeReg3:
+		return "reg3"
+	case SRTLATypeRegErr:
+		return "reg-err"
+	case SRTLATypeRegNGP:
+		return "reg-ngp"
+	case SRTLATypeCongestion:
+		return "congestion"
+	case SRTTypeHandshake:
+		return "srt-handshake"
+	case SRTTypeACK:
+		return "srt-ack"
+	case SRTTypeNAK:
+		return "srt-nak"
+	case SRTTypeShutdown:
+		return "srt-shutdown"
+	}
+	if getSRTSN(pkt) >= 0 {
+		return "srt-data"
+	}
+	return fmt.Sprintf("0x%04x", getSRTType(pkt))
+}
+
+func (r *replayer) summary(elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	log.Printf("[replay] Done in %v: %d links, %d groups", elapsed.Round(time.Millisecond), len(r.links), len(r.groups))
+	for _, l := range r.links {
+		var names []string
+		for name := range l.replies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		replies := ""
+		for _, name := range names {
+			replies += fmt.Sprintf(" %s=%d", name, l.replies[name])
+		}
+		log.Printf("[replay] [%s] %d packets sent, replies:%s", l.recorded, l.sent, replies)
+		l.conn.Close()
+	}
+}
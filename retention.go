@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const RetentionCheckPeriod = 30 * time.Second
+
+// retention prunes the recordings in dir by total size and age, and alerts
+// when the disk is running out of space, see -record-max-gb,
+// -record-max-age and -record-min-free-gb.
+type retention struct {
+	dir      string
+	maxBytes int64         // 0 is unlimited
+	maxAge   time.Duration // 0 is unlimited
+	minFree  int64         // 0 disables the alert
+
+	low bool
+}
+
+type recordingFile struct {
+	path     string
+	size     int64
+	modTime  time.Time
+	uploaded bool // safe to delete, or it is not going to be uploaded
+}
+
+func startRetention(r *retention) {
+	maxAge := "unlimited"
+	if r.maxAge > 0 {
+		maxAge = r.maxAge.String()
+	}
+	log.Printf("[retention] Keeping recordings in %s: max %s, max age %s, alerting below %s free",
+		r.dir, formatLimit(r.maxBytes), maxAge, formatLimit(r.minFree))
+	go supervise("retention", func() {
+		for {
+			r.check()
+			time.Sleep(RetentionCheckPeriod)
+		}
+	})
+}
+
+func formatLimit(bytes int64) string {
+	if bytes == 0 {
+		return "unlimited"
+	}
+	return gigabytes(bytes)
+}
+
+func gigabytes(bytes int64) string {
+	return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+}
+
+func (r *retention) check() {
+	r.prune()
+	if r.minFree == 0 {
+		return
+	}
+	free, ok := diskFree(r.dir)
+	if !ok {
+		return
+	}
+	if free < r.minFree && !r.low {
+		r.low = true
+		log.Printf("[retention] Only %s free in %s, recording will fail when the disk is full", gigabytes(free), r.dir)
+		emitEvent("record.low_space", map[string]any{"dir": r.dir, "freeBytes": free, "thresholdBytes": r.minFree})
+	} else if free >= r.minFree && r.low {
+		r.low = false
+		log.Printf("[retention] %s free again in %s", gigabytes(free), r.dir)
+		emitEvent("record.space_recovered", map[string]any{"dir": r.dir, "freeBytes": free})
+	}
+}
+
+// prune deletes recordings older than maxAge, then the oldest ones until
+// all of them fit in maxBytes. Recordings still waiting for an upload are
+// kept past maxAge, and only deleted for size when nothing else is left.
+func (r *retention) prune() {
+	files := r.recordings()
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	now := time.Now()
+	kept := files[:0]
+	for _, f := range files {
+		if r.maxAge > 0 && f.uploaded && now.Sub(f.modTime) > r.maxAge {
+			if r.remove(f, "age") {
+				total -= f.size
+				continue
+			}
+		}
+		kept = append(kept, f)
+	}
+	if r.maxBytes == 0 {
+		return
+	}
+	for _, uploadedOnly := range []bool{true, false} {
+		for i := 0; i < len(kept) && total > r.maxBytes; i++ {
+			f := kept[i]
+			if f.path == "" || uploadedOnly && !f.uploaded {
+				continue
+			}
+			if r.remove(f, "size") {
+				total -= f.size
+			}
+			kept[i].path = ""
+		}
+	}
+}
+
+// recordings lists the finished recordings, oldest first.
+func (r *retention) recordings() []recordingFile {
+	paths, _ := filepath.Glob(filepath.Join(r.dir, "go-irl-*.ts"))
+	var files []recordingFile
+	for _, path := range paths {
+		if rec != nil && rec.recording(path) {
+			continue
+		}
+		if upl != nil && upl.busy(path) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		f := recordingFile{path: path, size: info.Size(), modTime: info.ModTime(), uploaded: true}
+		if upl != nil {
+			_, err := os.Stat(path + UploadDoneMarkerSuffix)
+			f.uploaded = err == nil
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	return files
+}
+
+func (r *retention) remove(f recordingFile, reason string) bool {
+	if err := os.Remove(f.path); err != nil {
+		log.Printf("[retention] Failed to delete %s: %v", f.path, err)
+		return false
+	}
+	os.Remove(f.path + UploadDoneMarkerSuffix)
+	// The session's sidecar goes with its last file
+	if base := filepath.Base(f.path); len(base) >= len(RecordSessionLayout) {
+		session := filepath.Join(r.dir, base[:len(RecordSessionLayout)])
+		if rest, _ := filepath.Glob(session + "*.ts"); len(rest) == 0 {
+			os.Remove(session + ".json")
+		}
+	}
+	if !f.uploaded {
+		log.Printf("[retention] Deleted %s (%s) before it was uploaded, over -record-max-gb", f.path, gigabytes(f.size))
+	} else {
+		log.Printf("[retention] Deleted %s (%s, %s)", f.path, gigabytes(f.size), reason)
+	}
+	emitEvent("record.pruned", map[string]any{"file": f.path, "bytes": f.size, "reason": reason})
+	return true
+}
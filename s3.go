@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	S3PartSize       = 16 << 20 // multipart upload part size, S3 needs at least 5 MB
+	S3PartRetries    = 3
+	S3RequestTimeout = 10 * time.Minute
+)
+
+// s3Target is an S3-compatible bucket location (AWS, MinIO, Backblaze B2,
+// ...), given as s3://bucket/prefix?endpoint=https://host&region=name.
+// Credentials come from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+// Objects are addressed path-style, endpoint/bucket/key, which every
+// S3-compatible service understands.
+type s3Target struct {
+	endpoint *url.URL
+	region   string
+	bucket   string
+	prefix   string
+
+	accessKey, secretKey string
+
+	client *http.Client
+}
+
+func parseS3Target(spec string) (*s3Target, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("expected s3://bucket/prefix")
+	}
+	q := u.Query()
+	t := &s3Target{
+		region:    q.Get("region"),
+		bucket:    u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		client:    &http.Client{Timeout: S3RequestTimeout},
+	}
+	if t.region == "" {
+		t.region = "us-east-1"
+	}
+	endpoint := q.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "https://s3." + t.region + ".amazonaws.com"
+	}
+	if t.endpoint, err = url.Parse(endpoint); err != nil || t.endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	if t.accessKey == "" || t.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return t, nil
+}
+
+func (t *s3Target) String() string {
+	return "s3://" + t.bucket + "/" + t.prefix
+}
+
+// key returns the object key for a file name.
+func (t *s3Target) key(name string) string {
+	if t.prefix == "" {
+		return name
+	}
+	return t.prefix + "/" + name
+}
+
+// do sends a signed request for key. body may be nil.
+func (t *s3Target) do(method, key string, query url.Values, body []byte) (http.Header, []byte, error) {
+	u := *t.endpoint
+	u.Path = "/" + t.bucket + "/" + key
+	u.RawPath = s3EscapePath(u.Path) // send the path exactly as it is signed
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	t.sign(req, u.RawPath, u.RawQuery, time.Now().UTC())
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, bytes.TrimSpace(data))
+	}
+	return resp.Header, data, nil
+}
+
+// sign adds an AWS Signature Version 4 to req. The payload is not hashed
+// (UNSIGNED-PAYLOAD), so parts do not have to be read twice; TLS protects
+// them in transit.
+func (t *s3Target) sign(req *http.Request, path, query string, now time.Time) {
+	signV4(req, path, query, "UNSIGNED-PAYLOAD", "s3", t.region, t.accessKey, t.secretKey, now)
+}
+
+// signV4 adds an AWS Signature Version 4 for service to req, path and
+// query being its canonical (escaped) forms.
+func signV4(req *http.Request, path, query, payloadHash, service, region, accessKey, secretKey string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		query,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape is the URI encoding of SigV4: everything but the unreserved
+// characters is percent-encoded.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(path string) string {
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		segs[i] = s3Escape(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, s3Escape(k)+"="+s3Escape(q.Get(k)))
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Multipart is an S3 multipart upload in progress.
+type s3Multipart struct {
+	t        *s3Target
+	key      string
+	uploadID string
+	etags    []string
+}
+
+func (t *s3Target) createMultipart(key string) (*s3Multipart, error) {
+	_, data, err := t.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &res); err != nil || res.UploadID == "" {
+		return nil, fmt.Errorf("create multipart upload %s: no upload ID in response", key)
+	}
+	return &s3Multipart{t: t, key: key, uploadID: res.UploadID}, nil
+}
+
+// uploadPart uploads the next part. Every part but the last must be at
+// least 5 MB.
+func (m *s3Multipart) uploadPart(part []byte) error {
+	query := url.Values{"partNumber": {strconv.Itoa(len(m.etags) + 1)}, "uploadId": {m.uploadID}}
+	header, _, err := m.t.do(http.MethodPut, m.key, query, part)
+	if err != nil {
+		return err
+	}
+	m.etags = append(m.etags, header.Get("ETag"))
+	return nil
+}
+
+func (m *s3Multipart) complete() error {
+	var body bytes.Buffer
+	body.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range m.etags {
+		fmt.Fprintf(&body, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, etag)
+	}
+	body.WriteString("</CompleteMultipartUpload>")
+	_, data, err := m.t.do(http.MethodPost, m.key, url.Values{"uploadId": {m.uploadID}}, body.Bytes())
+	if err != nil {
+		return err
+	}
+	// S3 may report a failure with 200 OK once it started responding
+	if bytes.Contains(data, []byte("<Error>")) {
+		return fmt.Errorf("complete multipart upload %s: %s", m.key, bytes.TrimSpace(data))
+	}
+	return nil
+}
+
+func (m *s3Multipart) abort() {
+	m.t.do(http.MethodDelete, m.key, url.Values{"uploadId": {m.uploadID}}, nil)
+}
+
+// upload sends a file as a multipart upload, named after the file.
+func (t *s3Target) upload(path string, progress func(sent int64)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m, err := t.createMultipart(t.key(filepath.Base(path)))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, S3PartSize)
+	var sent int64
+	for {
+		n, err := io.ReadFull(f, buf)
+		if err == io.EOF && len(m.etags) > 0 {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			m.abort()
+			return err
+		}
+		for try := 1; ; try++ {
+			perr := m.uploadPart(buf[:n])
+			if perr == nil {
+				break
+			}
+			if try == S3PartRetries {
+				m.abort()
+				return perr
+			}
+			log.Printf("[upload] Part %d of %s failed, retrying: %v", len(m.etags)+1, path, perr)
+			time.Sleep(time.Duration(try) * time.Second)
+		}
+		sent += int64(n)
+		progress(sent)
+		if n < len(buf) {
+			break
+		}
+	}
+	if err := m.complete(); err != nil {
+		m.abort()
+		return err
+	}
+	return nil
+}
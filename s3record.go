@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	S3RecordMemParts      = 2   // parts kept in memory while S3 is behind, later ones are spilled to disk
+	S3RecordQueueLen      = 256 // parts waiting to be uploaded, 4 GB
+	S3RecordRetries       = 10  // per part, about 4 minutes with S3RecordMaxRetryDelay
+	S3RecordMaxRetryDelay = 30 * time.Second
+)
+
+// s3Part is a part of a recording waiting to be uploaded, in memory or
+// spilled to a file.
+type s3Part struct {
+	data  []byte
+	spill string
+}
+
+// s3Recording streams a recording straight into an S3 multipart upload,
+// for servers without the disk space to keep long streams. Full parts are
+// uploaded in the background. While the upload is behind, the first
+// S3RecordMemParts parts wait in memory and any further ones in spillDir.
+type s3Recording struct {
+	t        *s3Target
+	name     string
+	spillDir string
+	cur      []byte
+	parts    chan s3Part
+	done     func(error)
+
+	mu           sync.Mutex
+	inMemory     int
+	failed       error // the upload gave up, later parts are dropped
+	spillWarned  bool
+	spilledParts int
+}
+
+func newS3Recording(t *s3Target, name, spillDir string) *s3Recording {
+	s := &s3Recording{
+		t:        t,
+		name:     name,
+		spillDir: spillDir,
+		cur:      make([]byte, 0, S3PartSize),
+		parts:    make(chan s3Part, S3RecordQueueLen),
+	}
+	go s.run()
+	return s
+}
+
+func (s *s3Recording) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	failed := s.failed
+	s.mu.Unlock()
+	if failed != nil {
+		return 0, failed
+	}
+	s.cur = append(s.cur, b...)
+	if len(s.cur) >= S3PartSize {
+		if err := s.queue(s.cur); err != nil {
+			return 0, err
+		}
+		s.cur = make([]byte, 0, S3PartSize)
+	}
+	return len(b), nil
+}
+
+// Flush does nothing, parts are only uploaded once they are full.
+func (s *s3Recording) Flush() error { return nil }
+
+// queue hands a part to the uploader, spilling it to disk if enough parts
+// are already waiting in memory.
+func (s *s3Recording) queue(data []byte) error {
+	s.mu.Lock()
+	spill := s.inMemory >= S3RecordMemParts
+	if !spill {
+		s.inMemory++
+	}
+	s.mu.Unlock()
+	part := s3Part{data: data}
+	if spill {
+		s.spilledParts++
+		path := filepath.Join(s.spillDir, fmt.Sprintf("%s.part%d", s.name, s.spilledParts))
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			os.Remove(path)
+			return fmt.Errorf("spilling to %s: %w", s.spillDir, err)
+		}
+		if !s.spillWarned {
+			s.spillWarned = true
+			log.Printf("[record] Upload of %s is falling behind, spilling parts to %s", s.name, s.spillDir)
+		}
+		part = s3Part{spill: path}
+	}
+	select {
+	case s.parts <- part:
+		return nil
+	default:
+		if part.spill != "" {
+			os.Remove(part.spill)
+		}
+		return fmt.Errorf("upload of %s is %d parts behind", s.name, S3RecordQueueLen)
+	}
+}
+
+// finish queues the last part; done runs once the upload is completed.
+func (s *s3Recording) finish(done func(error)) {
+	s.done = done
+	if len(s.cur) > 0 {
+		if err := s.queue(s.cur); err != nil {
+			s.mu.Lock()
+			if s.failed == nil {
+				s.failed = err
+			}
+			s.mu.Unlock()
+		}
+		s.cur = nil
+	}
+	close(s.parts)
+}
+
+func (s *s3Recording) run() {
+	var m *s3Multipart
+	err := s.retry("starting the upload", func() (err error) {
+		m, err = s.t.createMultipart(s.t.key(s.name))
+		return err
+	})
+	if err != nil {
+		s.fail(err)
+	}
+	for part := range s.parts {
+		data, rerr := part.data, error(nil)
+		if part.spill != "" {
+			data, rerr = os.ReadFile(part.spill)
+			os.Remove(part.spill)
+		} else {
+			s.mu.Lock()
+			s.inMemory--
+			s.mu.Unlock()
+		}
+		if err != nil {
+			continue // gave up, drop the rest
+		}
+		if err = rerr; err == nil {
+			err = s.retry(fmt.Sprintf("part %d", len(m.etags)+1), func() error { return m.uploadPart(data) })
+		}
+		if err != nil {
+			s.fail(err)
+		}
+	}
+	// s.parts is closed, so finish has run
+	s.mu.Lock()
+	if err == nil {
+		err = s.failed
+	}
+	s.mu.Unlock()
+	if err == nil {
+		err = s.retry("completing the upload", m.complete)
+	}
+	if err != nil && m != nil {
+		m.abort()
+	}
+	s.done(err)
+}
+
+// fail makes later writes fail so the recorder stops this session.
+func (s *s3Recording) fail(err error) {
+	s.mu.Lock()
+	if s.failed == nil {
+		s.failed = err
+	}
+	s.mu.Unlock()
+}
+
+func (s *s3Recording) retry(what string, fn func() error) error {
+	delay := time.Second
+	for try := 1; ; try++ {
+		err := fn()
+		if err == nil || try == S3RecordRetries {
+			return err
+		}
+		log.Printf("[record] Uploading %s of %s failed, retrying in %s: %v", what, s.name, delay, err)
+		time.Sleep(delay)
+		delay = min(delay*2, S3RecordMaxRetryDelay)
+	}
+}
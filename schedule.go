@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ScheduleCheckPeriod    = 15 * time.Second
+	ScheduleAlertInterval  = 1 * time.Minute // at most one out-of-schedule alert per interval
+	ScheduleWebhookTimeout = 5 * time.Second
+
+	SchedulePolicyAlert  = "alert"
+	SchedulePolicyReject = "reject"
+)
+
+// scheduleWindow is a weekly recurring time range in local time. A window
+// whose end is before its start runs past midnight into the next day.
+type scheduleWindow struct {
+	days       [7]bool // indexed by time.Weekday
+	start, end int     // minutes since midnight
+}
+
+// schedule arms ingest during its windows. Outside of them connections are
+// alerted on or rejected, depending on the policy.
+type schedule struct {
+	windows []scheduleWindow
+	policy  string
+	webhook string
+
+	mu        sync.Mutex
+	lastAlert time.Time
+}
+
+// activeSchedule is nil when no -schedule is configured, which means ingest
+// is always armed.
+var activeSchedule *schedule
+
+// srtLastData is when the SRT proxy last forwarded data, in unix nanoseconds.
+var srtLastData atomic.Int64
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseSchedule parses windows like "mon-fri 18:00-23:00; sat,sun 12:00-02:00".
+// Days are optional ("18:00-23:00" means every day).
+func parseSchedule(spec, policy, webhook string) (*schedule, error) {
+	if policy != SchedulePolicyAlert && policy != SchedulePolicyReject {
+		return nil, fmt.Errorf("unknown policy %q (expected %s or %s)", policy, SchedulePolicyAlert, SchedulePolicyReject)
+	}
+	s := &schedule{policy: policy, webhook: webhook}
+	for _, entry := range strings.Split(spec, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		var w scheduleWindow
+		switch len(fields) {
+		case 1:
+			for d := range w.days {
+				w.days[d] = true
+			}
+		case 2:
+			if err := parseScheduleDays(fields[0], &w.days); err != nil {
+				return nil, err
+			}
+			fields = fields[1:]
+		default:
+			return nil, fmt.Errorf("%q: expected [days] HH:MM-HH:MM", strings.TrimSpace(entry))
+		}
+		from, to, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected HH:MM-HH:MM", fields[0])
+		}
+		var err error
+		if w.start, err = parseClock(from); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseClock(to); err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	if len(s.windows) == 0 {
+		return nil, fmt.Errorf("no time windows")
+	}
+	return s, nil
+}
+
+func parseScheduleDays(spec string, days *[7]bool) error {
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		if part == "daily" || part == "*" {
+			for d := range days {
+				days[d] = true
+			}
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return fmt.Errorf("unknown day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return fmt.Errorf("unknown day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	h, err1 := strconv.Atoi(hh)
+	m, err2 := strconv.Atoi(mm)
+	if !ok || err1 != nil || err2 != nil || h < 0 || h > 24 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	return h*60 + m, nil
+}
+
+// contains reports whether t falls into the window.
+func (w scheduleWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	if w.start <= w.end {
+		return w.days[today] && minute >= w.start && minute < w.end
+	}
+	// Past midnight: the window belongs to the day it started on
+	return (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+}
+
+func (s *schedule) armed(t time.Time) bool {
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleAdmits is called for every new ingest connection. It returns
+// false if the connection must be rejected because it is out of schedule.
+func scheduleAdmits(kind, peer string) bool {
+	s := activeSchedule
+	if s == nil || s.armed(clk.Now()) {
+		return true
+	}
+
+	if s.alertDue() {
+		s.notify("schedule.out_of_schedule", map[string]any{
+			"kind":   kind,
+			"peer":   peer,
+			"policy": s.policy,
+		})
+	}
+	return s.policy != SchedulePolicyReject
+}
+
+// ingestLive reports whether a stream is coming in right now.
+func ingestLive() bool {
+	if len(groupList()) > 0 {
+		return true
+	}
+	last := srtLastData.Load()
+	return last != 0 && time.Since(time.Unix(0, last)) < ScheduleCheckPeriod
+}
+
+// run tracks window transitions and keeps alerting while a stream is still
+// live after its window closed.
+func (s *schedule) run() {
+	ticker := time.NewTicker(ScheduleCheckPeriod)
+	defer ticker.Stop()
+
+	armed := s.armed(clk.Now())
+	log.Printf("[schedule] %d windows, policy %s, ingest currently %s", len(s.windows), s.policy, armedString(armed))
+	for range ticker.C {
+		now := clk.Now()
+		wasArmed := armed
+		armed = s.armed(now)
+		switch {
+		case armed && !wasArmed:
+			s.notify("schedule.window_start", nil)
+		case !armed && wasArmed:
+			s.notify("schedule.window_end", map[string]any{"live": ingestLive()})
+		case !armed && ingestLive() && s.alertDue():
+			s.notify("schedule.live_out_of_schedule", nil)
+		}
+	}
+}
+
+// alertDue rate limits out-of-schedule alerts to one per ScheduleAlertInterval.
+func (s *schedule) alertDue() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastAlert) < ScheduleAlertInterval {
+		return false
+	}
+	s.lastAlert = time.Now()
+	return true
+}
+
+func armedString(armed bool) string {
+	if armed {
+		return "armed"
+	}
+	return "disarmed"
+}
+
+// notify emits the event and posts it to the webhook, if one is configured.
+func (s *schedule) notify(name string, fields map[string]any) {
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	emitEvent(name, fields)
+	if s.webhook == "" {
+		return
+	}
+
+	body, err := json.Marshal(event{Timestamp: time.Now(), Type: "event", Name: name, Fields: fields})
+	if err != nil {
+		return
+	}
+	go func() {
+		client := &http.Client{Timeout: ScheduleWebhookTimeout}
+		resp, err := client.Post(s.webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("[schedule] Webhook failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("[schedule] Webhook returned %s", resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+const (
+	SendRedialPeriod = 2 * time.Second
+	SendLinger       = 1 * time.Second
+)
+
+// runSend implements the "send" subcommand: it reads MPEG-TS from a local
+// input, e.g. the UDP output of ffmpeg or OBS, and publishes it to a remote
+// SRT listener, or over bonded links to an SRTLA server.
+func runSend(args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	input := fs.String("input", "udp://127.0.0.1:5003", "MPEG-TS input: udp://host:port to listen on, or - for stdin")
+	output := fs.String("output", "", "Where to publish: srt://host:port?streamid=...&passphrase=... or srtla://host:port with -links")
+	links := fs.String("links", "", "Uplinks for an srtla:// output, as for the bond subcommand")
+	exclude := fs.String("exclude", BondDefaultExclude, "Interface name patterns ignored by -links=auto")
+	wsPort := fs.Int("ws-port", 0, "WebSocket port publishing the SRT sender stats, 0 disables it")
+	fs.Parse(args)
+
+	if *output == "" {
+		log.Fatalf("ERROR: send requires -output")
+	}
+	u, err := url.Parse(*output)
+	if err != nil {
+		log.Fatalf("ERROR: invalid -output: %v", err)
+	}
+	switch u.Scheme {
+	case "srt":
+	case "srtla":
+		if *links == "" {
+			log.Fatalf("ERROR: an srtla:// output requires -links")
+		}
+		b := newBondSender(bondConfig{Server: u.Host, Listen: "127.0.0.1:0", Links: *links, Exclude: *exclude})
+		b.start()
+		u.Scheme, u.Host = "srt", b.local.LocalAddr().String()
+	default:
+		log.Fatalf("ERROR: unsupported -output %q (expected srt:// or srtla://)", *output)
+	}
+	config := srt.DefaultConfig()
+	if err := config.UnmarshalQuery(u.RawQuery); err != nil {
+		log.Fatalf("ERROR: invalid -output: %v", err)
+	}
+
+	in, err := openInput(*input)
+	if err != nil {
+		log.Fatalf("ERROR: failed to open -input: %v", err)
+	}
+	log.Printf("[send] Publishing %s to %s", *input, *output)
+
+	st := &stats{interval: statsInterval, summaryInterval: statsSummaryInterval, hub: runStatsHub(*wsPort)}
+	done := make(chan struct{})
+	go func() {
+		supervise("send", func() { sendStream(in, u.Host, config, st) })
+		close(done)
+	}()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-signalChan:
+		log.Println("Shutdown signal received, exiting.")
+	}
+}
+
+// sendStream copies in to an SRT caller connection to addr, dialling again
+// whenever the connection breaks. Input read while disconnected is lost.
+func sendStream(in io.Reader, addr string, config srt.Config, st *stats) {
+	buf := make([]byte, SRTChunkSize)
+	var conn srt.Conn
+	for {
+		n, err := in.Read(buf)
+		if err == io.EOF {
+			log.Printf("[send] Input ended")
+			if conn != nil {
+				time.Sleep(SendLinger) // let the SRT sender flush its buffer
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("[send] Input read error: %v", err)
+			continue
+		}
+
+		if conn == nil {
+			if conn, err = srt.Dial("srt", addr, config); err != nil {
+				log.Printf("[send] Failed to connect to %s: %v. Retrying in %s...", addr, err, SendRedialPeriod)
+				time.Sleep(SendRedialPeriod)
+				continue
+			}
+			log.Printf("[send] Connected to %s", addr)
+			emitEvent("send.connected", map[string]any{"addr": addr})
+			st.writer = conn
+		}
+		if _, err := conn.Write(buf[:n]); err != nil {
+			log.Printf("[send] SRT write error: %v. Reconnecting...", err)
+			emitEvent("send.disconnected", map[string]any{"addr": addr, "error": err.Error()})
+			conn.Close()
+			conn = nil
+			continue
+		}
+		st.reportIfDue()
+	}
+}
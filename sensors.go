@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	SensorMaxCount    = 32
+	SensorMinInterval = 100 * time.Millisecond // per sensor, faster readings are dropped
+)
+
+// sensorMessage carries a reading of an external sensor (heart rate
+// monitor, thermometer, ...) to the browser source. Data is whatever JSON
+// the sensor's companion app sent.
+type sensorMessage struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Type      string          `json:"type"` // always "sensor"
+	Sensor    string          `json:"sensor"`
+	Data      json.RawMessage `json:"data"`
+}
+
+var sensors = struct {
+	mu     sync.Mutex
+	latest map[string]sensorMessage
+}{latest: map[string]sensorMessage{}}
+
+var sensorNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+var (
+	errSensorName  = errors.New("sensor names are 1-64 letters, digits, '_', '.' or '-'")
+	errSensorCount = errors.New("too many sensors")
+	errSensorRate  = errors.New("sensor readings are limited to ten per second per sensor")
+	errSensorData  = errors.New("sensor data must be JSON")
+)
+
+// updateSensor publishes a reading and keeps it as the sensor's latest.
+func updateSensor(name string, data json.RawMessage) error {
+	if !sensorNameRe.MatchString(name) {
+		return errSensorName
+	}
+	if len(data) == 0 || !json.Valid(data) {
+		return errSensorData
+	}
+	now := time.Now()
+	msg := sensorMessage{Timestamp: now, Type: "sensor", Sensor: name, Data: data}
+
+	sensors.mu.Lock()
+	last, ok := sensors.latest[name]
+	switch {
+	case !ok && len(sensors.latest) >= SensorMaxCount:
+		sensors.mu.Unlock()
+		return errSensorCount
+	case ok && now.Sub(last.Timestamp) < SensorMinInterval:
+		sensors.mu.Unlock()
+		return errSensorRate
+	}
+	sensors.latest[name] = msg
+	sensors.mu.Unlock()
+
+	publishMessage(msg)
+	return nil
+}
+
+func sensorSnapshot() []sensorMessage {
+	sensors.mu.Lock()
+	defer sensors.mu.Unlock()
+	out := make([]sensorMessage, 0, len(sensors.latest))
+	for _, msg := range sensors.latest {
+		out = append(out, msg)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Sensor < out[j].Sensor })
+	return out
+}
+
+// sensorWelcome returns the latest reading of every sensor for a newly
+// connected WebSocket client.
+func sensorWelcome() [][]byte {
+	var msgs [][]byte
+	for _, msg := range sensorSnapshot() {
+		if data, err := json.Marshal(msg); err == nil {
+			msgs = append(msgs, data)
+		}
+	}
+	return msgs
+}
+
+// handleSensors serves GET /api/sensors with the latest reading of every
+// sensor.
+func handleSensors(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, sensorSnapshot())
+}
+
+// handleSensorReading serves POST /api/sensors/{name}, the body being the
+// reading.
+func handleSensorReading(w http.ResponseWriter, r *http.Request) {
+	var data json.RawMessage
+	r.Body = http.MaxBytesReader(w, r.Body, MetadataMaxBytes)
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch err := updateSensor(r.PathValue("name"), data); err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errSensorRate:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errSensorCount:
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// ShutdownGrace is how long a group is kept after its sender's SRT
+// shutdown, for the group worker to forward it to the SRT server.
+const ShutdownGrace = 200 * time.Millisecond
+
+// endGroup tears down g after an SRT shutdown, reason "sender shutdown" or
+// "server shutdown", instead of waiting for its links to time out. The
+// group.removed event carries a summary of the session.
+func endGroup(g *Group, reason string, delay time.Duration) {
+	g.mu.Lock()
+	if g.closed || g.endReason != "" {
+		g.mu.Unlock()
+		return
+	}
+	g.endReason = reason
+	g.mu.Unlock()
+
+	clk.AfterFunc(delay, func() {
+		g.mu.Lock()
+		duration := clk.Now().Sub(g.createdAt)
+		links := len(g.conns)
+		var received uint64
+		for _, c := range g.conns {
+			received += c.rxBytes
+		}
+		g.mu.Unlock()
+
+		removeGroup(g)
+		log.Printf("[group %p] Removed (%s) after %s, %d links, %d MB received", g, reason, duration.Round(time.Second), links, received>>20)
+		emitEvent("group.removed", map[string]any{
+			"group":           fmt.Sprintf("%p", g),
+			"reason":          reason,
+			"durationSeconds": int(duration.Seconds()),
+			"links":           links,
+			"receivedBytes":   received,
+			"duplicates":      g.dupDrops.Load(),
+			"queueDrops":      g.workDrops.Load(),
+		})
+		publishLinks()
+	})
+}
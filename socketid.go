@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SRTHandshakeConclusion = 0xFFFFFFFF // handshake type of the HSv5 conclusion
+	SRTHandshakeInduction  = 1
+
+	MisroutedLogPeriod = 10 * time.Second
+)
+
+// srtSession holds the SRT socket IDs of a group's session, learned from
+// the handshakes passing through. Every SRT packet carries the socket ID of
+// its receiver; packets with another one belong to no session of the
+// group, e.g. stale packets of an earlier session or corrupt ones, and are
+// dropped instead of being left to the SRT server or the sender.
+type srtSession struct {
+	sender atomic.Uint32 // the sender's socket, 0 until its handshake
+	server atomic.Uint32 // the SRT server's, 0 until its conclusion
+
+	misrouted atomic.Uint64 // packets dropped for an unknown socket ID
+	lastLog   atomic.Int64  // unix nanoseconds of the last misrouted log
+}
+
+func srtDestID(pkt []byte) uint32 { return binary.BigEndian.Uint32(pkt[12:16]) }
+
+// fromSender learns the sender's socket from its handshakes and reports
+// whether pkt, at least SRTMinLen long, may be forwarded to the server.
+func (s *srtSession) fromSender(g *Group, pkt []byte) bool {
+	if getSRTType(pkt) == SRTTypeHandshake {
+		if hs, err := parseSRTHandshake(pkt); err == nil && hs.Type == SRTHandshakeInduction && hs.SourceID != s.sender.Load() {
+			// A new session, the server's socket is known again from its
+			// conclusion
+			s.sender.Store(hs.SourceID)
+			s.server.Store(0)
+		}
+		return true
+	}
+	return s.check(g, pkt, s.server.Load(), "sender")
+}
+
+// fromServer learns the server's socket from its conclusion and reports
+// whether pkt may be forwarded to the sender.
+func (s *srtSession) fromServer(g *Group, pkt []byte) bool {
+	if getSRTType(pkt) == SRTTypeHandshake {
+		if hs, err := parseSRTHandshake(pkt); err == nil && hs.Type == SRTHandshakeConclusion && srtDestID(pkt) == s.sender.Load() {
+			s.server.Store(hs.SourceID)
+		}
+		return true
+	}
+	return s.check(g, pkt, s.sender.Load(), "server")
+}
+
+func (s *srtSession) check(g *Group, pkt []byte, want uint32, from string) bool {
+	dest := srtDestID(pkt)
+	if want == 0 || dest == want {
+		return true
+	}
+	s.misrouted.Add(1)
+	now := clk.Now().UnixNano()
+	if last := s.lastLog.Load(); now-last >= int64(MisroutedLogPeriod) && s.lastLog.CompareAndSwap(last, now) {
+		log.Printf("[group %p] Dropping SRT packets from the %s for socket 0x%08x, the session's is 0x%08x (%d so far)", g, from, dest, want, s.misrouted.Load())
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,23 +10,15 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	srt "github.com/datarhei/gosrt"
 	"github.com/gorilla/websocket"
 )
 
-type listenerConn struct {
-	srt.Conn
-	listener srt.Listener
-}
-
-func (lc listenerConn) Close() error {
-	lc.listener.Close()
-	return lc.Conn.Close()
-}
-
 type writer interface {
 	io.WriteCloser
 }
@@ -44,20 +37,69 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+const (
+	WSPingPeriod   = 10 * time.Second
+	WSPongTimeout  = 30 * time.Second // clients that answer no ping for this long are closed
+	WSWriteTimeout = 5 * time.Second
+	WSClientQueue  = 64 // messages queued per client; while it is full, new ones are dropped for that client
+)
+
 type hub struct {
-	clients    map[*websocket.Conn]bool
-	broadcast  chan []byte
-	register   chan *websocket.Conn
-	unregister chan *websocket.Conn
+	clients    map[*wsClient]bool
+	broadcast  chan hubMessage
+	register   chan *wsClient
+	unregister chan *wsClient
 	mutex      sync.RWMutex
+
+	// for /metrics
+	broadcastDrops atomic.Uint64 // messages dropped because broadcast was full
+	clientDrops    atomic.Uint64 // per client copies dropped because its queue was full
+	sent           atomic.Uint64 // messages written to clients
+}
+
+// hubMessage is a message for the clients. The per-interval stats are
+// marked as detail, which clients asking for summaries only don't get.
+type hubMessage struct {
+	data   []byte
+	detail bool
 }
 
+// wsClient is one WebSocket connection. Its messages are written by its
+// own goroutine, so a stalled client (e.g. a crashed OBS whose TCP
+// connection is still half open) cannot hold up the others.
+type wsClient struct {
+	conn      *websocket.Conn
+	remote    string
+	connected time.Time
+	summary   bool        // connected with ?stats=summary
+	version   int         // message schema, see WSSchemaLatest
+	control   bool        // may send commands, see -control-token
+	send      chan []byte // closed by the hub when the client is unregistered
+	dropped   atomic.Int64
+	lastPong  atomic.Int64 // unix nanoseconds
+	rtt       atomic.Int64 // of the last ping, in nanoseconds
+}
+
+// wsClientDiagnostics is a client's entry in /api/diagnostics.
+type wsClientDiagnostics struct {
+	Remote           string  `json:"remote"`
+	ConnectedSeconds float64 `json:"connectedSeconds"`
+	LastPongSeconds  float64 `json:"lastPongSeconds"` // since the last pong, or since connecting
+	RttMs            float64 `json:"rttMs"`
+	Queued           int     `json:"queued"`
+	Dropped          int64   `json:"dropped"` // messages skipped while its queue was full
+	Alive            bool    `json:"alive"`   // answered within WSPingPeriod + WSWriteTimeout
+}
+
+// statsHub is the hub of the local stats WebSocket, nil without -ws-port.
+var statsHub *hub
+
 func newHub() *hub {
 	return &hub{
-		clients:    make(map[*websocket.Conn]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *websocket.Conn),
-		unregister: make(chan *websocket.Conn),
+		clients:    make(map[*wsClient]bool),
+		broadcast:  make(chan hubMessage, 64), // publishers drop messages when it is full
+		register:   make(chan *wsClient),
+		unregister: make(chan *wsClient),
 	}
 }
 
@@ -67,25 +109,42 @@ func (h *hub) run() {
 		case client := <-h.register:
 			h.mutex.Lock()
 			h.clients[client] = true
+			n := len(h.clients)
 			h.mutex.Unlock()
-			log.Printf("WebSocket client connected. Total clients: %d", len(h.clients))
+			log.Printf("WebSocket client %s connected. Total clients: %d", client.remote, n)
+			if client.version >= WSSchemaLatest {
+				h.queue(client, wsHello(client.version))
+			}
+			for _, msg := range welcomeMessages() {
+				h.queue(client, client.encode(msg))
+			}
 
 		case client := <-h.unregister:
 			h.mutex.Lock()
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				client.Close()
+				close(client.send)
 			}
+			n := len(h.clients)
 			h.mutex.Unlock()
-			log.Printf("WebSocket client disconnected. Total clients: %d", len(h.clients))
+			log.Printf("WebSocket client %s disconnected. Total clients: %d", client.remote, n)
 
 		case message := <-h.broadcast:
+			var envelope []byte // converted once, for the first client that needs it
 			h.mutex.RLock()
 			for client := range h.clients {
-				err := client.WriteMessage(websocket.TextMessage, message)
-				if err != nil {
-					delete(h.clients, client)
-					client.Close()
+				if message.detail && client.summary {
+					continue
+				}
+				if client.version < WSSchemaLatest {
+					h.queue(client, message.data)
+					continue
+				}
+				if envelope == nil {
+					envelope = toEnvelope(message.data)
+				}
+				if envelope != nil {
+					h.queue(client, envelope)
 				}
 			}
 			h.mutex.RUnlock()
@@ -93,32 +152,192 @@ func (h *hub) run() {
 	}
 }
 
+func (h *hub) diagnostics() []wsClientDiagnostics {
+	now := time.Now()
+	out := []wsClientDiagnostics{}
+	h.mutex.RLock()
+	for c := range h.clients {
+		last := c.connected
+		if ns := c.lastPong.Load(); ns != 0 {
+			last = time.Unix(0, ns)
+		}
+		out = append(out, wsClientDiagnostics{
+			Remote:           c.remote,
+			ConnectedSeconds: now.Sub(c.connected).Seconds(),
+			LastPongSeconds:  now.Sub(last).Seconds(),
+			RttMs:            float64(c.rtt.Load()) / float64(time.Millisecond),
+			Queued:           len(c.send),
+			Dropped:          c.dropped.Load(),
+			Alive:            now.Sub(last) < WSPingPeriod+WSWriteTimeout,
+		})
+	}
+	h.mutex.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ConnectedSeconds > out[j].ConnectedSeconds })
+	return out
+}
+
+// publish hands msg to the hub without blocking, dropping it when the
+// hub is behind.
+func (h *hub) publish(msg []byte) {
+	h.send(hubMessage{data: msg})
+}
+
+// publishDetail is publish for the per-interval stats.
+func (h *hub) publishDetail(msg []byte) {
+	h.send(hubMessage{data: msg, detail: true})
+}
+
+func (h *hub) send(msg hubMessage) {
+	select {
+	case h.broadcast <- msg:
+	default:
+		h.broadcastDrops.Add(1)
+	}
+}
+
+func (h *hub) clientCount() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.clients)
+}
+
+// encode returns msg in the schema version of c.
+func (c *wsClient) encode(msg []byte) []byte {
+	if c.version < WSSchemaLatest {
+		return msg
+	}
+	return toEnvelope(msg)
+}
+
+// reply queues msg for c alone. Only the read loop of c may call it,
+// the hub doesn't close c.send before that loop ended.
+func (c *wsClient) reply(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- c.encode(data):
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// queue must be called by the hub goroutine, which owns c.send.
+func (h *hub) queue(c *wsClient, msg []byte) {
+	if msg == nil {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		c.dropped.Add(1)
+		h.clientDrops.Add(1)
+	}
+}
+
+// writePump writes queued messages and pings the client. The pings carry
+// their send time, so the pongs give the RTT.
+func (c *wsClient) writePump(h *hub) {
+	ticker := time.NewTicker(WSPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close() // also ends the read loop, which unregisters c
+	}()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(WSWriteTimeout))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, nil)
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Printf("WebSocket client %s: write failed, closing: %v", c.remote, err)
+				return
+			}
+			h.sent.Add(1)
+		case now := <-ticker.C:
+			ts := binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano()))
+			if err := c.conn.WriteControl(websocket.PingMessage, ts, now.Add(WSWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readPump handles the client's messages until the connection fails or
+// no pong arrived for WSPongTimeout.
+func (c *wsClient) readPump(h *hub) {
+	defer func() {
+		h.unregister <- c
+	}()
+	c.conn.SetReadLimit(MetadataMaxBytes)
+	c.conn.SetReadDeadline(time.Now().Add(WSPongTimeout))
+	c.conn.SetPongHandler(func(data string) error {
+		now := time.Now()
+		c.lastPong.Store(now.UnixNano())
+		if len(data) == 8 {
+			c.rtt.Store(now.UnixNano() - int64(binary.BigEndian.Uint64([]byte(data))))
+		}
+		return c.conn.SetReadDeadline(now.Add(WSPongTimeout))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				log.Printf("WebSocket client %s stopped answering pings, closing", c.remote)
+			}
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(WSPongTimeout))
+		handleClientMessage(c, data)
+	}
+}
+
 type statsMessage struct {
 	Timestamp time.Time       `json:"timestamp"`
 	Type      string          `json:"type"` // "writer" or "reader"
 	Stats     *srt.Statistics `json:"stats"`
+	Test      bool            `json:"test,omitempty"` // made up by -test-signal
 }
 
 type stats struct {
 	interval   time.Duration // reporting interval
 	lastReport time.Time     // last time a report was sent
 
+	summaryInterval time.Duration // see -stats-summary, 0 disables summaries
+	lastSummary     time.Time
+	readerSum       statsAccumulator
+	writerSum       statsAccumulator
+
 	reader io.ReadCloser
 	writer io.WriteCloser
 	hub    *hub
 }
 
 func (s *stats) reportIfDue() {
-	if time.Since(s.lastReport) < s.interval {
+	// A requested snapshot goes to every client, also the ones that
+	// otherwise only get summaries
+	snapshot := statsSnapshotRequested.Swap(false)
+	if !snapshot && time.Since(s.lastReport) < s.interval {
 		return
 	}
 
 	now := time.Now()
+	publish := func(data []byte) {
+		if snapshot {
+			s.hub.publish(data)
+		} else {
+			s.hub.publishDetail(data)
+		}
+	}
 
 	// Writer statistics
 	if srtconn, ok := s.writer.(srt.Conn); ok {
 		stats := &srt.Statistics{}
 		srtconn.Stats(stats)
+		s.writerSum.add(stats)
 
 		if s.hub != nil {
 			writerMsg := statsMessage{
@@ -127,18 +346,27 @@ func (s *stats) reportIfDue() {
 				Stats:     stats,
 			}
 			if jsonData, err := json.Marshal(writerMsg); err == nil {
-				select {
-				case s.hub.broadcast <- jsonData:
-				default:
-				}
+				publish(jsonData)
 			}
 		}
 	}
 
-	// Reader statistics
-	if srtconn, ok := s.reader.(srt.Conn); ok {
-		stats := &srt.Statistics{}
-		srtconn.Stats(stats)
+	// Reader statistics, made up from the stream for a UDP input
+	var stats *srt.Statistics
+	switch r := s.reader.(type) {
+	case srt.Conn:
+		stats = &srt.Statistics{}
+		r.Stats(stats)
+		abr.update(stats, time.Now())
+		publishMessage(abr.snapshot())
+	case *udpInput:
+		stats = r.stats()
+	}
+	if stats != nil {
+		s.readerSum.add(stats)
+
+		publishMessage(streamMetrics.update(stats, now))
+		recordIngestStats(stats, now)
 
 		if s.hub != nil {
 			readerMsg := statsMessage{
@@ -147,11 +375,26 @@ func (s *stats) reportIfDue() {
 				Stats:     stats,
 			}
 			if jsonData, err := json.Marshal(readerMsg); err == nil {
-				select {
-				case s.hub.broadcast <- jsonData:
-				default:
-				}
+				publish(jsonData)
+			}
+		}
+	}
+
+	if s.summaryInterval > 0 && s.hub != nil {
+		if s.lastSummary.IsZero() {
+			s.lastSummary = now
+		} else if elapsed := now.Sub(s.lastSummary); elapsed >= s.summaryInterval {
+			msg := statsSummaryMessage{
+				Timestamp:       now,
+				Type:            "stats_summary",
+				IntervalSeconds: elapsed.Seconds(),
+				Reader:          s.readerSum.summary(true, elapsed),
+				Writer:          s.writerSum.summary(false, elapsed),
 			}
+			if jsonData, err := json.Marshal(msg); err == nil && (msg.Reader != nil || msg.Writer != nil) {
+				s.hub.publish(jsonData)
+			}
+			s.lastSummary = now
 		}
 	}
 
@@ -159,86 +402,213 @@ func (s *stats) reportIfDue() {
 }
 
 func handleWebSocket(hub *hub, w http.ResponseWriter, r *http.Request) {
+	version, ok := wsSchemaVersion(r)
+	if !ok {
+		http.Error(w, "v must be a schema version, e.g. 2", http.StatusBadRequest)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		log.Printf("WebSocket upgrade error from %s: %v", clientIP(r), err)
 		return
 	}
 
-	hub.register <- conn
+	c := &wsClient{
+		conn:      conn,
+		remote:    clientIP(r),
+		connected: time.Now(),
+		summary:   r.URL.Query().Get("stats") == "summary",
+		version:   version,
+		control:   controlAuthorized(wsRequestToken(r)),
+		send:      make(chan []byte, WSClientQueue),
+	}
+	hub.register <- c
+	go c.writePump(hub)
+	go c.readPump(hub)
+}
 
-	go func() {
-		defer func() {
-			hub.unregister <- conn
-		}()
+// welcomeMessages returns the state a newly connected WebSocket client
+// would otherwise only learn about on the next change.
+func welcomeMessages() [][]byte {
+	var msgs [][]byte
+	for _, fn := range []func() []byte{metadataWelcome, locationWelcome, brbWelcome} {
+		if msg := fn(); msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	if msg := linksWelcome(); msg != nil {
+		msgs = append(msgs, msg)
+	}
+	msgs = append(msgs, sensorWelcome()...)
+	return append(msgs, widgetWelcome()...)
+}
 
-		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
-				break
-			}
+// handleClientMessage handles a message sent by a WebSocket client, e.g. a
+// companion app posting location updates or sensor readings. Anything else
+// is ignored, and so are updates dropped by the rate limits or while the
+// location is hidden.
+func handleClientMessage(c *wsClient, data []byte) {
+	var msg struct {
+		Type   string `json:"type"`
+		Hidden bool   `json:"hidden"`
+		locationPoint
+		Sensor string          `json:"sensor"`
+		Data   json.RawMessage `json:"data"`
+		Label  string          `json:"label"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+	switch msg.Type {
+	case "location":
+		if err := updateLocation(msg.locationPoint); err == errLocationRange {
+			log.Printf("[location] Update from WebSocket dropped: %v", err)
+		}
+	case "location_privacy":
+		setLocationHidden(msg.Hidden)
+	case "sensor":
+		if err := updateSensor(msg.Sensor, msg.Data); err != nil && err != errSensorRate {
+			log.Printf("[sensor] Reading from WebSocket dropped: %v", err)
+		}
+	case "stats_request":
+		statsSnapshotRequested.Store(true)
+	case "auth", "command":
+		handleControlMessage(c, msg.Type, data)
+	case "marker":
+		if rec == nil {
+			return
+		}
+		if _, err := rec.addMarker(msg.Label); err != nil {
+			log.Printf("[record] Marker from WebSocket dropped: %v", err)
+		}
+	}
+}
+
+// runStatsHub serves the local stats WebSocket on wsPort and forwards every
+// published message to it. It returns nil when wsPort is 0.
+func runStatsHub(wsPort int) *hub {
+	if wsPort <= 0 {
+		return nil
+	}
+	hub := newHub()
+	statsHub = hub
+	go supervise("hub", hub.run)
+	subscribeMessages(hub.publish)
+
+	wsMux := http.NewServeMux()
+	wsMux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(hub, w, r)
+	})
+
+	go func() {
+		log.Printf("WebSocket server address: %s", webURL("ws", "127.0.0.1", wsPort, "/ws"))
+		if err := listenAndServe("ws", fmt.Sprintf("127.0.0.1:%d", wsPort), wsMux); err != nil {
+			log.Printf("WebSocket server error: %v", err)
+			reportError(listenSubject("tcp", wsPort), err)
 		}
 	}()
+	return hub
 }
 
-func runSrtProxy(from string, to string, wsPort int) <-chan error {
-	var hub *hub
-	if wsPort > 0 {
-		hub = newHub()
-		go hub.run()
+// SRTFailoverTimeout is how long the active SRT source may stay silent
+// before another source that is delivering data takes over the output.
+const SRTFailoverTimeout = 1 * time.Second
+
+// failoverWriter forwards data from several SRT sources to one output,
+// passing through only the source that is currently active. A different
+// source becomes active as soon as the active one has been silent for
+// SRTFailoverTimeout while the other delivers data.
+type failoverWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	froms    []string
+	readers  []io.ReadCloser
+	lastData []time.Time
+	active   int
+	stats    *stats
+}
 
-		wsMux := http.NewServeMux()
-		wsMux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-			handleWebSocket(hub, w, r)
-		})
+func (f *failoverWriter) setReader(idx int, r io.ReadCloser) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readers[idx] = r
+	if idx == f.active {
+		f.stats.reader = r
+	}
+}
 
-		go func() {
-			log.Printf("WebSocket server address: ws://127.0.0.1:%d/ws", wsPort)
-			if err := http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", wsPort), wsMux); err != nil {
-				log.Printf("WebSocket server error: %v", err)
-			}
-		}()
+// kick closes the connection of the active publisher, which normally
+// reconnects right away. It returns false when none is connected.
+func (f *failoverWriter) kick() bool {
+	f.mu.Lock()
+	r, from := f.readers[f.active], f.froms[f.active]
+	f.mu.Unlock()
+	if r == nil || isFileInput(from) || isUDPInput(from) {
+		return false
 	}
+	log.Printf("Kicking SRT publisher on %s", from)
+	emitEvent("srt.publisher_kicked", map[string]any{"source": from})
+	r.Close()
+	return true
+}
 
-	doneChan := make(chan error, 1)
+func (f *failoverWriter) write(idx int, p []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	r, err := openSrtStream(from)
-	if err != nil {
-		doneChan <- fmt.Errorf("from: %w", err)
-		return doneChan
+	now := time.Now()
+	f.lastData[idx] = now
+	srtLastData.Store(now.UnixNano())
+	if idx != f.active {
+		if now.Sub(f.lastData[f.active]) < SRTFailoverTimeout {
+			return nil // active source is healthy, drop the standby's data
+		}
+		log.Printf("SRT source %s stalled, switching output to %s", f.froms[f.active], f.froms[idx])
+		emitEvent("srt.failover", map[string]any{
+			"from": f.froms[f.active],
+			"to":   f.froms[idx],
+		})
+		f.active = idx
+		f.stats.reader = f.readers[idx]
 	}
 
-	w, err := openUDPWriter(to)
-	if err != nil {
-		r.Close()
-		doneChan <- fmt.Errorf("to: %w", err)
-		return doneChan
+	if _, err := f.w.Write(p); err != nil {
+		return err
 	}
+	f.stats.reportIfDue()
+	return nil
+}
 
-	go func() {
-		defer r.Close()
-		defer w.Close()
-
-		buffer := make([]byte, 2048)
+// runSource accepts the SRT stream on from and feeds it into f, reconnecting
+// whenever the stream breaks.
+func (f *failoverWriter) runSource(idx int, from string, doneChan chan<- error) {
+	r, err := openSource(from)
+	if err != nil {
+		sendDone(doneChan, fmt.Errorf("from: %w", err))
+		return
+	}
+	defer func() { r.Close() }()
+	f.setReader(idx, r)
 
-		s := &stats{
-			interval: time.Second,
-			reader:   r,
-			writer:   w,
-			hub:      hub,
-		}
+	buffer := make([]byte, 2048)
 
+	supervise("srt-proxy", func() {
 		for {
 			n, err := r.Read(buffer)
+			if err == io.EOF && isFileInput(from) {
+				log.Printf("[playback] %s ended", from)
+				sendDone(doneChan, nil)
+				return
+			}
 			if err != nil {
 				log.Printf("\nSRT reader error: %v. Attempting to reconnect...", err)
 				r.Close()
 				for {
 					var reconnErr error
-					r, reconnErr = openSrtStream(from)
+					r, reconnErr = openSource(from)
 					if reconnErr == nil {
 						log.Println("SRT reader reconnected successfully.")
-						s.reader = r
+						f.setReader(idx, r)
 						break
 					}
 					log.Printf("Failed to reconnect reader: %v. Retrying in 5 seconds...", reconnErr)
@@ -247,53 +617,190 @@ func runSrtProxy(from string, to string, wsPort int) <-chan error {
 				continue
 			}
 
-			if _, err := w.Write(buffer[:n]); err != nil {
-				doneChan <- fmt.Errorf("write: %w", err)
+			if err := f.write(idx, buffer[:n]); err != nil {
+				sendDone(doneChan, fmt.Errorf("write: %w", err))
 				return
 			}
-			s.reportIfDue()
 		}
-	}()
+	})
+}
 
-	return doneChan
+func sendDone(doneChan chan<- error, err error) {
+	select {
+	case doneChan <- err:
+	default:
+	}
 }
 
-func openSrtStream(addr string) (io.ReadCloser, error) {
-	u, err := url.Parse(addr)
-	if err != nil {
-		return nil, err
+// InternalSRTHost is where standalone mode's SRT proxy listens for srtla.
+const InternalSRTHost = "127.0.0.1:0"
+
+// srtIngest is an SRT ingest listener. It is bound once per address and
+// kept for the life of the process: the sessions are accepted one after
+// the other on the same socket, so the port cannot be taken by someone
+// else between two streams.
+type srtIngest struct {
+	ln     srt.Listener
+	host   string // as requested
+	config srt.Config
+
+	waiting atomic.Int32  // openSrtStream calls waiting for a publisher not yet admitted
+	conns   chan srt.Conn // accepted publishers, handed to the waiting call
+	done    chan struct{} // closed when the listener failed, see err
+	err     error
+}
+
+var (
+	srtIngestsMu sync.Mutex
+	srtIngests   = map[string]*srtIngest{} // requested host:port -> listener
+)
+
+// srtListenHosts remembers the address a listener for port 0 was given, so
+// whoever forwards to it can find it.
+var srtListenHosts sync.Map // requested host:port -> bound host:port
+
+// srtListenHost returns the address a listener for host is bound to, host
+// itself unless it asked for port 0.
+func srtListenHost(host string) string {
+	if bound, ok := srtListenHosts.Load(host); ok {
+		return bound.(string)
 	}
+	return host
+}
 
-	config := srt.DefaultConfig()
-	if err := config.UnmarshalQuery(u.RawQuery); err != nil {
-		return nil, err
+// srtIngestFor returns the listener for u, binding it on first use.
+func srtIngestFor(u *url.URL, config srt.Config) (*srtIngest, error) {
+	srtIngestsMu.Lock()
+	defer srtIngestsMu.Unlock()
+	if in := srtIngests[u.Host]; in != nil {
+		return in, nil
 	}
 
 	ln, err := srt.Listen("srt", u.Host, config)
 	if err != nil {
 		return nil, err
 	}
+	if u.Port() == "0" {
+		srtListenHosts.Store(u.Host, ln.Addr().String())
+	}
+	recordListener("srt-listener", "srt", ln.Addr())
+	markReady(readySRTListener(u.Host))
 
-	conn, _, err := ln.Accept(func(req srt.ConnRequest) srt.ConnType {
-		if len(config.StreamId) > 0 && config.StreamId != req.StreamId() {
-			return srt.REJECT
+	in := &srtIngest{ln: ln, host: u.Host, config: config, conns: make(chan srt.Conn), done: make(chan struct{})}
+	srtIngests[u.Host] = in
+	go supervise("srt-accept", in.acceptLoop)
+	return in, nil
+}
+
+// acceptLoop accepts publishers until the listener fails. Only then is it
+// closed and forgotten, so the next openSrtStream binds a new one.
+func (in *srtIngest) acceptLoop() {
+	for {
+		req, err := in.ln.Accept2()
+		if err != nil {
+			srtIngestsMu.Lock()
+			delete(srtIngests, in.host)
+			srtIngestsMu.Unlock()
+			in.ln.Close()
+			in.err = err
+			close(in.done)
+			return
 		}
+		if reason, ok := in.admit(req); !ok {
+			req.Reject(reason)
+			continue
+		}
+		conn, err := req.Accept()
+		if err != nil {
+			in.waiting.Add(1) // the handshake failed, give the slot back
+			continue
+		}
+		// admit reserved a waiting openSrtStream for it
+		in.conns <- conn
+	}
+}
 
-		req.SetPassphrase(config.Passphrase)
+// admit decides on a connection request. A publisher is only accepted
+// while openSrtStream waits for one, and takes that call's slot right
+// away; during a session the others are turned away instead of piling up
+// for later.
+func (in *srtIngest) admit(req srt.ConnRequest) (srt.RejectionReason, bool) {
+	wakeUp("connection attempt from " + req.RemoteAddr().String())
+
+	connType, reason := classifyConnRequest(in.config.StreamId, req)
+	switch connType {
+	case srt.SUBSCRIBE:
+		// This listener only ingests; players are served elsewhere
+		logHot("Rejected SRT player %s (streamid %q): ingest listener only accepts publishers", req.RemoteAddr(), req.StreamId())
+		return srt.REJX_BAD_MODE, false
+	case srt.REJECT:
+		logHot("Rejected SRT publisher %s (streamid %q)", req.RemoteAddr(), req.StreamId())
+		return reason, false
+	}
 
-		return srt.PUBLISH
-	})
+	if !scheduleAdmits("srt", req.RemoteAddr().String()) {
+		logHot("Rejected SRT publisher %s: outside of the ingest schedule", req.RemoteAddr())
+		return srt.REJX_FORBIDDEN, false
+	}
+
+	if in.config.Passphrase != "" {
+		if err := req.SetPassphrase(in.config.Passphrase); err != nil {
+			logHot("Rejected SRT publisher %s: %v", req.RemoteAddr(), err)
+			reportProblem(FailureWrongPassphrase, in.host, err)
+			return srt.REJ_BADSECRET, false
+		}
+	}
+	clearProblem(in.host)
+
+	if !in.reserve() {
+		logHot("Rejected SRT publisher %s: a stream is already being received on %s", req.RemoteAddr(), in.host)
+		return srt.REJX_CONFLICT, false
+	}
+	return 0, true
+}
+
+// reserve takes the slot of one waiting openSrtStream call, if there is
+// one.
+func (in *srtIngest) reserve() bool {
+	for {
+		n := in.waiting.Load()
+		if n <= 0 {
+			return false
+		}
+		if in.waiting.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
+
+// openSrtStream waits for the next publisher on the SRT listener of addr.
+// Closing the stream ends the session; the listener stays.
+func openSrtStream(addr string) (io.ReadCloser, error) {
+	u, err := url.Parse(addr)
 	if err != nil {
-		ln.Close()
 		return nil, err
 	}
 
-	if conn == nil {
-		ln.Close()
-		return nil, fmt.Errorf("incoming connection rejected")
+	config := srt.DefaultConfig()
+	if err := config.UnmarshalQuery(u.RawQuery); err != nil {
+		return nil, err
 	}
 
-	return listenerConn{Conn: conn, listener: ln}, nil
+	in, err := srtIngestFor(u, config)
+	if err != nil {
+		return nil, err
+	}
+	// The slot is taken by the publisher admit accepts for this call
+	in.waiting.Add(1)
+	select {
+	case conn := <-in.conns:
+		if integrityCheck {
+			return newIntegrityConn(conn), nil
+		}
+		return conn, nil
+	case <-in.done:
+		return nil, in.err
+	}
 }
 
 func openUDPWriter(addr string) (io.WriteCloser, error) {
@@ -311,6 +818,7 @@ func openUDPWriter(addr string) (io.WriteCloser, error) {
 	if err != nil {
 		return nil, err
 	}
+	recordTarget("udp-output", "udp", conn.RemoteAddr().String())
 
 	return conn, nil
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// openIngest binds an ingest listener on a free loopback port and forgets
+// it when the test ends.
+func openIngest(t *testing.T) (from string, in *srtIngest) {
+	t.Helper()
+	const host = "127.0.0.1:0"
+	from = "srt://" + host
+	accepted := acceptAsync(from)
+	eventually(t, func() bool {
+		srtIngestsMu.Lock()
+		defer srtIngestsMu.Unlock()
+		in = srtIngests[host]
+		return in != nil
+	}, "the listener")
+	t.Cleanup(func() {
+		in.ln.Close()
+		<-in.done
+		srtListenHosts.Delete(host)
+	})
+	// Settle the first openSrtStream with a session
+	conn := dialIngest(t)
+	r := <-accepted
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	r.Close()
+	conn.Close()
+	return from, in
+}
+
+type acceptResult struct {
+	io.ReadCloser
+	err error
+}
+
+func acceptAsync(from string) <-chan acceptResult {
+	ch := make(chan acceptResult, 1)
+	go func() {
+		r, err := openSrtStream(from)
+		ch <- acceptResult{r, err}
+	}()
+	return ch
+}
+
+func dialIngest(t *testing.T) srt.Conn {
+	t.Helper()
+	conn, err := srt.Dial("srt", srtListenHost("127.0.0.1:0"), srt.DefaultConfig())
+	if err != nil {
+		t.Fatalf("connecting to the ingest listener: %v", err)
+	}
+	return conn
+}
+
+// TestSRTIngestListenerKept checks that consecutive sessions are accepted
+// on the listener of the first one, on the same port, instead of a new
+// listener bound after the old one was closed.
+func TestSRTIngestListenerKept(t *testing.T) {
+	from, in := openIngest(t)
+	bound := srtListenHost("127.0.0.1:0")
+
+	for i := 0; i < 3; i++ {
+		accepted := acceptAsync(from)
+		conn := dialIngest(t)
+		r := <-accepted
+		if r.err != nil {
+			t.Fatalf("session %d: %v", i, r.err)
+		}
+		if _, err := conn.Write(make([]byte, 188)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 2048)
+		if n, err := r.Read(buf); err != nil || n != 188 {
+			t.Fatalf("session %d read %d bytes: %v", i, n, err)
+		}
+		r.Close()
+		conn.Close()
+
+		srtIngestsMu.Lock()
+		same := srtIngests["127.0.0.1:0"] == in
+		srtIngestsMu.Unlock()
+		if !same || srtListenHost("127.0.0.1:0") != bound {
+			t.Fatalf("session %d on a new listener", i)
+		}
+		select {
+		case <-in.done:
+			t.Fatalf("the listener closed after session %d: %v", i, in.err)
+		default:
+		}
+	}
+}
+
+// TestSRTIngestBusyRejects checks that a second publisher is turned away
+// while a session runs rather than queued for after it.
+func TestSRTIngestBusyRejects(t *testing.T) {
+	from, _ := openIngest(t)
+
+	accepted := acceptAsync(from)
+	first := dialIngest(t)
+	defer first.Close()
+	r := <-accepted
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	defer r.Close()
+
+	if second, err := srt.Dial("srt", srtListenHost("127.0.0.1:0"), srt.DefaultConfig()); err == nil {
+		second.Close()
+		t.Fatal("a second publisher was accepted during a session")
+	}
+}
+
+// TestSRTIngestConcurrentPublishers has two publishers handshake at once
+// for one waiting call: one must be accepted and the other rejected, and
+// the next call must get a new publisher rather than a surplus one.
+func TestSRTIngestConcurrentPublishers(t *testing.T) {
+	from, in := openIngest(t)
+
+	accepted := acceptAsync(from)
+	eventually(t, func() bool { return in.waiting.Load() == 1 }, "the call to wait")
+	dialed := make(chan srt.Conn, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			conn, err := srt.Dial("srt", srtListenHost("127.0.0.1:0"), srt.DefaultConfig())
+			if err != nil {
+				conn = nil
+			}
+			dialed <- conn
+		}()
+	}
+	var conns []srt.Conn
+	for i := 0; i < 2; i++ {
+		if conn := <-dialed; conn != nil {
+			conns = append(conns, conn)
+		}
+	}
+	if len(conns) != 1 {
+		t.Fatalf("%d of two concurrent publishers accepted, want 1", len(conns))
+	}
+	r := <-accepted
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	r.Close()
+	conns[0].Close()
+
+	accepted = acceptAsync(from)
+	next := dialIngest(t)
+	defer next.Close()
+	r = <-accepted
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	defer r.Close()
+	if _, err := next.Write([]byte("next session")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	if n, err := r.Read(buf); err != nil || string(buf[:n]) != "next session" {
+		t.Fatalf("the next session read %q, %v: got a stale publisher", buf[:n], err)
+	}
+}
+
+// TestSRTIngestTwoWaiters has two calls wait at once: two publishers must
+// each be handed to one of them.
+func TestSRTIngestTwoWaiters(t *testing.T) {
+	from, in := openIngest(t)
+
+	first, second := acceptAsync(from), acceptAsync(from)
+	eventually(t, func() bool { return in.waiting.Load() == 2 }, "both calls to wait")
+	for i := 0; i < 2; i++ {
+		conn := dialIngest(t)
+		defer conn.Close()
+	}
+	for _, accepted := range []<-chan acceptResult{first, second} {
+		r := <-accepted
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		r.Close()
+	}
+}
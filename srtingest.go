@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SRTIngestTimeout     = 10 * time.Second // a sender is forgotten after this long without packets
+	SRTIngestMaxSessions = MaxGroups
+)
+
+// srtRelay relays plain (non-SRTLA) SRT senders to the downstream SRT
+// server, each over its own socket so the server sees every sender as its
+// own peer. The server's replies go back out through reply, the socket the
+// senders' packets came in on.
+type srtRelay struct {
+	name       string // log prefix
+	downstream *net.UDPAddr
+	reply      packetConn
+
+	mu       sync.Mutex
+	sessions map[netip.AddrPort]*srtRelaySession
+}
+
+type srtRelaySession struct {
+	addr     *net.UDPAddr
+	upstream srtConn
+	lastRcvd atomic.Int64 // unix nanoseconds
+}
+
+func newSRTRelay(name string, downstream *net.UDPAddr, reply packetConn) *srtRelay {
+	return &srtRelay{name: name, downstream: downstream, reply: reply, sessions: map[netip.AddrPort]*srtRelaySession{}}
+}
+
+// runSRTIngest accepts plain SRT senders on port and relays them to the same
+// downstream SRT server the SRTLA groups use, so single-connection encoders
+// can use a server mode deployment too. It runs until ctx is cancelled.
+func runSRTIngest(ctx context.Context, port int, downstream string) {
+	raddr, err := net.ResolveUDPAddr("udp", downstream)
+	if err != nil {
+		log.Printf("[srt-ingest] Invalid downstream %s: %v", downstream, err)
+		return
+	}
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6unspecified, Port: port})
+	if err != nil {
+		log.Printf("[srt-ingest] Failed to listen on UDP port %d: %v", port, err)
+		reportError(listenSubject("udp", port), err)
+		return
+	}
+	_ = sock.SetReadBuffer(recvBufSize)
+	_ = sock.SetWriteBuffer(sendBufSize)
+	log.Printf("[srt-ingest] Accepting plain SRT senders on %s, relaying to %s", sock.LocalAddr(), raddr)
+	recordListener("srt-ingest", "udp", sock.LocalAddr())
+	recordTarget("srt-ingest-downstream", "srt", raddr.String())
+
+	relay := newSRTRelay("srt-ingest", raddr, sock)
+	go supervise("srt-ingest-cleanup", func() { relay.runCleanup(ctx) })
+	go func() {
+		<-ctx.Done()
+		sock.Close()
+	}()
+
+	buf := make([]byte, MTU)
+	for {
+		n, addr, err := sock.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logHot("[srt-ingest] Read error: %v", err)
+			continue
+		}
+		if !relay.forward(buf[:n], addr) && n >= SRTMinLen {
+			relay.accept(buf[:n], addr)
+		}
+	}
+}
+
+// forward relays pkt if addr belongs to a known sender and reports whether
+// it did.
+func (r *srtRelay) forward(pkt []byte, addr netip.AddrPort) bool {
+	r.mu.Lock()
+	s := r.sessions[addr]
+	r.mu.Unlock()
+	if s == nil {
+		return false
+	}
+	s.lastRcvd.Store(time.Now().UnixNano())
+	if _, err := s.upstream.Write(pkt); err != nil {
+		r.remove(addr, s, fmt.Sprintf("forwarding failed: %v", err))
+	}
+	return true
+}
+
+// accept starts relaying a new sender with its first packet.
+func (r *srtRelay) accept(pkt []byte, addr netip.AddrPort) {
+	udpAddr := net.UDPAddrFromAddrPort(addr)
+	peer := udpAddr.String()
+	wakeUp("SRT sender " + peer)
+	if !scheduleAdmits("srt", peer) {
+		return
+	}
+
+	r.mu.Lock()
+	active := len(r.sessions)
+	r.mu.Unlock()
+	if active >= SRTIngestMaxSessions {
+		logHot("[%s] [%s] Sender rejected: too many senders (%d)", r.name, peer, active)
+		return
+	}
+	upstream, err := dialSRT(r.downstream)
+	if err != nil {
+		log.Printf("[%s] [%s] Sender rejected: %v", r.name, peer, err)
+		return
+	}
+	s := &srtRelaySession{addr: udpAddr, upstream: upstream}
+	s.lastRcvd.Store(time.Now().UnixNano())
+
+	r.mu.Lock()
+	r.sessions[addr] = s
+	r.mu.Unlock()
+	log.Printf("[%s] [%s] Sender connected (local %s)", r.name, peer, upstream.LocalAddr())
+	emitEvent("srt_ingest.added", map[string]any{"addr": peer})
+
+	go supervise(r.name+"-reader", func() {
+		s.relayReplies(r.reply)
+		r.remove(addr, s, "downstream closed")
+	})
+	r.forward(pkt, addr)
+}
+
+// remove stops relaying for addr if s is still its session.
+func (r *srtRelay) remove(addr netip.AddrPort, s *srtRelaySession, reason string) {
+	r.mu.Lock()
+	if r.sessions[addr] != s {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.sessions, addr)
+	r.mu.Unlock()
+	s.upstream.Close()
+	log.Printf("[%s] [%s] Sender removed (%s)", r.name, s.addr, reason)
+	emitEvent("srt_ingest.removed", map[string]any{"addr": s.addr.String(), "reason": reason})
+}
+
+// drop forgets the sender at addr, if any.
+func (r *srtRelay) drop(addr netip.AddrPort, reason string) {
+	r.mu.Lock()
+	s := r.sessions[addr]
+	r.mu.Unlock()
+	if s != nil {
+		r.remove(addr, s, reason)
+	}
+}
+
+// runCleanup forgets senders that went quiet until ctx is cancelled.
+func (r *srtRelay) runCleanup(ctx context.Context) {
+	ticker := time.NewTicker(CleanupPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		r.mu.Lock()
+		var stale []netip.AddrPort
+		for addr, s := range r.sessions {
+			if now.Sub(time.Unix(0, s.lastRcvd.Load())) >= SRTIngestTimeout {
+				stale = append(stale, addr)
+			}
+		}
+		r.mu.Unlock()
+		for _, addr := range stale {
+			r.drop(addr, "timed out")
+		}
+	}
+}
+
+// relayReplies copies the SRT server's packets back to the sender until the
+// upstream socket is closed.
+func (s *srtRelaySession) relayReplies(reply packetConn) {
+	buf := make([]byte, MTU)
+	for {
+		n, err := s.upstream.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := reply.WriteToUDP(buf[:n], s.addr); err != nil {
+			logHot("[%s] Failed to relay SRT pkt: %v", s.addr, err)
+		}
+	}
+}
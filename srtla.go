@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
@@ -8,7 +9,9 @@ import (
 	"log"
 	mathrand "math/rand"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,6 +33,10 @@ const (
 	SRTLATypeRegErr    = 0x9210
 	SRTLATypeRegNGP    = 0x9211
 
+	// go-irl extension, only sent with -backpressure=hint: the group's
+	// queue level in percent, see backpressure.go
+	SRTLATypeCongestion = 0x9300
+
 	SRTLAIDLen   = 256
 	SRTLAReg1Len = 2 + SRTLAIDLen
 	SRTLAReg2Len = 2 + SRTLAIDLen
@@ -86,27 +93,82 @@ func udpAddrEqual(a, b *net.UDPAddr) bool {
 }
 
 type Conn struct {
-	addr     *net.UDPAddr
-	lastRcvd time.Time
-	recvIdx  int                     // next slot in recvLog
-	recvLog  [RecvACKInterval]uint32 // SRT sequence numbers for SRTLA ACK
+	addr      *net.UDPAddr
+	addrPort  netip.AddrPort // addr in the form the packet path compares against
+	lastRcvd  time.Time
+	recvIdx   int                     // next slot in recvLog
+	recvLog   [RecvACKInterval]uint32 // SRT sequence numbers for SRTLA ACK
+	recvFirst time.Time               // when recvLog[0] was logged, see -ack-max-delay
+	ackBuf    [4 + RecvACKInterval*4]byte
+
+	// Sender clock minus server clock (including the one-way delay), taken
+	// from extended keepalives. Zero clockSampled means not yet known.
+	clockOffset  time.Duration
+	clockSampled time.Time
+
+	senderLabel string // from label keepalives, see labels.go
+	rxBytes     uint64 // received from the sender, for the link history
+
+	bitrateAnomaly anomalyMetric // see anomaly.go
+	anomalyBytes   uint64
+	anomalyAt      time.Time
 }
 
 type Group struct {
-	id        [SRTLAIDLen]byte
-	conns     []*Conn
-	createdAt time.Time
-	srtSock   *net.UDPConn // connection to downstream SRT server
-	lastAddr  *net.UDPAddr // most recently active client addr
-	mu        sync.Mutex   // protects conns + lastAddr + srtSock
+	id           [SRTLAIDLen]byte
+	conns        []*Conn
+	createdAt    time.Time
+	lastActivity time.Time  // last registration or packet from any conn
+	srtSock      srtConn    // connection to downstream SRT server
+	readers      int        // running SRT reader goroutines
+	closed       bool       // set once the group has been torn down
+	endReason    string     // set once an SRT shutdown ends the group, see shutdown.go
+	mu           sync.Mutex // protects everything below id
+
+	// For the SRTLA reader, which matches addresses without taking mu:
+	// connsSnap is a copy of conns published on every change, lastAddr
+	// the most recently active client addr. addrsSnap holds the conns'
+	// addresses, for packets that go out on every link.
+	connsSnap atomic.Pointer[[]*Conn]
+	addrsSnap atomic.Pointer[[]*net.UDPAddr]
+	lastAddr  atomic.Pointer[net.UDPAddr]
+
+	work      chan *packetBuf // packets for the group's worker
+	done      chan struct{}   // closed on teardown, stops the worker
+	workDrops atomic.Uint64   // packets dropped because work was full
+
+	overloaded atomic.Bool // the worker is falling behind, see backpressure.go
+	lastHint   time.Time   // last congestion hint, used by the worker only
+
+	dedup    *dedupWindow  // nil unless -dedup, used by the worker only
+	dupDrops atomic.Uint64 // duplicate data packets not forwarded
+
+	reorder   *reorderBuffer // nil unless -reorder-delay, used by the worker only
+	queueWait windowMax      // how long packets waited in work
+
+	integrity *integrityTracker // nil unless -integrity
+	session   srtSession        // SRT socket IDs, see socketid.go
+
+	anomalyConns int // links when the bitrate baselines were started
 }
 
 var (
-	groupsMu sync.RWMutex
-	groups   []*Group
+	// The group registry is copy-on-write: readers (the packet path,
+	// diagnostics) load the current snapshot without locking, writers
+	// serialize on groupsMu and publish a modified copy.
+	groupsMu   sync.Mutex
+	groupsSnap atomic.Pointer[[]*Group]
 
-	srtlaSock *net.UDPConn
+	srtlaSock packetConn
 	srtAddr   *net.UDPAddr // resolved downstream SRT server address
+
+	// how long a group without connections is kept after its last activity
+	emptyGroupGrace = GroupTimeout
+
+	// Process-wide accounting used by the diagnostics endpoint to detect
+	// reader goroutines and sockets that outlive their group.
+	srtReadersActive atomic.Int64
+	srtSocketsOpen   atomic.Int64
 )
 
 func be16(b []byte) uint16 { return binary.BigEndian.Uint16(b) }
@@ -121,6 +183,7 @@ func getSRTType(pkt []byte) uint16 {
 func isSRTAck(pkt []byte) bool         { return getSRTType(pkt) == SRTTypeACK }
 func isSRTNak(pkt []byte) bool         { return getSRTType(pkt) == SRTTypeNAK }
 func isSRTLAKeepalive(pkt []byte) bool { return getSRTType(pkt) == SRTLATypeKeepalive }
+func isSRTShutdown(pkt []byte) bool    { return getSRTType(pkt) == SRTTypeShutdown }
 
 // getSRTSN returns the SRT sequence number from a data packet (bit 31 == 0).
 // Returns -1 for control packets or packets too short.
@@ -135,17 +198,72 @@ func getSRTSN(pkt []byte) int32 {
 	return -1
 }
 
-func isSRTLAReg1(pkt []byte) bool {
-	return len(pkt) == SRTLAReg1Len && getSRTType(pkt) == SRTLATypeReg1
+// srtlaRegID returns the ID carried by a REG1/REG2 packet of the given
+// type, or nil if pkt is not exactly such a packet. The returned slice
+// aliases pkt.
+func srtlaRegID(pkt []byte, typ uint16) []byte {
+	if len(pkt) != 2+SRTLAIDLen || getSRTType(pkt) != typ {
+		return nil
+	}
+	return pkt[2 : 2+SRTLAIDLen]
+}
+
+// srtHandshake holds the fixed part of an SRT handshake control packet
+// (srt_handshake_t without the header and peer IP).
+type srtHandshake struct {
+	Version    uint32
+	EncField   uint16
+	ExtField   uint16
+	InitialSeq uint32
+	MTU        uint32
+	MFW        uint32
+	Type       uint32
+	SourceID   uint32
+	SynCookie  uint32
+}
+
+// parseSRTHandshake decodes an SRT handshake control packet. Trailing
+// handshake extensions are ignored.
+func parseSRTHandshake(pkt []byte) (srtHandshake, error) {
+	var hs srtHandshake
+	if len(pkt) < SRTHandshakeSize {
+		return hs, fmt.Errorf("handshake too short (%d bytes)", len(pkt))
+	}
+	if getSRTType(pkt) != SRTTypeHandshake {
+		return hs, fmt.Errorf("not a handshake packet (type 0x%04x)", getSRTType(pkt))
+	}
+	b := pkt[SRTMinLen:SRTHandshakeSize]
+	hs.Version = binary.BigEndian.Uint32(b[0:4])
+	hs.EncField = binary.BigEndian.Uint16(b[4:6])
+	hs.ExtField = binary.BigEndian.Uint16(b[6:8])
+	hs.InitialSeq = binary.BigEndian.Uint32(b[8:12])
+	hs.MTU = binary.BigEndian.Uint32(b[12:16])
+	hs.MFW = binary.BigEndian.Uint32(b[16:20])
+	hs.Type = binary.BigEndian.Uint32(b[20:24])
+	hs.SourceID = binary.BigEndian.Uint32(b[24:28])
+	hs.SynCookie = binary.BigEndian.Uint32(b[28:32])
+	return hs, nil
+}
+
+func isSRTLAReg1(pkt []byte) bool { return srtlaRegID(pkt, SRTLATypeReg1) != nil }
+func isSRTLAReg2(pkt []byte) bool { return srtlaRegID(pkt, SRTLATypeReg2) != nil }
+
+// groupList returns the current registry snapshot. It must not be modified.
+func groupList() []*Group {
+	if p := groupsSnap.Load(); p != nil {
+		return *p
+	}
+	return nil
 }
-func isSRTLAReg2(pkt []byte) bool {
-	return len(pkt) == SRTLAReg2Len && getSRTType(pkt) == SRTLATypeReg2
+
+// setGroups publishes a new registry snapshot. Must be called with groupsMu
+// held.
+func setGroups(gs []*Group) {
+	groupsSnap.Store(&gs)
 }
 
 func findGroupByID(id []byte) *Group {
-	groupsMu.RLock()
-	defer groupsMu.RUnlock()
-	for _, g := range groups {
+	for _, g := range groupList() {
 		if constantTimeCompare(g.id[:], id) {
 			return g
 		}
@@ -153,25 +271,91 @@ func findGroupByID(id []byte) *Group {
 	return nil
 }
 
+// findGroupByClientID returns the group whose sender-chosen half of the ID
+// matches clientID.
+func findGroupByClientID(clientID []byte) *Group {
+	for _, g := range groupList() {
+		if constantTimeCompare(g.id[:SRTLAIDLen/2], clientID) {
+			return g
+		}
+	}
+	return nil
+}
+
+// connList returns the group's connections without locking. It must not
+// be modified.
+func (g *Group) connList() []*Conn {
+	if p := g.connsSnap.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// setConns replaces the group's connections and publishes a copy for
+// connList. Must be called with g.mu held.
+func (g *Group) setConns(conns []*Conn) {
+	g.conns = conns
+	snap := append(make([]*Conn, 0, len(conns)), conns...)
+	addrs := make([]*net.UDPAddr, len(conns))
+	for i, c := range conns {
+		addrs[i] = c.addr
+	}
+	g.connsSnap.Store(&snap)
+	g.addrsSnap.Store(&addrs)
+}
+
+// connAddrs returns the addresses of the group's connections without
+// locking. It must not be modified.
+func (g *Group) connAddrs() []*net.UDPAddr {
+	if p := g.addrsSnap.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
 func findByAddr(addr *net.UDPAddr) (g *Group, c *Conn) {
-	groupsMu.RLock()
-	defer groupsMu.RUnlock()
-	for _, gr := range groups {
-		for _, conn := range gr.conns {
+	for _, gr := range groupList() {
+		for _, conn := range gr.connList() {
 			if udpAddrEqual(conn.addr, addr) {
 				return gr, conn
 			}
 		}
-		if udpAddrEqual(gr.lastAddr, addr) {
+		if udpAddrEqual(gr.lastAddr.Load(), addr) {
 			return gr, nil
 		}
 	}
 	return nil, nil
 }
 
+// findConn is findByAddr for the packet path: it only matches registered
+// connections and compares addresses without allocating.
+func findConn(addr netip.AddrPort) (g *Group, c *Conn) {
+	for _, gr := range groupList() {
+		for _, conn := range gr.connList() {
+			if conn.addrPort == addr {
+				return gr, conn
+			}
+		}
+	}
+	return nil, nil
+}
+
 func newGroup(clientID []byte) *Group {
 	var g Group
-	g.createdAt = time.Now()
+	g.conns = make([]*Conn, 0, MaxConnsPerGroup)
+	g.work = make(chan *packetBuf, GroupWorkQueueLen)
+	g.done = make(chan struct{})
+	if dedupPackets {
+		g.dedup = newDedupWindow()
+	}
+	if reorderDelay > 0 {
+		g.reorder = newReorderBuffer()
+	}
+	if integrityCheck {
+		g.integrity = newIntegrityTracker()
+	}
+	g.createdAt = clk.Now()
+	g.lastActivity = g.createdAt
 
 	copy(g.id[:SRTLAIDLen/2], clientID)
 	copy(g.id[SRTLAIDLen/2:], randomBytes(SRTLAIDLen/2))
@@ -185,25 +369,56 @@ func sendRegErr(addr *net.UDPAddr) {
 }
 
 func registerGroup(addr *net.UDPAddr, pkt []byte) {
-	if len(groups) >= MaxGroups {
-		log.Printf("[%s] Registration failed: Max groups reached", addr)
+	reqID := srtlaRegID(pkt, SRTLATypeReg1)
+	if reqID == nil {
+		return
+	}
+
+	wakeUp("SRTLA registration from " + addr.String())
+
+	if !scheduleAdmits("srtla", addr.String()) {
+		logHot("[%s] Registration failed: Outside of the ingest schedule", addr)
+		sendRegErr(addr)
+		return
+	}
+
+	// A sender that restarts re-registers with the same client ID. Its old
+	// SRT session is gone, so replace the stale group right away instead of
+	// running both until the old one times out.
+	if old := findGroupByClientID(reqID[:SRTLAIDLen/2]); old != nil {
+		log.Printf("[%s] [group %p] Sender re-registered, replacing group", addr, old)
+		removeGroup(old)
+		emitEvent("group.replaced", map[string]any{
+			"group": fmt.Sprintf("%p", old),
+			"addr":  addr.String(),
+		})
+	}
+
+	if len(groupList()) >= MaxGroups {
+		logHot("[%s] Registration failed: Max groups reached", addr)
+		sendRegErr(addr)
+		return
+	}
+
+	if !memoryAdmits() {
+		logHot("[%s] Registration failed: Memory cap reached", addr)
 		sendRegErr(addr)
 		return
 	}
 
 	// Prevent duplicate registration from same remote addr
 	if g, _ := findByAddr(addr); g != nil {
-		log.Printf("[%s] Registration failed: Addr already in group", addr)
+		logHot("[%s] Registration failed: Addr already in group", addr)
 		sendRegErr(addr)
 		return
 	}
 
 	clientID := make([]byte, SRTLAIDLen/2)
-	copy(clientID, pkt[2:2+SRTLAIDLen/2])
+	copy(clientID, reqID[:SRTLAIDLen/2])
 	g := newGroup(clientID)
 
 	// store last addr so that no other group can register from it
-	g.lastAddr = addr
+	g.lastAddr.Store(addr)
 
 	// build REG2
 	out := make([]byte, SRTLAReg2Len)
@@ -216,27 +431,44 @@ func registerGroup(addr *net.UDPAddr, pkt []byte) {
 	}
 
 	groupsMu.Lock()
-	groups = append(groups, g)
+	current := groupList()
+	next := make([]*Group, 0, len(current)+1)
+	setGroups(append(append(next, current...), g))
 	groupsMu.Unlock()
+	if clusterNode != nil {
+		clusterNode.announce() // before the sender's other links reach other nodes
+	}
+
+	groupWorkersActive.Add(1) // before the goroutine, for the leak accounting
+	go func() {
+		defer groupWorkersActive.Add(-1)
+		supervise("group-worker", g.runWorker)
+	}()
 
 	log.Printf("[%s] [group %p] Registered", addr, g)
 }
 
 func registerConn(addr *net.UDPAddr, pkt []byte) {
-	id := pkt[2:]
+	id := srtlaRegID(pkt, SRTLATypeReg2)
+	if id == nil {
+		return
+	}
 	g := findGroupByID(id)
+	if g == nil && clusterNode != nil && clusterNode.forwardReg2(addr.AddrPort(), pkt, id) {
+		return // another node's group
+	}
 	if g == nil {
 		var hdr [2]byte
 		binary.BigEndian.PutUint16(hdr[:], SRTLATypeRegNGP)
 		srtlaSock.WriteToUDP(hdr[:], addr)
-		log.Printf("[%s] Conn registration failed: no group", addr)
+		logHot("[%s] Conn registration failed: no group", addr)
 		return
 	}
 
 	// Reject if this addr is already tied to another group
 	if tmp, _ := findByAddr(addr); tmp != nil && tmp != g {
 		sendRegErr(addr)
-		log.Printf("[%s] [group %p] Conn registration failed: Addr in other group", addr, g)
+		logHot("[%s] [group %p] Conn registration failed: Addr in other group", addr, g)
 		return
 	}
 
@@ -253,7 +485,7 @@ func registerConn(addr *net.UDPAddr, pkt []byte) {
 	if existingConn == nil && len(g.conns) >= MaxConnsPerGroup {
 		g.mu.Unlock()
 		sendRegErr(addr)
-		log.Printf("[%s] [group %p] Conn registration failed: Too many conns", addr, g)
+		logHot("[%s] [group %p] Conn registration failed: Too many conns", addr, g)
 		return
 	}
 	g.mu.Unlock()
@@ -267,230 +499,378 @@ func registerConn(addr *net.UDPAddr, pkt []byte) {
 	}
 
 	g.mu.Lock()
+	now := clk.Now()
 	if existingConn == nil {
-		g.conns = append(g.conns, &Conn{addr: addr, lastRcvd: time.Now()})
+		c := &Conn{addr: addr, addrPort: addr.AddrPort(), lastRcvd: now}
+		g.setConns(append(g.conns, c))
+		linkHistories.connUp(g, c, now)
 	}
-	g.lastAddr = addr
+	g.lastAddr.Store(addr)
+	g.lastActivity = now
 	g.mu.Unlock()
 
 	log.Printf("[%s] [group %p] Conn Registered", addr, g)
+	if existingConn == nil {
+		publishLinks()
+	}
 }
 
-func startSRTReader(g *Group) {
+// startSRTReader reads from conn until it fails. The reader is bound to the
+// socket it was started for, so once the group closes or replaces that socket
+// the resulting read error ends the goroutine without touching the group.
+func startSRTReader(g *Group, conn srtConn) {
+	g.mu.Lock()
+	g.readers++
+	g.mu.Unlock()
+	srtReadersActive.Add(1)
+
 	go func() {
-		buf := make([]byte, MTU)
-		for {
+		defer func() {
 			g.mu.Lock()
-			conn := g.srtSock
+			g.readers--
 			g.mu.Unlock()
-			if conn == nil {
-				return
-			}
-			n, err := conn.Read(buf)
-			if err != nil || n < SRTMinLen {
-				log.Printf("[group %p] Failed to read the SRT sock (n=%d, err=%v), terminating the group", g, n, err)
-				removeGroup(g)
-				return
+			srtReadersActive.Add(-1)
+		}()
+
+		supervise("srt-reader", func() {
+			buf := make([]byte, MTU)
+			answered := false
+			for {
+				n, err := conn.Read(buf)
+				if err != nil || n < SRTMinLen {
+					if !g.ownsSocket(conn) {
+						return // socket was closed by the group teardown
+					}
+					log.Printf("[group %p] Failed to read the SRT sock (n=%d, err=%v), terminating the group", g, n, err)
+					reportError(srtAddr.String(), err)
+					removeGroup(g)
+					return
+				}
+				if !answered {
+					answered = true
+					clearProblem(srtAddr.String())
+				}
+				handleSRTData(g, buf[:n])
 			}
-			pkt := make([]byte, n)
-			copy(pkt, buf[:n])
-			handleSRTData(g, pkt)
-		}
+		})
 	}()
 }
 
+// ownsSocket reports whether conn is still the group's live SRT socket.
+func (g *Group) ownsSocket(conn srtConn) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !g.closed && g.srtSock == conn
+}
+
 func handleSRTData(g *Group, pkt []byte) {
 	if len(pkt) < SRTMinLen {
 		return
 	}
 
-	// Broadcast ACKs and NAKs to all connections so they reach the sender
-	// even if some connections are dead. Other packets go to last_address.
-	if isSRTAck(pkt) || isSRTNak(pkt) {
-		g.mu.Lock()
-		conns := make([]*Conn, len(g.conns))
-		copy(conns, g.conns)
-		g.mu.Unlock()
-		for _, c := range conns {
-			if _, err := srtlaSock.WriteToUDP(pkt, c.addr); err != nil {
-				log.Printf("[%s] [group %p] Failed to fwd SRT ACK/NAK: %v", c.addr, g, err)
-			}
+	if !g.session.fromServer(g, pkt) {
+		return
+	}
+	shutdown := isSRTShutdown(pkt)
+	if shutdown {
+		log.Printf("[group %p] SRT shutdown from the server", g)
+		flushGroupAcks(g) // before the sender stops listening
+		defer endGroup(g, "server shutdown", 0)
+	}
+
+	// Broadcast ACKs, NAKs and shutdowns to all connections so they reach
+	// the sender even if some connections are dead. Other packets go to
+	// last_address.
+	if isSRTAck(pkt) || isSRTNak(pkt) || shutdown {
+		if err := writeToAll(srtlaSock, pkt, g.connAddrs()); err != nil {
+			logHot("[group %p] Failed to fwd SRT ACK/NAK: %v", g, err)
 		}
-	} else {
-		g.mu.Lock()
-		dst := g.lastAddr
-		g.mu.Unlock()
-		if dst != nil {
-			if _, err := srtlaSock.WriteToUDP(pkt, dst); err != nil {
-				log.Printf("[%s] [group %p] Failed to fwd SRT pkt: %v", dst, g, err)
-			}
+	} else if dst := g.lastAddr.Load(); dst != nil {
+		if _, err := srtlaSock.WriteToUDP(pkt, dst); err != nil {
+			logHot("[%s] [group %p] Failed to fwd SRT pkt: %v", dst, g, err)
 		}
 	}
 }
 
-func handleSRTLAIncoming(pkt []byte, addr *net.UDPAddr) {
-	now := time.Now()
-
+// handleSRTLAIncoming handles registrations inline and hands every other
+// packet from a registered connection to its group's worker. It reports
+// whether pb was handed off. Called by the SRTLA reader goroutine only.
+func handleSRTLAIncoming(pb *packetBuf, n int, addr netip.AddrPort) bool {
+	pkt := pb.b[:n]
 	if isSRTLAReg1(pkt) {
-		registerGroup(addr, pkt)
-		return
+		if srtlaRelay != nil {
+			srtlaRelay.drop(addr, "registered for SRTLA")
+		}
+		if clusterNode != nil {
+			clusterNode.dropRelay(addr)
+		}
+		registerGroup(net.UDPAddrFromAddrPort(addr), pkt)
+		return false
 	}
 	if isSRTLAReg2(pkt) {
-		registerConn(addr, pkt)
-		return
+		if srtlaRelay != nil {
+			srtlaRelay.drop(addr, "registered for SRTLA")
+		}
+		registerConn(net.UDPAddrFromAddrPort(addr), pkt)
+		return false
+	}
+
+	g, c := findConn(addr)
+	if g == nil {
+		if clusterNode != nil && clusterNode.relay(pkt, addr) {
+			return false // a link of another node's group
+		}
+		if getSRTType(pkt) == SRTLATypeKeepalive && n == SRTLAKeepaliveTSLen {
+			// RTT probe of a sender asking a director, echoed like the
+			// keepalives of registered links where -director-probes
+			// is set
+			if probeAnswers != nil && probeAnswers.allow(addr.Addr().Unmap(), clk.Now()) {
+				srtlaSock.WriteToUDP(pkt, net.UDPAddrFromAddrPort(addr))
+			}
+			return false
+		}
+		detectIngest(pkt, addr) // not part of any group
+		return false
 	}
 
-	g, c := findByAddr(addr)
-	if g == nil || c == nil {
-		return // not part of any group
+	pb.n, pb.conn = n, c
+	select {
+	case g.work <- pb:
+		return true
+	default:
+		g.workDrops.Add(1) // the worker is not keeping up
+		return false
 	}
+}
 
+// handleConnPacket processes a packet from a registered connection on the
+// group's worker and reports whether it must be forwarded to the SRT server.
+func handleConnPacket(g *Group, c *Conn, pkt []byte) bool {
+	now := clk.Now()
+	g.mu.Lock()
 	c.lastRcvd = now
+	c.rxBytes += uint64(len(pkt))
+	g.lastActivity = now
+	g.mu.Unlock()
 
 	if isSRTLAKeepalive(pkt) {
+		if label, ok := keepaliveLabel(pkt); ok {
+			g.mu.Lock()
+			changed := label != c.senderLabel
+			c.senderLabel = label
+			g.mu.Unlock()
+			srtlaSock.WriteToUDP(pkt, c.addr)
+			if changed {
+				log.Printf("[%s] [group %p] Sender labels the link %q", c.addr, g, label)
+				publishLinks()
+			}
+			return false
+		}
+		if ts, ok := keepaliveTimestamp(pkt, now); ok {
+			g.mu.Lock()
+			c.clockOffset = ts.Sub(now)
+			c.clockSampled = now
+			g.mu.Unlock()
+		}
 		// Echo back the keepalive.  Do NOT update lastAddr for keepalives
-		srtlaSock.WriteToUDP(pkt, addr)
-		return
+		srtlaSock.WriteToUDP(pkt, c.addr)
+		return false
 	}
 
 	// Non-keepalive packet – must be at least SRT minimum length
 	if len(pkt) < SRTMinLen {
-		return
+		return false
 	}
 
 	// Update lastAddr only for real SRT data/control packets
-	g.mu.Lock()
-	g.lastAddr = addr
-	g.mu.Unlock()
+	g.lastAddr.Store(c.addr)
+
+	if !g.session.fromSender(g, pkt) {
+		return false
+	}
+	if isSRTShutdown(pkt) {
+		log.Printf("[%s] [group %p] SRT shutdown from the sender", c.addr, g)
+		flushGroupAcks(g) // the sender's last packets
+		endGroup(g, "sender shutdown", ShutdownGrace)
+	}
 
 	// Register packet sequence number and send SRTLA ACK when buffer is full
 	sn := getSRTSN(pkt)
 	if sn >= 0 {
-		registerPacket(g, c, sn)
-	}
-
-	// Forward to SRT socket, creating it if needed
-	if !ensureGroupSocket(g) {
-		return
-	}
+		registerPacket(g, c, sn, now)
 
-	g.mu.Lock()
-	srtConn := g.srtSock
-	g.mu.Unlock()
-	if srtConn == nil {
-		return
+		// The copy is still ACKed above so the sender's accounting for
+		// the link it came over stays right
+		if g.dedup != nil && g.dedup.duplicate(pkt, sn) {
+			g.dupDrops.Add(1)
+			return false
+		}
+		if g.integrity != nil {
+			g.integrity.addSRTPacket(pkt, sn)
+		}
 	}
 
-	_, err := srtConn.Write(pkt)
-	if err != nil {
-		log.Printf("[group %p] Failed to forward SRTLA packet, terminating the group: %v", g, err)
-		removeGroup(g)
-	}
+	// Forward to SRT socket, creating it if needed
+	return ensureGroupSocket(g)
 }
 
 // ensureGroupSocket creates the SRT socket for a group if it doesn't exist.
 // Returns true if the socket is ready.
 func ensureGroupSocket(g *Group) bool {
 	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return false
+	}
 	if g.srtSock != nil {
 		g.mu.Unlock()
 		return true
 	}
 	g.mu.Unlock()
 
-	conn, err := net.DialUDP("udp", nil, srtAddr)
+	conn, err := dialSRT(srtAddr)
 	if err != nil {
 		log.Printf("[group %p] Failed to create an SRT socket: %v", g, err)
 		removeGroup(g)
 		return false
 	}
-	if err := conn.SetReadBuffer(RecvBufSize); err != nil {
-		log.Printf("[group %p] Failed to set receive buffer: %v", g, err)
-		conn.Close()
-		removeGroup(g)
-		return false
-	}
-	if err := conn.SetWriteBuffer(SendBufSize); err != nil {
-		log.Printf("[group %p] Failed to set send buffer: %v", g, err)
-		conn.Close()
-		removeGroup(g)
-		return false
-	}
+	srtSocketsOpen.Add(1)
 
 	g.mu.Lock()
-	// Double-check – another goroutine might have created it
-	if g.srtSock != nil {
+	// Double-check – another goroutine might have created it or torn the
+	// group down in the meantime
+	if g.closed || g.srtSock != nil {
+		ready := !g.closed
 		g.mu.Unlock()
-		conn.Close()
-		return true
+		closeSRTSock(conn)
+		return ready
 	}
 	g.srtSock = conn
 	g.mu.Unlock()
 
 	log.Printf("[group %p] Created SRT socket (local %s)", g, conn.LocalAddr())
-	startSRTReader(g)
+	startSRTReader(g, conn)
 	return true
 }
 
 // registerPacket logs a received SRT data packet's sequence number and,
-// once RecvACKInterval packets have been logged, sends an SRTLA ACK back
-// to the sender.
-func registerPacket(g *Group, c *Conn, sn int32) {
+// once RecvACKInterval packets have been logged or the oldest one has
+// waited -ack-max-delay, sends an SRTLA ACK back to the sender.
+func registerPacket(g *Group, c *Conn, sn int32, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	idx := c.recvIdx + 1
 	if idx <= 0 || idx > RecvACKInterval {
 		idx = 1
 	}
 	c.recvIdx = idx
 	c.recvLog[idx-1] = uint32(sn)
+	if idx == 1 {
+		c.recvFirst = now
+	}
 
-	if c.recvIdx == RecvACKInterval {
-		// Build srtla_ack_pkt: 4 bytes type + RecvACKInterval * 4 bytes
-		var ack [4 + RecvACKInterval*4]byte
-		binary.BigEndian.PutUint32(ack[0:4], uint32(SRTLATypeACK)<<16)
-		for i := 0; i < RecvACKInterval; i++ {
-			binary.BigEndian.PutUint32(ack[4+i*4:], c.recvLog[i])
-		}
-		if _, err := srtlaSock.WriteToUDP(ack[:], c.addr); err != nil {
-			log.Printf("[%s] [group %p] Failed to send the SRTLA ACK: %v", c.addr, g, err)
-		}
-		c.recvIdx = 0
+	if c.recvIdx < RecvACKInterval && (ackMaxDelay <= 0 || now.Sub(c.recvFirst) < ackMaxDelay) {
+		return
 	}
+	flushAck(g, c)
 }
 
+var keepalivePkt = binary.BigEndian.AppendUint16(nil, SRTLATypeKeepalive)
+
 func sendKeepalive(c *Conn) {
-	var pkt [2]byte
-	binary.BigEndian.PutUint16(pkt[:], SRTLATypeKeepalive)
-	srtlaSock.WriteToUDP(pkt[:], c.addr)
+	srtlaSock.WriteToUDP(keepalivePkt, c.addr)
+}
+
+// srtlaConfig holds the runtime settings of the SRTLA receiver.
+type srtlaConfig struct {
+	SrtlaPort     uint
+	SrtHost       string
+	SrtPort       uint
+	Verbose       bool
+	CleanupPeriod time.Duration
+	GroupTimeout  time.Duration  // grace period for groups without connections
+	MaxMemory     int64          // bytes all groups may hold in flight, 0 for no cap
+	Dedup         bool           // drop data packets duplicated across links
+	ReorderDelay  time.Duration  // how long packets wait for earlier ones, 0 disables it
+	Detect        bool           // relay plain SRT senders on the SRTLA port too
+	Cluster       *clusterConfig // share groups with other nodes, nil outside cluster mode
+	AckMaxDelay   time.Duration  // longest wait for an SRTLA ACK, 0 for count based ACKs only
+	AnomalyZ      float64        // link.degrading threshold in deviations, 0 disables it
+	Capture       *captureConfig // record the SRTLA traffic, nil disables it
+	Probes        bool           // answer the RTT probes of senders asking a director
+}
+
+type pendingEvent struct {
+	name   string
+	fields map[string]any
 }
 
 func cleanup() {
-	now := time.Now()
+	now := clk.Now()
+
+	// Events are emitted after the registry locks are released so slow
+	// subscribers can never stall the packet path.
+	var evs []pendingEvent
+	removed := false
+	defer func() {
+		for _, ev := range evs {
+			emitEvent(ev.name, ev.fields)
+		}
+		if removed {
+			publishLinks()
+		}
+	}()
 
 	groupsMu.Lock()
 	defer groupsMu.Unlock()
 
 	var newGroups []*Group
-	for _, g := range groups {
+	for _, g := range groupList() {
 		g.mu.Lock()
 		var newConns []*Conn
 		for _, c := range g.conns {
-			if now.Sub(c.lastRcvd) >= ConnTimeout {
+			idle := now.Sub(c.lastRcvd)
+			if idle >= ConnTimeout {
+				label, _ := c.label()
 				log.Printf("[%s] [group %p] Connection removed (timed out)", c.addr, g)
+				fields := map[string]any{
+					"group": fmt.Sprintf("%p", g),
+					"addr":  c.addr.String(),
+				}
+				if label != "" {
+					fields["label"] = label
+				}
+				evs = append(evs, pendingEvent{"conn.removed", fields})
+				linkHistories.connDown(g, c, now, "timed out")
+				removed = true
 				continue
 			}
 			// Send keepalive to connections that haven't been heard from recently
-			if now.Sub(c.lastRcvd) >= KeepalivePeriod {
+			if idle >= KeepalivePeriod {
+				flushAck(g, c) // the tail of what the link carried
 				sendKeepalive(c)
+				evs = append(evs, pendingEvent{"conn.removal_pending", map[string]any{
+					"group":           fmt.Sprintf("%p", g),
+					"addr":            c.addr.String(),
+					"removeInSeconds": (ConnTimeout - idle).Seconds(),
+				}})
 			}
 			newConns = append(newConns, c)
 		}
 		if len(newConns) != len(g.conns) {
-			g.conns = newConns
+			g.setConns(newConns)
 		}
 
 		keep := true
-		if len(g.conns) == 0 && now.Sub(g.createdAt) > GroupTimeout {
-			keep = false
+		if len(g.conns) == 0 {
+			if idle := now.Sub(g.lastActivity); idle > emptyGroupGrace {
+				keep = false
+			} else {
+				evs = append(evs, pendingEvent{"group.removal_pending", map[string]any{
+					"group":           fmt.Sprintf("%p", g),
+					"removeInSeconds": (emptyGroupGrace - idle).Seconds(),
+				}})
+			}
 		}
 		g.mu.Unlock()
 
@@ -498,10 +878,14 @@ func cleanup() {
 			newGroups = append(newGroups, g)
 		} else {
 			log.Printf("[group %p] Removed (No connections)", g)
+			evs = append(evs, pendingEvent{"group.removed", map[string]any{
+				"group":  fmt.Sprintf("%p", g),
+				"reason": "no connections",
+			}})
 			g.close()
 		}
 	}
-	groups = newGroups
+	setGroups(newGroups)
 }
 
 func resolveSRTAddr(host string, port uint16) (*net.UDPAddr, error) {
@@ -536,10 +920,12 @@ func resolveSRTAddr(host string, port uint16) (*net.UDPAddr, error) {
 			buf := make([]byte, MTU)
 			n, err := conn.Read(buf)
 			if err == nil && n == SRTHandshakeSize {
-				conn.Close()
-				return raddr, nil
+				if _, err = parseSRTHandshake(buf[:n]); err == nil {
+					conn.Close()
+					return raddr, nil
+				}
 			}
-			log.Printf("Failed to receive handshake response (n=%d)", n)
+			log.Printf("Failed to receive handshake response (n=%d, err=%v)", n, err)
 		}
 		conn.Close()
 	}
@@ -551,48 +937,115 @@ func resolveSRTAddr(host string, port uint16) (*net.UDPAddr, error) {
 	return &net.UDPAddr{IP: addrs[0], Port: int(port)}, nil
 }
 
-func runSrtla(srtlaPort uint, srtHost string, srtPort uint, verbose bool) {
-	if verbose {
+// runSrtla runs the SRTLA receiver until ctx is cancelled.
+func runSrtla(ctx context.Context, cfg srtlaConfig) {
+	if cfg.Verbose {
 		log.SetFlags(log.LstdFlags | log.Lshortfile)
 	}
+	if cfg.CleanupPeriod <= 0 {
+		cfg.CleanupPeriod = CleanupPeriod
+	}
+	if cfg.GroupTimeout > 0 {
+		emptyGroupGrace = cfg.GroupTimeout
+	}
+	maxMemory = cfg.MaxMemory
+	dedupPackets = cfg.Dedup
+	reorderDelay = cfg.ReorderDelay
+	anomalyZ = cfg.AnomalyZ
+	ackMaxDelay = cfg.AckMaxDelay
+	if cfg.Probes {
+		probeAnswers = newProbeLimiter()
+	}
 
 	var err error
-	srtAddr, err = resolveSRTAddr(srtHost, uint16(srtPort))
+	srtAddr, err = resolveSRTAddr(cfg.SrtHost, uint16(cfg.SrtPort))
 	if err != nil {
-		log.Fatalf("Could not resolve downstream SRT server: %v", err)
+		fatalWithHint(err, cfg.SrtHost, "Could not resolve downstream SRT server: %v", err)
 	}
 	log.Printf("Downstream SRT server %s", srtAddr)
 
 	// Listen UDP (dual-stack) for SRT-LA
-	laddr := &net.UDPAddr{IP: net.IPv6unspecified, Port: int(srtlaPort)}
-	srtlaSock, err = net.ListenUDP("udp", laddr)
+	laddr := &net.UDPAddr{IP: net.IPv6unspecified, Port: int(cfg.SrtlaPort)}
+	srtlaSock, err = listenSRTLA(laddr)
 	if err != nil {
-		log.Fatalf("Failed to listen on UDP port %d: %v", srtlaPort, err)
+		fatalWithHint(err, listenSubject("udp", int(cfg.SrtlaPort)), "Failed to listen on UDP port %d: %v", cfg.SrtlaPort, err)
 	}
-	_ = srtlaSock.SetReadBuffer(RecvBufSize)
-	_ = srtlaSock.SetWriteBuffer(SendBufSize)
+	silent := listenSubject("udp", int(cfg.SrtlaPort))
+	var seen atomic.Bool
+	watchSilence(silent, &seen)
 
 	log.Printf("Listening on %s", srtlaSock.LocalAddr())
+	recordListener(ReadySRTLA, "udp", srtlaSock.LocalAddr())
+	recordTarget("srtla-downstream", "srt", srtAddr.String())
+	markReady(ReadySRTLA)
+	if cfg.Cluster != nil {
+		srtlaSock = startCluster(ctx, *cfg.Cluster, srtlaSock)
+	}
+	if cfg.Capture != nil {
+		if srtlaSock, err = startCapture(*cfg.Capture, srtlaSock); err != nil {
+			log.Fatalf("ERROR: -capture: %v", err)
+		}
+	}
+	if cfg.Detect {
+		startProtocolDetection(ctx)
+	}
 
 	// Reader goroutine for SRT-LA socket
-	go func() {
-		buf := make([]byte, MTU)
+	go supervise("srtla-reader", func() {
+		// Packets go to the group workers in the buffer they were read
+		// into; a handed off buffer is replaced from the pool.
+		pbs := make([]*packetBuf, SRTLAReadBatch)
+		bufs := make([][]byte, SRTLAReadBatch)
+		for i := range pbs {
+			pbs[i] = getPacketBuf()
+			bufs[i] = pbs[i].b[:]
+		}
+		sizes := make([]int, SRTLAReadBatch)
+		addrs := make([]netip.AddrPort, SRTLAReadBatch)
 		for {
-			n, addr, err := srtlaSock.ReadFromUDP(buf)
+			n, err := readBatch(srtlaSock, bufs, sizes, addrs)
 			if err != nil {
-				log.Printf("read error: %v", err)
+				if ctx.Err() != nil {
+					return
+				}
+				logHot("read error: %v", err)
 				continue
 			}
-			pkt := make([]byte, n)
-			copy(pkt, buf[:n])
-			handleSRTLAIncoming(pkt, addr)
+			if !seen.Load() {
+				seen.Store(true)
+				clearProblem(silent)
+			}
+			now := clk.Now().UnixNano()
+			for i := 0; i < n; i++ {
+				pbs[i].readAt = now
+				if handleSRTLAIncoming(pbs[i], sizes[i], addrs[i]) {
+					pbs[i] = getPacketBuf()
+					bufs[i] = pbs[i].b[:]
+				}
+			}
 		}
-	}()
+	})
+
+	go supervise("link-history", func() { runHistory(ctx) })
+	if ackMaxDelay > 0 {
+		go supervise("ack-flusher", func() { runAckFlusher(ctx) })
+	}
+	if anomalyZ > 0 {
+		go supervise("anomaly-watch", func() { runAnomalyWatch(ctx) })
+	}
 
 	// Periodic cleanup ticker
-	ticker := time.NewTicker(CleanupPeriod)
-	for range ticker.C {
-		cleanup()
+	ticker := clk.NewTicker(cfg.CleanupPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			srtlaSock.Close()
+			return
+		case <-ticker.C():
+			runRecovered("cleanup", cleanup)
+			runRecovered("memory-cap", enforceMemoryCap)
+		}
 	}
 }
 
@@ -602,9 +1055,12 @@ func removeGroup(g *Group) {
 
 	groupsMu.Lock()
 	defer groupsMu.Unlock()
-	for i, gg := range groups {
+	current := groupList()
+	for i, gg := range current {
 		if gg == g {
-			groups = append(groups[:i], groups[i+1:]...)
+			next := make([]*Group, 0, len(current)-1)
+			next = append(next, current[:i]...)
+			setGroups(append(next, current[i+1:]...))
 			return
 		}
 	}
@@ -613,8 +1069,22 @@ func removeGroup(g *Group) {
 func (g *Group) close() {
 	g.mu.Lock()
 	defer g.mu.Unlock()
+	if !g.closed {
+		close(g.done)
+		reason := g.endReason
+		if reason == "" {
+			reason = "group closed"
+		}
+		linkHistories.groupClosed(g, clk.Now(), reason)
+	}
+	g.closed = true
 	if g.srtSock != nil {
-		g.srtSock.Close()
+		closeSRTSock(g.srtSock)
 		g.srtSock = nil
 	}
 }
+
+func closeSRTSock(conn srtConn) {
+	conn.Close()
+	srtSocketsOpen.Add(-1)
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// srtlaHarness runs the SRTLA receiver's packet path against a fake clock
+// and in-memory sockets. Packets are fed to handleSRTLAIncoming the way the
+// reader goroutine does; the group workers and SRT readers run as usual.
+type srtlaHarness struct {
+	t    testing.TB
+	clk  *fakeClock
+	sock *fakePacketConn
+
+	mu       sync.Mutex
+	srtConns []*fakeSRTConn // in the order the groups dialed them
+}
+
+func newSRTLAHarness(t testing.TB) *srtlaHarness {
+	h := &srtlaHarness{t: t, clk: newFakeClock(), sock: newFakePacketConn()}
+	oldClk, oldSock, oldAddr, oldDial := clk, srtlaSock, srtAddr, dialSRT
+	oldAckMaxDelay, oldReorderDelay, oldDedup, oldGrace := ackMaxDelay, reorderDelay, dedupPackets, emptyGroupGrace
+	clk, srtlaSock = h.clk, h.sock
+	srtAddr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4001}
+	dialSRT = func(*net.UDPAddr) (srtConn, error) {
+		c := newFakeSRTConn()
+		h.mu.Lock()
+		h.srtConns = append(h.srtConns, c)
+		h.mu.Unlock()
+		return c, nil
+	}
+	resetGroups()
+
+	t.Cleanup(func() {
+		resetGroups()
+		// The groups' goroutines read the globals restored below
+		eventually(t, func() bool {
+			return groupWorkersActive.Load() == 0 && srtReadersActive.Load() == 0
+		}, "the group goroutines to end")
+		clk, srtlaSock, srtAddr, dialSRT = oldClk, oldSock, oldAddr, oldDial
+		ackMaxDelay, reorderDelay, dedupPackets, emptyGroupGrace = oldAckMaxDelay, oldReorderDelay, oldDedup, oldGrace
+	})
+	return h
+}
+
+// resetGroups tears down every registered group.
+func resetGroups() {
+	for _, g := range groupList() {
+		removeGroup(g)
+	}
+}
+
+// deliver passes pkt from addr to the packet path like the SRTLA reader.
+func (h *srtlaHarness) deliver(addr netip.AddrPort, pkt []byte) {
+	pb := getPacketBuf()
+	n := copy(pb.b[:], pkt)
+	pb.readAt = h.clk.Now().UnixNano()
+	if !handleSRTLAIncoming(pb, n, addr) {
+		putPacketBuf(pb)
+	}
+}
+
+// register registers a group from the first address and a connection from
+// each address, and returns the group.
+func (h *srtlaHarness) register(addrs ...netip.AddrPort) *Group {
+	h.t.Helper()
+	clientID := bytes.Repeat([]byte{byte(len(groupList()) + 1)}, SRTLAIDLen/2)
+	h.deliver(addrs[0], srtlaReg(SRTLATypeReg1, clientID))
+	replies := h.sock.sent(addrs[0])
+	if len(replies) != 1 || getSRTType(replies[0]) != SRTLATypeReg2 {
+		h.t.Fatalf("REG1: got %x, want a REG2", replies)
+	}
+	id := srtlaRegID(replies[0], SRTLATypeReg2)
+	if !bytes.Equal(id[:SRTLAIDLen/2], clientID) {
+		h.t.Fatalf("REG2 ID starts with %x, want the client ID %x", id[:SRTLAIDLen/2], clientID)
+	}
+	for _, addr := range addrs {
+		h.deliver(addr, srtlaReg(SRTLATypeReg2, id))
+		replies := h.sock.sent(addr)
+		if len(replies) != 1 || getSRTType(replies[0]) != SRTLATypeReg3 {
+			h.t.Fatalf("REG2 from %s: got %x, want a REG3", addr, replies)
+		}
+	}
+	g := findGroupByID(id)
+	if g == nil {
+		h.t.Fatal("group not registered")
+	}
+	return g
+}
+
+// srtConn returns the group's SRT socket once the worker dialed it.
+func (h *srtlaHarness) srtConn(i int) *fakeSRTConn {
+	h.t.Helper()
+	eventually(h.t, func() bool {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return len(h.srtConns) > i
+	}, "SRT socket %d", i)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.srtConns[i]
+}
+
+func srtlaReg(typ uint16, id []byte) []byte {
+	pkt := make([]byte, 2+SRTLAIDLen)
+	binary.BigEndian.PutUint16(pkt, typ)
+	copy(pkt[2:], id)
+	return pkt
+}
+
+// srtData returns an SRT data packet with sequence number sn.
+func srtData(sn uint32) []byte {
+	pkt := make([]byte, SRTMinLen+8)
+	binary.BigEndian.PutUint32(pkt, sn&0x7fffffff)
+	return pkt
+}
+
+// srtControl returns an SRT control packet of the given type.
+func srtControl(typ uint16) []byte {
+	pkt := make([]byte, SRTMinLen+4)
+	binary.BigEndian.PutUint16(pkt, typ)
+	return pkt
+}
+
+// ackedSeqs returns the sequence numbers of the SRTLA ACKs in pkts.
+func ackedSeqs(pkts [][]byte) []uint32 {
+	var sns []uint32
+	for _, p := range pkts {
+		if len(p) < 4 || getSRTType(p) != SRTLATypeACK {
+			continue
+		}
+		for off := 4; off+4 <= len(p); off += 4 {
+			sns = append(sns, binary.BigEndian.Uint32(p[off:]))
+		}
+	}
+	return sns
+}
+
+func connCount(g *Group) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.conns)
+}
+
+var (
+	link1 = netip.MustParseAddrPort("192.0.2.1:40001")
+	link2 = netip.MustParseAddrPort("198.51.100.2:40002")
+)
+
+func TestRegisterGroupAndConns(t *testing.T) {
+	h := newSRTLAHarness(t)
+	g := h.register(link1, link2)
+	if n := connCount(g); n != 2 {
+		t.Fatalf("group has %d conns, want 2", n)
+	}
+	if found, c := findConn(link2); found != g || c == nil {
+		t.Fatalf("findConn(%s) = %p, want the group", link2, found)
+	}
+}
+
+func TestRegisterConnUnknownGroup(t *testing.T) {
+	h := newSRTLAHarness(t)
+	h.deliver(link1, srtlaReg(SRTLATypeReg2, bytes.Repeat([]byte{7}, SRTLAIDLen)))
+	replies := h.sock.sent(link1)
+	if len(replies) != 1 || getSRTType(replies[0]) != SRTLATypeRegNGP {
+		t.Fatalf("got %x, want a REG_NGP", replies)
+	}
+}
+
+func TestRegisterConnLimit(t *testing.T) {
+	h := newSRTLAHarness(t)
+	g := h.register(link1)
+	for i := 1; i < MaxConnsPerGroup; i++ {
+		addr := netip.AddrPortFrom(netip.MustParseAddr("192.0.2.100"), uint16(50000+i))
+		h.deliver(addr, srtlaReg(SRTLATypeReg2, g.id[:]))
+	}
+	if n := connCount(g); n != MaxConnsPerGroup {
+		t.Fatalf("group has %d conns, want %d", n, MaxConnsPerGroup)
+	}
+	h.deliver(link2, srtlaReg(SRTLATypeReg2, g.id[:]))
+	replies := h.sock.sent(link2)
+	if len(replies) != 1 || getSRTType(replies[0]) != SRTLATypeRegErr {
+		t.Fatalf("conn %d: got %x, want a REG_ERR", MaxConnsPerGroup+1, replies)
+	}
+}
+
+func TestRegisterConnAddrInOtherGroup(t *testing.T) {
+	h := newSRTLAHarness(t)
+	h.register(link1)
+	other := h.register(link2)
+	h.deliver(link1, srtlaReg(SRTLATypeReg2, other.id[:]))
+	replies := h.sock.sent(link1)
+	if len(replies) != 1 || getSRTType(replies[0]) != SRTLATypeRegErr {
+		t.Fatalf("got %x, want a REG_ERR", replies)
+	}
+}
+
+func TestReregistrationReplacesGroup(t *testing.T) {
+	h := newSRTLAHarness(t)
+	old := h.register(link1)
+	h.deliver(link2, srtlaReg(SRTLATypeReg1, old.id[:SRTLAIDLen/2]))
+	if len(h.sock.sent(link2)) != 1 {
+		t.Fatal("no REG2 for the re-registration")
+	}
+	if findGroupByID(old.id[:]) != nil {
+		t.Fatal("the stale group is still registered")
+	}
+	if n := len(groupList()); n != 1 {
+		t.Fatalf("%d groups registered, want 1", n)
+	}
+}
+
+func TestDataForwardedToSRTServer(t *testing.T) {
+	h := newSRTLAHarness(t)
+	h.register(link1, link2)
+	h.deliver(link1, srtData(1))
+	h.deliver(link2, srtData(2))
+	conn := h.srtConn(0)
+	eventually(t, func() bool { return len(conn.written()) == 2 }, "2 packets at the SRT server")
+	for i, p := range conn.written() {
+		if sn := getSRTSN(p); sn != int32(i+1) {
+			t.Errorf("packet %d has sequence number %d, want %d", i, sn, i+1)
+		}
+	}
+}
+
+func TestSRTLAAckAfterInterval(t *testing.T) {
+	h := newSRTLAHarness(t)
+	h.register(link1)
+	for sn := uint32(1); sn < RecvACKInterval; sn++ {
+		h.deliver(link1, srtData(sn))
+	}
+	conn := h.srtConn(0)
+	eventually(t, func() bool { return len(conn.written()) == RecvACKInterval-1 }, "packets at the SRT server")
+	if acks := ackedSeqs(h.sock.sent(link1)); len(acks) != 0 {
+		t.Fatalf("ACK sent after %d packets: %v", RecvACKInterval-1, acks)
+	}
+
+	h.deliver(link1, srtData(RecvACKInterval))
+	var acks []uint32
+	eventually(t, func() bool {
+		acks = append(acks, ackedSeqs(h.sock.sent(link1))...)
+		return len(acks) == RecvACKInterval
+	}, "an SRTLA ACK")
+	for i, sn := range acks {
+		if sn != uint32(i+1) {
+			t.Fatalf("ACK %v, want 1 to %d", acks, RecvACKInterval)
+		}
+	}
+}
+
+func TestAckMaxDelayFlush(t *testing.T) {
+	h := newSRTLAHarness(t)
+	ackMaxDelay = 20 * time.Millisecond
+	g := h.register(link1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		runAckFlusher(ctx)
+		close(done)
+	}()
+	defer func() { cancel(); <-done }()
+	h.clk.waitPending(t, 1)
+
+	for sn := uint32(1); sn <= 3; sn++ {
+		h.deliver(link1, srtData(sn))
+	}
+	c := g.connList()[0]
+	eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return c.recvIdx == 3
+	}, "3 logged packets")
+
+	h.clk.Advance(10 * time.Millisecond)
+	time.Sleep(10 * time.Millisecond) // the flusher must not find anything due
+	if acks := ackedSeqs(h.sock.sent(link1)); len(acks) != 0 {
+		t.Fatalf("ACK sent before -ack-max-delay: %v", acks)
+	}
+	h.clk.Advance(10 * time.Millisecond)
+	eventually(t, func() bool {
+		acks := ackedSeqs(h.sock.sent(link1))
+		return len(acks) == 3 && acks[0] == 1 && acks[2] == 3
+	}, "an ACK of 3 packets")
+}
+
+func TestKeepaliveEchoed(t *testing.T) {
+	h := newSRTLAHarness(t)
+	g := h.register(link1, link2)
+	h.deliver(link2, keepalivePkt)
+	eventually(t, func() bool {
+		replies := h.sock.sent(link2)
+		return len(replies) == 1 && bytes.Equal(replies[0], keepalivePkt)
+	}, "the keepalive echo")
+	if last := g.lastAddr.Load().AddrPort(); last != link2 {
+		// registerConn set it, but a keepalive alone must not move it
+		t.Fatalf("lastAddr %s, want %s", last, link2)
+	}
+	h.deliver(link1, srtData(1))
+	eventually(t, func() bool { return g.lastAddr.Load().AddrPort() == link1 }, "lastAddr to follow the data")
+	h.deliver(link2, keepalivePkt)
+	eventually(t, func() bool { return len(h.sock.sent(link2)) == 1 }, "the keepalive echo")
+	if last := g.lastAddr.Load().AddrPort(); last != link1 {
+		t.Fatalf("lastAddr moved to %s on a keepalive", last)
+	}
+}
+
+func TestCleanupTimesOutConns(t *testing.T) {
+	h := newSRTLAHarness(t)
+	g := h.register(link1, link2)
+
+	h.clk.Advance(KeepalivePeriod)
+	h.deliver(link2, keepalivePkt) // link2 stays alive
+	eventually(t, func() bool { return len(h.sock.sent(link2)) == 1 }, "the keepalive echo")
+	cleanup()
+	if replies := h.sock.sent(link1); len(replies) != 1 || !bytes.Equal(replies[0], keepalivePkt) {
+		t.Fatalf("idle link got %x, want a keepalive", replies)
+	}
+	if n := connCount(g); n != 2 {
+		t.Fatalf("%d conns after %s, want 2", n, KeepalivePeriod)
+	}
+
+	h.clk.Advance(ConnTimeout - KeepalivePeriod)
+	cleanup()
+	if n := connCount(g); n != 1 {
+		t.Fatalf("%d conns after %s, want 1", n, ConnTimeout)
+	}
+	if found, _ := findConn(link1); found != nil {
+		t.Fatal("the timed out link is still found")
+	}
+	if found, _ := findConn(link2); found != g {
+		t.Fatal("the live link is no longer found")
+	}
+}
+
+func TestCleanupRemovesEmptyGroup(t *testing.T) {
+	h := newSRTLAHarness(t)
+	emptyGroupGrace = 2 * ConnTimeout
+	g := h.register(link1)
+	h.deliver(link1, srtData(1))
+	conn := h.srtConn(0)
+	eventually(t, func() bool { return len(conn.written()) == 1 }, "the packet at the SRT server")
+
+	h.clk.Advance(ConnTimeout)
+	cleanup()
+	if n := connCount(g); n != 0 {
+		t.Fatalf("%d conns after the timeout, want 0", n)
+	}
+	if findGroupByID(g.id[:]) == nil {
+		t.Fatal("group removed before its grace period")
+	}
+
+	h.clk.Advance(emptyGroupGrace - ConnTimeout + time.Millisecond)
+	cleanup()
+	if findGroupByID(g.id[:]) != nil {
+		t.Fatal("empty group not removed after its grace period")
+	}
+	if !conn.isClosed() {
+		t.Fatal("the SRT socket of the removed group is open")
+	}
+}
+
+func TestServerPacketsToSender(t *testing.T) {
+	h := newSRTLAHarness(t)
+	g := h.register(link1, link2)
+	h.deliver(link1, srtData(1))
+	conn := h.srtConn(0)
+	eventually(t, func() bool { return g.lastAddr.Load().AddrPort() == link1 }, "lastAddr")
+
+	conn.in <- srtControl(SRTTypeACK)
+	for _, addr := range []netip.AddrPort{link1, link2} {
+		eventually(t, func() bool {
+			replies := h.sock.sent(addr)
+			return len(replies) == 1 && getSRTType(replies[0]) == SRTTypeACK
+		}, "the SRT ACK on %s", addr)
+	}
+
+	conn.in <- srtData(100)
+	eventually(t, func() bool { return len(h.sock.sent(link1)) == 1 }, "the packet on the last link")
+	if replies := h.sock.sent(link2); len(replies) != 0 {
+		t.Fatalf("other link got %x", replies)
+	}
+}
+
+func TestSenderShutdownGrace(t *testing.T) {
+	h := newSRTLAHarness(t)
+	g := h.register(link1)
+	h.deliver(link1, srtData(1))
+	h.deliver(link1, srtControl(SRTTypeShutdown))
+	conn := h.srtConn(0)
+	eventually(t, func() bool { return len(conn.written()) == 2 }, "the shutdown at the SRT server")
+	h.clk.waitPending(t, 1)
+	if findGroupByID(g.id[:]) == nil {
+		t.Fatal("group removed before the shutdown grace")
+	}
+
+	h.clk.Advance(ShutdownGrace)
+	if findGroupByID(g.id[:]) != nil {
+		t.Fatal("group not removed after the shutdown grace")
+	}
+	eventually(t, conn.isClosed, "the SRT socket to close")
+}
+
+func TestSRTReadErrorRemovesGroup(t *testing.T) {
+	h := newSRTLAHarness(t)
+	g := h.register(link1)
+	h.deliver(link1, srtData(1))
+	conn := h.srtConn(0)
+	conn.Close() // as if the SRT server went away
+	eventually(t, func() bool { return findGroupByID(g.id[:]) == nil }, "the group to be removed")
+}
+
+func TestReorderReleasesAfterDelay(t *testing.T) {
+	h := newSRTLAHarness(t)
+	reorderDelay = 50 * time.Millisecond
+	h.register(link1, link2)
+	h.deliver(link1, srtData(1))
+	conn := h.srtConn(0)
+	eventually(t, func() bool { return len(conn.written()) == 1 }, "the first packet")
+
+	h.deliver(link2, srtData(3)) // 2 is still on its way
+	h.clk.waitPending(t, 1)
+	if n := len(conn.written()); n != 1 {
+		t.Fatalf("%d packets forwarded past the gap, want 1", n)
+	}
+	h.clk.Advance(reorderDelay - time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if n := len(conn.written()); n != 1 {
+		t.Fatalf("packet released before -reorder-delay")
+	}
+
+	h.clk.Advance(time.Millisecond)
+	eventually(t, func() bool { return len(conn.written()) == 2 }, "the held packet")
+	if sn := getSRTSN(conn.written()[1]); sn != 3 {
+		t.Fatalf("released %d, want 3", sn)
+	}
+}
+
+func TestReorderFillsGap(t *testing.T) {
+	h := newSRTLAHarness(t)
+	reorderDelay = time.Second
+	h.register(link1, link2)
+	for _, sn := range []uint32{1, 3, 4, 2} {
+		h.deliver(link1, srtData(sn))
+	}
+	conn := h.srtConn(0)
+	eventually(t, func() bool { return len(conn.written()) == 4 }, "4 packets")
+	for i, p := range conn.written() {
+		if sn := getSRTSN(p); sn != int32(i+1) {
+			t.Fatalf("packet %d is %d, want them in order", i, sn)
+		}
+	}
+}
+
+// discardPacketConn and discardSRTConn drop what is written to them, so
+// the benchmarks measure the packet path rather than the fakes.
+type discardPacketConn struct{ fakePacketConn }
+
+func (*discardPacketConn) WriteToUDP(b []byte, _ *net.UDPAddr) (int, error) { return len(b), nil }
+
+type discardSRTConn struct {
+	fakeSRTConn
+	writes atomic.Int64
+}
+
+func (c *discardSRTConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return len(b), nil
+}
+
+// benchGroups registers groups × conns links and switches the harness to
+// discarding sockets. It returns each group's link addresses.
+func (h *srtlaHarness) benchGroups(groups, conns int) (gs []*Group, links [][]netip.AddrPort, srt *discardSRTConn) {
+	for i := 0; i < groups; i++ {
+		addrs := make([]netip.AddrPort, conns)
+		for j := range addrs {
+			addrs[j] = netip.AddrPortFrom(netip.AddrFrom4([4]byte{10, byte(j), byte(i >> 8), byte(i)}), uint16(40000+j))
+		}
+		gs = append(gs, h.register(addrs...))
+		links = append(links, addrs)
+	}
+	srt = &discardSRTConn{fakeSRTConn: *newFakeSRTConn()}
+	dialSRT = func(*net.UDPAddr) (srtConn, error) { return srt, nil }
+	srtlaSock = &discardPacketConn{*newFakePacketConn()}
+	return gs, links, srt
+}
+
+var benchSizes = []struct{ groups, conns int }{{1, 1}, {1, 4}, {10, 4}, {100, 4}, {MaxGroups, 2}}
+
+// BenchmarkHandleSRTLAIncoming measures the sender to server direction:
+// the reader's lookup and hand-off, and the group workers forwarding to
+// the SRT server, with data spread evenly over all groups and links. The
+// reader waits for a full queue, so ns/op is the throughput of the whole
+// path; drops/op counts what a worker still lost.
+func BenchmarkHandleSRTLAIncoming(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("groups=%d/conns=%d", size.groups, size.conns), func(b *testing.B) {
+			h := newSRTLAHarness(b)
+			gs, links, srt := h.benchGroups(size.groups, size.conns)
+			pkt := srtData(0)
+			for i := range gs { // dial the SRT sockets outside the timing
+				h.deliver(links[i][0], pkt)
+			}
+			eventually(b, func() bool { return srt.writes.Load() == int64(len(gs)) }, "the SRT sockets")
+			var drops0 uint64
+			for _, g := range gs {
+				drops0 += g.workDrops.Load()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				gi := i % len(gs)
+				for len(gs[gi].work) == cap(gs[gi].work) {
+					runtime.Gosched() // like a full socket buffer, wait for the worker
+				}
+				binary.BigEndian.PutUint32(pkt, uint32(i/len(gs)+1)&0x7fffffff)
+				h.deliver(links[gi][(i/len(gs))%size.conns], pkt)
+			}
+			var drops uint64
+			for _, g := range gs {
+				drops += g.workDrops.Load()
+			}
+			want := int64(len(gs)) + int64(b.N) - int64(drops-drops0)
+			for srt.writes.Load() < want {
+				runtime.Gosched()
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(drops-drops0)/float64(b.N), "drops/op")
+		})
+	}
+}
+
+// BenchmarkHandleSRTData measures the server to sender direction: one in
+// ten packets is an SRT ACK, which goes out on every link of the group.
+func BenchmarkHandleSRTData(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("groups=%d/conns=%d", size.groups, size.conns), func(b *testing.B) {
+			h := newSRTLAHarness(b)
+			gs, _, _ := h.benchGroups(size.groups, size.conns)
+			data, ack := srtData(0), srtControl(SRTTypeACK)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pkt := data
+				if i%10 == 9 {
+					pkt = ack
+				}
+				handleSRTData(gs[i%len(gs)], pkt)
+			}
+		})
+	}
+}
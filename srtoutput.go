@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// srtOutputs collects repeated -srt-output flags: SRT listeners the proxy
+// publishes to as a caller, each with its own streamid and passphrase.
+type srtOutputs []string
+
+func (o *srtOutputs) String() string { return strings.Join(*o, " ") }
+
+func (o *srtOutputs) Set(s string) error {
+	if _, _, err := parseSRTCaller(s); err != nil {
+		return err
+	}
+	*o = append(*o, s)
+	return nil
+}
+
+// parseSRTCaller parses srt://host:port?streamid=...&passphrase=... and
+// checks its settings, so mistakes show at startup rather than at the
+// first connection attempt.
+func parseSRTCaller(addr string) (*url.URL, srt.Config, error) {
+	config := srt.DefaultConfig()
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, config, err
+	}
+	if u.Scheme != "srt" || u.Host == "" {
+		return nil, config, fmt.Errorf("expected srt://host:port?streamid=...&passphrase=..., got %q", redactURL(addr))
+	}
+	if err := config.UnmarshalQuery(u.RawQuery); err != nil {
+		return nil, config, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, config, err
+	}
+	return u, config, nil
+}
+
+var (
+	passphraseParam = regexp.MustCompile(`([?&]passphrase=)[^&]*`)
+	// The MediaMTX streamid publish:<path>:<user>:<pass>
+	mediaMTXStreamIDPass = regexp.MustCompile(`([?&]streamid=(?:publish|read):[^&:]*:[^&:]*:)[^&]*`)
+	// The password in user:pass@, also in a URL nested in a parameter
+	userinfoPass = regexp.MustCompile(`(://[^/?#@:\s]*:)[^/?#\s]*@`)
+)
+
+// redactURL hides the passphrase of an SRT URL for logs and events, the
+// password of a MediaMTX streamid and the password in the URL's userinfo.
+func redactURL(addr string) string {
+	addr = userinfoPass.ReplaceAllString(addr, "${1}xxxxx@")
+	addr = passphraseParam.ReplaceAllString(addr, "${1}xxxxx")
+	return mediaMTXStreamIDPass.ReplaceAllString(addr, "${1}xxxxx")
+}
+
+// srtCaller publishes the stream to a remote SRT listener, e.g. MediaMTX
+// or srt-live-server. It dials again whenever the connection breaks;
+// what is written meanwhile is dropped, so an unreachable destination
+// never holds up the other outputs.
+type srtCaller struct {
+	addr   string // host:port
+	name   string // the URL without its passphrase
+	config srt.Config
+
+	mu       sync.Mutex
+	conn     srt.Conn
+	dialing  bool
+	closed   bool
+	disabled bool // through the API, until enabled again
+}
+
+// srtCallers counts the SRT outputs, which are toggled as srt-output.1 and
+// so on in the order of the flags.
+var srtCallers atomic.Int32
+
+func openSRTCaller(addr string) (*srtCaller, error) {
+	u, config, err := parseSRTCaller(addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &srtCaller{addr: u.Host, name: redactURL(addr), config: config}
+	recordEndpoint(EndpointTarget, "srt-output", "srt", u.Host, c.name)
+	registerToggle(fmt.Sprintf("srt-output.%d", srtCallers.Add(1)), "publishing to "+c.name, c.enabled, c.setEnabled)
+	c.redial()
+	return c, nil
+}
+
+func (c *srtCaller) enabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.disabled
+}
+
+// setEnabled disconnects from the destination until it is enabled again,
+// the other outputs go on.
+func (c *srtCaller) setEnabled(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disabled == !on {
+		return
+	}
+	c.disabled = !on
+	if on {
+		log.Printf("[srt-output] Publishing to %s again", c.name)
+		emitEvent("output.enabled", map[string]any{"url": c.name})
+		c.redial()
+		return
+	}
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	log.Printf("[srt-output] Stopped publishing to %s", c.name)
+	emitEvent("output.disabled", map[string]any{"url": c.name})
+}
+
+// redial must be called with c.mu held or before c is shared.
+func (c *srtCaller) redial() {
+	if c.dialing || c.closed || c.disabled {
+		return
+	}
+	c.dialing = true
+	go func() {
+		for {
+			conn, err := srt.Dial("srt", c.addr, c.config)
+			c.mu.Lock()
+			if c.closed || c.disabled {
+				c.dialing = false
+				c.mu.Unlock()
+				if conn != nil {
+					conn.Close()
+				}
+				return
+			}
+			if err == nil {
+				c.conn, c.dialing = conn, false
+				c.mu.Unlock()
+				log.Printf("[srt-output] Connected to %s", c.name)
+				clearProblem(c.addr)
+				emitEvent("output.connected", map[string]any{"url": c.name})
+				return
+			}
+			c.mu.Unlock()
+			log.Printf("[srt-output] Failed to connect to %s: %v. Retrying in %s...", c.name, err, SendRedialPeriod)
+			reportError(c.addr, err)
+			time.Sleep(SendRedialPeriod)
+		}
+	}()
+}
+
+func (c *srtCaller) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return len(b), nil
+	}
+	if _, err := c.conn.Write(b); err != nil {
+		log.Printf("[srt-output] Write to %s failed: %v. Reconnecting...", c.name, err)
+		emitEvent("output.disconnected", map[string]any{"url": c.name, "error": err.Error()})
+		c.conn.Close()
+		c.conn = nil
+		c.redial()
+	}
+	return len(b), nil
+}
+
+func (c *srtCaller) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directions of an endpoint in the startup summary.
+const (
+	EndpointListen = "listen"
+	EndpointTarget = "target"
+)
+
+// endpoint is an address go-irl bound, or sends the stream to, as it
+// actually is: port 0 resolved, host names resolved.
+type endpoint struct {
+	Direction string `json:"direction"`
+	Name      string `json:"name"`  // the component, e.g. "srtla" or "udp-output"
+	Proto     string `json:"proto"` // "udp", "tcp", "srt", "unix" or "file"
+	Addr      string `json:"addr"`
+	URL       string `json:"url,omitempty"`
+}
+
+// startupSummary is what /api/startup serves and -json prints.
+type startupSummary struct {
+	Mode      string     `json:"mode"`
+	Version   string     `json:"version"`
+	Ready     bool       `json:"ready"` // every component came up in time
+	ElapsedMs int64      `json:"elapsedMs"`
+	Listeners []endpoint `json:"listeners"`
+	Targets   []endpoint `json:"targets"`
+}
+
+var topology = struct {
+	mu        sync.Mutex
+	endpoints []endpoint
+	started   time.Time
+	ready     bool
+	elapsed   time.Duration
+}{started: time.Now()}
+
+// serverURLs are the paths the HTTP servers of listenAndServe are used at.
+var serverURLs = map[string]struct{ scheme, path string }{
+	"api":              {"http", "/api/"},
+	ReadyBrowserSource: {"http", "/app"},
+	ReadyWebSocket:     {"ws", "/ws"},
+}
+
+// recordEndpoint adds an endpoint to the summary. Reopening a listener or
+// an output for the next stream records it again, which is a no-op.
+func recordEndpoint(direction, name, proto, addr, url string) {
+	e := endpoint{Direction: direction, Name: name, Proto: proto, Addr: addr, URL: url}
+	topology.mu.Lock()
+	defer topology.mu.Unlock()
+	for _, old := range topology.endpoints {
+		if old == e {
+			return
+		}
+	}
+	topology.endpoints = append(topology.endpoints, e)
+}
+
+func recordListener(name, proto string, addr net.Addr) {
+	recordEndpoint(EndpointListen, name, proto, addr.String(), "")
+}
+
+func recordTarget(name, proto, addr string) {
+	recordEndpoint(EndpointTarget, name, proto, addr, "")
+}
+
+// recordHTTPServer records the listener of an HTTP server started by
+// listenAndServe, with the URL it is used at.
+func recordHTTPServer(name string, addr net.Addr) {
+	var url string
+	if u, ok := serverURLs[name]; ok {
+		host, port, _ := net.SplitHostPort(addr.String())
+		p, _ := strconv.Atoi(port)
+		url = webURL(u.scheme, host, p, u.path)
+	}
+	recordEndpoint(EndpointListen, name, "tcp", addr.String(), url)
+}
+
+func currentStartupSummary() startupSummary {
+	topology.mu.Lock()
+	defer topology.mu.Unlock()
+	s := startupSummary{
+		Mode:      *mode,
+		Version:   version,
+		Ready:     topology.ready,
+		ElapsedMs: topology.elapsed.Milliseconds(),
+		Listeners: []endpoint{},
+		Targets:   []endpoint{},
+	}
+	if s.Mode == "" {
+		s.Mode = ModeStandalone
+	}
+	for _, e := range topology.endpoints {
+		if e.Direction == EndpointListen {
+			s.Listeners = append(s.Listeners, e)
+		} else {
+			s.Targets = append(s.Targets, e)
+		}
+	}
+	return s
+}
+
+// startupComponents are the components whose readiness the summary waits
+// for in the roles that run.
+func startupComponents() []string {
+	var names []string
+	if *apiPort > 0 {
+		names = append(names, "api")
+	}
+	if runsReceiver() {
+		names = append(names, ReadySRTLA)
+	}
+	if runsProxy() || roles[ModeDirector] {
+		if *wsPort > 0 {
+			names = append(names, ReadyWebSocket)
+		}
+	}
+	if runsProxy() {
+		names = append(names, ReadyBrowserSource)
+		switch {
+		case roles[ModeStandalone] && *inputAddr == "":
+			names = append(names, readySRTListener(InternalSRTHost))
+		case roles[ModeClient] && *inputAddr == "":
+			names = append(names, readySRTListener(fmt.Sprintf("0.0.0.0:%d", *srtPort)))
+			if *srtBackupPort > 0 {
+				names = append(names, readySRTListener(fmt.Sprintf("0.0.0.0:%d", *srtBackupPort)))
+			}
+		}
+	}
+	return names
+}
+
+// reportStartup waits for the components of the running roles, then logs
+// the startup summary, or prints it as JSON on stdout with -json.
+func reportStartup() {
+	_, err := waitReady(StartupTimeout, nil, startupComponents()...)
+	if err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+	topology.mu.Lock()
+	topology.ready = err == nil
+	topology.elapsed = time.Since(topology.started)
+	topology.mu.Unlock()
+	if err == nil {
+		emitEvent("startup.ready", map[string]any{"elapsedMs": topology.elapsed.Milliseconds()})
+	}
+
+	s := currentStartupSummary()
+	if *jsonSummary {
+		b, _ := json.Marshal(s)
+		fmt.Println(string(b))
+		return
+	}
+	state := fmt.Sprintf("ready in %v", topology.elapsed.Round(time.Millisecond))
+	if !s.Ready {
+		state = "not fully ready"
+	}
+	log.Printf("[startup] %s mode, go-irl %s, %s", s.Mode, s.Version, state)
+	for _, e := range append(s.Listeners, s.Targets...) {
+		line := fmt.Sprintf("[startup] %-6s %-3s %-22s %s", e.Direction, e.Proto, e.Addr, e.Name)
+		if e.URL != "" {
+			line += "  " + e.URL
+		}
+		log.Print(strings.TrimRight(line, " "))
+	}
+}
+
+func handleStartup(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, currentStartupSummary())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// StatsSummaryInterval is the default of -stats-summary.
+const StatsSummaryInterval = 5 * time.Second
+
+// statsSnapshotRequested makes the next reportIfDue report right away,
+// set when a WebSocket client sends {"type": "stats_request"}.
+var statsSnapshotRequested atomic.Bool
+
+// statsSummaryMessage averages the SRT stats over -stats-summary, for
+// dashboards that can't take a full stats message every second. Clients
+// connecting to /ws?stats=summary get these instead of the reader and
+// writer messages.
+type statsSummaryMessage struct {
+	Timestamp       time.Time     `json:"timestamp"`
+	Type            string        `json:"type"` // always "stats_summary"
+	IntervalSeconds float64       `json:"intervalSeconds"`
+	Reader          *statsSummary `json:"reader,omitempty"`
+	Writer          *statsSummary `json:"writer,omitempty"`
+}
+
+type statsSummary struct {
+	Mbps          float64 `json:"mbps"`
+	RttMs         float64 `json:"rttMs"` // mean of the samples
+	RttMsMax      float64 `json:"rttMsMax"`
+	Packets       uint64  `json:"packets"`
+	Lost          uint64  `json:"lost"`
+	Retransmitted uint64  `json:"retransmitted"`
+	Dropped       uint64  `json:"dropped"`
+	LossPercent   float64 `json:"lossPercent"`
+}
+
+// statsAccumulator collects one side's stats between two summaries.
+type statsAccumulator struct {
+	base, last     srt.StatisticsAccumulated // counters when the window began and now
+	started        bool
+	samples        int
+	rttSum, rttMax float64
+}
+
+func (a *statsAccumulator) add(st *srt.Statistics) {
+	switch {
+	case !a.started:
+		a.base, a.started = st.Accumulated, true
+	case st.Accumulated.ByteRecv+st.Accumulated.ByteSent < a.last.ByteRecv+a.last.ByteSent:
+		a.base = srt.StatisticsAccumulated{} // the connection was replaced, its counters start at 0
+	}
+	a.last = st.Accumulated
+	a.samples++
+	a.rttSum += st.Instantaneous.MsRTT
+	a.rttMax = max(a.rttMax, st.Instantaneous.MsRTT)
+}
+
+// summary returns the summary since the last one, nil without samples,
+// counting received traffic for the reader and sent traffic for the writer.
+func (a *statsAccumulator) summary(recv bool, elapsed time.Duration) *statsSummary {
+	if a.samples == 0 {
+		return nil
+	}
+	f, l := a.base, a.last
+	var s statsSummary
+	var bytes uint64
+	if recv {
+		bytes = l.ByteRecv - f.ByteRecv
+		s.Packets, s.Lost = l.PktRecv-f.PktRecv, l.PktRecvLoss-f.PktRecvLoss
+		s.Retransmitted, s.Dropped = l.PktRecvRetrans-f.PktRecvRetrans, l.PktRecvDrop-f.PktRecvDrop
+	} else {
+		bytes = l.ByteSent - f.ByteSent
+		s.Packets, s.Lost = l.PktSent-f.PktSent, l.PktSendLoss-f.PktSendLoss
+		s.Retransmitted, s.Dropped = l.PktRetrans-f.PktRetrans, l.PktSendDrop-f.PktSendDrop
+	}
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+	s.Mbps = round(float64(bytes) * 8 / elapsed.Seconds() / 1e6)
+	s.RttMs, s.RttMsMax = round(a.rttSum/float64(a.samples)), round(a.rttMax)
+	if s.Packets+s.Lost > 0 {
+		s.LossPercent = round(100 * float64(s.Lost) / float64(s.Packets+s.Lost))
+	}
+	a.base, a.samples, a.rttSum, a.rttMax = l, 0, 0, 0
+	return &s
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// Stream modes of the SRT access control streamid convention
+// (https://github.com/Haivision/srt/blob/master/docs/features/access-control.md),
+// as used by srt-live-server and most SRT tooling.
+const (
+	StreamModeRequest       = "request" // play / subscribe
+	StreamModePublish       = "publish"
+	StreamModeBidirectional = "bidirectional"
+)
+
+// streamID is a parsed SRT streamid.
+type streamID struct {
+	Resource string // "r", e.g. "live/feed1"
+	Mode     string // "m", defaults to publish
+	User     string // "u"
+	Host     string // "h"
+	Session  string // "s"
+	Type     string // "t"
+}
+
+// parseStreamID parses the "#!::r=live/feed1,m=publish" access control
+// syntax. Any other non-empty streamid is taken as the resource name of a
+// publisher, which keeps plain streamids working as before.
+func parseStreamID(s string) streamID {
+	id := streamID{Mode: StreamModePublish}
+
+	body, ok := strings.CutPrefix(s, "#!::")
+	if !ok {
+		id.Resource = s
+		return id
+	}
+
+	for _, kv := range strings.Split(body, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		switch strings.TrimSpace(k) {
+		case "r":
+			id.Resource = v
+		case "m":
+			id.Mode = v
+		case "u":
+			id.User = v
+		case "h":
+			id.Host = v
+		case "s":
+			id.Session = v
+		case "t":
+			id.Type = v
+		}
+	}
+	return id
+}
+
+// sameResource reports whether two streamids, in either syntax, name the
+// same resource.
+func sameResource(a, b string) bool {
+	return parseStreamID(a).Resource == parseStreamID(b).Resource
+}
+
+// classifyConnRequest maps an incoming SRT request to the role it asks for.
+// It returns srt.REJECT together with a rejection reason if the streamid
+// cannot be served by a listener configured with want.
+func classifyConnRequest(want string, req srt.ConnRequest) (srt.ConnType, srt.RejectionReason) {
+	id := parseStreamID(req.StreamId())
+
+	switch id.Mode {
+	case StreamModePublish, "":
+	case StreamModeRequest:
+		return srt.SUBSCRIBE, 0
+	default:
+		return srt.REJECT, srt.REJX_BAD_MODE
+	}
+
+	if id.Type != "" && id.Type != "stream" {
+		return srt.REJECT, srt.REJX_NOTSUP_MEDIA
+	}
+	if !acceptStreamID(want, req.StreamId()) {
+		return srt.REJECT, srt.REJX_NOTFOUND
+	}
+	return srt.PUBLISH, 0
+}
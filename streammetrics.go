@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+const (
+	// StreamMetricsVersion is bumped when a field of streamMetricsMessage
+	// changes meaning or is removed. New fields may appear in any version.
+	StreamMetricsVersion = 1
+
+	StreamMetricsWindow = 10 * time.Second // for the smoothed bitrate, the loss and the retransmission ratio
+)
+
+// streamMetricsMessage holds values derived from the ingest's raw SRT
+// stats, for overlays that don't want to do the math. It is broadcast on
+// the WebSocket with every stats report and served at /api/stream.
+type streamMetricsMessage struct {
+	Timestamp         time.Time `json:"timestamp"`
+	Type              string    `json:"type"` // always "stream_metrics"
+	SchemaVersion     int       `json:"schemaVersion"`
+	UptimeSeconds     float64   `json:"uptimeSeconds"`  // of the current SRT connection
+	BitrateKbps1s     float64   `json:"bitrateKbps1s"`  // received over the last second
+	BitrateKbps10s    float64   `json:"bitrateKbps10s"` // received over the last 10 seconds
+	LossPercent       float64   `json:"lossPercent"`    // lost / (received + lost) over 10 seconds
+	RetransmitPercent float64   `json:"retransmitPercent"`
+	RttMs             float64   `json:"rttMs"`
+	PacketsReceived   uint64    `json:"packetsReceived"` // totals of the current connection
+	PacketsLost       uint64    `json:"packetsLost"`
+	PacketsRetrans    uint64    `json:"packetsRetransmitted"`
+	PacketsDropped    uint64    `json:"packetsDropped"`
+}
+
+type streamSample struct {
+	at                            time.Time
+	bytes, pkts, lost, retr, drop uint64
+}
+
+type streamMetricsTracker struct {
+	mu      sync.Mutex
+	samples []streamSample // oldest first, covering StreamMetricsWindow
+	last    streamMetricsMessage
+}
+
+var streamMetrics = &streamMetricsTracker{}
+
+// update adds a stats sample of the ingest connection and returns the
+// derived metrics.
+func (t *streamMetricsTracker) update(s *srt.Statistics, now time.Time) streamMetricsMessage {
+	a := s.Accumulated
+	cur := streamSample{now, a.ByteRecv, a.PktRecv, a.PktRecvLoss, a.PktRecvRetrans, a.PktRecvDrop}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := len(t.samples); n > 0 && cur.bytes < t.samples[n-1].bytes {
+		t.samples = t.samples[:0] // a new connection, its counters start at 0
+	}
+	t.samples = append(t.samples, cur)
+	for len(t.samples) > 2 && now.Sub(t.samples[1].at) >= StreamMetricsWindow {
+		t.samples = t.samples[1:]
+	}
+
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+	m := streamMetricsMessage{
+		Timestamp:       now,
+		Type:            "stream_metrics",
+		SchemaVersion:   StreamMetricsVersion,
+		UptimeSeconds:   float64(s.MsTimeStamp) / 1000,
+		RttMs:           round(s.Instantaneous.MsRTT),
+		PacketsReceived: cur.pkts,
+		PacketsLost:     cur.lost,
+		PacketsRetrans:  cur.retr,
+		PacketsDropped:  cur.drop,
+	}
+	kbps := func(from streamSample) float64 {
+		if dt := now.Sub(from.at).Seconds(); dt > 0 {
+			return round(float64(cur.bytes-from.bytes) * 8 / dt / 1000)
+		}
+		return 0
+	}
+	oldest := t.samples[0]
+	m.BitrateKbps10s = kbps(oldest)
+	// the newest sample at least a second old
+	for i := len(t.samples) - 1; i >= 0; i-- {
+		if now.Sub(t.samples[i].at) >= time.Second || i == 0 {
+			m.BitrateKbps1s = kbps(t.samples[i])
+			break
+		}
+	}
+	if pkts, lost := cur.pkts-oldest.pkts, cur.lost-oldest.lost; pkts+lost > 0 {
+		m.LossPercent = round(100 * float64(lost) / float64(pkts+lost))
+	}
+	if pkts := cur.pkts - oldest.pkts; pkts > 0 {
+		m.RetransmitPercent = round(100 * float64(cur.retr-oldest.retr) / float64(pkts))
+	}
+	t.last = m
+	return m
+}
+
+// ingestStats is the last raw stats report of the ingest connection, for
+// /stats and /metrics.
+var ingestStats struct {
+	mu    sync.Mutex
+	at    time.Time
+	stats srt.Statistics
+}
+
+func recordIngestStats(s *srt.Statistics, now time.Time) {
+	ingestStats.mu.Lock()
+	ingestStats.at, ingestStats.stats = now, *s
+	ingestStats.mu.Unlock()
+}
+
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	streamMetrics.mu.Lock()
+	m := streamMetrics.last
+	streamMetrics.mu.Unlock()
+	if m.Timestamp.IsZero() {
+		http.Error(w, "no stream", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, m)
+}
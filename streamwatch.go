@@ -0,0 +1,54 @@
+package main
+
+import "time"
+
+const (
+	StreamCheckPeriod     = 1 * time.Second
+	StreamStopTimeout     = 5 * time.Second // no data for this long means the stream stopped
+	StreamLowBitrateAfter = 5 * time.Second // below -low-bitrate for this long raises stream.low_bitrate
+)
+
+// runStreamWatch turns the proxy's data flow into stream.started and
+// stream.stopped events, and, with lowKbps set, into stream.low_bitrate and
+// stream.bitrate_recovered events, for actions and overlays to act on.
+func runStreamWatch(lowKbps int) {
+	ticker := time.NewTicker(StreamCheckPeriod)
+	defer ticker.Stop()
+
+	var live, low bool
+	var started, lowSince time.Time
+	for now := range ticker.C {
+		last := srtLastData.Load()
+		nowLive := last != 0 && now.Sub(time.Unix(0, last)) < StreamStopTimeout
+		switch {
+		case nowLive && !live:
+			started = now
+			emitEvent("stream.started", nil)
+		case !nowLive && live:
+			emitEvent("stream.stopped", map[string]any{"durationSeconds": int(now.Sub(started).Seconds())})
+			low, lowSince = false, time.Time{}
+		}
+		live = nowLive
+		if !live || lowKbps <= 0 {
+			continue
+		}
+
+		snap := abr.snapshot()
+		if snap.Timestamp.IsZero() {
+			continue // not an SRT source, e.g. -input
+		}
+		kbps := snap.RecvKbps
+		switch {
+		case kbps >= float64(lowKbps):
+			if low {
+				emitEvent("stream.bitrate_recovered", map[string]any{"recvKbps": int(kbps)})
+			}
+			low, lowSince = false, time.Time{}
+		case lowSince.IsZero():
+			lowSince = now
+		case !low && now.Sub(lowSince) >= StreamLowBitrateAfter:
+			low = true
+			emitEvent("stream.low_bitrate", map[string]any{"recvKbps": int(kbps), "thresholdKbps": lowKbps})
+		}
+	}
+}
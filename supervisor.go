@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const SupervisorRestartDelay = 1 * time.Second
+
+var (
+	restartsMu sync.Mutex
+	restarts   = make(map[string]int) // subsystem name -> number of recovered panics
+)
+
+// supervise runs fn and restarts it after SupervisorRestartDelay whenever it
+// panics, so a bug in one subsystem does not leave the process running in a
+// half-broken state. supervise returns once fn returns normally.
+func supervise(name string, fn func()) {
+	for !runRecovered(name, fn) {
+		time.Sleep(SupervisorRestartDelay)
+		log.Printf("[supervisor] Restarting %s", name)
+	}
+}
+
+// runRecovered calls fn and reports whether it returned without panicking.
+func runRecovered(name string, fn func()) (ok bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("[supervisor] %s panicked: %v\n%s", name, r, debug.Stack())
+
+		restartsMu.Lock()
+		restarts[name]++
+		n := restarts[name]
+		restartsMu.Unlock()
+
+		emitEvent("subsystem.panic", map[string]any{
+			"subsystem": name,
+			"panic":     fmt.Sprint(r),
+			"restarts":  n,
+		})
+		ok = false
+	}()
+	fn()
+	return true
+}
+
+// subsystemRestarts returns a snapshot of recovered panics per subsystem.
+func subsystemRestarts() map[string]int {
+	restartsMu.Lock()
+	defer restartsMu.Unlock()
+	out := make(map[string]int, len(restarts))
+	for k, v := range restarts {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// testSignalPhase is a part of the -test-signal scenario. The values are
+// reached by the end of the phase, moving there from the previous phase's.
+type testSignalPhase struct {
+	name    string
+	length  time.Duration
+	mbps    float64
+	rttMs   float64
+	lossPct float64
+	silent  bool // no stats at all, for the overlay's disconnected state
+}
+
+// testSignalScenario cycles through every state an overlay shows: a good
+// stream, one that degrades until the overlay switches to poor connection,
+// its recovery, and a dropped stream.
+var testSignalScenario = []testSignalPhase{
+	{name: "good", length: 30 * time.Second, mbps: 6, rttMs: 45, lossPct: 0.5},
+	{name: "degrading", length: 10 * time.Second, mbps: 2, rttMs: 220, lossPct: 10},
+	{name: "poor", length: 8 * time.Second, mbps: 0.6, rttMs: 400, lossPct: 30},
+	{name: "recovering", length: 10 * time.Second, mbps: 5, rttMs: 60, lossPct: 1},
+	{name: "dropped", length: 8 * time.Second, silent: true},
+}
+
+// runTestSignal publishes made up reader stats while no stream is
+// connected, so overlays can be laid out in OBS without a phone streaming.
+// A real stream takes over as soon as it delivers data.
+func runTestSignal() {
+	log.Printf("[test-signal] Sending synthetic stats while no stream is connected")
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var acc srt.StatisticsAccumulated
+	var prev testSignalPhase
+	var phase string
+	for now := range ticker.C {
+		last := srtLastData.Load()
+		if last != 0 && now.Sub(time.Unix(0, last)) < StreamStopTimeout {
+			start = now // start from the good phase when the stream ends
+			continue
+		}
+		hub := statsHub
+		if hub == nil {
+			continue
+		}
+
+		p, frac := testSignalAt(now.Sub(start))
+		if p.name != phase {
+			phase = p.name
+			log.Printf("[test-signal] Phase %s", phase)
+		}
+		if p.silent {
+			prev = testSignalPhase{}
+			continue
+		}
+		if prev.mbps == 0 {
+			prev = testSignalScenario[0]
+		}
+		lerp := func(a, b float64) float64 { return a + (b-a)*frac }
+		// Some jitter, so graphs don't look flat
+		wobble := 1 + 0.08*math.Sin(float64(now.UnixMilli())/700)
+		mbps := lerp(prev.mbps, p.mbps) * wobble
+		rtt := lerp(prev.rttMs, p.rttMs) * wobble
+		loss := lerp(prev.lossPct, p.lossPct)
+		if frac >= 1 {
+			prev = p
+		}
+
+		secs := statsInterval.Seconds()
+		pkts := uint64(mbps * 1e6 / 8 / 1316 * secs)
+		lost := uint64(float64(pkts) * loss / 100)
+		acc.PktRecv += pkts
+		acc.ByteRecv += pkts * 1316
+		acc.PktRecvLoss += lost
+		stats := &srt.Statistics{
+			MsTimeStamp: uint64(now.Sub(start).Milliseconds()),
+			Accumulated: acc,
+			Interval: srt.StatisticsInterval{
+				MsInterval:   uint64(statsInterval.Milliseconds()),
+				PktRecv:      pkts,
+				PktRecvLoss:  lost,
+				ByteRecv:     pkts * 1316,
+				MbpsRecvRate: mbps,
+			},
+			Instantaneous: srt.StatisticsInstantaneous{
+				MsRTT:            rtt,
+				MbpsRecvRate:     mbps,
+				MbpsLinkCapacity: mbps * 1.5,
+				MsRecvBuf:        uint64(min(rtt*4, 2000)),
+				MsRecvTsbPdDelay: 2000,
+				PktRecvLossRate:  loss,
+			},
+		}
+		msg := statsMessage{Timestamp: now, Type: "reader", Stats: stats, Test: true}
+		if data, err := json.Marshal(msg); err == nil {
+			hub.publishDetail(data)
+		}
+	}
+}
+
+// testSignalAt returns the phase of the scenario at d since it started and
+// how far into the phase d is, from 0 to 1.
+func testSignalAt(d time.Duration) (testSignalPhase, float64) {
+	var total time.Duration
+	for _, p := range testSignalScenario {
+		total += p.length
+	}
+	d %= total
+	for _, p := range testSignalScenario {
+		if d < p.length {
+			return p, min(float64(d)/float64(p.length)*2, 1) // there halfway in
+		}
+		d -= p.length
+	}
+	return testSignalScenario[0], 1
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DefaultTheme is the default of -theme, the look of the first releases.
+const DefaultTheme = "dark"
+
+// themes are the palettes of the Browser Source, served as CSS variables
+// (--goirl-<name>) at /theme.css. The chroma palettes fill the background
+// with the key color and keep it out of everything drawn on top.
+var themes = map[string]map[string]string{
+	"dark": {
+		"background": "transparent",
+		"panel":      "rgba(20, 20, 20, 0.8)",
+		"graph":      "rgba(0, 0, 0, 0.9)",
+		"text":       "#CFD8DC",
+		"shadow":     "none",
+		"bitrate":    "#42A5F5",
+		"rtt":        "#66BB6A",
+		"loss":       "#FFB74D",
+		"good":       "#8BC34A",
+		"warn":       "#FFC107",
+		"bad":        "#E57373",
+		"idle":       "#CFD8DC",
+		"axis":       "#757575",
+	},
+	"light": {
+		"background": "transparent",
+		"panel":      "rgba(255, 255, 255, 0.85)",
+		"graph":      "rgba(255, 255, 255, 0.9)",
+		"text":       "#263238",
+		"shadow":     "none",
+		"bitrate":    "#1E88E5",
+		"rtt":        "#2E7D32",
+		"loss":       "#EF6C00",
+		"good":       "#7CB342",
+		"warn":       "#FFA000",
+		"bad":        "#D32F2F",
+		"idle":       "#90A4AE",
+		"axis":       "#9E9E9E",
+	},
+	// transparent draws only the text and the graph, with a shadow keeping
+	// them readable on any scene
+	"transparent": {
+		"background": "transparent",
+		"panel":      "transparent",
+		"graph":      "transparent",
+		"text":       "#FFFFFF",
+		"shadow":     "0 0 3px #000, 0 0 1px #000",
+		"bitrate":    "#64B5F6",
+		"rtt":        "#81C784",
+		"loss":       "#FFB74D",
+		"good":       "#8BC34A",
+		"warn":       "#FFC107",
+		"bad":        "#E57373",
+		"idle":       "#CFD8DC",
+		"axis":       "#BDBDBD",
+	},
+	"chroma-green": {
+		"background": "#00FF00",
+		"panel":      "#00FF00",
+		"graph":      "#00FF00",
+		"text":       "#FFFFFF",
+		"shadow":     "none",
+		"bitrate":    "#2962FF",
+		"rtt":        "#FFFFFF",
+		"loss":       "#FF6D00",
+		"good":       "#FFFFFF",
+		"warn":       "#FFAB00",
+		"bad":        "#D50000",
+		"idle":       "#9E9E9E",
+		"axis":       "#424242",
+	},
+	"chroma-blue": {
+		"background": "#0000FF",
+		"panel":      "#0000FF",
+		"graph":      "#0000FF",
+		"text":       "#FFFFFF",
+		"shadow":     "none",
+		"bitrate":    "#FFFFFF",
+		"rtt":        "#00E676",
+		"loss":       "#FF9100",
+		"good":       "#00E676",
+		"warn":       "#FFC400",
+		"bad":        "#FF1744",
+		"idle":       "#BDBDBD",
+		"axis":       "#E0E0E0",
+	},
+}
+
+// Browser Source theme, see -theme and -theme-vars.
+var (
+	themeName      = DefaultTheme
+	themeOverrides map[string]string
+)
+
+// parseThemeVars parses -theme-vars, e.g. "text=#fff,panel=rgba(0,0,0,0.5)".
+func parseThemeVars(s string) (map[string]string, error) {
+	vars := map[string]string{}
+	if strings.TrimSpace(s) == "" {
+		return vars, nil
+	}
+	// values like rgba(...) contain commas, split only outside parentheses
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, s[start:])
+	for _, p := range parts {
+		name, value, ok := strings.Cut(strings.TrimSpace(p), "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("%q is not name=value", p)
+		}
+		if _, known := themes[DefaultTheme][name]; !known {
+			return nil, fmt.Errorf("unknown variable %q, known are %s", name, strings.Join(themeVarNames(), ", "))
+		}
+		if strings.ContainsAny(value, ";{}<>\\") {
+			return nil, fmt.Errorf("invalid value %q for %s", value, name)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+func themeVarNames() []string {
+	var names []string
+	for name := range themes[DefaultTheme] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func themeNames() []string {
+	var names []string
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleThemeCSS serves the theme as CSS variables. A Browser Source can
+// pick another theme than -theme with ?theme=, the -theme-vars apply to
+// every theme.
+func handleThemeCSS(w http.ResponseWriter, r *http.Request) {
+	name := themeName
+	if q := r.URL.Query().Get("theme"); q != "" {
+		if _, ok := themes[q]; !ok {
+			http.Error(w, "unknown theme, known are "+strings.Join(themeNames(), ", "), http.StatusNotFound)
+			return
+		}
+		name = q
+	}
+	var b strings.Builder
+	b.WriteString(":root {\n")
+	for _, v := range themeVarNames() {
+		value := themes[name][v]
+		if o, ok := themeOverrides[v]; ok {
+			value = o
+		}
+		fmt.Fprintf(&b, "  --goirl-%s: %s;\n", v, value)
+	}
+	b.WriteString("}\n\nhtml, body {\n  background: var(--goirl-background);\n}\n")
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// tlsConfig serves the API, WebSocket and Browser Source over HTTPS/WSS,
+// nil for plain HTTP. tlsHost is the name the certificate is for, used in
+// the addresses logged at startup.
+var (
+	tlsConfig *tls.Config
+	tlsHost   string
+)
+
+// setupTLS enables TLS with a certificate from files (-tls-cert/-tls-key)
+// or from ACME (-tls-domain).
+func setupTLS(certFile, keyFile, domain string, acmeCfg acmeConfig) error {
+	switch {
+	case domain != "" && (certFile != "" || keyFile != ""):
+		return fmt.Errorf("-tls-domain and -tls-cert/-tls-key are mutually exclusive")
+	case domain != "":
+		m, err := newACMEManager(domain, acmeCfg)
+		if err != nil {
+			return err
+		}
+		tlsConfig, tlsHost = &tls.Config{GetCertificate: m.getCertificate}, domain
+		m.start()
+	case certFile != "" || keyFile != "":
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil && len(leaf.DNSNames) > 0 {
+			tlsHost = leaf.DNSNames[0]
+		}
+	}
+	return nil
+}
+
+// listenAndServe is http.ListenAndServe, over TLS when it is enabled and
+// under -base-path when it is set. Requests are counted by server name
+// for /metrics, and the server is marked ready by that name once it
+// listens.
+func listenAndServe(name, addr string, h http.Handler) error {
+	h = countRequests(name, withBasePath(h))
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	recordHTTPServer(name, ln.Addr())
+	markReady(name)
+	srv := &http.Server{Addr: addr, Handler: h, TLSConfig: tlsConfig}
+	if tlsConfig == nil {
+		return srv.Serve(ln)
+	}
+	return srv.ServeTLS(ln, "", "")
+}
+
+// webURL formats the address of a local server for the logs: scheme
+// ("http" or "ws") gets its TLS variant and host the certificate's name
+// when TLS is enabled.
+func webURL(scheme, host string, port int, path string) string {
+	if tlsConfig != nil {
+		scheme += "s"
+		if tlsHost != "" {
+			host = tlsHost
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)) + path
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// toggle is a subsystem the API can switch off and on again while the
+// stream runs, e.g. to stop restreaming to one target without dropping
+// the others. Subsystems register theirs when they start.
+type toggle struct {
+	name        string
+	description string
+	enabled     func() bool
+	setEnabled  func(bool) // logs and emits the subsystem's own events
+}
+
+var toggles struct {
+	mu   sync.Mutex
+	list []*toggle // in the order they registered
+}
+
+func registerToggle(name, description string, enabled func() bool, setEnabled func(bool)) {
+	toggles.mu.Lock()
+	defer toggles.mu.Unlock()
+	toggles.list = append(toggles.list, &toggle{name, description, enabled, setEnabled})
+}
+
+func findToggle(name string) *toggle {
+	toggles.mu.Lock()
+	defer toggles.mu.Unlock()
+	for _, t := range toggles.list {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+type toggleInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+}
+
+func (t *toggle) info() toggleInfo {
+	return toggleInfo{Name: t.name, Description: t.description, Enabled: t.enabled()}
+}
+
+func handleToggles(w http.ResponseWriter, r *http.Request) {
+	toggles.mu.Lock()
+	list := make([]toggleInfo, len(toggles.list))
+	for i, t := range toggles.list {
+		list[i] = t.info()
+	}
+	toggles.mu.Unlock()
+	writeJSON(w, list)
+}
+
+// handleToggleSet switches a subsystem: {"enabled": false} turns it off
+// until it is turned on again, go-irl restarts in the state of its flags.
+func handleToggleSet(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Enabled == nil {
+		http.Error(w, "enabled is missing", http.StatusBadRequest)
+		return
+	}
+	t := findToggle(r.PathValue("name"))
+	if t == nil {
+		http.Error(w, "unknown subsystem "+r.PathValue("name"), http.StatusNotFound)
+		return
+	}
+	t.setEnabled(*req.Enabled)
+	writeJSON(w, t.info())
+}
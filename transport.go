@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"time"
+)
+
+// The srtla code talks to the network and the clock only through the
+// interfaces below, so timeouts, keepalives, cleanup and ACK batching can be
+// driven deterministically with in-memory sockets and a fake clock.
+
+// packetConn is the subset of *net.UDPConn used for the public SRTLA socket.
+type packetConn interface {
+	ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// srtConn is the subset of *net.UDPConn used for a group's connected socket
+// towards the downstream SRT server.
+type srtConn interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// clock is the time source of the srtla code: timestamps, the cleanup and
+// flusher tickers, the worker's reorder deadline and the shutdown grace.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+	NewTimer(d time.Duration) timer
+	AfterFunc(d time.Duration, f func()) timer
+}
+
+// ticker and timer are *time.Ticker and *time.Timer with the channel
+// behind a method, so a fake clock can provide its own.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) ticker { return realTicker{time.NewTicker(d)} }
+
+func (realClock) NewTimer(d time.Duration) timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) AfterFunc(d time.Duration, f func()) timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+var (
+	clk clock = realClock{}
+
+	// listenSRTLA opens the public SRTLA socket.
+	listenSRTLA = func(laddr *net.UDPAddr) (packetConn, error) {
+		conn, err := net.ListenUDP("udp", laddr)
+		if err != nil {
+			return nil, err
+		}
+		_ = conn.SetReadBuffer(recvBufSize)
+		_ = conn.SetWriteBuffer(sendBufSize)
+		return wrapSRTLASock(conn), nil
+	}
+
+	// dialSRT opens a group's socket towards the downstream SRT server.
+	dialSRT = func(raddr *net.UDPAddr) (srtConn, error) {
+		conn, err := net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.SetReadBuffer(recvBufSize); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := conn.SetWriteBuffer(sendBufSize); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return wrapSRTSock(conn), nil
+	}
+)
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when the test advances it. Timers
+// and tickers fire from Advance, in the order they are due.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	return fakeTicker{c.add(d, d, nil)}
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) timer {
+	return c.add(d, 0, nil)
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	return c.add(d, 0, f)
+}
+
+func (c *fakeClock) add(d, period time.Duration, f func()) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clk: c, period: period, f: f, c: make(chan time.Time, 1)}
+	t.when, t.active = c.now.Add(d), true
+	c.waiters = append(c.waiters, t)
+	return t
+}
+
+// pending returns how many timers and tickers wait to fire.
+func (c *fakeClock) pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.waiters {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+// waitPending blocks until at least n timers or tickers wait, so a
+// goroutine that is about to arm one doesn't miss the next Advance.
+func (c *fakeClock) waitPending(t *testing.T, n int) {
+	t.Helper()
+	eventually(t, func() bool { return c.pending() >= n }, "%d pending timers", n)
+}
+
+// Advance moves the clock forward by d, firing what falls due on the way.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].when.Before(c.waiters[j].when) })
+		var next *fakeTimer
+		for _, t := range c.waiters {
+			if t.active && !t.when.After(target) {
+				next = t
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		c.now = next.when
+		if next.period > 0 {
+			next.when = next.when.Add(next.period)
+		} else {
+			next.active = false
+		}
+		if next.f != nil {
+			c.mu.Unlock()
+			next.f()
+			c.mu.Lock()
+			continue
+		}
+		select {
+		case next.c <- c.now:
+		default: // like time.Ticker, a slow receiver misses ticks
+		}
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+type fakeTimer struct {
+	clk    *fakeClock
+	when   time.Time
+	period time.Duration
+	f      func()
+	c      chan time.Time
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clk.mu.Lock()
+	defer t.clk.mu.Unlock()
+	was := t.active
+	t.active = false
+	return was
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clk.mu.Lock()
+	defer t.clk.mu.Unlock()
+	was := t.active
+	t.when, t.active = t.clk.now.Add(d), true
+	return was
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
+
+// datagram is a packet a fake socket sent or is to receive.
+type datagram struct {
+	addr netip.AddrPort
+	b    []byte
+}
+
+// fakePacketConn is the public SRTLA socket in memory: the test queues
+// what senders send with deliver and reads what go-irl answered with sent.
+type fakePacketConn struct {
+	in     chan datagram
+	closed chan struct{}
+	once   sync.Once
+
+	mu  sync.Mutex
+	out []datagram
+}
+
+func newFakePacketConn() *fakePacketConn {
+	return &fakePacketConn{in: make(chan datagram, 64), closed: make(chan struct{})}
+}
+
+func (f *fakePacketConn) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, error) {
+	select {
+	case d := <-f.in:
+		return copy(b, d.b), d.addr, nil
+	case <-f.closed:
+		return 0, netip.AddrPort{}, net.ErrClosed
+	}
+}
+
+func (f *fakePacketConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.out = append(f.out, datagram{addr.AddrPort(), append([]byte(nil), b...)})
+	return len(b), nil
+}
+
+func (f *fakePacketConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv6unspecified, Port: 5000}
+}
+
+func (f *fakePacketConn) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+// sent returns and forgets what was written to addr.
+func (f *fakePacketConn) sent(addr netip.AddrPort) [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var pkts [][]byte
+	rest := f.out[:0]
+	for _, d := range f.out {
+		if d.addr == addr {
+			pkts = append(pkts, d.b)
+		} else {
+			rest = append(rest, d)
+		}
+	}
+	f.out = rest
+	return pkts
+}
+
+// fakeSRTConn is a group's socket towards the SRT server in memory.
+type fakeSRTConn struct {
+	in     chan []byte
+	closed chan struct{}
+	once   sync.Once
+
+	mu  sync.Mutex
+	out [][]byte
+}
+
+func newFakeSRTConn() *fakeSRTConn {
+	return &fakeSRTConn{in: make(chan []byte, 64), closed: make(chan struct{})}
+}
+
+func (f *fakeSRTConn) Read(b []byte) (int, error) {
+	select {
+	case p := <-f.in:
+		return copy(b, p), nil
+	case <-f.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (f *fakeSRTConn) Write(b []byte) (int, error) {
+	select {
+	case <-f.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.out = append(f.out, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (f *fakeSRTConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+
+func (f *fakeSRTConn) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *fakeSRTConn) isClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *fakeSRTConn) written() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]byte(nil), f.out...)
+}
+
+// eventually fails the test if cond doesn't hold within a second. The
+// group workers and SRT readers run on their own goroutines even with
+// the fake clock.
+func eventually(t testing.TB, cond func() bool, format string, args ...any) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for "+format, args...)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TrayPollPeriod  = 2 * time.Second
+	TrayStopTimeout = 5 * time.Second // before the instance is killed
+	TrayIconSize    = 32
+)
+
+// trayState is what the tray shows about its instance.
+type trayState struct {
+	Running bool
+	Ready   bool
+	Live    bool
+	Kbps    float64
+	Overlay string // URL of the Browser Source app
+	Exit    string // why the instance stopped on its own
+}
+
+func (s trayState) String() string {
+	switch {
+	case s.Live:
+		return fmt.Sprintf("Live, %.1f Mbps", s.Kbps/1000)
+	case s.Running && s.Ready:
+		return "Waiting for a stream"
+	case s.Running:
+		return "Starting"
+	case s.Exit != "":
+		return "Stopped: " + s.Exit
+	}
+	return "Stopped"
+}
+
+// trayInstance is the go-irl process the tray companion starts and stops.
+// It runs the command line given to the tray subcommand, with an API port
+// added for the tray to read its state from.
+type trayInstance struct {
+	args    []string
+	apiPort int
+	logPath string
+	logFile *os.File
+	client  *http.Client
+
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	done  chan struct{} // closed when cmd exited
+	state trayState
+}
+
+func newTrayInstance(args []string) (*trayInstance, error) {
+	if len(args) == 0 {
+		args = []string{ModeStandalone}
+	}
+	t := &trayInstance{args: args, client: &http.Client{Timeout: time.Second}}
+	if port, ok := flagValue(args, "api-port"); ok {
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 {
+			return nil, fmt.Errorf("the tray needs a valid -api-port, got %q", port)
+		}
+		t.apiPort = p
+	} else {
+		p, err := freeTCPPort()
+		if err != nil {
+			return nil, err
+		}
+		t.apiPort = p
+		t.args = append(t.args, fmt.Sprintf("-api-port=%d", p))
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	t.logPath = filepath.Join(dir, "go-irl", "tray.log")
+	if err := os.MkdirAll(filepath.Dir(t.logPath), 0o755); err != nil {
+		return nil, err
+	}
+	if t.logFile, err = os.Create(t.logPath); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// flagValue returns the value of -name or --name in args.
+func flagValue(args []string, name string) (string, bool) {
+	for i, a := range args {
+		a = strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if v, ok := strings.CutPrefix(a, name+"="); ok {
+			return v, true
+		}
+		if a == name && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+func freeTCPPort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (t *trayInstance) start() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cmd != nil {
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, t.args...)
+	cmd.Stdout, cmd.Stderr = t.logFile, t.logFile
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("[tray] Started go-irl %s, logging to %s", strings.Join(t.args, " "), t.logPath)
+	done := make(chan struct{})
+	t.cmd, t.done, t.state = cmd, done, trayState{Running: true}
+	go func() {
+		err := cmd.Wait()
+		t.mu.Lock()
+		if t.cmd == cmd {
+			t.cmd = nil
+			t.state = trayState{}
+			if err != nil {
+				t.state.Exit = err.Error()
+				log.Printf("[tray] go-irl exited: %v", err)
+			}
+		}
+		t.mu.Unlock()
+		close(done)
+	}()
+	return nil
+}
+
+// stop ends the instance the way Ctrl+C would, and kills it if it doesn't
+// exit in time. Windows processes can't be interrupted, they are killed.
+func (t *trayInstance) stop() {
+	t.mu.Lock()
+	cmd, done := t.cmd, t.done
+	t.cmd, t.state = nil, trayState{}
+	t.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-time.After(TrayStopTimeout):
+		cmd.Process.Kill()
+		<-done
+	}
+	log.Printf("[tray] Stopped go-irl")
+}
+
+// poll updates the state from the instance's API.
+func (t *trayInstance) poll() trayState {
+	t.mu.Lock()
+	running := t.cmd != nil
+	t.mu.Unlock()
+	var s trayState
+	if running {
+		var startup startupSummary
+		if t.get("/api/startup", &startup) {
+			s.Ready = startup.Ready
+			for _, e := range startup.Listeners {
+				if e.Name == ReadyBrowserSource {
+					s.Overlay = e.URL
+				}
+			}
+		}
+		var m streamMetricsMessage
+		if t.get("/api/stream", &m) && time.Since(m.Timestamp) < StreamStopTimeout {
+			s.Live, s.Kbps = true, m.BitrateKbps10s
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cmd == nil {
+		return t.state // stopped meanwhile
+	}
+	s.Running = true
+	t.state = s
+	return s
+}
+
+func (t *trayInstance) get(path string, v any) bool {
+	resp, err := t.client.Get(fmt.Sprintf("http://127.0.0.1:%d%s", t.apiPort, path))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(v) == nil
+}
+
+// openURL opens a URL or a file with the desktop's default application.
+func openURL(target string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	case "darwin":
+		cmd = exec.Command("open", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("[tray] Failed to open %s: %v", target, err)
+		return
+	}
+	go cmd.Wait()
+}
+
+// Colors of the tray icon.
+var (
+	TrayColorLive    = color.RGBA{0x2e, 0xb8, 0x4b, 0xff}
+	TrayColorWaiting = color.RGBA{0xf2, 0xb1, 0x1b, 0xff}
+	TrayColorStopped = color.RGBA{0x8a, 0x8a, 0x8a, 0xff}
+)
+
+func (s trayState) color() color.RGBA {
+	switch {
+	case s.Live:
+		return TrayColorLive
+	case s.Running:
+		return TrayColorWaiting
+	}
+	return TrayColorStopped
+}
+
+// trayIcon draws a filled circle as a 32-bit ICO, which is what the
+// Windows tray takes and macOS reads too.
+func trayIcon(c color.RGBA) []byte {
+	const n = TrayIconSize
+	var pixels bytes.Buffer // BGRA, bottom-up
+	r := float64(n)/2 - 1
+	for y := n - 1; y >= 0; y-- {
+		for x := 0; x < n; x++ {
+			dx, dy := float64(x)+0.5-float64(n)/2, float64(y)+0.5-float64(n)/2
+			alpha := min(max(r-math.Hypot(dx, dy)+0.5, 0), 1) // a pixel of antialiasing
+			pixels.Write([]byte{c.B, c.G, c.R, byte(alpha * 255)})
+		}
+	}
+	mask := make([]byte, n*n/8) // all opaque, the alpha channel decides
+
+	var b bytes.Buffer
+	w := func(v any) { binary.Write(&b, binary.LittleEndian, v) }
+	w([3]uint16{0, 1, 1}) // reserved, type icon, one image
+	size := 40 + pixels.Len() + len(mask)
+	w([4]uint8{n, n, 0, 0})
+	w([2]uint16{1, 32}) // planes, bits per pixel
+	w([2]uint32{uint32(size), 6 + 16})
+	// BITMAPINFOHEADER, the height counts the mask too
+	w(struct {
+		Size, Width, Height uint32
+		Planes, Bits        uint16
+		Compression, Image  uint32
+		XPPM, YPPM          uint32
+		Used, Important     uint32
+	}{Size: 40, Width: n, Height: 2 * n, Planes: 1, Bits: 32, Image: uint32(pixels.Len() + len(mask))})
+	b.Write(pixels.Bytes())
+	b.Write(mask)
+	return b.Bytes()
+}
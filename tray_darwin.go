@@ -0,0 +1,7 @@
+//go:build darwin
+
+package main
+
+// hideConsole does nothing on macOS, where the tray runs from a terminal
+// that may be closed, or from a launch agent.
+func hideConsole() {}
@@ -0,0 +1,17 @@
+//go:build !windows && !(darwin && cgo)
+
+package main
+
+import (
+	"log"
+	"runtime"
+)
+
+// runTray needs the tray icon of Windows or macOS, which on macOS is only
+// built with cgo.
+func runTray(args []string) {
+	if runtime.GOOS == "darwin" {
+		log.Fatalf("ERROR: this build of go-irl has no tray icon, it needs a macOS build with cgo")
+	}
+	log.Fatalf("ERROR: the tray icon is only available on Windows and macOS")
+}
@@ -0,0 +1,85 @@
+//go:build windows || (darwin && cgo)
+
+package main
+
+import (
+	"image/color"
+	"log"
+	"time"
+
+	"fyne.io/systray"
+)
+
+// runTray implements the "tray" subcommand: a tray (menu bar on macOS)
+// icon that runs go-irl with the given command line in the background,
+// for desktop users who don't want a terminal window next to OBS.
+func runTray(args []string) {
+	t, err := newTrayInstance(args)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	hideConsole()
+	systray.Run(func() { trayMenu(t) }, t.stop)
+}
+
+func trayMenu(t *trayInstance) {
+	systray.SetTooltip("go-irl")
+	status := systray.AddMenuItem("Starting", "State of the stream")
+	status.Disable()
+	toggle := systray.AddMenuItem("Stop", "Start or stop receiving the stream")
+	overlay := systray.AddMenuItem("Open overlay", "Open the Browser Source overlay in the browser")
+	overlay.Disable()
+	logs := systray.AddMenuItem("Open log", "Open the log of go-irl")
+	systray.AddSeparator()
+	quit := systray.AddMenuItem("Quit", "Stop go-irl and remove the icon")
+
+	if err := t.start(); err != nil {
+		log.Printf("[tray] Failed to start go-irl: %v", err)
+	}
+	var shown trayState
+	var icon color.RGBA
+	show := func(s trayState) {
+		if c := s.color(); c != icon {
+			systray.SetIcon(trayIcon(c))
+			icon = c
+		}
+		shown = s
+		status.SetTitle(s.String())
+		systray.SetTooltip("go-irl: " + s.String())
+		if s.Running {
+			toggle.SetTitle("Stop")
+		} else {
+			toggle.SetTitle("Start")
+		}
+		if s.Overlay != "" {
+			overlay.Enable()
+		} else {
+			overlay.Disable()
+		}
+	}
+	show(t.poll())
+
+	ticker := time.NewTicker(TrayPollPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-toggle.ClickedCh:
+			if shown.Running {
+				t.stop()
+			} else if err := t.start(); err != nil {
+				log.Printf("[tray] Failed to start go-irl: %v", err)
+			}
+		case <-overlay.ClickedCh:
+			if shown.Overlay != "" {
+				openURL(shown.Overlay)
+			}
+		case <-logs.ClickedCh:
+			openURL(t.logPath)
+		case <-quit.ClickedCh:
+			systray.Quit()
+			return
+		}
+		show(t.poll())
+	}
+}
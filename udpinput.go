@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// TSNullPID carries stuffing, its continuity counter is undefined.
+const TSNullPID = 0x1fff
+
+// isUDPInput reports whether addr is a udp:// input, see openUDPInput.
+func isUDPInput(addr string) bool {
+	u, err := url.Parse(addr)
+	return err == nil && u.Scheme == "udp"
+}
+
+// listenUDP listens for MPEG-TS datagrams on host, joining the group when
+// it is a multicast address.
+func listenUDP(host string) (*net.UDPConn, error) {
+	laddr, err := net.ResolveUDPAddr("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	var conn *net.UDPConn
+	if laddr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp", nil, laddr)
+	} else {
+		conn, err = net.ListenUDP("udp", laddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetReadBuffer(recvBufSize)
+	return conn, nil
+}
+
+// udpInput receives plain MPEG-TS over UDP for the client, from encoders
+// that don't speak SRT. Without SRT there are no transport stats, so it
+// counts what it can see in the stream itself: the bytes and TS packets
+// received, and the packets missing by the continuity counters.
+type udpInput struct {
+	conn    *net.UDPConn
+	started time.Time
+
+	mu      sync.Mutex
+	bytes   uint64
+	pkts    uint64 // TS packets
+	lost    uint64 // TS packets missing by the continuity counters
+	cc      tsContinuity
+	lastSrc string
+}
+
+// openUDPInput listens on udp://host:port, e.g. udp://0.0.0.0:5000 or
+// udp://239.0.0.1:5000 for multicast.
+func openUDPInput(addr string) (*udpInput, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := listenUDP(u.Host)
+	if err != nil {
+		return nil, err
+	}
+	recordListener("udp-input", "udp", conn.LocalAddr())
+	return &udpInput{conn: conn, started: time.Now(), cc: tsContinuity{}}, nil
+}
+
+func (u *udpInput) Read(p []byte) (int, error) {
+	n, src, err := u.conn.ReadFromUDP(p)
+	if err != nil {
+		return n, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if s := src.String(); s != u.lastSrc {
+		if u.lastSrc != "" {
+			clear(u.cc) // another encoder, its counters are unrelated
+		}
+		u.lastSrc = s
+	}
+	u.bytes += uint64(n)
+	for off := 0; off+TSPacketLen <= n; off += TSPacketLen {
+		u.pkts++
+		if pkt := p[off : off+TSPacketLen]; pkt[0] == 0x47 {
+			u.lost += uint64(u.cc.missing(pkt))
+		}
+	}
+	return n, nil
+}
+
+// stats returns what udpInput knows in the shape of SRT stats, so the
+// stream metrics, /stats and the overlays work as for an SRT ingest. All
+// SRT specific fields stay 0.
+func (u *udpInput) stats() *srt.Statistics {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s := &srt.Statistics{MsTimeStamp: uint64(time.Since(u.started).Milliseconds())}
+	s.Accumulated.ByteRecv = u.bytes
+	s.Accumulated.PktRecv = u.pkts
+	s.Accumulated.PktRecvLoss = u.lost
+	return s
+}
+
+func (u *udpInput) Close() error {
+	return u.conn.Close()
+}
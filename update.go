@@ -0,0 +1,333 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is overwritten at build time via -ldflags "-X main.version=...".
+var version = "dev"
+
+const (
+	releaseAPIURL     = "https://api.github.com/repos/e04/go-irl/releases/latest"
+	checksumsAsset    = "SHA256SUMS"
+	updateHTTPTimeout = 60 * time.Second
+)
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+type releaseInfo struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+// platformAssetName returns the release archive name for the running
+// platform, matching the names produced by .github/workflows/release.yml.
+func platformAssetName() (string, error) {
+	switch runtime.GOOS + "/" + runtime.GOARCH {
+	case "darwin/arm64":
+		return "go-irl-macos-arm64.zip", nil
+	case "linux/amd64":
+		return "go-irl-linux-x64.zip", nil
+	case "linux/arm64":
+		return "go-irl-linux-arm64.zip", nil
+	case "windows/amd64":
+		return "go-irl-windows-x64.zip", nil
+	}
+	return "", fmt.Errorf("no release build for %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+func binaryName() string {
+	if runtime.GOOS == "windows" {
+		return "go-irl.exe"
+	}
+	return "go-irl"
+}
+
+func httpGet(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseChecksums parses a sha256sum(1) style file into name -> hex digest.
+func parseChecksums(data []byte) map[string]string {
+	sums := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return sums
+}
+
+func extractBinary(archive []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if filepath.Base(f.Name) != binaryName() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%s not found in archive", binaryName())
+}
+
+// semver is a parsed release version, MAJOR.MINOR.PATCH[-PRERELEASE].
+type semver struct {
+	nums [3]int
+	pre  []string // dot separated pre-release identifiers, empty for a release
+}
+
+// parseSemver parses a release tag like v1.4.2 or 1.5.0-rc.1. Missing
+// minor and patch numbers count as 0; build metadata after '+' is ignored.
+func parseSemver(s string) (semver, error) {
+	var v semver
+	rest, _, _ := strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	rest, pre, hasPre := strings.Cut(rest, "-")
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("%q is not a version", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("%q is not a version", s)
+		}
+		v.nums[i] = n
+	}
+	if hasPre {
+		if pre == "" {
+			return v, fmt.Errorf("%q is not a version", s)
+		}
+		v.pre = strings.Split(pre, ".")
+	}
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 as v is older than, the same as or newer than
+// w, ordering pre-releases before their release as semver does.
+func (v semver) compare(w semver) int {
+	for i := range v.nums {
+		if v.nums[i] != w.nums[i] {
+			return cmp.Compare(v.nums[i], w.nums[i])
+		}
+	}
+	switch {
+	case len(v.pre) == 0 && len(w.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(w.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(w.pre); i++ {
+		a, aErr := strconv.Atoi(v.pre[i])
+		b, bErr := strconv.Atoi(w.pre[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if a != b {
+				return cmp.Compare(a, b)
+			}
+		case aErr == nil:
+			return -1 // numeric identifiers sort first
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(v.pre[i], w.pre[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmp.Compare(len(v.pre), len(w.pre))
+}
+
+var errUpToDate = errors.New("already up to date")
+
+// checkUpdate decides whether the release latest may replace the running
+// version current. Without force it refuses to go back to an older release,
+// and to replace a development build, which may well be newer.
+func checkUpdate(current, latest string, force bool) error {
+	want, err := parseSemver(latest)
+	if err != nil {
+		return fmt.Errorf("latest release: %w", err)
+	}
+	have, err := parseSemver(current)
+	if err != nil {
+		if force {
+			return nil
+		}
+		return fmt.Errorf("this is a development build (%s), use -force to replace it with %s", current, latest)
+	}
+	switch c := want.compare(have); {
+	case c > 0:
+		return nil
+	case force:
+		return nil
+	case c == 0:
+		return errUpToDate
+	default:
+		return fmt.Errorf("%s is newer than the latest release %s, use -force to downgrade", current, latest)
+	}
+}
+
+// replaceExecutable atomically swaps the running binary at path for data.
+// Windows does not allow overwriting a running executable, so the old file
+// is first moved aside.
+func replaceExecutable(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".new"
+	if err := os.WriteFile(tmp, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		old := path + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(path, old); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// runUpdate implements the "update" subcommand: it fetches the latest GitHub
+// release, verifies the platform archive against the published SHA256SUMS
+// and replaces the current executable.
+func runUpdate(args []string) {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	checkOnly := fs.Bool("check", false, "Only report whether a newer release is available")
+	force := fs.Bool("force", false, "Install the latest release even over a newer or development build")
+	fs.Parse(args)
+
+	client := &http.Client{Timeout: updateHTTPTimeout}
+
+	body, err := httpGet(client, releaseAPIURL)
+	if err != nil {
+		log.Fatalf("ERROR: failed to query latest release: %v", err)
+	}
+	var rel releaseInfo
+	if err := json.Unmarshal(body, &rel); err != nil {
+		log.Fatalf("ERROR: failed to parse release info: %v", err)
+	}
+
+	log.Printf("Current version: %s  Latest release: %s", version, rel.TagName)
+	if err := checkUpdate(version, rel.TagName, *force); errors.Is(err, errUpToDate) {
+		log.Println("Already up to date.")
+		return
+	} else if err != nil {
+		log.Fatalf("ERROR: not updating: %v", err)
+	}
+	if *checkOnly {
+		log.Printf("%s is available, run go-irl update to install it.", rel.TagName)
+		return
+	}
+
+	assetName, err := platformAssetName()
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+
+	var archiveURL, sumsURL string
+	for _, a := range rel.Assets {
+		switch a.Name {
+		case assetName:
+			archiveURL = a.URL
+		case checksumsAsset:
+			sumsURL = a.URL
+		}
+	}
+	if archiveURL == "" {
+		log.Fatalf("ERROR: release %s has no asset %s", rel.TagName, assetName)
+	}
+	if sumsURL == "" {
+		log.Fatalf("ERROR: release %s has no %s, refusing to install an unverified binary", rel.TagName, checksumsAsset)
+	}
+
+	sumsData, err := httpGet(client, sumsURL)
+	if err != nil {
+		log.Fatalf("ERROR: failed to download checksums: %v", err)
+	}
+	want, ok := parseChecksums(sumsData)[assetName]
+	if !ok {
+		log.Fatalf("ERROR: %s has no entry for %s", checksumsAsset, assetName)
+	}
+
+	log.Printf("Downloading %s ...", archiveURL)
+	archive, err := httpGet(client, archiveURL)
+	if err != nil {
+		log.Fatalf("ERROR: failed to download release: %v", err)
+	}
+	sum := sha256.Sum256(archive)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		log.Fatalf("ERROR: checksum mismatch for %s (got %s, want %s)", assetName, got, want)
+	}
+
+	bin, err := extractBinary(archive)
+	if err != nil {
+		log.Fatalf("ERROR: failed to extract binary: %v", err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("ERROR: failed to locate current executable: %v", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		log.Fatalf("ERROR: failed to resolve current executable: %v", err)
+	}
+
+	if err := replaceExecutable(exe, bin); err != nil {
+		log.Fatalf("ERROR: failed to replace %s: %v", exe, err)
+	}
+
+	// There is no zero-downtime restart: a running instance keeps the old
+	// binary until it is restarted, which drops its streams until the
+	// senders reconnect.
+	log.Printf("Updated %s to %s. Restart go-irl to run the new version; streams drop until the senders reconnect.", exe, rel.TagName)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"testing"
+)
+
+func TestSemverCompare(t *testing.T) {
+	ordered := []string{"0.9", "v1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "v1.0.0", "1.0.1", "1.2", "v1.10.0", "2.0.0+build.5"}
+	for i, a := range ordered {
+		va, err := parseSemver(a)
+		if err != nil {
+			t.Fatalf("parseSemver(%q): %v", a, err)
+		}
+		for j, b := range ordered {
+			vb, _ := parseSemver(b)
+			if got, want := va.compare(vb), cmp.Compare(i, j); got != want {
+				t.Errorf("compare(%q, %q) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+	for _, bad := range []string{"dev", "", "v", "1.2.3.4", "1.x", "1.2.3-", "-1.0"} {
+		if _, err := parseSemver(bad); err == nil {
+			t.Errorf("parseSemver(%q) accepted", bad)
+		}
+	}
+}
+
+func TestCheckUpdate(t *testing.T) {
+	for _, tc := range []struct {
+		current, latest string
+		force           bool
+		want            string // "" to install, "up to date", or "refuse"
+	}{
+		{"v1.2.0", "v1.3.0", false, ""},
+		{"1.2.0", "v1.2.1", false, ""},
+		{"v1.3.0-rc.1", "v1.3.0", false, ""},
+		{"v1.3.0", "v1.3.0", false, "up to date"},
+		{"v1.3.0", "v1.3.0", true, ""},
+		{"v1.4.0", "v1.3.0", false, "refuse"},
+		{"v1.4.0", "v1.3.0", true, ""},
+		{"v1.10.0", "v1.9.0", false, "refuse"}, // not a string comparison
+		{"dev", "v1.3.0", false, "refuse"},
+		{"dev", "v1.3.0", true, ""},
+		{"v1.3.0", "latest", true, "refuse"},
+	} {
+		err := checkUpdate(tc.current, tc.latest, tc.force)
+		got := "refuse"
+		switch {
+		case err == nil:
+			got = ""
+		case errors.Is(err, errUpToDate):
+			got = "up to date"
+		}
+		if got != tc.want {
+			t.Errorf("checkUpdate(%q, %q, force %v) = %v, want %q", tc.current, tc.latest, tc.force, err, tc.want)
+		}
+	}
+}
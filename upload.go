@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	UploadQueueLen         = 256
+	UploadRetries          = 5
+	UploadRetryDelay       = 30 * time.Second // doubled after every failed attempt
+	UploadProgressPeriod   = 1 * time.Second
+	UploadHistory          = 50 // uploads listed at /api/uploads
+	UploadDoneMarkerSuffix = ".uploaded"
+)
+
+// uploadTarget is where completed recordings are uploaded to, see -upload.
+type uploadTarget interface {
+	String() string
+	upload(path string, progress func(sent int64)) error
+}
+
+// uploadMessage reports the state of an upload on the WebSocket and at
+// /api/uploads.
+type uploadMessage struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Type       string    `json:"type"` // always "upload"
+	File       string    `json:"file"`
+	Target     string    `json:"target"`
+	State      string    `json:"state"` // queued, uploading, retrying, done or failed
+	Attempt    int       `json:"attempt"`
+	BytesSent  int64     `json:"bytesSent"`
+	TotalBytes int64     `json:"totalBytes"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type uploader struct {
+	target      uploadTarget
+	deleteAfter bool
+	queue       chan string
+
+	mu      sync.Mutex
+	uploads []*uploadMessage // newest last
+}
+
+// upl uploads completed recordings, nil unless -upload is set.
+var upl *uploader
+
+func parseUploadTarget(spec string) (uploadTarget, error) {
+	switch {
+	case strings.HasPrefix(spec, "s3://"):
+		return parseS3Target(spec)
+	case spec == "youtube" || strings.HasPrefix(spec, "youtube:"):
+		return parseYouTubeTarget(spec)
+	}
+	return nil, fmt.Errorf("unsupported upload target %q (expected s3://bucket/prefix or youtube)", spec)
+}
+
+func newUploader(target uploadTarget, deleteAfter bool) *uploader {
+	u := &uploader{target: target, deleteAfter: deleteAfter, queue: make(chan string, UploadQueueLen)}
+	go supervise("uploader", u.run)
+	return u
+}
+
+// enqueuePending queues the recordings in dir that were not uploaded yet,
+// e.g. because go-irl was restarted in the middle of an upload.
+func (u *uploader) enqueuePending(dir string) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ts"))
+	for _, path := range files {
+		if _, err := os.Stat(path + UploadDoneMarkerSuffix); err == nil {
+			continue
+		}
+		if rec != nil && rec.recording(path) {
+			continue
+		}
+		u.enqueue(path)
+	}
+}
+
+func (u *uploader) enqueue(path string) {
+	msg := u.track(path)
+	select {
+	case u.queue <- path:
+		u.publish(msg, "queued", nil)
+	default:
+		log.Printf("[upload] Queue full, not uploading %s", path)
+		u.publish(msg, "failed", fmt.Errorf("upload queue full"))
+	}
+}
+
+// track adds an entry for path to the upload list.
+func (u *uploader) track(path string) *uploadMessage {
+	msg := &uploadMessage{Type: "upload", File: filepath.Base(path), Target: u.target.String()}
+	if info, err := os.Stat(path); err == nil {
+		msg.TotalBytes = info.Size()
+	}
+	u.mu.Lock()
+	u.uploads = append(u.uploads, msg)
+	if len(u.uploads) > UploadHistory {
+		u.uploads = u.uploads[len(u.uploads)-UploadHistory:]
+	}
+	u.mu.Unlock()
+	return msg
+}
+
+func (u *uploader) publish(msg *uploadMessage, state string, err error) {
+	u.mu.Lock()
+	msg.Timestamp, msg.State, msg.Error = time.Now(), state, ""
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	snap := *msg
+	u.mu.Unlock()
+	publishMessage(snap)
+}
+
+func (u *uploader) find(path string) *uploadMessage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	name := filepath.Base(path)
+	for i := len(u.uploads) - 1; i >= 0; i-- {
+		if u.uploads[i].File == name {
+			return u.uploads[i]
+		}
+	}
+	return nil
+}
+
+// busy reports whether path is waiting to be uploaded or being uploaded.
+func (u *uploader) busy(path string) bool {
+	msg := u.find(path)
+	if msg == nil {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return msg.State == "queued" || msg.State == "uploading" || msg.State == "retrying"
+}
+
+func (u *uploader) run() {
+	for path := range u.queue {
+		u.uploadWithRetry(path)
+	}
+}
+
+func (u *uploader) uploadWithRetry(path string) {
+	msg := u.find(path)
+	if msg == nil {
+		msg = u.track(path)
+	}
+	delay := UploadRetryDelay
+	for attempt := 1; ; attempt++ {
+		u.mu.Lock()
+		msg.Attempt, msg.BytesSent = attempt, 0
+		u.mu.Unlock()
+		u.publish(msg, "uploading", nil)
+		log.Printf("[upload] Uploading %s to %s (attempt %d)", path, u.target, attempt)
+
+		start := time.Now()
+		var lastReport time.Time
+		err := u.target.upload(path, func(sent int64) {
+			u.mu.Lock()
+			msg.BytesSent = sent
+			u.mu.Unlock()
+			if time.Since(lastReport) >= UploadProgressPeriod {
+				lastReport = time.Now()
+				u.publish(msg, "uploading", nil)
+			}
+		})
+		if err == nil {
+			log.Printf("[upload] Uploaded %s in %s", path, time.Since(start).Round(time.Second))
+			u.publish(msg, "done", nil)
+			emitEvent("upload.completed", map[string]any{"file": path, "target": u.target.String()})
+			u.finish(path)
+			return
+		}
+		if attempt == UploadRetries {
+			log.Printf("[upload] Giving up on %s: %v", path, err)
+			u.publish(msg, "failed", err)
+			emitEvent("upload.failed", map[string]any{"file": path, "target": u.target.String(), "error": err.Error()})
+			return
+		}
+		log.Printf("[upload] Uploading %s failed, retrying in %s: %v", path, delay, err)
+		u.publish(msg, "retrying", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// finish marks path as uploaded, or deletes it with -upload-delete.
+func (u *uploader) finish(path string) {
+	if u.deleteAfter {
+		if err := os.Remove(path); err != nil {
+			log.Printf("[upload] Failed to delete %s: %v", path, err)
+		}
+		return
+	}
+	marker := fmt.Sprintf("%s %s\n", u.target, time.Now().Format(time.RFC3339))
+	if err := os.WriteFile(path+UploadDoneMarkerSuffix, []byte(marker), 0o644); err != nil {
+		log.Printf("[upload] Failed to mark %s as uploaded: %v", path, err)
+	}
+}
+
+// handleUploads serves /api/uploads with the recent uploads.
+func handleUploads(w http.ResponseWriter, r *http.Request) {
+	out := []uploadMessage{}
+	if upl != nil {
+		upl.mu.Lock()
+		for _, msg := range upl.uploads {
+			out = append(out, *msg)
+		}
+		upl.mu.Unlock()
+	}
+	writeJSON(w, out)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// flagNeeds are flags that do nothing without another one.
+var flagNeeds = map[string]string{
+	"stream-key":         "compat",
+	"demo-input":         "demo",
+	"capture-anonymize":  "capture",
+	"mediamtx-srt":       "preset",
+	"mediamtx-api":       "preset",
+	"mediamtx-path":      "preset",
+	"mediamtx-user":      "preset",
+	"mediamtx-pass":      "preset",
+	"obs-password":       "obs-websocket",
+	"upload-delete":      "upload",
+	"record-spill":       "record",
+	"record-segment":     "record",
+	"record-max-gb":      "record",
+	"record-max-age":     "record",
+	"record-min-free-gb": "record",
+	"tts":                "audio-alerts",
+	"ffmpeg":             "preview-interval",
+	"schedule-policy":    "schedule",
+	"schedule-webhook":   "schedule",
+	"cluster-peers":      "cluster-port",
+	"cluster-secret":     "cluster-port",
+	"mqtt-topic":         "mqtt",
+	"mqtt-discovery":     "mqtt",
+	"log-http-token":     "log-http",
+	"action-timeout":     "on-event",
+	"action-concurrency": "on-event",
+	"api-host":           "api-port",
+	"tls-key":            "tls-cert",
+	"acme-dns":           "tls-domain",
+	"acme-email":         "tls-domain",
+	"acme-dir":           "tls-domain",
+	"acme-ca":            "tls-domain",
+}
+
+// ignoredFlagNotes explain the flags people most often set in the wrong
+// mode.
+var ignoredFlagNotes = map[string]string{
+	"passphrase": "the server relays the sender's SRT packets unchanged, set the passphrase on the client and the sender",
+	"srt-host":   "the client listens on -srt-port on all addresses; -srt-host tells the server where the client is",
+	"udp-port":   "the server has no outputs, OBS connects to the client",
+}
+
+// portFlag is a port go-irl binds, or sends the stream to, with the flags
+// in effect.
+type portFlag struct {
+	name  string
+	proto string // "udp" or "tcp"
+	port  int
+	dest  bool // go-irl sends to it on 127.0.0.1 rather than listening
+}
+
+// flagInUse reports whether the flag is set to something that turns its
+// feature on, not just to its zero value.
+func flagInUse(name string) bool {
+	f := flag.Lookup(name)
+	if f == nil {
+		return false
+	}
+	switch f.Value.String() {
+	case "", "0", "0s", "false":
+		return false
+	}
+	return true
+}
+
+// validateFlags checks the flags as a whole before anything starts, and
+// returns every problem at once: errors for combinations that can't work,
+// warnings for flags that are set but have no effect.
+func validateFlags() (warnings, errs []string) {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var names []string
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var active []string
+	for _, m := range []string{ModeServer, ModeClient, ModeStandalone, ModeDirector} {
+		if roles[m] {
+			active = append(active, m)
+		}
+	}
+	modeName := strings.Join(active, ",")
+	for _, name := range names {
+		if !flagApplies(name, roles) {
+			msg := fmt.Sprintf("-%s is ignored in %s mode, it applies to %s", name, modeName, strings.ReplaceAll(flagRoles[name], ",", "/"))
+			if note, ok := ignoredFlagNotes[name]; ok {
+				msg += ": " + note
+			}
+			warnings = append(warnings, msg)
+			continue
+		}
+		if need, ok := flagNeeds[name]; ok && !flagInUse(need) {
+			warnings = append(warnings, fmt.Sprintf("-%s is ignored without -%s", name, need))
+		}
+	}
+
+	var ports []portFlag
+	add := func(name, proto string, port int, dest bool) {
+		if !flagApplies(name, roles) {
+			return
+		}
+		if port < 0 || port > 65535 {
+			errs = append(errs, fmt.Sprintf("-%s must be 0-65535, got %d", name, port))
+			return
+		}
+		if port > 0 {
+			ports = append(ports, portFlag{name, proto, port, dest})
+		}
+	}
+	add("srtla-port", "udp", *srtlaPort, false)
+	add("srt-ingest-port", "udp", *srtIngestPort, false)
+	add("cluster-port", "udp", *clusterPort, false)
+	if roles[ModeClient] {
+		add("srt-port", "udp", *srtPort, false)
+	}
+	add("srt-backup-port", "udp", *srtBackupPort, false)
+	add("play-port", "udp", *playPort, false)
+	add("udp-port", "udp", *udpPort, true)
+	add("bs-port", "tcp", *bsPort, false)
+	add("ws-port", "tcp", *wsPort, false)
+	add("api-port", "tcp", *apiPort, false)
+	for i, a := range ports {
+		for _, b := range ports[i+1:] {
+			if a.proto != b.proto || a.port != b.port {
+				continue
+			}
+			switch {
+			case a.dest && b.dest:
+			case a.dest || b.dest:
+				dest, ln := a, b
+				if b.dest {
+					dest, ln = b, a
+				}
+				errs = append(errs, fmt.Sprintf("-%s=%d would send the stream to go-irl's own -%s", dest.name, dest.port, ln.name))
+			default:
+				errs = append(errs, fmt.Sprintf("-%s and -%s both use %s port %d", a.name, b.name, strings.ToUpper(a.proto), a.port))
+			}
+		}
+	}
+
+	if (roles[ModeServer] || roles[ModeClient]) && (*srtPort <= 0 || *srtPort > 65535) {
+		errs = append(errs, fmt.Sprintf("-srt-port must be 1-65535, got %d", *srtPort))
+	}
+	if roles[ModeServer] && *clusterPort > 0 && *clusterSecret == "" {
+		errs = append(errs, "-cluster-port needs -cluster-secret")
+	}
+	if roles[ModeDirector] && *directorServers == "" {
+		errs = append(errs, "director mode needs -director-servers")
+	}
+	if roles[ModeDirector] && *apiPort <= 0 {
+		errs = append(errs, "director mode needs -api-port")
+	}
+	if runsProxy() && *passphrase != "" && len(*passphrase) < 10 {
+		errs = append(errs, "-passphrase must be at least 10 characters long")
+	}
+	return warnings, errs
+}
+
+// checkFlags logs the problems validateFlags finds and exits on errors.
+func checkFlags() {
+	warnings, errs := validateFlags()
+	for _, w := range warnings {
+		log.Printf("WARNING: %s", w)
+	}
+	if len(errs) == 0 {
+		return
+	}
+	for _, e := range errs[:len(errs)-1] {
+		log.Printf("ERROR: %s", e)
+	}
+	log.Fatalf("ERROR: %s", errs[len(errs)-1])
+}
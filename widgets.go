@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WidgetMaxCount    = 32
+	WidgetMinInterval = 100 * time.Millisecond // per widget, faster pushes are dropped
+)
+
+// Widget data sources let external processes feed community-made overlay
+// widgets. A process registers a name and gets a token back, then pushes
+// JSON payloads with that token. Each payload is published on the WebSocket
+// under the widget's own topic, "widget.<name>".
+type widgetSource struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Registered  time.Time `json:"registered"`
+	Pushes      int64     `json:"pushes"`
+
+	token  string
+	latest *widgetMessage
+}
+
+type widgetMessage struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Type      string          `json:"type"` // "widget.<name>"
+	Widget    string          `json:"widget"`
+	Data      json.RawMessage `json:"data"`
+}
+
+var widgets = struct {
+	mu      sync.Mutex
+	sources map[string]*widgetSource
+}{sources: map[string]*widgetSource{}}
+
+// widgetToken returns the bearer token of the request, if any.
+func widgetToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// widgetAuthorized must be called with widgets.mu held.
+func widgetAuthorized(src *widgetSource, r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(src.token), []byte(widgetToken(r))) == 1
+}
+
+func newWidgetToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// handleWidgets serves GET /api/widgets, listing the registered sources.
+func handleWidgets(w http.ResponseWriter, r *http.Request) {
+	widgets.mu.Lock()
+	list := make([]widgetSource, 0, len(widgets.sources))
+	for _, src := range widgets.sources {
+		list = append(list, *src)
+	}
+	widgets.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeJSON(w, list)
+}
+
+// handleWidgetRegister serves POST /api/widgets/{name}. Registering a new
+// name needs the -control-token; one that is taken needs its token (or the
+// control token), which lets a source restart and keep it.
+func handleWidgetRegister(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !sensorNameRe.MatchString(name) {
+		http.Error(w, "widget names are 1-64 letters, digits, '_', '.' or '-'", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Description string `json:"description"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, MetadataMaxBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	control := controlAuthorized(widgetToken(r))
+	widgets.mu.Lock()
+	src, ok := widgets.sources[name]
+	switch {
+	case ok && !control && !widgetAuthorized(src, r):
+		widgets.mu.Unlock()
+		http.Error(w, "widget "+name+" is registered by another source", http.StatusConflict)
+		return
+	case !ok && !control:
+		widgets.mu.Unlock()
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "registering a widget needs the -control-token", http.StatusUnauthorized)
+		return
+	case !ok && len(widgets.sources) >= WidgetMaxCount:
+		widgets.mu.Unlock()
+		http.Error(w, "too many widgets", http.StatusInsufficientStorage)
+		return
+	case !ok:
+		src = &widgetSource{Name: name, Registered: time.Now(), token: newWidgetToken()}
+		widgets.sources[name] = src
+	}
+	src.Description = req.Description
+	token := src.token
+	widgets.mu.Unlock()
+
+	if !ok {
+		log.Printf("[widgets] Registered %s from %s", name, clientIP(r))
+		emitEvent("widget_source.registered", map[string]any{"widget": name})
+	}
+	writeJSON(w, map[string]string{"name": name, "topic": "widget." + name, "token": token})
+}
+
+// handleWidgetPush serves PUT /api/widgets/{name}/data, the body being the
+// payload.
+func handleWidgetPush(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var data json.RawMessage
+	r.Body = http.MaxBytesReader(w, r.Body, MetadataMaxBytes)
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	widgets.mu.Lock()
+	src, ok := widgets.sources[name]
+	switch {
+	case !ok:
+		widgets.mu.Unlock()
+		http.Error(w, "unknown widget "+name, http.StatusNotFound)
+		return
+	case !widgetAuthorized(src, r):
+		widgets.mu.Unlock()
+		http.Error(w, "wrong or missing token", http.StatusUnauthorized)
+		return
+	case src.latest != nil && now.Sub(src.latest.Timestamp) < WidgetMinInterval:
+		widgets.mu.Unlock()
+		http.Error(w, "widget pushes are limited to ten per second", http.StatusTooManyRequests)
+		return
+	}
+	msg := &widgetMessage{Timestamp: now, Type: "widget." + name, Widget: name, Data: data}
+	src.latest = msg
+	src.Pushes++
+	widgets.mu.Unlock()
+
+	publishMessage(msg)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWidgetRemove serves DELETE /api/widgets/{name}, with the widget's
+// token or the -control-token.
+func handleWidgetRemove(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	control := controlAuthorized(widgetToken(r))
+	widgets.mu.Lock()
+	src, ok := widgets.sources[name]
+	if ok && !control && !widgetAuthorized(src, r) {
+		widgets.mu.Unlock()
+		http.Error(w, "wrong or missing token", http.StatusUnauthorized)
+		return
+	}
+	delete(widgets.sources, name)
+	widgets.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "unknown widget "+name, http.StatusNotFound)
+		return
+	}
+	log.Printf("[widgets] Removed %s", name)
+	emitEvent("widget_source.removed", map[string]any{"widget": name})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// widgetWelcome returns the latest payload of every widget for a newly
+// connected WebSocket client.
+func widgetWelcome() [][]byte {
+	widgets.mu.Lock()
+	defer widgets.mu.Unlock()
+	var msgs [][]byte
+	for _, src := range widgets.sources {
+		if src.latest == nil {
+			continue
+		}
+		if data, err := json.Marshal(src.latest); err == nil {
+			msgs = append(msgs, data)
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GroupWorkQueueLen is how many packets may wait for a group's worker
+// before the reader starts dropping them.
+const GroupWorkQueueLen = 1024
+
+// packetBuf is a datagram on its way from the SRTLA reader to a group
+// worker. Buffers are recycled through packetBufPool so the steady state
+// does not allocate.
+type packetBuf struct {
+	b      [MTU]byte
+	n      int
+	conn   *Conn
+	readAt int64 // unix nanoseconds, set by the reader
+}
+
+var packetBufPool = sync.Pool{New: func() any { return new(packetBuf) }}
+
+func getPacketBuf() *packetBuf { return packetBufPool.Get().(*packetBuf) }
+
+func putPacketBuf(pb *packetBuf) {
+	pb.conn = nil
+	packetBufPool.Put(pb)
+}
+
+// groupWorkersActive counts running group workers for the diagnostics,
+// from before their goroutine starts.
+var groupWorkersActive atomic.Int64
+
+// runWorker processes the group's packets until the group is torn down, so
+// independent streams are handled on separate cores. Whatever is queued
+// when a packet arrives is taken along, up to SRTLAReadBatch packets, and
+// forwarded to the SRT server together.
+func (g *Group) runWorker() {
+	segs := make([][]byte, 0, SRTLAReadBatch)
+	held := make([]*packetBuf, 0, SRTLAReadBatch)
+	take := func(pb *packetBuf, now time.Time) {
+		g.queueWait.observe(now.UnixNano()-pb.readAt, now)
+		if !handleConnPacket(g, pb.conn, pb.b[:pb.n]) {
+			putPacketBuf(pb)
+		} else if g.reorder != nil {
+			held = g.reorder.push(pb, now, held)
+		} else {
+			held = append(held, pb)
+		}
+	}
+
+	// Only armed while the reorder buffer holds packets back
+	timer := clk.NewTimer(time.Hour)
+	timer.Stop()
+	var expire <-chan time.Time
+	defer func() {
+		timer.Stop()
+		if g.reorder != nil {
+			for _, pb := range g.reorder.flush(clk.Now(), nil) {
+				putPacketBuf(pb)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case pb := <-g.work:
+			take(pb, clk.Now())
+		case now := <-expire:
+			expire = nil
+			held = g.reorder.expire(now, held)
+		case <-g.done:
+			return
+		}
+	drain:
+		for taken := 1; taken < SRTLAReadBatch; taken++ {
+			select {
+			case pb := <-g.work:
+				take(pb, clk.Now())
+			default:
+				break drain
+			}
+		}
+
+		if g.reorder != nil {
+			if t, ok := g.reorder.deadline(); ok && expire == nil {
+				timer.Reset(t.Sub(clk.Now()))
+				expire = timer.C()
+			}
+		}
+		g.updateBackpressure()
+
+		for _, pb := range held {
+			segs = append(segs, pb.b[:pb.n])
+		}
+		if len(segs) > 0 {
+			g.mu.Lock()
+			conn := g.srtSock
+			g.mu.Unlock()
+			if conn != nil {
+				if err := writeSegments(conn, segs); err != nil {
+					log.Printf("[group %p] Failed to forward SRTLA packet, terminating the group: %v", g, err)
+					removeGroup(g)
+				}
+			}
+		}
+		for i, pb := range held {
+			putPacketBuf(pb)
+			held[i] = nil
+		}
+		for i := range segs {
+			segs[i] = nil
+		}
+		segs, held = segs[:0], held[:0]
+	}
+}
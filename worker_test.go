@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/binary"
+	"runtime"
+	"testing"
+)
+
+// TestPacketPathAllocs checks that the steady state does not allocate per
+// packet: from the reader's hand-off through the worker to writeSegments,
+// including the SRTLA ACKs, and the server's packets back to the sender.
+func TestPacketPathAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("counts allocations of the whole process")
+	}
+	h := newSRTLAHarness(t)
+	gs, links, srt := h.benchGroups(2, 3)
+	pkt := srtData(0)
+	for i := range gs {
+		h.deliver(links[i][0], pkt)
+	}
+	eventually(t, func() bool { return srt.writes.Load() == int64(len(gs)) }, "the SRT sockets")
+
+	sn := uint32(1)
+	allocs := testing.AllocsPerRun(1000, func() {
+		want := srt.writes.Load() + int64(len(gs))
+		binary.BigEndian.PutUint32(pkt, sn)
+		for i := range gs {
+			h.deliver(links[i][int(sn)%len(links[i])], pkt)
+		}
+		sn++
+		for srt.writes.Load() < want {
+			runtime.Gosched() // the workers are done with the packets
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("%.1f allocations per packet from the sender, want 0", allocs/float64(len(gs)))
+	}
+
+	data, ack := srtData(0), srtControl(SRTTypeACK)
+	allocs = testing.AllocsPerRun(1000, func() {
+		for _, g := range gs {
+			handleSRTData(g, data)
+			handleSRTData(g, ack)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("%.1f allocations per packet from the server, want 0", allocs/float64(2*len(gs)))
+	}
+}
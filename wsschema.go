@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	srt "github.com/datarhei/gosrt"
+)
+
+// WebSocket message schema versions. Clients pick one with /ws?v=N and
+// get the newest one this build knows when they ask for a later one.
+// Version 1 is the flat format of the first releases, with the gosrt
+// Statistics struct as the stats; it stays the default for the existing
+// overlays. Version 2 wraps every message in a wsEnvelope.
+const (
+	WSSchemaLegacy = 1
+	WSSchemaLatest = 2
+)
+
+// wsEnvelope is a version 2 message. Payload holds the fields of the
+// version 1 message except type and timestamp, apart from the reader and
+// writer stats, which get a wsStatsPayload.
+type wsEnvelope struct {
+	Type      string          `json:"type"`
+	Version   int             `json:"version"`
+	Timestamp time.Time       `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// wsStatsPayload is the reader or writer stats in version 2. Unlike the
+// gosrt struct its fields don't change with gosrt upgrades; the counters
+// are of the received traffic for the reader, of the sent for the writer.
+type wsStatsPayload struct {
+	UptimeSeconds          float64 `json:"uptimeSeconds"` // since the SRT socket was created
+	Mbps                   float64 `json:"mbps"`
+	LinkCapacityMbps       float64 `json:"linkCapacityMbps"`
+	RttMs                  float64 `json:"rttMs"`
+	BufferMs               uint64  `json:"bufferMs"`
+	LatencyMs              uint64  `json:"latencyMs"`
+	Bytes                  uint64  `json:"bytes"`
+	Packets                uint64  `json:"packets"`
+	PacketsLost            uint64  `json:"packetsLost"`
+	PacketsRetransmitted   uint64  `json:"packetsRetransmitted"`
+	PacketsDropped         uint64  `json:"packetsDropped"`
+	IntervalPackets        uint64  `json:"intervalPackets"` // since the previous stats message
+	IntervalPacketsLost    uint64  `json:"intervalPacketsLost"`
+	IntervalPacketsRetrans uint64  `json:"intervalPacketsRetransmitted"`
+	Test                   bool    `json:"test,omitempty"` // see -test-signal
+}
+
+func newWSStatsPayload(s *srt.Statistics, recv bool) wsStatsPayload {
+	a, i, n := s.Accumulated, s.Interval, s.Instantaneous
+	p := wsStatsPayload{
+		UptimeSeconds:    float64(s.MsTimeStamp) / 1000,
+		LinkCapacityMbps: n.MbpsLinkCapacity,
+		RttMs:            n.MsRTT,
+	}
+	if recv {
+		p.Mbps, p.BufferMs, p.LatencyMs = n.MbpsRecvRate, n.MsRecvBuf, n.MsRecvTsbPdDelay
+		p.Bytes, p.Packets, p.PacketsLost = a.ByteRecv, a.PktRecv, a.PktRecvLoss
+		p.PacketsRetransmitted, p.PacketsDropped = a.PktRecvRetrans, a.PktRecvDrop
+		p.IntervalPackets, p.IntervalPacketsLost, p.IntervalPacketsRetrans = i.PktRecv, i.PktRecvLoss, i.PktRecvRetrans
+	} else {
+		p.Mbps, p.BufferMs, p.LatencyMs = n.MbpsSentRate, n.MsSendBuf, n.MsSendTsbPdDelay
+		p.Bytes, p.Packets, p.PacketsLost = a.ByteSent, a.PktSent, a.PktSendLoss
+		p.PacketsRetransmitted, p.PacketsDropped = a.PktRetrans, a.PktSendDrop
+		p.IntervalPackets, p.IntervalPacketsLost, p.IntervalPacketsRetrans = i.PktSent, i.PktSendLoss, i.PktRetrans
+	}
+	return p
+}
+
+// wsSchemaVersion returns the version r asks for with ?v=, the legacy one
+// without it and false when it is no version at all.
+func wsSchemaVersion(r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("v")
+	if v == "" {
+		return WSSchemaLegacy, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return min(n, WSSchemaLatest), true
+}
+
+// wsHello is the first message of a version 2 connection, telling the
+// client which version it got.
+func wsHello(version int) []byte {
+	payload, _ := json.Marshal(map[string]any{
+		"version":  version,
+		"versions": []int{WSSchemaLegacy, WSSchemaLatest},
+	})
+	data, _ := json.Marshal(wsEnvelope{Type: "hello", Version: version, Timestamp: time.Now(), Payload: payload})
+	return data
+}
+
+// toEnvelope converts a version 1 message to version 2, nil when it isn't
+// a JSON object.
+func toEnvelope(data []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	env := wsEnvelope{Version: WSSchemaLatest, Timestamp: time.Now()}
+	json.Unmarshal(fields["type"], &env.Type)
+	if ts, ok := fields["timestamp"]; ok {
+		json.Unmarshal(ts, &env.Timestamp)
+	}
+	delete(fields, "type")
+	delete(fields, "timestamp")
+
+	var payload any = fields
+	if st, ok := fields["stats"]; ok && (env.Type == "reader" || env.Type == "writer") {
+		var s srt.Statistics
+		if err := json.Unmarshal(st, &s); err == nil {
+			p := newWSStatsPayload(&s, env.Type == "reader")
+			if t, ok := fields["test"]; ok {
+				json.Unmarshal(t, &p.Test)
+			}
+			payload = p
+		}
+	}
+	var err error
+	if env.Payload, err = json.Marshal(payload); err != nil {
+		return nil
+	}
+	out, err := json.Marshal(env)
+	if err != nil {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	YouTubeChunkSize      = 32 * 256 << 10 // 8 MB, chunks must be multiples of 256 KB
+	YouTubeRequestTimeout = 10 * time.Minute
+
+	youtubeTokenURL  = "https://oauth2.googleapis.com/token"
+	youtubeUploadURL = "https://www.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&part=snippet,status"
+)
+
+// youtubeTarget uploads recordings as videos with the YouTube Data API's
+// resumable uploads. The OAuth client and a refresh token with the
+// youtube.upload scope come from YOUTUBE_CLIENT_ID, YOUTUBE_CLIENT_SECRET
+// and YOUTUBE_REFRESH_TOKEN.
+type youtubeTarget struct {
+	privacy string
+
+	clientID, clientSecret, refreshToken string
+
+	client *http.Client
+
+	mu       sync.Mutex
+	sessions map[string]string // file -> upload session, to resume a failed upload
+}
+
+// parseYouTubeTarget parses youtube: or youtube:?privacy=unlisted.
+func parseYouTubeTarget(spec string) (*youtubeTarget, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	t := &youtubeTarget{
+		privacy:      u.Query().Get("privacy"),
+		clientID:     os.Getenv("YOUTUBE_CLIENT_ID"),
+		clientSecret: os.Getenv("YOUTUBE_CLIENT_SECRET"),
+		refreshToken: os.Getenv("YOUTUBE_REFRESH_TOKEN"),
+		client: &http.Client{
+			Timeout: YouTubeRequestTimeout,
+			// 308 means Resume Incomplete here, not a redirect
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		sessions: map[string]string{},
+	}
+	switch t.privacy {
+	case "":
+		t.privacy = "private"
+	case "private", "unlisted", "public":
+	default:
+		return nil, fmt.Errorf("unknown privacy %q (expected private, unlisted or public)", t.privacy)
+	}
+	if t.clientID == "" || t.clientSecret == "" || t.refreshToken == "" {
+		return nil, fmt.Errorf("YOUTUBE_CLIENT_ID, YOUTUBE_CLIENT_SECRET and YOUTUBE_REFRESH_TOKEN must be set")
+	}
+	return t, nil
+}
+
+func (t *youtubeTarget) String() string { return "youtube" }
+
+func (t *youtubeTarget) accessToken() (string, error) {
+	resp, err := t.client.PostForm(youtubeTokenURL, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {t.clientID},
+		"client_secret": {t.clientSecret},
+		"refresh_token": {t.refreshToken},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", fmt.Errorf("token refresh: %s", resp.Status)
+	}
+	if tok.AccessToken == "" {
+		return "", fmt.Errorf("token refresh: %s %s", resp.Status, tok.Error)
+	}
+	return tok.AccessToken, nil
+}
+
+// upload sends the file, resuming the session of an earlier attempt if
+// there is one.
+func (t *youtubeTarget) upload(path string, progress func(sent int64)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	token, err := t.accessToken()
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	session := t.sessions[path]
+	t.mu.Unlock()
+
+	var offset int64
+	if session != "" {
+		if offset, err = t.resumeOffset(session, token, size); err != nil {
+			session = "" // expired, start over
+		}
+	}
+	if session == "" {
+		if session, err = t.startSession(path, token, size); err != nil {
+			return err
+		}
+		offset = 0
+		t.mu.Lock()
+		t.sessions[path] = session
+		t.mu.Unlock()
+	}
+
+	buf := make([]byte, YouTubeChunkSize)
+	for offset < size {
+		progress(offset)
+		n, err := f.ReadAt(buf[:min(int64(len(buf)), size-offset)], offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		req, _ := http.NewRequest(http.MethodPut, session, bytes.NewReader(buf[:n]))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(n)-1, size))
+		if offset, err = t.chunkResult(req, size); err != nil {
+			return err
+		}
+	}
+	progress(size)
+
+	t.mu.Lock()
+	delete(t.sessions, path)
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *youtubeTarget) startSession(path, token string, size int64) (string, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	meta, _ := json.Marshal(map[string]any{
+		"snippet": map[string]any{"title": name, "description": "Recorded with go-irl"},
+		"status":  map[string]any{"privacyStatus": t.privacy},
+	})
+	req, _ := http.NewRequest(http.MethodPost, youtubeUploadURL, bytes.NewReader(meta))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("X-Upload-Content-Type", "video/mp2t")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("starting the upload: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	session := resp.Header.Get("Location")
+	if session == "" {
+		return "", fmt.Errorf("starting the upload: no session URL in response")
+	}
+	return session, nil
+}
+
+// resumeOffset asks how much of the file an interrupted session received.
+func (t *youtubeTarget) resumeOffset(session, token string, size int64) (int64, error) {
+	req, _ := http.NewRequest(http.MethodPut, session, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	return t.chunkResult(req, size)
+}
+
+// chunkResult sends req and returns the offset to continue at: where the
+// server's Range ends for 308 Resume Incomplete, size once it is complete.
+func (t *youtubeTarget) chunkResult(req *http.Request, size int64) (int64, error) {
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return size, nil
+	case http.StatusPermanentRedirect: // 308 Resume Incomplete
+		_, end, ok := strings.Cut(resp.Header.Get("Range"), "-")
+		if !ok {
+			return 0, nil // nothing received yet
+		}
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Range %q", resp.Header.Get("Range"))
+		}
+		return n + 1, nil
+	}
+	msg, _ := io.ReadAll(resp.Body)
+	return 0, fmt.Errorf("upload: %s: %s", resp.Status, bytes.TrimSpace(msg))
+}